@@ -0,0 +1,48 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aurora-is-near/evm-bully/replayer"
+	"github.com/aurora-is-near/near-api-go"
+)
+
+// Bisect implements the 'bisect' command.
+func Bisect(argv0 string, args ...string) error {
+	fs := flag.NewFlagSet(argv0, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -good <commit> -bad <commit> <breakpoint>\n", argv0)
+		fmt.Fprintf(os.Stderr, "Find first aurora-engine commit for which transaction from breakpoint fails.\n")
+		fs.PrintDefaults()
+	}
+	bad := fs.String("bad", "", "Bad aurora-engine commit (transaction fails)")
+	build := fs.Bool("build", false, "Build nearcore version given in breakpoint.json before bisecting")
+	gas := fs.Uint64("gas", defaultGas, "Max amount of gas a call can use (in gas units)")
+	good := fs.String("good", "", "Good aurora-engine commit (transaction succeeds)")
+	release := fs.Bool("release", false, "Run release version of neard")
+	cfg := near.GetConfig()
+	registerCfgFlags(fs, cfg, true)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *good == "" {
+		return errors.New("option -good is mandatory")
+	}
+	if *bad == "" {
+		return errors.New("option -bad is mandatory")
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	breakpoint := fs.Arg(0)
+	commit, err := replayer.Bisect(breakpoint, *good, *bad, *build, *release, *gas)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is the first bad commit\n", commit)
+	return nil
+}
@@ -1,6 +1,7 @@
 package command
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -17,23 +18,56 @@ func DumpDB(argv0 string, args ...string) error {
 		fmt.Fprintf(os.Stderr, "Calculate testnet statistics.\n")
 		fs.PrintDefaults()
 	}
-	block := fs.Uint64("block", defaultGoerliBlockHeight, "Block height")
+	appendDump := fs.Bool("append", false, "Append new blocks to existing dump file")
+	block := fs.Uint64("block", 0, "Block height (default of network, if unset)")
+	codecName := fs.String("codec", "none", "Compression codec for dump file (none, gzip, zstd, snappy)")
 	dataDir := fs.String("datadir", defaultDataDir, "Data directory containing the database to read")
 	defrost := fs.Bool("defrost", false, "Defrost the database first")
-	hash := fs.String("hash", defaultGoerliBlockHash, "Block hash")
+	hash := fs.String("hash", "", "Block hash (default of network, if -block is unset)")
+	receipts := fs.Bool("receipts", false, "Dump transaction receipts (required by replay -verify)")
+	rpcURL := fs.String("rpc", "", "Dump from Ethereum JSON-RPC endpoint instead of local database (resumes automatically)")
+	workers := fs.Int("workers", 8, "Number of concurrent block fetches when dumping via -rpc")
 	f.registerFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
-	_, testnet, err := f.determineTestnet()
+	net, err := f.determineTestnet()
 	if err != nil {
 		return err
 	}
-	adjustBlockDefaults(block, hash, testnet)
+	if err := adjustBlockDefaults(block, hash, net); err != nil {
+		return err
+	}
+	testnet := net.Name
 	if fs.NArg() != 0 {
 		fs.Usage()
 		return flag.ErrHelp
 	}
+	if *rpcURL != "" && *appendDump {
+		return errors.New("options -rpc and -append exclude each other (-rpc extends existing dumps automatically)")
+	}
+	if *rpcURL != "" && *defrost {
+		return errors.New("options -rpc and -defrost exclude each other")
+	}
+	if *appendDump && *codecName != "none" {
+		return errors.New("options -append and -codec exclude each other (the codec of the existing dump is used)")
+	}
+	if *rpcURL == "" && *hash == "" {
+		return errors.New("option -hash is mandatory (unless -rpc is used)")
+	}
+	codec, err := db.ParseCodec(*codecName)
+	if err != nil {
+		return err
+	}
+	if *rpcURL != "" {
+		// dump via JSON-RPC
+		genesis := net.Genesis.ToBlock(nil).Hash()
+		return db.DumpRPC(*rpcURL, testnet, genesis, *block, *hash, *workers, codec, *receipts)
+	}
+	if *appendDump {
+		// extend existing dump
+		return db.Append(*dataDir, testnet, *block, *hash, *defrost, *receipts)
+	}
 	// dump database
-	return db.Dump(*dataDir, testnet, *block, *hash, *defrost)
+	return db.Dump(*dataDir, testnet, *block, *hash, *defrost, codec, *receipts)
 }
@@ -0,0 +1,63 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/aurora-is-near/evm-bully/gen"
+	"github.com/aurora-is-near/evm-bully/network"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+// defaultGenChainID is the default chain ID of generated networks (Aurora
+// betanet).
+var defaultGenChainID = big.NewInt(1313161556)
+
+// Gen implements the 'gen' command.
+func Gen(argv0 string, args ...string) error {
+	fs := flag.NewFlagSet(argv0, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -network <name>\n", argv0)
+		fmt.Fprintf(os.Stderr, "Generate synthetic network with dump file from scenario.\n")
+		fs.PrintDefaults()
+	}
+	chainIDStr := fs.String("chainid", defaultGenChainID.String(), "Chain ID the transactions are signed for")
+	codecName := fs.String("codec", "none", "Compression codec for dump file (none, gzip, zstd, snappy)")
+	force := fs.Bool("f", false, "Overwrite existing network")
+	name := fs.String("network", "", "Name of the network to generate (mandatory)")
+	scenario := fs.String("scenario", "mixed",
+		fmt.Sprintf("Scenario JSON file or built-in scenario (%s)", strings.Join(gen.Builtins(), ", ")))
+	seed := fs.Int64("seed", 1, "Seed for accounts and transactions")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	if *name == "" {
+		return errors.New("option -network is mandatory")
+	}
+	chainID, ok := math.ParseBig256(*chainIDStr)
+	if !ok {
+		return fmt.Errorf("cannot parse -chainid value '%s'", *chainIDStr)
+	}
+	codec, err := db.ParseCodec(*codecName)
+	if err != nil {
+		return err
+	}
+	if _, err := network.Lookup(*name); err == nil && !*force {
+		return fmt.Errorf("network '%s' exists already (use -f to overwrite)", *name)
+	}
+	s, err := gen.LoadScenario(*scenario)
+	if err != nil {
+		return err
+	}
+	_, err = gen.Generate(*name, chainID, s, *seed, codec)
+	return err
+}
@@ -21,7 +21,7 @@ func Genesis(argv0 string, args ...string) error {
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
-	_, testnet, err := f.determineTestnet()
+	net, err := f.determineTestnet()
 	if err != nil {
 		return err
 	}
@@ -29,5 +29,5 @@ func Genesis(argv0 string, args ...string) error {
 		fs.Usage()
 		return flag.ErrHelp
 	}
-	return replayer.ProcGenesisBlock(testnet)
+	return replayer.ProcGenesisBlock(net.Genesis)
 }
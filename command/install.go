@@ -0,0 +1,53 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/aurora-is-near/evm-bully/util/aurora"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+// defaultInstallChainID is the default chain ID of installed EVM contracts
+// (Aurora betanet).
+var defaultInstallChainID = big.NewInt(1313161556)
+
+// Install implements the 'install' command.
+func Install(argv0 string, args ...string) error {
+	fs := flag.NewFlagSet(argv0, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s <evmContract> <contract>\n", argv0)
+		fmt.Fprintf(os.Stderr, "Install EVM contract file <contract> to account <evmContract> (which signs it).\n")
+		fs.PrintDefaults()
+	}
+	chainIDStr := fs.String("chainid", defaultInstallChainID.String(), "Chain ID of the EVM contract")
+	owner := fs.String("owner", "", "Owner account of the EVM contract (default: <evmContract>, ignored with -upgrade)")
+	upgrade := fs.Bool("upgrade", false, "Upgrade installed EVM contract (keeps chain ID and owner, ignores -chainid)")
+	cfg := near.GetConfig()
+	registerCfgFlags(fs, cfg, true)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	chainID, ok := math.ParseBig256(*chainIDStr)
+	if !ok {
+		return fmt.Errorf("cannot parse -chainid value '%s'", *chainIDStr)
+	}
+	evmContract := fs.Arg(0)
+	contract := fs.Arg(1)
+	c := near.NewConnection(cfg.NodeURL)
+	a, err := near.LoadAccount(c, cfg, evmContract)
+	if err != nil {
+		return err
+	}
+	if *upgrade {
+		return aurora.Upgrade(a, evmContract, contract)
+	}
+	return aurora.Install(a, evmContract, *owner, chainID, contract)
+}
@@ -7,10 +7,12 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/aurora-is-near/evm-bully/replayer"
-	"github.com/aurora-is-near/evm-bully/util/aurora"
+	"github.com/aurora-is-near/evm-bully/replayer/neard"
 	"github.com/aurora-is-near/near-api-go"
+	"github.com/ethereum/go-ethereum/common/math"
 )
 
 // Replay implements the 'replay' command.
@@ -24,24 +26,38 @@ func Replay(argv0 string, args ...string) error {
 	}
 	autobreak := fs.Bool("autobreak", false, "Automatically repeat with a break point after an error")
 	accountID := fs.String("accountId", "", "Unique identifier for the account that will be used to sign this call")
+	backend := fs.String("backend", replayer.BackendNeard, "Execute the engine on a NEAR node (neard) or in an embedded wasm runtime (wasm, requires -contract)")
 	batch := fs.Bool("batch", false, "Batch transactions")
 	batchSize := fs.Int("size", 10, "Batch size when batching transactions")
 	breakBlock := fs.Int("breakblock", -1, "Break replaying at this block height")
 	breakTx := fs.Int("breaktx", 0, "Break replaying at this transaction (in block given by -breakblock)")
+	breakpointMode := fs.String("breakpoint-mode", replayer.BreakpointModeFull, "Save breakpoint as copy of neard data (full) or as snapshot of engine state (state)")
+	chainIDStr := fs.String("chainid", "", "Chain ID of the EVM contract (default: chain ID of network, e.g., 1313161556 for Aurora)")
+	concurrency := fs.Int("concurrency", 1, "Number of submit calls in flight (pipelined mode, if > 1)")
 	contract := fs.String("contract", "", "EVM contract file to deploy")
 	dataDir := fs.String("datadir", defaultDataDir, "Data directory containing the database to read")
 	defrost := fs.Bool("defrost", false, "Defrost the database first")
+	eventLog := fs.String("events", "", "Write JSONL event log to this file")
+	filter := fs.String("filter", "", "Only replay transactions matching filter (e.g., from=<address>,selector=<hex>)")
+	filterDeps := fs.Bool("filter-deps", false, "Also replay transitive dependencies of filtered transactions")
 	gas := fs.Uint64("gas", defaultGas, "Max amount of gas a call can use (in gas units)")
 	initialBalance := fs.String("initial-balance", defaultInitialBalance, "Number of tokens to transfer to newly created account")
 	release := fs.Bool("release", false, "Run release version of neard (instead of debug version)")
+	resume := fs.Bool("resume", false, "Resume after the last confirmed transaction in the checkpoint journal")
 	setup := fs.Bool("setup", false, "Setup and run neard before replaying (auto-deploys contract)")
+	metricsAddr := fs.String("metrics-addr", "", "Serve Prometheus metrics on this address (e.g., localhost:9100)")
 	neardPath := fs.String("neard", "", "Path to neard binary (won't build neard if -setup is provided)")
-	neardHead := fs.String("neardhead", "", "Git hash of neard (required if -neard is provided)")
-	auroraCliPath := fs.String("auroracli", "aurora", "Path (or alias) to aurora-cli")
+	neardProfile := fs.String("neard-profile", neard.DefaultProfile,
+		fmt.Sprintf("neard profile YAML/JSON file or built-in profile (%s)", strings.Join(neard.ProfileBuiltins(), ", ")))
+	neardHead := fs.String("neardhead", "", "Git hash of neard (required if -neard is provided, recorded in journal and breakpoint)")
+	nodes := fs.Int("nodes", 1, "Number of validator nodes of localnet started with -setup")
+	signers := fs.String("signers", "", "Comma-separated NEAR accounts signing submit calls in pipelined mode (created automatically with -setup)")
+	shards := fs.Int("shards", 1, "Number of shards of localnet started with -setup")
 	skip := fs.Bool("skip", false, "Skip empty blocks during replay")
 	startBlock := fs.Int("startblock", 0, "Start replaying at this block height")
 	startTx := fs.Int("starttx", 0, "Start replaying at this transaction (in block given by -startblock)")
 	timeout := fs.Duration("timeout", 0, "Timeout for JSON-RPC client")
+	verify := fs.Bool("verify", false, "Compare submit results with Ethereum receipts (requires dump with receipts)")
 	cfg := near.GetConfig()
 	registerCfgFlags(fs, cfg, true)
 	testnetFlags.registerFlags(fs)
@@ -51,7 +67,24 @@ func Replay(argv0 string, args ...string) error {
 	if !*setup && *initialBalance != defaultInitialBalance {
 		return errors.New("option -initial-balance requires -setup")
 	}
-	if !*setup && *accountID == "" {
+	wasm := *backend == replayer.BackendWasm
+	if !wasm && *backend != replayer.BackendNeard {
+		return fmt.Errorf("option -backend must be '%s' or '%s': %s",
+			replayer.BackendNeard, replayer.BackendWasm, *backend)
+	}
+	if wasm && *contract == "" {
+		return errors.New("option -backend wasm requires option -contract")
+	}
+	if wasm && (*setup || *neardPath != "") {
+		return errors.New("option -backend wasm excludes options -setup and -neard")
+	}
+	if wasm && (*concurrency > 1 || *signers != "") {
+		return errors.New("option -backend wasm excludes options -concurrency and -signers")
+	}
+	if wasm && (*resume || *autobreak || *breakBlock != -1 || *breakTx != 0) {
+		return errors.New("option -backend wasm excludes options -resume, -autobreak, -breakblock, and -breaktx")
+	}
+	if !*setup && !wasm && *accountID == "" {
 		return errors.New("option -accountId is mandatory")
 	}
 	if *autobreak && *breakBlock != -1 {
@@ -78,23 +111,84 @@ func Replay(argv0 string, args ...string) error {
 	if *startTx != 0 && *breakTx != 0 {
 		return errors.New("options -starttx and -breaktx exclude each other")
 	}
+	if *breakpointMode != replayer.BreakpointModeFull && *breakpointMode != replayer.BreakpointModeState {
+		return fmt.Errorf("option -breakpoint-mode must be '%s' or '%s': %s",
+			replayer.BreakpointModeFull, replayer.BreakpointModeState, *breakpointMode)
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("option -concurrency must be positive: %d", *concurrency)
+	}
+	if *concurrency > 1 && *batch {
+		return errors.New("options -concurrency and -batch exclude each other")
+	}
+	if *concurrency > 1 && *signers == "" && !*setup {
+		return errors.New("option -concurrency requires option -signers (or -setup)")
+	}
+	if *signers != "" && *concurrency == 1 {
+		return errors.New("option -signers requires option -concurrency")
+	}
+	if *resume && *setup {
+		return errors.New("options -resume and -setup exclude each other")
+	}
+	if *resume && *autobreak {
+		return errors.New("options -resume and -autobreak exclude each other")
+	}
+	if *resume && (*startBlock != 0 || *startTx != 0) {
+		return errors.New("option -resume excludes options -startblock and -starttx")
+	}
+	if *verify && *batch {
+		return errors.New("options -verify and -batch exclude each other")
+	}
 	if *release && !*setup {
 		return errors.New("option -release requires option -setup")
 	}
 	if *setup && *contract == "" {
 		return errors.New("option -setup requires option -contract")
 	}
-	if *contract != "" && !*setup {
-		return errors.New("option -contract requires option -setup")
+	if *contract != "" && !*setup && !wasm {
+		return errors.New("option -contract requires option -setup (or -backend wasm)")
 	}
 	if *neardPath != "" && *neardHead == "" {
 		return errors.New("option -neard requires option -neardhead")
 	}
-	chainID, testnet, err := testnetFlags.determineTestnet()
+	if *nodes < 1 || *shards < 1 {
+		return fmt.Errorf("options -nodes and -shards must be positive: %d, %d", *nodes, *shards)
+	}
+	if (*nodes != 1 || *shards != 1) && !*setup {
+		return errors.New("options -nodes and -shards require option -setup")
+	}
+	if *neardProfile != neard.DefaultProfile && !*setup {
+		return errors.New("option -neard-profile requires option -setup")
+	}
+	profile, err := neard.LoadProfile(*neardProfile)
 	if err != nil {
 		return err
 	}
-	if !*setup {
+	if *filterDeps && *filter == "" {
+		return errors.New("option -filter-deps requires option -filter")
+	}
+	var txFilter *replayer.TxFilter
+	if *filter != "" {
+		f, err := replayer.ParseTxFilter(*filter)
+		if err != nil {
+			return err
+		}
+		f.Dependencies = *filterDeps
+		txFilter = f
+	}
+	net, err := testnetFlags.determineTestnet()
+	if err != nil {
+		return err
+	}
+	chainID := net.ChainID()
+	if *chainIDStr != "" {
+		id, ok := math.ParseBig256(*chainIDStr)
+		if !ok {
+			return fmt.Errorf("cannot parse -chainid value '%s'", *chainIDStr)
+		}
+		chainID = id
+	}
+	if !*setup && !wasm {
 		if fs.NArg() != 1 {
 			fs.Usage()
 			return flag.ErrHelp
@@ -106,8 +200,6 @@ func Replay(argv0 string, args ...string) error {
 		}
 	}
 
-	aurora.SetAuroraCliPath(*auroraCliPath)
-
 	// determine evmContract
 	var evmContract string
 	if fs.NArg() == 1 {
@@ -117,7 +209,7 @@ func Replay(argv0 string, args ...string) error {
 		if _, err := rand.Read(b[:]); err != nil {
 			return err
 		}
-		evmContract = hex.EncodeToString(b[:]) + ".test.near"
+		evmContract = hex.EncodeToString(b[:]) + "." + neard.MasterAccount(*nodes, *shards)
 		fmt.Fprintf(os.Stderr, "evmContract name generated: %s\n", evmContract)
 	}
 
@@ -133,22 +225,35 @@ func Replay(argv0 string, args ...string) error {
 		ChainID:        chainID,
 		Gas:            *gas,
 		DataDir:        *dataDir,
-		Testnet:        testnet,
+		Testnet:        net.Name,
+		Genesis:        net.Genesis,
 		Defrost:        *defrost,
 		Skip:           *skip,
 		Batch:          *batch,
+		Verify:         *verify,
 		BatchSize:      *batchSize,
+		Concurrency:    *concurrency,
+		Signers:        splitSigners(*signers),
 		StartBlock:     *startBlock,
 		StartTx:        *startTx,
 		Autobreak:      *autobreak,
+		BreakpointMode: *breakpointMode,
+		Filter:         txFilter,
 		BreakBlock:     *breakBlock,
 		BreakTx:        *breakTx,
 		Release:        *release,
 		Setup:          *setup,
 		NeardPath:      *neardPath,
 		NeardHead:      *neardHead,
+		NeardProfile:   profile,
+		Nodes:          *nodes,
+		Shards:         *shards,
 		InitialBalance: *initialBalance,
 		Contract:       *contract,
+		Resume:         *resume,
+		MetricsAddr:    *metricsAddr,
+		EventLog:       *eventLog,
+		Backend:        *backend,
 		Breakpoint: replayer.Breakpoint{
 			AccountID: *accountID,
 		},
@@ -13,8 +13,8 @@ import (
 func ReplayTx(argv0 string, args ...string) error {
 	fs := flag.NewFlagSet(argv0, flag.ContinueOnError)
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s <breakpointDir>\n", argv0)
-		fmt.Fprintf(os.Stderr, "Replay transaction from breakpoint directory.\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s <breakpoint>\n", argv0)
+		fmt.Fprintf(os.Stderr, "Replay transaction from breakpoint directory or archive (.tar.gz).\n")
 		fs.PrintDefaults()
 	}
 	build := fs.Bool("build", false, "Build nearcore and aurora-engine before replaying tx")
@@ -30,6 +30,6 @@ func ReplayTx(argv0 string, args ...string) error {
 		fs.Usage()
 		return flag.ErrHelp
 	}
-	breakpointDir := fs.Arg(0)
-	return replayer.ReplayTx(breakpointDir, *build, *contract, *release, *gas)
+	breakpoint := fs.Arg(0)
+	return replayer.ReplayTx(breakpoint, *build, *contract, *release, *gas)
 }
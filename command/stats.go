@@ -1,6 +1,7 @@
 package command
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -17,24 +18,31 @@ func Stats(argv0 string, args ...string) error {
 		fmt.Fprintf(os.Stderr, "Calculate testnet statistics.\n")
 		fs.PrintDefaults()
 	}
-	block := fs.Uint64("block", defaultGoerliBlockHeight, "Block height")
+	block := fs.Uint64("block", 0, "Block height (default of network, if unset)")
 	dataDir := fs.String("datadir", defaultDataDir, "Data directory containing the database to read")
 	defrost := fs.Bool("defrost", false, "Defrost the database first")
 	dump := fs.Bool("dump", false, "Use dump file instead of database")
-	hash := fs.String("hash", defaultGoerliBlockHash, "Block hash")
+	hash := fs.String("hash", "", "Block hash (default of network, if -block is unset)")
 	f.registerFlags(fs)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
-	_, testnet, err := f.determineTestnet()
+	net, err := f.determineTestnet()
 	if err != nil {
 		return err
 	}
-	adjustBlockDefaults(block, hash, testnet)
 	if fs.NArg() != 0 {
 		fs.Usage()
 		return flag.ErrHelp
 	}
+	if !*dump {
+		if err := adjustBlockDefaults(block, hash, net); err != nil {
+			return err
+		}
+		if *hash == "" {
+			return errors.New("option -hash is mandatory (unless -dump is used)")
+		}
+	}
 	// calculate statistics
-	return replayer.CalcStats(*dataDir, testnet, *block, *hash, *defrost, *dump)
+	return replayer.CalcStats(*dataDir, net.Name, *block, *hash, *defrost, *dump)
 }
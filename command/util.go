@@ -4,7 +4,9 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"strings"
 
+	"github.com/aurora-is-near/evm-bully/network"
 	"github.com/aurora-is-near/near-api-go"
 )
 
@@ -19,52 +21,82 @@ type testnetFlags struct {
 	goerli  bool
 	rinkeby bool
 	ropsten bool
+	network string
+	genesis string
 }
 
 func (f *testnetFlags) registerFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&f.goerli, "goerli", false, "Use the Görli testnet")
 	fs.BoolVar(&f.rinkeby, "rinkeby", false, "Use the Rinkeby testnet")
 	fs.BoolVar(&f.ropsten, "ropsten", false, "Use the Ropsten testnet")
+	fs.StringVar(&f.network, "network", "", "Use the network with the given name (built-in or defined with -genesis)")
+	fs.StringVar(&f.genesis, "genesis", "", "Define network from genesis JSON file (name given by -network or file name)")
 }
 
-func (f *testnetFlags) determineTestnet() (chainID uint8, testnet string, err error) {
-	if !f.goerli && !f.rinkeby && !f.ropsten {
-		return 0, "", errors.New("one of the options -goerli, -rinkeby, or -ropsten is mandatory")
+func (f *testnetFlags) determineTestnet() (*network.Network, error) {
+	var names []string
+	if f.goerli {
+		names = append(names, "goerli")
 	}
-	if f.goerli && f.rinkeby {
-		return 0, "", errors.New("the options -goerli and -rinkeby exclude each other")
+	if f.rinkeby {
+		names = append(names, "rinkeby")
 	}
-	if f.goerli && f.ropsten {
-		return 0, "", errors.New("the options -goerli and -ropsten exclude each other")
+	if f.ropsten {
+		names = append(names, "ropsten")
 	}
-	if f.rinkeby && f.ropsten {
-		return 0, "", errors.New("the options -rinkeby and -ropsten exclude each other")
+	if len(names) > 1 {
+		return nil, fmt.Errorf("the options -%s exclude each other", strings.Join(names, " and -"))
 	}
-	if f.rinkeby {
-		return 4, "rinkeby", nil
-	} else if f.ropsten {
-		return 3, "ropsten", nil
+	if len(names) == 1 && (f.network != "" || f.genesis != "") {
+		return nil, fmt.Errorf("option -%s excludes options -network and -genesis", names[0])
 	}
-	// use Görli as the default
-	return 5, "goerli", nil
-}
-
-func adjustBlockDefaults(block *uint64, hash *string, testnet string) {
-	switch testnet {
-	case "rinkeby":
-		if *block == defaultGoerliBlockHeight && *hash == defaultGoerliBlockHash {
-			fmt.Printf("changing -block value to %d\n", defaultRinkebyBlockHeight)
-			*block = defaultRinkebyBlockHeight
-			fmt.Printf("changing -hash value to %s\n", defaultRinkebyBlockHash)
-			*hash = defaultRinkebyBlockHash
+	if len(names) == 1 {
+		return network.Lookup(names[0])
+	}
+	if f.genesis != "" {
+		// define (or redefine) network from genesis file
+		n, err := network.LoadGenesis(f.genesis, f.network)
+		if err != nil {
+			return nil, err
 		}
-	case "ropsten":
-		if *block == defaultGoerliBlockHeight && *hash == defaultGoerliBlockHash {
-			fmt.Printf("changing -block value to %d\n", defaultRopstenBlockHeight)
-			*block = defaultRopstenBlockHeight
-			fmt.Printf("changing -hash value to %s\n", defaultRopstenBlockHash)
-			*hash = defaultRopstenBlockHash
+		if err := n.Save(); err != nil {
+			return nil, err
 		}
+		fmt.Printf("network '%s' defined (chain ID %s)\n", n.Name, n.ChainID())
+		return n, nil
+	}
+	if f.network != "" {
+		return network.Lookup(f.network)
+	}
+	return nil, errors.New("one of the options -goerli, -rinkeby, -ropsten, -network, or -genesis is mandatory")
+}
 
+// adjustBlockDefaults sets block and hash to the defaults of network n, if
+// they are unset.
+func adjustBlockDefaults(block *uint64, hash *string, n *network.Network) error {
+	if *block != 0 {
+		return nil
+	}
+	if n.Block == 0 {
+		return fmt.Errorf("network '%s' has no default block height, option -block is mandatory", n.Name)
+	}
+	fmt.Printf("using -block value %d\n", n.Block)
+	*block = n.Block
+	if *hash == "" && n.Hash != "" {
+		fmt.Printf("using -hash value %s\n", n.Hash)
+		*hash = n.Hash
+	}
+	return nil
+}
+
+// splitSigners splits the comma-separated list of signers.
+func splitSigners(signers string) []string {
+	if signers == "" {
+		return nil
+	}
+	var ids []string
+	for _, id := range strings.Split(signers, ",") {
+		ids = append(ids, strings.TrimSpace(id))
 	}
+	return ids
 }
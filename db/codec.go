@@ -0,0 +1,90 @@
+package db
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec defines the compression codec used for the chunks of a dump file.
+type Codec uint8
+
+// Supported compression codecs.
+const (
+	CodecNone Codec = iota
+	CodecGzip
+	CodecZstd
+	CodecSnappy
+)
+
+var codecNames = map[Codec]string{
+	CodecNone:   "none",
+	CodecGzip:   "gzip",
+	CodecZstd:   "zstd",
+	CodecSnappy: "snappy",
+}
+
+// ParseCodec returns the Codec with the given name.
+func ParseCodec(name string) (Codec, error) {
+	for codec, n := range codecNames {
+		if n == name {
+			return codec, nil
+		}
+	}
+	return CodecNone, fmt.Errorf("db: unknown codec '%s'", name)
+}
+
+// String returns the name of codec c.
+func (c Codec) String() string {
+	name, ok := codecNames[c]
+	if !ok {
+		return fmt.Sprintf("codec(%d)", c)
+	}
+	return name
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newWriter returns a compressing writer for codec c which writes to w.
+// Closing the returned writer flushes it, but doesn't close w.
+func (c Codec) newWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CodecNone:
+		return nopWriteCloser{w}, nil
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+	case CodecZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case CodecSnappy:
+		return snappy.NewBufferedWriter(w), nil
+	default:
+		return nil, fmt.Errorf("db: unsupported codec %s", c)
+	}
+}
+
+// newReader returns a decompressing reader for codec c which reads from r.
+func (c Codec) newReader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CodecNone:
+		return io.NopCloser(r), nil
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case CodecSnappy:
+		return io.NopCloser(snappy.NewReader(r)), nil
+	default:
+		return nil, fmt.Errorf("db: unsupported codec %s", c)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/aurora-is-near/evm-bully/util"
 	"github.com/aurora-is-near/evm-bully/util/hashcache"
@@ -16,6 +17,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/frankbraun/codechain/util/file"
 )
 
@@ -37,6 +39,24 @@ type Transaction struct {
 	To       *common.Address
 	Value    *big.Int
 	Data     []byte
+	Receipt  *Receipt // nil, if the dump contains no receipts
+}
+
+// Receipt defines the Ethereum receipt of a transaction.
+type Receipt struct {
+	PostState         []byte // only set for pre-Byzantium receipts (Status is undefined then)
+	Status            uint64
+	GasUsed           uint64
+	CumulativeGasUsed uint64
+	ContractAddress   *common.Address // address of created contract (nil, if none)
+	Logs              []*Log
+}
+
+// Log defines an Ethereum log entry.
+type Log struct {
+	Address common.Address
+	Topics  []common.Hash
+	Data    []byte
 }
 
 // traverse blockchain backwards starting at block b with given blockHeight
@@ -149,14 +169,53 @@ func readTx(tx *types.Transaction) (*Transaction, error) {
 	return &encTx, nil
 }
 
+func readReceipt(tx *types.Transaction, r *types.Receipt) (*Receipt, error) {
+	if r.TxHash != tx.Hash() {
+		return nil, fmt.Errorf("db: receipt for tx %s has tx hash %s",
+			tx.Hash().Hex(), r.TxHash.Hex())
+	}
+	encReceipt := Receipt{
+		PostState:         r.PostState,
+		Status:            r.Status,
+		GasUsed:           r.GasUsed,
+		CumulativeGasUsed: r.CumulativeGasUsed,
+	}
+	if tx.To() == nil {
+		addr := r.ContractAddress
+		encReceipt.ContractAddress = &addr
+	}
+	for _, l := range r.Logs {
+		encReceipt.Logs = append(encReceipt.Logs, &Log{
+			Address: l.Address,
+			Topics:  l.Topics,
+			Data:    l.Data,
+		})
+	}
+	return &encReceipt, nil
+}
+
+// readChainConfig reads the chain config for the chain with the given
+// genesis hash from db.
+func readChainConfig(db ethdb.Database, genesis common.Hash) (*params.ChainConfig, error) {
+	config := rawdb.ReadChainConfig(db, genesis)
+	if config == nil {
+		return nil, fmt.Errorf("db: cannot read chain config for genesis %s", genesis.Hex())
+	}
+	return config, nil
+}
+
 // Dump dumps the Ethereum database for the given testnet stored in dataDir
 // up to blockHeight with given blockHash into the evm-bully cache directory:
 //  ~/.config/evm-bully/tetstnet/dbdump.
+// The blocks are compressed with the given codec. If receipts is true, the
+// transaction receipts are dumped as well.
 func Dump(
 	dataDir, testnet string,
 	blockHeight uint64,
 	blockHash string,
 	defrost bool,
+	codec Codec,
+	receipts bool,
 ) error {
 	// determine cache directory
 	cacheDir, err := util.DetermineCacheDir(testnet)
@@ -186,49 +245,98 @@ func Dump(
 	}()
 
 	// open dump file
-	fp, err := os.Create(dumpFile)
+	w, err := NewWriter(dumpFile, codec)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		fp.Close()
-		log.Info(fmt.Sprintf("'%s' written", dumpFile))
-	}()
-	/*
-		gw := gzip.NewWriter(fp)
-		defer func() {
-			gw.Close()
-			log.Info("gzip writer closed")
-		}()
-	*/
-	enc := gob.NewEncoder(fp)
 
 	// read DB
-	for blockHeight, blockHash := range blocks {
-		// read block from DB
-		b := rawdb.ReadBlock(db, blockHash, uint64(blockHeight))
-		if b == nil {
-			return fmt.Errorf("cannot read block at height %d with hash %s",
+	if err := dumpBlocks(db, blocks, w, receipts); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("'%s' written", dumpFile))
+	return nil
+}
+
+// readBlock reads the block with given blockHash at blockHeight from db.
+// If config is not nil, the transaction receipts are read as well.
+func readBlock(
+	db ethdb.Database,
+	config *params.ChainConfig,
+	blockHash common.Hash,
+	blockHeight uint64,
+) (*Block, error) {
+	b := rawdb.ReadBlock(db, blockHash, blockHeight)
+	if b == nil {
+		return nil, fmt.Errorf("cannot read block at height %d with hash %s",
+			blockHeight, blockHash.Hex())
+	}
+	var receipts types.Receipts
+	if config != nil && len(b.Transactions()) > 0 {
+		receipts = rawdb.ReadReceipts(db, blockHash, blockHeight, config)
+		if len(receipts) != len(b.Transactions()) {
+			return nil, fmt.Errorf("cannot read receipts for block at height %d with hash %s",
 				blockHeight, blockHash.Hex())
 		}
+	}
+	return EncodeBlock(b, receipts)
+}
 
-		// transactions
-		var encBlock Block
-		encBlock.Header = b.Header()
-		encBlock.Coinbase = b.Coinbase()
-		encBlock.Time = b.Time()
-		encBlock.Hash = b.Hash()
-		if len(b.Transactions()) > 0 {
-			for _, tx := range b.Transactions() {
-				encTx, err := readTx(tx)
+// EncodeBlock converts the Ethereum block b into a Block. If receipts is not
+// nil, it must contain the receipts for all transactions in b.
+func EncodeBlock(b *types.Block, receipts types.Receipts) (*Block, error) {
+	var encBlock Block
+	encBlock.Header = b.Header()
+	encBlock.Coinbase = b.Coinbase()
+	encBlock.Time = b.Time()
+	encBlock.Hash = b.Hash()
+	if len(b.Transactions()) > 0 {
+		for i, tx := range b.Transactions() {
+			encTx, err := readTx(tx)
+			if err != nil {
+				return nil, err
+			}
+			if receipts != nil {
+				encTx.Receipt, err = readReceipt(tx, receipts[i])
 				if err != nil {
-					return err
+					return nil, err
 				}
-				encBlock.Transactions = append(encBlock.Transactions, encTx)
 			}
+			encBlock.Transactions = append(encBlock.Transactions, encTx)
+		}
+	}
+	return &encBlock, nil
+}
+
+// dumpBlocks reads the blocks from db, starting at the height of the next
+// block to be written by w, and writes them to w (with receipts, if
+// requested).
+func dumpBlocks(
+	db ethdb.Database,
+	blocks []common.Hash,
+	w *Writer,
+	receipts bool,
+) error {
+	var (
+		config *params.ChainConfig
+		err    error
+	)
+	if receipts {
+		config, err = readChainConfig(db, blocks[0])
+		if err != nil {
+			return err
 		}
-		// save block
-		if err := enc.Encode(encBlock); err != nil {
+	}
+	for blockHeight := w.Height(); blockHeight < uint64(len(blocks)); blockHeight++ {
+		b, err := readBlock(db, config, blocks[blockHeight], blockHeight)
+		if err != nil {
+			return err
+		}
+		if err := w.Write(b); err != nil {
 			return err
 		}
 		log.Info(fmt.Sprintf("block %d/%d written", blockHeight, len(blocks)))
@@ -236,10 +344,133 @@ func Dump(
 	return nil
 }
 
+// Append extends the existing dump of the given testnet in the evm-bully
+// cache directory with the blocks from the Ethereum database stored in
+// dataDir up to blockHeight with given blockHash.
+// The tip of the existing dump must be part of the chain leading to blockHash.
+// If receipts is true, the transaction receipts of the new blocks are dumped
+// as well.
+func Append(
+	dataDir, testnet string,
+	blockHeight uint64,
+	blockHash string,
+	defrost bool,
+	receipts bool,
+) error {
+	// determine cache directory
+	cacheDir, err := util.DetermineCacheDir(testnet)
+	if err != nil {
+		return err
+	}
+
+	// check dump file
+	dumpFile := filepath.Join(cacheDir, "dump.db")
+	exists, err := file.Exists(dumpFile)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("db: file '%s' doesn't exist", dumpFile)
+	}
+
+	// read tip of existing dump
+	tip, tipHeight, err := readTip(dumpFile)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("tip of dump at height %d with hash %s", tipHeight,
+		tip.Hash.Hex()))
+	if blockHeight <= tipHeight {
+		return fmt.Errorf("db: dump already contains block %d (tip is at height %d)",
+			blockHeight, tipHeight)
+	}
+
+	// open database
+	db, blocks, err := Open(dataDir, testnet, cacheDir, blockHeight,
+		blockHash, defrost)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		log.Info("closing DB")
+		db.Close()
+	}()
+
+	// check tip against hash cache
+	if blocks[tipHeight] != tip.Hash {
+		return fmt.Errorf("db: tip of dump at height %d has hash %s, but chain has %s",
+			tipHeight, tip.Hash.Hex(), blocks[tipHeight].Hex())
+	}
+
+	// check that the first new block extends the tip
+	first, err := readBlock(db, nil, blocks[tipHeight+1], tipHeight+1)
+	if err != nil {
+		return err
+	}
+	if first.Header.ParentHash != tip.Hash {
+		return fmt.Errorf("db: parent hash %s of block %d doesn't match tip of dump %s",
+			first.Header.ParentHash.Hex(), tipHeight+1, tip.Hash.Hex())
+	}
+
+	// open dump file for appending
+	w, err := OpenWriter(dumpFile)
+	if err != nil {
+		return err
+	}
+	if w.Height() != tipHeight+1 {
+		w.abort()
+		return fmt.Errorf("db: dump contains %d blocks, but tip is at height %d",
+			w.Height(), tipHeight)
+	}
+
+	// read DB
+	if err := dumpBlocks(db, blocks, w, receipts); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("'%s' extended to height %d", dumpFile, blockHeight))
+	return nil
+}
+
+// readTip returns the last block stored in the dump file with the given
+// filename and its height.
+func readTip(filename string) (*Block, uint64, error) {
+	r, err := OpenReader(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	if r.legacy {
+		return nil, 0, fmt.Errorf("db: cannot append to legacy dump '%s'", filename)
+	}
+	if len(r.index) == 0 {
+		return nil, 0, fmt.Errorf("db: dump '%s' is empty", filename)
+	}
+	last := r.index[len(r.index)-1]
+	height := last.Height + uint64(last.NumBlocks) - 1
+	if err := r.Seek(height); err != nil {
+		return nil, 0, err
+	}
+	b, err := r.Next()
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, height, nil
+}
+
 // Reader implments a DB dump reader.
 type Reader struct {
-	fp  *os.File
-	dec *gob.Decoder
+	fp     *os.File
+	codec  Codec
+	zr     io.ReadCloser // decompressor for current chunk
+	dec    *gob.Decoder
+	index  []chunkIndex // chunk index (nil for legacy dumps)
+	chunk  int          // current chunk
+	height uint64       // height of the block returned by the next call to Next
+	legacy bool         // legacy dump without header and index
 }
 
 // NewReader returns a new DB dump reader for the given testnet.
@@ -259,36 +490,159 @@ func NewReader(testnet string) (*Reader, error) {
 	if !exists {
 		return nil, fmt.Errorf("db: file '%s' doesn't exist", dumpFile)
 	}
+	return OpenReader(dumpFile)
+}
 
-	// open reader
-	var r Reader
-	r.fp, err = os.Open(dumpFile)
+// OpenReader returns a new DB dump reader for the dump file with the given
+// filename.
+func OpenReader(filename string) (*Reader, error) {
+	var (
+		r   Reader
+		err error
+	)
+	r.fp, err = os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	/*
-		gr, err := gzip.NewReader(r.fp)
-		if err != nil {
+	ok, codec, err := readHeader(r.fp)
+	if err != nil {
+		r.fp.Close()
+		return nil, err
+	}
+	if !ok {
+		// legacy dump: one gob stream without index
+		log.Info(fmt.Sprintf("'%s' is a legacy dump without index", filename))
+		r.legacy = true
+		if err := r.rewind(); err != nil {
+			r.fp.Close()
 			return nil, err
 		}
-	*/
-	r.dec = gob.NewDecoder(r.fp)
+		return &r, nil
+	}
+	r.codec = codec
+	log.Info(fmt.Sprintf("'%s' uses codec %s", filename, codec))
+	fi, err := r.fp.Stat()
+	if err != nil {
+		r.fp.Close()
+		return nil, err
+	}
+	var end int64
+	r.index, end, err = lastIndex(r.fp, fi.Size())
+	if err != nil {
+		r.fp.Close()
+		return nil, err
+	}
+	if end < fi.Size() {
+		log.Warn(fmt.Sprintf("'%s' ends with %d bytes of an interrupted append (ignored)",
+			filename, fi.Size()-end))
+	}
 	return &r, nil
 }
 
+// rewind resets a legacy reader to the start of the dump file.
+func (r *Reader) rewind() error {
+	if _, err := r.fp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r.dec = gob.NewDecoder(r.fp)
+	r.height = 0
+	return nil
+}
+
+// openChunk prepares the reader to decode the chunk with index i.
+func (r *Reader) openChunk(i int) error {
+	ci := r.index[i]
+	r.closeChunk()
+	zr, err := r.codec.newReader(io.NewSectionReader(r.fp, ci.Offset, ci.Length))
+	if err != nil {
+		return err
+	}
+	r.zr = zr
+	r.dec = gob.NewDecoder(zr)
+	r.chunk = i
+	r.height = ci.Height
+	return nil
+}
+
+// closeChunk releases the decompressor of the current chunk, if any.
+func (r *Reader) closeChunk() {
+	if r.zr != nil {
+		r.zr.Close()
+		r.zr = nil
+	}
+	r.dec = nil
+}
+
+// Height returns the height of the block returned by the next call to Next.
+func (r *Reader) Height() uint64 {
+	return r.height
+}
+
 // Next returns the next Block for the given reader or nil.
 func (r *Reader) Next() (*Block, error) {
-	var b Block
-	if err := r.dec.Decode(&b); err != nil {
-		if err == io.EOF {
-			return nil, nil
+	for {
+		if r.dec == nil {
+			if r.chunk >= len(r.index) {
+				return nil, nil
+			}
+			if err := r.openChunk(r.chunk); err != nil {
+				return nil, err
+			}
+		}
+		var b Block
+		if err := r.dec.Decode(&b); err != nil {
+			if err == io.EOF {
+				if r.legacy {
+					return nil, nil
+				}
+				// continue with next chunk
+				r.closeChunk()
+				r.chunk++
+				continue
+			}
+			return nil, err
+		}
+		r.height++
+		return &b, nil
+	}
+}
+
+// Seek positions the reader such that the next call to Next returns the
+// block with the given height.
+func (r *Reader) Seek(height uint64) error {
+	if r.legacy {
+		// legacy dumps can only be read sequentially
+		if height < r.height {
+			if err := r.rewind(); err != nil {
+				return err
+			}
+		}
+	} else {
+		i := sort.Search(len(r.index), func(i int) bool {
+			return r.index[i].Height+uint64(r.index[i].NumBlocks) > height
+		})
+		if i == len(r.index) {
+			return fmt.Errorf("db: block %d not in dump", height)
+		}
+		if err := r.openChunk(i); err != nil {
+			return err
 		}
-		return nil, err
 	}
-	return &b, nil
+	for r.height < height {
+		var b Block
+		if err := r.dec.Decode(&b); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("db: block %d not in dump", height)
+			}
+			return err
+		}
+		r.height++
+	}
+	return nil
 }
 
 // Close closes the reader.
 func (r *Reader) Close() error {
+	r.closeChunk()
 	return r.fp.Close()
 }
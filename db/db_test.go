@@ -0,0 +1,487 @@
+package db
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+func testBlock(height uint64) *Block {
+	return &Block{
+		Header: &types.Header{
+			Number:     new(big.Int).SetUint64(height),
+			Difficulty: big.NewInt(1),
+		},
+		Time: height,
+	}
+}
+
+func writeTestDump(t *testing.T, n uint64, codec Codec) string {
+	filename := filepath.Join(t.TempDir(), "dump.db")
+	w, err := NewWriter(filename, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < n; i++ {
+		if err := w.Write(testBlock(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func expectBlock(t *testing.T, r *Reader, height uint64) {
+	b, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatalf("expected block %d, got end of dump", height)
+	}
+	if b.Header.Number.Uint64() != height {
+		t.Fatalf("expected block %d, got %d", height, b.Header.Number.Uint64())
+	}
+}
+
+func TestReaderNext(t *testing.T) {
+	n := uint64(2*blocksPerChunk + 17)
+	r, err := OpenReader(writeTestDump(t, n, CodecNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := uint64(0); i < n; i++ {
+		expectBlock(t, r, i)
+	}
+	b, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != nil {
+		t.Fatalf("expected end of dump, got block %d", b.Header.Number.Uint64())
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	n := uint64(3*blocksPerChunk + 5)
+	r, err := OpenReader(writeTestDump(t, n, CodecNone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, height := range []uint64{2500, 0, blocksPerChunk - 1, blocksPerChunk, n - 1} {
+		if err := r.Seek(height); err != nil {
+			t.Fatal(err)
+		}
+		if r.Height() != height {
+			t.Fatalf("Height() = %d, expected %d", r.Height(), height)
+		}
+		expectBlock(t, r, height)
+		if height+1 < n {
+			expectBlock(t, r, height+1)
+		}
+	}
+	if err := r.Seek(n); err == nil {
+		t.Fatalf("Seek(%d) should fail", n)
+	}
+}
+
+func TestReaderCodecs(t *testing.T) {
+	n := uint64(blocksPerChunk + 3)
+	for _, codec := range []Codec{CodecGzip, CodecZstd, CodecSnappy} {
+		r, err := OpenReader(writeTestDump(t, n, codec))
+		if err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		if r.codec != codec {
+			t.Fatalf("codec %s detected, expected %s", r.codec, codec)
+		}
+		expectBlock(t, r, 0)
+		if err := r.Seek(n - 2); err != nil {
+			t.Fatalf("%s: %v", codec, err)
+		}
+		expectBlock(t, r, n-2)
+		expectBlock(t, r, n-1)
+		r.Close()
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	for codec, name := range codecNames {
+		c, err := ParseCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != codec {
+			t.Fatalf("ParseCodec(%s) = %s", name, c)
+		}
+	}
+	if _, err := ParseCodec("lzma"); err == nil {
+		t.Fatal("ParseCodec(lzma) should fail")
+	}
+}
+
+func TestWriterAppend(t *testing.T) {
+	n := uint64(blocksPerChunk + 500)
+	filename := writeTestDump(t, n, CodecGzip)
+	w, err := OpenWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Height() != n {
+		t.Fatalf("Height() = %d, expected %d", w.Height(), n)
+	}
+	m := n + blocksPerChunk
+	for i := n; i < m; i++ {
+		if err := w.Write(testBlock(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := uint64(0); i < m; i++ {
+		expectBlock(t, r, i)
+	}
+	if err := r.Seek(n); err != nil {
+		t.Fatal(err)
+	}
+	expectBlock(t, r, n)
+}
+
+func TestWriterAppendAbort(t *testing.T) {
+	n := uint64(10)
+	filename := writeTestDump(t, n, CodecNone)
+	w, err := OpenWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := n; i < 2*n; i++ {
+		if err := w.Write(testBlock(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.abort()
+	tip, height, err := readTip(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != n-1 || tip.Header.Number.Uint64() != n-1 {
+		t.Fatalf("tip at height %d, expected %d", height, n-1)
+	}
+}
+
+func TestWriterAppendInterrupted(t *testing.T) {
+	n := uint64(blocksPerChunk + 10)
+	filename := writeTestDump(t, n, CodecGzip)
+	w, err := OpenWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := n; i < n+blocksPerChunk+10; i++ {
+		if err := w.Write(testBlock(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// simulate crash before Close (new chunks without index)
+	if err := w.suspend(); err != nil {
+		t.Fatal(err)
+	}
+	tip, height, err := readTip(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != n-1 || tip.Header.Number.Uint64() != n-1 {
+		t.Fatalf("tip at height %d, expected %d", height, n-1)
+	}
+	// appending again discards the interrupted append
+	w, err = OpenWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Height() != n {
+		t.Fatalf("Height() = %d, expected %d", w.Height(), n)
+	}
+	if err := w.Write(testBlock(n)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := uint64(0); i <= n; i++ {
+		expectBlock(t, r, i)
+	}
+	if b, err := r.Next(); err != nil || b != nil {
+		t.Fatalf("Next() = %v, %v after last block", b, err)
+	}
+}
+
+func TestReaderLegacy(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.db")
+	fp, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := gob.NewEncoder(fp)
+	for i := uint64(0); i < 10; i++ {
+		if err := enc.Encode(testBlock(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fp.Close()
+	r, err := OpenReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Seek(7); err != nil {
+		t.Fatal(err)
+	}
+	expectBlock(t, r, 7)
+	if err := r.Seek(3); err != nil {
+		t.Fatal(err)
+	}
+	expectBlock(t, r, 3)
+}
+
+func TestIncompleteDump(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.db")
+	w, err := NewWriter(filename, CodecNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testBlock(0)); err != nil {
+		t.Fatal(err)
+	}
+	// simulate interrupted dump
+	w.abort()
+	if _, err := OpenReader(filename); err != ErrIncompleteDump {
+		t.Fatalf("expected ErrIncompleteDump, got %v", err)
+	}
+}
+
+func TestReadReceipt(t *testing.T) {
+	to := common.Address{1}
+	created := common.Address{2}
+	call := types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1), nil)
+	create := types.NewContractCreation(1, big.NewInt(0), 100000, big.NewInt(1), []byte{0x60})
+	logs := []*types.Log{
+		{Address: to, Topics: []common.Hash{{3}, {4}}, Data: []byte{5}},
+		{Address: created},
+	}
+	postState := common.Hash{6}.Bytes()
+	tests := []struct {
+		name     string
+		tx       *types.Transaction
+		receipt  types.Receipt
+		expected Receipt
+	}{
+		{
+			name:     "success",
+			tx:       call,
+			receipt:  types.Receipt{Status: types.ReceiptStatusSuccessful, GasUsed: 21000, CumulativeGasUsed: 42000},
+			expected: Receipt{Status: 1, GasUsed: 21000, CumulativeGasUsed: 42000},
+		},
+		{
+			name:     "failure",
+			tx:       call,
+			receipt:  types.Receipt{Status: types.ReceiptStatusFailed, GasUsed: 21000},
+			expected: Receipt{Status: 0, GasUsed: 21000},
+		},
+		{
+			name:     "pre-Byzantium",
+			tx:       call,
+			receipt:  types.Receipt{PostState: postState, GasUsed: 21000},
+			expected: Receipt{PostState: postState, GasUsed: 21000},
+		},
+		{
+			name:    "logs",
+			tx:      call,
+			receipt: types.Receipt{Status: types.ReceiptStatusSuccessful, Logs: logs},
+			expected: Receipt{Status: 1, Logs: []*Log{
+				{Address: to, Topics: []common.Hash{{3}, {4}}, Data: []byte{5}},
+				{Address: created},
+			}},
+		},
+		{
+			name:     "contract creation",
+			tx:       create,
+			receipt:  types.Receipt{Status: types.ReceiptStatusSuccessful, ContractAddress: created},
+			expected: Receipt{Status: 1, ContractAddress: &created},
+		},
+		{
+			// a contract address in the receipt of a call is ignored
+			name:     "call with contract address",
+			tx:       call,
+			receipt:  types.Receipt{Status: types.ReceiptStatusSuccessful, ContractAddress: created},
+			expected: Receipt{Status: 1},
+		},
+	}
+	for _, test := range tests {
+		test.receipt.TxHash = test.tx.Hash()
+		r, err := readReceipt(test.tx, &test.receipt)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*r, test.expected) {
+			t.Errorf("%s: readReceipt() = %+v, expected %+v", test.name, *r, test.expected)
+		}
+	}
+	// receipt of another transaction
+	if _, err := readReceipt(call, &types.Receipt{TxHash: create.Hash()}); err == nil {
+		t.Error("readReceipt() should fail for receipt of another transaction")
+	}
+}
+
+func TestEncodeBlockReceipts(t *testing.T) {
+	to := common.Address{1}
+	created := common.Address{2}
+	txs := []*types.Transaction{
+		types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1), nil),
+		types.NewContractCreation(1, big.NewInt(0), 100000, big.NewInt(1), []byte{0x60}),
+	}
+	receipts := types.Receipts{
+		{Status: types.ReceiptStatusSuccessful, TxHash: txs[0].Hash(), GasUsed: 21000},
+		{Status: types.ReceiptStatusSuccessful, TxHash: txs[1].Hash(), GasUsed: 53000, ContractAddress: created},
+	}
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+	eb := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+
+	// without receipts
+	b, err := EncodeBlock(eb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tx := range b.Transactions {
+		if tx.Receipt != nil {
+			t.Errorf("transaction %d has receipt", i)
+		}
+	}
+
+	// with receipts (encoded and decoded like in a dump)
+	b, err = EncodeBlock(eb, receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(b); err != nil {
+		t.Fatal(err)
+	}
+	var dec Block
+	if err := gob.NewDecoder(&buf).Decode(&dec); err != nil {
+		t.Fatal(err)
+	}
+	if len(dec.Transactions) != 2 {
+		t.Fatalf("block has %d transactions, expected 2", len(dec.Transactions))
+	}
+	if r := dec.Transactions[0].Receipt; r == nil || r.GasUsed != 21000 || r.ContractAddress != nil {
+		t.Errorf("wrong receipt of call: %+v", r)
+	}
+	if r := dec.Transactions[1].Receipt; r == nil || r.ContractAddress == nil || *r.ContractAddress != created {
+		t.Errorf("wrong receipt of contract creation: %+v", r)
+	}
+
+	// receipts of another block
+	if _, err := EncodeBlock(eb, types.Receipts{receipts[1], receipts[0]}); err == nil {
+		t.Error("EncodeBlock() should fail for receipts in wrong order")
+	}
+}
+
+func TestReadBlockReceipts(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	config := params.AllEthashProtocolChanges
+	signer := types.LatestSigner(config)
+	to := common.Address{1}
+	var txs []*types.Transaction
+	for _, tx := range []*types.Transaction{
+		types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1), nil),
+		types.NewContractCreation(1, big.NewInt(0), 100000, big.NewInt(1), []byte{0x60}),
+	} {
+		stx, err := types.SignTx(tx, signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, stx)
+	}
+	receipts := types.Receipts{
+		{Status: types.ReceiptStatusSuccessful, GasUsed: 21000, CumulativeGasUsed: 21000,
+			Logs: []*types.Log{{Address: to, Topics: []common.Hash{{2}}, Data: []byte{3}}}},
+		{Status: types.ReceiptStatusFailed, GasUsed: 53000, CumulativeGasUsed: 74000},
+	}
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+	eb := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+	db := rawdb.NewMemoryDatabase()
+	rawdb.WriteBlock(db, eb)
+	rawdb.WriteReceipts(db, eb.Hash(), 1, receipts)
+
+	// without config no receipts are read
+	b, err := readBlock(db, nil, eb.Hash(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Transactions[0].Receipt != nil {
+		t.Error("readBlock() without config read receipts")
+	}
+
+	b, err = readBlock(db, config, eb.Hash(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Receipt{
+		{Status: 1, GasUsed: 21000, CumulativeGasUsed: 21000,
+			Logs: []*Log{{Address: to, Topics: []common.Hash{{2}}, Data: []byte{3}}}},
+		{Status: 0, GasUsed: 53000, CumulativeGasUsed: 74000,
+			ContractAddress: func() *common.Address {
+				addr := crypto.CreateAddress(sender, 1)
+				return &addr
+			}()},
+	}
+	for i, tx := range b.Transactions {
+		if tx.Receipt == nil {
+			t.Errorf("transaction %d has no receipt", i)
+			continue
+		}
+		if !reflect.DeepEqual(*tx.Receipt, expected[i]) {
+			t.Errorf("transaction %d: receipt %+v, expected %+v", i, *tx.Receipt, expected[i])
+		}
+	}
+
+	// missing receipts
+	if _, err := readBlock(rawdb.NewMemoryDatabase(), config, eb.Hash(), 1); err == nil {
+		t.Error("readBlock() should fail for missing block")
+	}
+	db = rawdb.NewMemoryDatabase()
+	rawdb.WriteBlock(db, eb)
+	if _, err := readBlock(db, config, eb.Hash(), 1); err == nil {
+		t.Error("readBlock() should fail for missing receipts")
+	}
+}
@@ -0,0 +1,489 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Dump file layout (version 2):
+//
+//	header:  magic "EVMBDUMP" | version (uint32) | codec (uint8)
+//	chunks:  one independent gob stream per chunk of up to blocksPerChunk blocks,
+//	         each compressed separately with codec
+//	index:   gob encoded []chunkIndex
+//	trailer: index offset (uint64) | magic "EVMBINDX"
+//
+// All integers are big-endian. Every chunk can be decoded on its own, which
+// allows the Reader to seek to an arbitrary block height by consulting the
+// index and skipping at most blocksPerChunk-1 blocks.
+//
+// Appending to a dump writes the new chunks after the previous trailer, which
+// stays in place until the new index and trailer are written on close. If
+// appending is interrupted, the dump is read with the last complete index.
+//
+// Version 1 dumps have the same layout, but no codec in the header (their
+// chunks are uncompressed).
+const (
+	dumpMagic      = "EVMBDUMP"
+	indexMagic     = "EVMBINDX"
+	dumpVersion    = 2
+	headerSize     = len(dumpMagic) + 4 + 1
+	trailerSize    = 8 + len(indexMagic)
+	blocksPerChunk = 1000
+)
+
+// ErrIncompleteDump is returned if a dump file has no index (e.g., because
+// the dump was interrupted).
+var ErrIncompleteDump = errors.New("db: dump file has no index (incomplete dump?)")
+
+// chunkIndex describes a single chunk of blocks in a dump file.
+type chunkIndex struct {
+	Height    uint64 // height of the first block in the chunk
+	Offset    int64  // file offset of the chunk
+	Length    int64  // length of the chunk in bytes
+	NumBlocks int    // number of blocks in the chunk
+}
+
+func writeHeader(w io.Writer, codec Codec) error {
+	var buf [headerSize]byte
+	copy(buf[:], dumpMagic)
+	binary.BigEndian.PutUint32(buf[len(dumpMagic):], dumpVersion)
+	buf[headerSize-1] = byte(codec)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// readHeader reads the dump header from r and returns the codec used for the
+// chunks. It returns false, if r doesn't start with a dump header (legacy
+// format).
+func readHeader(r io.Reader) (bool, Codec, error) {
+	var buf [headerSize - 1]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, CodecNone, nil
+		}
+		return false, CodecNone, err
+	}
+	if string(buf[:len(dumpMagic)]) != dumpMagic {
+		return false, CodecNone, nil
+	}
+	version := binary.BigEndian.Uint32(buf[len(dumpMagic):])
+	switch version {
+	case 1:
+		return true, CodecNone, nil
+	case dumpVersion:
+		var codec [1]byte
+		if _, err := io.ReadFull(r, codec[:]); err != nil {
+			return false, CodecNone, err
+		}
+		if _, ok := codecNames[Codec(codec[0])]; !ok {
+			return false, CodecNone, fmt.Errorf("db: unsupported codec %d", codec[0])
+		}
+		return true, Codec(codec[0]), nil
+	default:
+		return false, CodecNone, fmt.Errorf("db: unsupported dump version %d", version)
+	}
+}
+
+// readIndex reads the chunk index from the end of the dump file fp with the
+// given size and returns it together with the offset where the index starts.
+func readIndex(fp *os.File, size int64) ([]chunkIndex, int64, error) {
+	if size < int64(headerSize-1+trailerSize) {
+		return nil, 0, ErrIncompleteDump
+	}
+	var buf [trailerSize]byte
+	if _, err := fp.ReadAt(buf[:], size-int64(trailerSize)); err != nil {
+		return nil, 0, err
+	}
+	if string(buf[8:]) != indexMagic {
+		return nil, 0, ErrIncompleteDump
+	}
+	indexOffset := int64(binary.BigEndian.Uint64(buf[:8]))
+	if indexOffset < int64(headerSize-1) || indexOffset > size-int64(trailerSize) {
+		return nil, 0, fmt.Errorf("db: index offset %d out of range", indexOffset)
+	}
+	sr := io.NewSectionReader(fp, indexOffset, size-int64(trailerSize)-indexOffset)
+	var index []chunkIndex
+	if err := gob.NewDecoder(sr).Decode(&index); err != nil {
+		return nil, 0, err
+	}
+	return index, indexOffset, nil
+}
+
+// validIndex returns true, if all chunks of index lie between the header and
+// indexOffset.
+func validIndex(index []chunkIndex, indexOffset int64) bool {
+	for _, ci := range index {
+		if ci.Offset < int64(headerSize-1) || ci.Length <= 0 || ci.NumBlocks <= 0 ||
+			ci.Offset+ci.Length > indexOffset {
+			return false
+		}
+	}
+	return true
+}
+
+// recoverIndex searches the dump file fp with the given size backwards for
+// the last complete trailer and returns its index together with the offset
+// where the trailer ends.
+func recoverIndex(fp *os.File, size int64) ([]chunkIndex, int64, error) {
+	const window = 1 << 20
+	magic := []byte(indexMagic)
+	for end := size; end > int64(headerSize-1); end -= window {
+		start := end - window
+		if start < 0 {
+			start = 0
+		}
+		// overlap with the previous window to find magics crossing its start
+		hi := end + int64(len(magic)-1)
+		if hi > size {
+			hi = size
+		}
+		buf := make([]byte, hi-start)
+		if _, err := fp.ReadAt(buf, start); err != nil {
+			return nil, 0, err
+		}
+		for i := len(buf); ; {
+			j := bytes.LastIndex(buf[:i], magic)
+			if j < 0 {
+				break
+			}
+			trailerEnd := start + int64(j+len(magic))
+			index, indexOffset, err := readIndex(fp, trailerEnd)
+			if err == nil && validIndex(index, indexOffset) {
+				return index, trailerEnd, nil
+			}
+			i = j + len(magic) - 1
+		}
+	}
+	return nil, 0, ErrIncompleteDump
+}
+
+// lastIndex returns the chunk index of the dump file fp with the given size
+// and the offset where its trailer ends. If fp doesn't end with a trailer
+// (e.g., because appending was interrupted), the last complete index is
+// returned and the data after its trailer has to be ignored.
+func lastIndex(fp *os.File, size int64) ([]chunkIndex, int64, error) {
+	index, _, err := readIndex(fp, size)
+	if err != ErrIncompleteDump {
+		return index, size, err
+	}
+	return recoverIndex(fp, size)
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Writer writes blocks to a dump file.
+type Writer struct {
+	fp     *os.File
+	bw     *bufio.Writer
+	cw     *countingWriter
+	codec  Codec
+	cz     io.WriteCloser // compressor for current chunk
+	enc    *gob.Encoder
+	height uint64
+	tip    common.Hash // hash of the last block written
+	index  []chunkIndex
+	// the following fields are only used when appending to an existing dump
+	appending  bool
+	base       int64 // end of the previous trailer
+	baseChunks int   // number of chunks in the previous index
+}
+
+// NewWriter creates a new dump file with the given filename and returns a
+// Writer for it which compresses blocks with codec.
+func NewWriter(filename string, codec Codec) (*Writer, error) {
+	if _, ok := codecNames[codec]; !ok {
+		return nil, fmt.Errorf("db: unsupported codec %d", codec)
+	}
+	fp, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{fp: fp, codec: codec}
+	w.bw = bufio.NewWriter(fp)
+	w.cw = &countingWriter{w: w.bw}
+	if err := writeHeader(w.cw, codec); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// OpenWriter opens the existing dump file with the given filename and
+// returns a Writer which appends to it. The codec is taken from the existing
+// dump.
+func OpenWriter(filename string) (*Writer, error) {
+	fp, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	ok, codec, err := readHeader(fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if !ok {
+		fp.Close()
+		return nil, fmt.Errorf("db: cannot append to legacy dump '%s'", filename)
+	}
+	fi, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	index, end, err := lastIndex(fp, fi.Size())
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	var tip common.Hash
+	if len(index) > 0 {
+		b, _, err := readTip(filename)
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		tip = b.Hash
+	}
+	// new chunks are written after the previous trailer (the data of an
+	// interrupted append is discarded)
+	if end < fi.Size() {
+		log.Warn(fmt.Sprintf("db: discarding %d bytes of interrupted append to '%s'",
+			fi.Size()-end, filename))
+		if err := fp.Truncate(end); err != nil {
+			fp.Close()
+			return nil, err
+		}
+	}
+	if _, err := fp.Seek(end, io.SeekStart); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	w := &Writer{
+		fp:         fp,
+		codec:      codec,
+		tip:        tip,
+		index:      index,
+		appending:  true,
+		base:       end,
+		baseChunks: len(index),
+	}
+	if len(index) > 0 {
+		last := index[len(index)-1]
+		w.height = last.Height + uint64(last.NumBlocks)
+	}
+	w.bw = bufio.NewWriter(fp)
+	w.cw = &countingWriter{w: w.bw, n: end}
+	return w, nil
+}
+
+// Height returns the height of the next block to be written.
+func (w *Writer) Height() uint64 {
+	return w.height
+}
+
+// Write writes the next block b to the dump file.
+func (w *Writer) Write(b *Block) error {
+	if w.enc == nil {
+		// start new chunk
+		cz, err := w.codec.newWriter(w.cw)
+		if err != nil {
+			return err
+		}
+		w.index = append(w.index, chunkIndex{
+			Height: w.height,
+			Offset: w.cw.n,
+		})
+		w.cz = cz
+		w.enc = gob.NewEncoder(cz)
+	}
+	if err := w.enc.Encode(b); err != nil {
+		return err
+	}
+	w.height++
+	w.tip = b.Hash
+	ci := &w.index[len(w.index)-1]
+	ci.NumBlocks++
+	if ci.NumBlocks == blocksPerChunk {
+		return w.finishChunk()
+	}
+	return nil
+}
+
+func (w *Writer) finishChunk() error {
+	if w.enc == nil {
+		return nil
+	}
+	if err := w.cz.Close(); err != nil {
+		return err
+	}
+	ci := &w.index[len(w.index)-1]
+	ci.Length = w.cw.n - ci.Offset
+	w.cz = nil
+	w.enc = nil
+	return nil
+}
+
+// resumeState records the state of a Writer at a chunk boundary, which
+// allows to resume an interrupted dump.
+type resumeState struct {
+	Codec  Codec
+	Offset int64       // end of the last complete chunk
+	Tip    common.Hash // hash of the last block in the last complete chunk
+	Index  []chunkIndex
+}
+
+// checkpoint saves the state of the writer to resumeFile, if the writer is at
+// a chunk boundary. Otherwise it does nothing.
+func (w *Writer) checkpoint(resumeFile string) error {
+	if w.enc != nil {
+		return nil
+	}
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if err := w.fp.Sync(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&resumeState{
+		Codec:  w.codec,
+		Offset: w.cw.n,
+		Tip:    w.tip,
+		Index:  w.index,
+	})
+	if err != nil {
+		return err
+	}
+	tmpFile := resumeFile + ".tmp"
+	if err := os.WriteFile(tmpFile, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, resumeFile)
+}
+
+// resumeWriter reopens the incomplete dump file with the given filename and
+// returns a Writer which continues after the last checkpoint saved in
+// resumeFile.
+func resumeWriter(filename, resumeFile string) (*Writer, error) {
+	data, err := os.ReadFile(resumeFile)
+	if err != nil {
+		return nil, err
+	}
+	var rs resumeState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rs); err != nil {
+		return nil, err
+	}
+	fp, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	ok, codec, err := readHeader(fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if !ok || codec != rs.Codec {
+		fp.Close()
+		return nil, fmt.Errorf("db: '%s' doesn't match resume file '%s'", filename, resumeFile)
+	}
+	// discard everything after the last checkpoint
+	if err := fp.Truncate(rs.Offset); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if _, err := fp.Seek(rs.Offset, io.SeekStart); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	w := &Writer{
+		fp:    fp,
+		codec: codec,
+		tip:   rs.Tip,
+		index: rs.Index,
+	}
+	if len(rs.Index) > 0 {
+		last := rs.Index[len(rs.Index)-1]
+		w.height = last.Height + uint64(last.NumBlocks)
+	}
+	w.bw = bufio.NewWriter(fp)
+	w.cw = &countingWriter{w: w.bw, n: rs.Offset}
+	return w, nil
+}
+
+// suspend closes the dump file without writing the index. The dump can be
+// resumed from the last checkpoint.
+func (w *Writer) suspend() error {
+	if err := w.bw.Flush(); err != nil {
+		w.fp.Close()
+		return err
+	}
+	return w.fp.Close()
+}
+
+// abort closes the dump file without writing the index. When appending, the
+// dump is restored to its previous state instead.
+func (w *Writer) abort() {
+	defer w.fp.Close()
+	if !w.appending {
+		w.bw.Flush()
+		return
+	}
+	// discard new chunks (the previous index and trailer are still intact)
+	w.bw.Reset(w.fp)
+	if err := w.fp.Truncate(w.base); err != nil {
+		log.Error(fmt.Sprintf("db: cannot restore dump: %s", err))
+		return
+	}
+	w.cw.n = w.base
+	w.index = w.index[:w.baseChunks]
+}
+
+// writeIndex writes the index and the trailer and flushes the dump file.
+func (w *Writer) writeIndex() error {
+	indexOffset := w.cw.n
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(w.index); err != nil {
+		return err
+	}
+	var trailer [trailerSize]byte
+	binary.BigEndian.PutUint64(trailer[:8], uint64(indexOffset))
+	copy(trailer[8:], indexMagic)
+	buf.Write(trailer[:])
+	if _, err := w.cw.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return w.bw.Flush()
+}
+
+// Close writes the index and closes the dump file.
+func (w *Writer) Close() error {
+	if err := w.finishChunk(); err != nil {
+		w.fp.Close()
+		return err
+	}
+	if err := w.writeIndex(); err != nil {
+		w.fp.Close()
+		return err
+	}
+	return w.fp.Close()
+}
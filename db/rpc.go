@@ -0,0 +1,298 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/frankbraun/codechain/util/file"
+)
+
+const fetchRetries = 5
+
+// fetchRetryWait is the time to wait before the first retry of a failed
+// fetch (it grows linearly with the number of attempts).
+var fetchRetryWait = time.Second
+
+type fetchJob struct {
+	height uint64
+	res    chan *fetchResult
+}
+
+type fetchResult struct {
+	block *Block
+	err   error
+}
+
+// fetchBlockOnce fetches the block at height (and its receipts, if
+// requested) via client.
+func fetchBlockOnce(
+	ctx context.Context,
+	client *ethclient.Client,
+	height uint64,
+	receipts bool,
+) (*Block, error) {
+	b, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(height))
+	if err != nil {
+		return nil, err
+	}
+	var rs types.Receipts
+	if receipts && len(b.Transactions()) > 0 {
+		for _, tx := range b.Transactions() {
+			r, err := client.TransactionReceipt(ctx, tx.Hash())
+			if err != nil {
+				return nil, err
+			}
+			rs = append(rs, r)
+		}
+	}
+	return EncodeBlock(b, rs)
+}
+
+// fetchBlock fetches the block at height via client and retries on failure.
+func fetchBlock(
+	ctx context.Context,
+	client *ethclient.Client,
+	height uint64,
+	receipts bool,
+) (*Block, error) {
+	for attempt := 1; ; attempt++ {
+		b, err := fetchBlockOnce(ctx, client, height, receipts)
+		if err == nil {
+			return b, nil
+		}
+		if attempt == fetchRetries || ctx.Err() != nil {
+			return nil, fmt.Errorf("db: cannot fetch block %d: %s", height, err)
+		}
+		log.Info(fmt.Sprintf("fetching block %d failed (attempt %d): %s", height,
+			attempt, err))
+		time.Sleep(time.Duration(attempt) * fetchRetryWait)
+	}
+}
+
+// openRPCWriter returns a Writer for dumpFile which resumes an interrupted
+// dump (if resumeFile exists), extends an existing dump, or starts a new
+// dump with the given codec.
+func openRPCWriter(dumpFile, resumeFile string, codec Codec) (*Writer, error) {
+	exists, err := file.Exists(resumeFile)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		log.Info(fmt.Sprintf("resume '%s'", dumpFile))
+		return resumeWriter(dumpFile, resumeFile)
+	}
+	exists, err = file.Exists(dumpFile)
+	if err != nil {
+		return nil, err
+	}
+	var w *Writer
+	if exists {
+		log.Info(fmt.Sprintf("extend '%s'", dumpFile))
+		w, err = OpenWriter(dumpFile)
+	} else {
+		w, err = NewWriter(dumpFile, codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// make sure the dump can be resumed from the start
+	if err := w.checkpoint(resumeFile); err != nil {
+		w.suspend()
+		return nil, err
+	}
+	return w, nil
+}
+
+// checkGenesis makes sure the chain served via client has the given genesis
+// block hash.
+func checkGenesis(ctx context.Context, client *ethclient.Client, genesis common.Hash) error {
+	h, err := client.HeaderByNumber(ctx, big.NewInt(0))
+	if err != nil {
+		return fmt.Errorf("db: cannot fetch genesis block: %s", err)
+	}
+	if h.Hash() != genesis {
+		return fmt.Errorf("db: endpoint serves chain with genesis block %s, expected %s",
+			h.Hash().Hex(), genesis.Hex())
+	}
+	return nil
+}
+
+// dumpComplete reports whether dumpFile is a complete dump up to blockHeight
+// (with blockHash, if not empty).
+func dumpComplete(dumpFile, resumeFile string, blockHeight uint64, blockHash string) (bool, error) {
+	for _, filename := range []string{resumeFile, dumpFile} {
+		exists, err := file.Exists(filename)
+		if err != nil {
+			return false, err
+		}
+		if exists != (filename == dumpFile) {
+			return false, nil // interrupted dump or no dump
+		}
+	}
+	tip, height, err := readTip(dumpFile)
+	if err != nil || height != blockHeight {
+		return false, err
+	}
+	if blockHash != "" && tip.Hash != common.HexToHash(blockHash) {
+		return false, fmt.Errorf("db: block %d has hash %s, expected %s", height,
+			tip.Hash.Hex(), blockHash)
+	}
+	return true, nil
+}
+
+// dumpRPC dumps the blocks fetched via client into dumpFile (see DumpRPC).
+func dumpRPC(
+	client *ethclient.Client,
+	dumpFile string,
+	genesis common.Hash,
+	blockHeight uint64,
+	blockHash string,
+	workers int,
+	codec Codec,
+	receipts bool,
+) error {
+	if workers < 1 {
+		return fmt.Errorf("db: number of workers must be positive: %d", workers)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := checkGenesis(ctx, client, genesis); err != nil {
+		return err
+	}
+	resumeFile := dumpFile + ".resume"
+	complete, err := dumpComplete(dumpFile, resumeFile, blockHeight, blockHash)
+	if err != nil {
+		return err
+	}
+	if complete {
+		log.Info(fmt.Sprintf("'%s' already contains block %d", dumpFile, blockHeight))
+		return nil
+	}
+	w, err := openRPCWriter(dumpFile, resumeFile, codec)
+	if err != nil {
+		return err
+	}
+	if w.codec != codec {
+		log.Info(fmt.Sprintf("using codec %s of existing dump", w.codec))
+	}
+	if w.Height() > blockHeight+1 {
+		w.suspend()
+		return fmt.Errorf("db: dump already contains %d blocks", w.Height())
+	}
+
+	// start workers
+	jobs := make(chan fetchJob)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range jobs {
+				b, err := fetchBlock(ctx, client, job.height, receipts)
+				job.res <- &fetchResult{block: b, err: err}
+			}
+		}()
+	}
+
+	// schedule jobs, the order channel preserves the block order
+	order := make(chan chan *fetchResult, 4*workers)
+	go func() {
+		defer close(order)
+		defer close(jobs)
+		for height := w.Height(); height <= blockHeight; height++ {
+			res := make(chan *fetchResult, 1)
+			select {
+			case order <- res:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- fetchJob{height: height, res: res}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// write blocks in order
+	for res := range order {
+		r := <-res
+		height := w.Height()
+		if r.err != nil {
+			w.suspend()
+			return r.err
+		}
+		if r.block.Header.ParentHash != w.tip {
+			w.suspend()
+			return fmt.Errorf("db: parent hash %s of block %d doesn't match previous block %s",
+				r.block.Header.ParentHash.Hex(), height, w.tip.Hex())
+		}
+		if height == blockHeight && blockHash != "" &&
+			r.block.Hash != common.HexToHash(blockHash) {
+			w.suspend()
+			return fmt.Errorf("db: block %d has hash %s, expected %s", height,
+				r.block.Hash.Hex(), blockHash)
+		}
+		if err := w.Write(r.block); err != nil {
+			w.suspend()
+			return err
+		}
+		if err := w.checkpoint(resumeFile); err != nil {
+			w.suspend()
+			return err
+		}
+		log.Info(fmt.Sprintf("block %d/%d written", height, blockHeight))
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Remove(resumeFile)
+}
+
+// DumpRPC dumps the Ethereum chain served by the JSON-RPC endpoint at url up
+// to blockHeight with given blockHash (can be empty) into the evm-bully cache
+// directory of the given testnet. The blocks are fetched concurrently by the
+// given number of workers and compressed with codec. If receipts is true, the
+// transaction receipts are dumped as well.
+//
+// The genesis block of the endpoint must have the hash genesis of the testnet.
+// An interrupted dump is resumed from the last complete chunk and an existing
+// dump is extended (in both cases with the codec of the existing dump). A
+// dump which already contains blockHeight is left unchanged.
+func DumpRPC(
+	url, testnet string,
+	genesis common.Hash,
+	blockHeight uint64,
+	blockHash string,
+	workers int,
+	codec Codec,
+	receipts bool,
+) error {
+	// determine cache directory
+	cacheDir, err := util.DetermineCacheDir(testnet)
+	if err != nil {
+		return err
+	}
+	dumpFile := filepath.Join(cacheDir, "dump.db")
+
+	// connect to endpoint
+	log.Info(fmt.Sprintf("connecting to '%s'", url))
+	client, err := ethclient.Dial(url)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := dumpRPC(client, dumpFile, genesis, blockHeight, blockHash, workers,
+		codec, receipts); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("'%s' written", dumpFile))
+	return nil
+}
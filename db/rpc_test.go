@@ -0,0 +1,221 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// fakeEth implements the parts of the eth JSON-RPC namespace used by DumpRPC.
+type fakeEth struct {
+	mu       sync.Mutex
+	blocks   []*types.Block
+	receipts map[common.Hash]*types.Receipt
+	failAt   uint64 // fail requests for this block height (if not zero)
+}
+
+func newFakeEth(n int) *fakeEth {
+	f := &fakeEth{receipts: make(map[common.Hash]*types.Receipt)}
+	parent := common.Hash{}
+	to := common.HexToAddress("0x01")
+	for i := 0; i < n; i++ {
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(1),
+			GasLimit:   8000000,
+			Time:       uint64(i),
+		}
+		var txs []*types.Transaction
+		var receipts []*types.Receipt
+		if i%10 == 1 {
+			tx := types.NewTransaction(uint64(i), to, big.NewInt(1), 21000, big.NewInt(1), nil)
+			txs = append(txs, tx)
+			receipts = append(receipts, &types.Receipt{
+				Status:            types.ReceiptStatusSuccessful,
+				CumulativeGasUsed: 21000,
+				GasUsed:           21000,
+				TxHash:            tx.Hash(),
+				Logs:              []*types.Log{},
+			})
+		}
+		b := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+		for _, r := range receipts {
+			f.receipts[r.TxHash] = r
+		}
+		f.blocks = append(f.blocks, b)
+		parent = b.Hash()
+	}
+	return f
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	jsn, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(jsn, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (f *fakeEth) GetBlockByNumber(number rpc.BlockNumber, full bool) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failAt != 0 && uint64(number) == f.failAt {
+		return nil, errors.New("unavailable")
+	}
+	if number < 0 || int(number) >= len(f.blocks) {
+		return nil, nil
+	}
+	b := f.blocks[number]
+	m, err := toMap(b.Header())
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]interface{}, 0, len(b.Transactions()))
+	for _, tx := range b.Transactions() {
+		tm, err := toMap(tx)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tm)
+	}
+	m["transactions"] = txs
+	m["uncles"] = []interface{}{}
+	return m, nil
+}
+
+func (f *fakeEth) GetTransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	return f.receipts[hash], nil
+}
+
+func startFakeEth(t *testing.T, f *fakeEth) *ethclient.Client {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("eth", f); err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(srv)
+	t.Cleanup(hs.Close)
+	client, err := ethclient.Dial(hs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func checkRPCDump(t *testing.T, filename string, f *fakeEth, n int) {
+	r, err := OpenReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := 0; i < n; i++ {
+		b, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == nil || b.Hash != f.blocks[i].Hash() {
+			t.Fatalf("block %d missing or wrong", i)
+		}
+		if len(b.Transactions) != len(f.blocks[i].Transactions()) {
+			t.Fatalf("block %d: wrong number of transactions", i)
+		}
+		for _, tx := range b.Transactions {
+			if tx.Receipt == nil || tx.Receipt.GasUsed != 21000 {
+				t.Fatalf("block %d: receipt missing", i)
+			}
+		}
+	}
+	if b, err := r.Next(); b != nil || err != nil {
+		t.Fatalf("expected end of dump after %d blocks", n)
+	}
+}
+
+func TestDumpRPC(t *testing.T) {
+	n := blocksPerChunk + 200
+	f := newFakeEth(n)
+	client := startFakeEth(t, f)
+	filename := filepath.Join(t.TempDir(), "dump.db")
+
+	// dump first half
+	half := n / 2
+	if err := dumpRPC(client, filename, f.blocks[0].Hash(), uint64(half-1), "", 4, CodecSnappy, true); err != nil {
+		t.Fatal(err)
+	}
+	checkRPCDump(t, filename, f, half)
+
+	// extend to full chain
+	hash := f.blocks[n-1].Hash().Hex()
+	if err := dumpRPC(client, filename, f.blocks[0].Hash(), uint64(n-1), hash, 4, CodecSnappy, true); err != nil {
+		t.Fatal(err)
+	}
+	checkRPCDump(t, filename, f, n)
+
+	// a complete dump is not touched
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dumpRPC(client, filename, f.blocks[0].Hash(), uint64(n-1), hash, 4, CodecSnappy, true); err != nil {
+		t.Fatal(err)
+	}
+	if data2, err := os.ReadFile(filename); err != nil || !bytes.Equal(data2, data) {
+		t.Errorf("dump changed without new blocks (%v)", err)
+	}
+}
+
+func TestDumpRPCWrongChain(t *testing.T) {
+	f := newFakeEth(10)
+	client := startFakeEth(t, f)
+	filename := filepath.Join(t.TempDir(), "dump.db")
+	err := dumpRPC(client, filename, common.Hash{1}, 9, "", 4, CodecNone, true)
+	if err == nil {
+		t.Fatal("dumpRPC() should fail for wrong genesis block")
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("dump written for wrong chain (%v)", err)
+	}
+}
+
+func TestDumpRPCResume(t *testing.T) {
+	fetchRetryWait = time.Millisecond
+	n := 2*blocksPerChunk + 10
+	f := newFakeEth(n)
+	f.failAt = blocksPerChunk + 5
+	client := startFakeEth(t, f)
+	filename := filepath.Join(t.TempDir(), "dump.db")
+
+	// interrupted dump
+	if err := dumpRPC(client, filename, f.blocks[0].Hash(), uint64(n-1), "", 3, CodecZstd, true); err == nil {
+		t.Fatal("dump should fail")
+	}
+	if _, err := OpenReader(filename); err != ErrIncompleteDump {
+		t.Fatalf("expected ErrIncompleteDump, got %v", err)
+	}
+
+	// resume
+	f.mu.Lock()
+	f.failAt = 0
+	f.mu.Unlock()
+	if err := dumpRPC(client, filename, f.blocks[0].Hash(), uint64(n-1), "", 3, CodecZstd, true); err != nil {
+		t.Fatal(err)
+	}
+	checkRPCDump(t, filename, f, n)
+}
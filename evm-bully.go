@@ -22,8 +22,11 @@ func usage() {
 	cmd := os.Args[0] + " [-v]"
 	fmt.Fprintf(os.Stderr, "Usage: %s genesis\n", cmd)
 	fmt.Fprintf(os.Stderr, "       %s dumpdb\n", cmd)
+	fmt.Fprintf(os.Stderr, "       %s gen -network <name>\n", cmd)
 	fmt.Fprintf(os.Stderr, "       %s replay <evmContract>\n", cmd)
-	fmt.Fprintf(os.Stderr, "       %s replay-tx <breakpointDir>\n", cmd)
+	fmt.Fprintf(os.Stderr, "       %s replay-tx <breakpoint>\n", cmd)
+	fmt.Fprintf(os.Stderr, "       %s bisect -good <commit> -bad <commit> <breakpoint>\n", cmd)
+	fmt.Fprintf(os.Stderr, "       %s install <evmContract> <contract>\n", cmd)
 	fmt.Fprintf(os.Stderr, "       %s create-account <accountId>\n", cmd)
 	fmt.Fprintf(os.Stderr, "       %s block\n", cmd)
 	fmt.Fprintf(os.Stderr, "       %s state <accountId>\n", cmd)
@@ -64,10 +67,16 @@ func main() {
 		err = command.Genesis(argv0, args...)
 	case "dumpdb":
 		err = command.DumpDB(argv0, args...)
+	case "gen":
+		err = command.Gen(argv0, args...)
 	case "replay":
 		err = command.Replay(argv0, args...)
 	case "replay-tx":
 		err = command.ReplayTx(argv0, args...)
+	case "bisect":
+		err = command.Bisect(argv0, args...)
+	case "install":
+		err = command.Install(argv0, args...)
 	case "create-account":
 		err = command.CreateAccount(argv0, args...)
 	case "block":
@@ -0,0 +1,118 @@
+package gen
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// An assembler assembles EVM bytecode. Jump targets are referenced by label
+// and resolved by code.
+type assembler struct {
+	buf    []byte
+	labels map[string]int
+	refs   map[int]string // offset of 2-byte push operand -> label
+}
+
+func newAssembler() *assembler {
+	return &assembler{
+		labels: make(map[string]int),
+		refs:   make(map[int]string),
+	}
+}
+
+// op appends the given opcodes.
+func (a *assembler) op(ops ...vm.OpCode) *assembler {
+	for _, op := range ops {
+		a.buf = append(a.buf, byte(op))
+	}
+	return a
+}
+
+// push appends the shortest push of v, which is an uint64, a []byte (at most
+// 32 bytes), a common.Address, or a common.Hash.
+func (a *assembler) push(v interface{}) *assembler {
+	var b []byte
+	switch v := v.(type) {
+	case uint64:
+		b = new(big.Int).SetUint64(v).Bytes()
+	case int:
+		b = big.NewInt(int64(v)).Bytes()
+	case []byte:
+		b = v
+	case common.Address:
+		b = v.Bytes()
+	case common.Hash:
+		b = v.Bytes()
+	default:
+		panic(fmt.Sprintf("gen: cannot push %T", v))
+	}
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+	if len(b) > 32 {
+		panic("gen: push of more than 32 bytes")
+	}
+	a.buf = append(a.buf, byte(vm.PUSH1)+byte(len(b)-1))
+	a.buf = append(a.buf, b...)
+	return a
+}
+
+// push2 appends a push of v with a fixed length of 2 bytes.
+func (a *assembler) push2(v int) *assembler {
+	a.buf = append(a.buf, byte(vm.PUSH2), byte(v>>8), byte(v))
+	return a
+}
+
+// label defines the jump target name at the current position.
+func (a *assembler) label(name string) *assembler {
+	a.labels[name] = len(a.buf)
+	return a.op(vm.JUMPDEST)
+}
+
+// pushLabel appends a push of the position of label name.
+func (a *assembler) pushLabel(name string) *assembler {
+	a.refs[len(a.buf)+1] = name
+	return a.push2(0)
+}
+
+// jump appends an unconditional jump to label name.
+func (a *assembler) jump(name string) *assembler {
+	return a.pushLabel(name).op(vm.JUMP)
+}
+
+// jumpi appends a jump to label name, if the top of the stack is not zero.
+func (a *assembler) jumpi(name string) *assembler {
+	return a.pushLabel(name).op(vm.JUMPI)
+}
+
+// code returns the assembled code with resolved labels.
+func (a *assembler) code() []byte {
+	code := make([]byte, len(a.buf))
+	copy(code, a.buf)
+	for offset, name := range a.refs {
+		pos, ok := a.labels[name]
+		if !ok {
+			panic(fmt.Sprintf("gen: undefined label '%s'", name))
+		}
+		code[offset] = byte(pos >> 8)
+		code[offset+1] = byte(pos)
+	}
+	return code
+}
+
+// initCode returns the contract creation code which executes the constructor
+// ctor (can be nil) and returns runtime as contract code.
+func initCode(ctor *assembler, runtime []byte) []byte {
+	if ctor == nil {
+		ctor = newAssembler()
+	}
+	// length of the copy sequence below
+	const copyLen = 3 + 1 + 3 + 2 + 1 + 2 + 1
+	offset := len(ctor.buf) + copyLen
+	ctor.push2(len(runtime)).op(vm.DUP1).push2(offset).push(0).op(vm.CODECOPY)
+	ctor.push(0).op(vm.RETURN)
+	return append(ctor.code(), runtime...)
+}
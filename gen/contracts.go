@@ -0,0 +1,199 @@
+package gen
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The generated contracts are written in EVM assembly, they implement the
+// relevant subset of the real contracts with the same ABI, storage layout, and
+// events.
+
+// selector returns the function selector of the function signature sig.
+func selector(sig string) []byte {
+	return crypto.Keccak256([]byte(sig))[:4]
+}
+
+// Function selectors and event topics.
+var (
+	transferSel  = selector("transfer(address,uint256)")
+	balanceOfSel = selector("balanceOf(address)")
+	swapSel      = selector("swap(uint256,uint256,address)")
+	syncSel      = selector("sync()")
+
+	transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	swapTopic     = crypto.Keccak256Hash([]byte("Swap(address,uint256,uint256,address)"))
+)
+
+// callData returns the ABI encoded call of the function with selector sel
+// and the given arguments (each a common.Address or *big.Int).
+func callData(sel []byte, args ...interface{}) []byte {
+	data := append([]byte{}, sel...)
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case common.Address:
+			data = append(data, common.LeftPadBytes(arg.Bytes(), 32)...)
+		case *big.Int:
+			data = append(data, common.LeftPadBytes(arg.Bytes(), 32)...)
+		default:
+			panic("gen: unsupported argument type")
+		}
+	}
+	return data
+}
+
+// balanceSlot appends the computation of the storage slot of the balance of
+// the address on top of the stack (the Solidity mapping at slot 0).
+func balanceSlot(a *assembler) {
+	a.push(0).op(vm.MSTORE)
+	a.push(0).push(0x20).op(vm.MSTORE)
+	a.push(0x40).push(0).op(vm.KECCAK256)
+}
+
+// revert appends the label "fail", which reverts the execution.
+func revert(a *assembler) {
+	a.label("fail").push(0).op(vm.DUP1, vm.REVERT)
+}
+
+// returnWord appends a return of the word on top of the stack.
+func returnWord(a *assembler) {
+	a.push(0).op(vm.MSTORE).push(0x20).push(0).op(vm.RETURN)
+}
+
+// dispatch appends a jump to each label if the function selector of the call
+// matches the selector of the label and reverts otherwise.
+func dispatch(a *assembler, sels map[string][]byte, labels ...string) {
+	a.push(0).op(vm.CALLDATALOAD).push(0xe0).op(vm.SHR)
+	for _, l := range labels {
+		a.op(vm.DUP1).push(sels[l]).op(vm.EQ).jumpi(l)
+	}
+	a.jump("fail")
+}
+
+// erc20Code returns the creation code of an ERC-20 token contract, which
+// assigns the total supply to its creator.
+func erc20Code(supply *big.Int) []byte {
+	ctor := newAssembler()
+	ctor.push(supply.Bytes()).op(vm.CALLER)
+	balanceSlot(ctor)
+	ctor.op(vm.SSTORE)
+
+	a := newAssembler()
+	dispatch(a, map[string][]byte{
+		"transfer":  transferSel,
+		"balanceOf": balanceOfSel,
+	}, "transfer", "balanceOf")
+	revert(a)
+
+	// balanceOf(address)
+	a.label("balanceOf").push(4).op(vm.CALLDATALOAD)
+	balanceSlot(a)
+	a.op(vm.SLOAD)
+	returnWord(a)
+
+	// transfer(address,uint256)
+	a.label("transfer").op(vm.CALLER)
+	balanceSlot(a)
+	a.op(vm.DUP1, vm.SLOAD)                     // fromSlot fromBal
+	a.push(0x24).op(vm.CALLDATALOAD)            // fromSlot fromBal amount
+	a.op(vm.DUP1, vm.DUP3, vm.LT).jumpi("fail") // fromBal < amount
+	a.op(vm.DUP1, vm.DUP3, vm.SUB, vm.DUP4)     // ... fromBal-amount fromSlot
+	a.op(vm.SSTORE)                             // fromSlot fromBal amount
+	a.push(4).op(vm.CALLDATALOAD)               // ... to
+	balanceSlot(a)                              // ... toSlot
+	a.op(vm.DUP1, vm.SLOAD, vm.DUP3, vm.ADD)    // ... toSlot toBal+amount
+	a.op(vm.SWAP1, vm.SSTORE)                   // fromSlot fromBal amount
+	a.op(vm.DUP1).push(0).op(vm.MSTORE)         // log data: amount
+	a.push(4).op(vm.CALLDATALOAD, vm.CALLER)    // topics: to, from
+	a.push(transferTopic).push(0x20).push(0).op(vm.LOG3)
+	a.push(1)
+	returnWord(a)
+
+	return initCode(ctor, a.code())
+}
+
+// callToken appends a call of token with the call data in memory at offset
+// 0x100 with length size and reverts, if the call fails. The return value
+// is stored in memory at offset 0.
+func callToken(a *assembler, token common.Address, size int, static bool) {
+	a.push(0x20).push(0).push(size).push(0x100)
+	if !static {
+		a.push(0)
+	}
+	a.push(token).op(vm.GAS)
+	if static {
+		a.op(vm.STATICCALL)
+	} else {
+		a.op(vm.CALL)
+	}
+	a.op(vm.ISZERO).jumpi("fail")
+}
+
+// storeSel appends storing the function selector sel in memory at 0x100.
+func storeSel(a *assembler, sel []byte) {
+	a.push(common.RightPadBytes(sel, 32)).push(0x100).op(vm.MSTORE)
+}
+
+// balanceOfSelf appends a call of token.balanceOf(this), the result is
+// pushed onto the stack.
+func balanceOfSelf(a *assembler, token common.Address) {
+	storeSel(a, balanceOfSel)
+	a.op(vm.ADDRESS).push(0x104).op(vm.MSTORE)
+	callToken(a, token, 0x24, true)
+	a.push(0).op(vm.MLOAD)
+}
+
+// pairCode returns the creation code of a Uniswap V2 style pair contract
+// for the tokens token0 and token1. The reserves are stored at slot 0 and 1.
+//
+// Like in Uniswap V2 the input tokens are transferred to the pair before
+// calling swap(amount0Out, amount1Out, to), which transfers the output tokens
+// to 'to' and checks that the product of the reserves did not decrease.
+// sync() sets the reserves to the balances of the pair.
+func pairCode(token0, token1 common.Address) []byte {
+	a := newAssembler()
+	dispatch(a, map[string][]byte{
+		"swap": swapSel,
+		"sync": syncSel,
+	}, "swap", "sync")
+	revert(a)
+
+	// swap(uint256,uint256,address)
+	a.label("swap")
+	for i, token := range []common.Address{token0, token1} {
+		storeSel(a, transferSel)
+		a.push(0x44).op(vm.CALLDATALOAD).push(0x104).op(vm.MSTORE)
+		a.push(4 + 32*i).op(vm.CALLDATALOAD).push(0x124).op(vm.MSTORE)
+		callToken(a, token, 0x44, false)
+	}
+	balanceOfSelf(a, token0)
+	balanceOfSelf(a, token1)                            // b0 b1
+	a.push(0).op(vm.SLOAD).push(1).op(vm.SLOAD, vm.MUL) // b0 b1 k
+	a.op(vm.DUP3, vm.DUP3, vm.MUL, vm.LT).jumpi("fail") // b0*b1 < k
+	a.push(1).op(vm.SSTORE).push(0).op(vm.SSTORE)       // update reserves
+	a.push(0x60).push(4).push(0).op(vm.CALLDATACOPY)    // log data: arguments
+	a.op(vm.CALLER).push(swapTopic).push(0x60).push(0).op(vm.LOG2)
+	a.op(vm.STOP)
+
+	// sync()
+	a.label("sync")
+	balanceOfSelf(a, token0)
+	balanceOfSelf(a, token1)
+	a.push(1).op(vm.SSTORE).push(0).op(vm.SSTORE)
+	a.op(vm.STOP)
+
+	return initCode(nil, a.code())
+}
+
+// hasherCode returns the creation code of a contract which stores the
+// Keccak-256 hash of its call data at slot 0.
+func hasherCode() []byte {
+	a := newAssembler()
+	a.op(vm.CALLDATASIZE).push(0).push(0).op(vm.CALLDATACOPY)
+	a.op(vm.CALLDATASIZE).push(0).op(vm.KECCAK256)
+	a.push(0).op(vm.SSTORE, vm.STOP)
+	return initCode(nil, a.code())
+}
@@ -0,0 +1,478 @@
+// Package gen generates synthetic Ethereum networks from scenarios.
+//
+// A generated network consists of a genesis with funded accounts and a dump
+// of blocks (in the format of package db) with properly signed transactions.
+// All transactions are executed with the go-ethereum EVM during generation,
+// the dump contains their receipts and generation fails, if a transaction
+// fails.
+package gen
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"path/filepath"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/aurora-is-near/evm-bully/network"
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Parameters of generated blocks and transactions.
+const (
+	genesisTime      = 1600000000
+	blockTime        = 13                  // seconds between blocks
+	blockGasLimit    = 30000000            // gas limit of all blocks
+	gasPrice         = 1000000000          // 1 gwei
+	setupTxsPerBlock = 100                 // transactions per block of setup blocks
+	tokenUnit        = 1000000000000000000 // 10^18
+)
+
+// Gas limits of generated transactions (calls with large call data get
+// additional gas for the call data).
+const (
+	transferGas = 21000
+	erc20Gas    = 100000
+	swapGas     = 200000
+	createGas   = 500000
+	calldataGas = 100000
+)
+
+type account struct {
+	key   *ecdsa.PrivateKey
+	addr  common.Address
+	nonce uint64
+}
+
+type generator struct {
+	s        *Scenario
+	config   *params.ChainConfig
+	signer   types.Signer
+	rnd      *rand.Rand
+	accounts []*account
+	statedb  *state.StateDB
+	w        *db.Writer
+	parent   *types.Header
+	// current block (header is nil, if no block has been started)
+	header   *types.Header
+	gp       *core.GasPool
+	txs      types.Transactions
+	receipts types.Receipts
+	// contracts (zero, if not deployed yet)
+	token    common.Address    // ERC-20 token for KindERC20
+	tokens   [2]common.Address // tokens of the pair
+	pair     common.Address
+	reserves [2]*big.Int // reserves of the pair
+	hasher   common.Address
+	numTxs   int
+}
+
+// accountKey derives the key of account i from seed.
+func accountKey(seed int64, i int) (*ecdsa.PrivateKey, error) {
+	return crypto.ToECDSA(crypto.Keccak256([]byte(fmt.Sprintf("evm-bully gen %d %d", seed, i))))
+}
+
+// chainConfig returns the chain config of generated networks (all forks up to
+// Berlin are active).
+func chainConfig(chainID *big.Int) *params.ChainConfig {
+	config := *params.AllEthashProtocolChanges
+	config.ChainID = new(big.Int).Set(chainID)
+	config.LondonBlock = nil
+	config.ArrowGlacierBlock = nil
+	return &config
+}
+
+// Generate generates the network name with the given chainID from scenario s
+// and writes its dump (compressed with codec) into the cache directory of the
+// network. The accounts are derived from seed, which also determines the
+// generated transactions. An existing dump of the network is overwritten.
+func Generate(
+	name string,
+	chainID *big.Int,
+	s *Scenario,
+	seed int64,
+	codec db.Codec,
+) (*network.Network, error) {
+	g := &generator{
+		s:      s,
+		config: chainConfig(chainID),
+		signer: types.LatestSignerForChainID(chainID),
+		rnd:    rand.New(rand.NewSource(seed)),
+	}
+	balance, _ := new(big.Int).SetString(s.Balance, 10)
+	alloc := make(core.GenesisAlloc)
+	for i := 0; i < s.Accounts; i++ {
+		key, err := accountKey(seed, i)
+		if err != nil {
+			return nil, err
+		}
+		a := &account{key: key, addr: crypto.PubkeyToAddress(key.PublicKey)}
+		g.accounts = append(g.accounts, a)
+		alloc[a.addr] = core.GenesisAccount{Balance: balance}
+	}
+	n := &network.Network{
+		Name: name,
+		Genesis: &core.Genesis{
+			Config:     g.config,
+			Timestamp:  genesisTime,
+			GasLimit:   blockGasLimit,
+			Difficulty: big.NewInt(1),
+			Alloc:      alloc,
+		},
+	}
+	memdb := rawdb.NewMemoryDatabase()
+	genesis, err := n.Genesis.Commit(memdb)
+	if err != nil {
+		return nil, err
+	}
+	g.statedb, err = state.New(genesis.Root(), state.NewDatabase(memdb), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.Save(); err != nil {
+		return nil, err
+	}
+
+	cacheDir, err := util.DetermineCacheDir(name)
+	if err != nil {
+		return nil, err
+	}
+	g.w, err = db.NewWriter(filepath.Join(cacheDir, "dump.db"), codec)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.writeBlock(genesis, nil); err != nil {
+		g.w.Close()
+		return nil, err
+	}
+	for i, step := range s.Steps {
+		log.Info(fmt.Sprintf("step %d: %d blocks with %d %s transactions",
+			i, step.Blocks, step.Txs, step.Kind))
+		if err := g.step(step); err != nil {
+			g.w.Close()
+			return nil, err
+		}
+	}
+	if err := g.w.Close(); err != nil {
+		return nil, err
+	}
+	n.Block = g.parent.Number.Uint64()
+	n.Hash = g.parent.Hash().Hex()
+	if err := n.Save(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("network '%s' generated: %d blocks with %d transactions (chain ID %s)\n",
+		name, n.Block+1, g.numTxs, chainID)
+	return n, nil
+}
+
+func (g *generator) writeBlock(b *types.Block, receipts types.Receipts) error {
+	enc, err := db.EncodeBlock(b, receipts)
+	if err != nil {
+		return err
+	}
+	if err := g.w.Write(enc); err != nil {
+		return err
+	}
+	g.parent = b.Header()
+	return nil
+}
+
+// beginBlock starts a new block.
+func (g *generator) beginBlock() {
+	number := new(big.Int).Add(g.parent.Number, common.Big1)
+	g.header = &types.Header{
+		ParentHash: g.parent.Hash(),
+		Number:     number,
+		GasLimit:   blockGasLimit,
+		Time:       g.parent.Time + blockTime,
+		Difficulty: big.NewInt(1),
+	}
+	g.gp = new(core.GasPool).AddGas(blockGasLimit)
+	g.txs = nil
+	g.receipts = nil
+}
+
+// endBlock finishes the current block (starting one, if necessary) and writes
+// it to the dump.
+func (g *generator) endBlock() error {
+	if g.header == nil {
+		g.beginBlock()
+	}
+	g.header.Root = g.statedb.IntermediateRoot(true)
+	b := types.NewBlock(g.header, g.txs, nil, g.receipts, trie.NewStackTrie(nil))
+	g.header = nil
+	return g.writeBlock(b, g.receipts)
+}
+
+// send signs the transaction from account a and adds it to the current block
+// (starting one, if necessary). The transaction is executed and its receipt
+// returned. An error is returned, if the execution fails.
+func (g *generator) send(
+	a *account,
+	to *common.Address,
+	value *big.Int,
+	gas uint64,
+	data []byte,
+) (*types.Receipt, error) {
+	if g.header == nil {
+		g.beginBlock()
+	}
+	tx, err := types.SignNewTx(a.key, g.signer, &types.LegacyTx{
+		Nonce:    a.nonce,
+		GasPrice: big.NewInt(gasPrice),
+		Gas:      gas,
+		To:       to,
+		Value:    value,
+		Data:     data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.statedb.Prepare(tx.Hash(), len(g.txs))
+	receipt, err := core.ApplyTransaction(g.config, nil, &g.header.Coinbase, g.gp,
+		g.statedb, g.header, tx, &g.header.GasUsed, vm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("gen: cannot apply transaction %s in block %s: %w",
+			tx.Hash().Hex(), g.header.Number, err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, fmt.Errorf("gen: transaction %s in block %s failed",
+			tx.Hash().Hex(), g.header.Number)
+	}
+	a.nonce++
+	g.txs = append(g.txs, tx)
+	g.receipts = append(g.receipts, receipt)
+	g.numTxs++
+	return receipt, nil
+}
+
+// setup sends a setup transaction, setup blocks are finished automatically
+// after setupTxsPerBlock transactions.
+func (g *generator) setup(
+	a *account,
+	to *common.Address,
+	gas uint64,
+	data []byte,
+) (*types.Receipt, error) {
+	r, err := g.send(a, to, new(big.Int), gas, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.txs) == setupTxsPerBlock {
+		if err := g.endBlock(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// deploy deploys a contract with the given creation code from the first
+// account and returns its address.
+func (g *generator) deploy(code []byte) (common.Address, error) {
+	r, err := g.setup(g.accounts[0], nil, createGas, code)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return r.ContractAddress, nil
+}
+
+// tokens returns amount token units.
+func tokens(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), big.NewInt(tokenUnit))
+}
+
+// deployToken deploys an ERC-20 token and distributes perAccount tokens to
+// each account (the first account keeps the remaining supply).
+func (g *generator) deployToken(supply, perAccount *big.Int) (common.Address, error) {
+	token, err := g.deploy(erc20Code(supply))
+	if err != nil {
+		return common.Address{}, err
+	}
+	for _, a := range g.accounts[1:] {
+		data := callData(transferSel, a.addr, perAccount)
+		if _, err := g.setup(g.accounts[0], &token, erc20Gas, data); err != nil {
+			return common.Address{}, err
+		}
+	}
+	return token, nil
+}
+
+// tokenSupply returns the supply of generated tokens.
+func (g *generator) tokenSupply() *big.Int {
+	return tokens(int64(1000000 * (len(g.accounts) + 1)))
+}
+
+func (g *generator) setupERC20() error {
+	if g.token != (common.Address{}) {
+		return nil
+	}
+	log.Info("setup ERC-20 token")
+	var err error
+	g.token, err = g.deployToken(g.tokenSupply(), tokens(1000000))
+	if err != nil {
+		return err
+	}
+	return g.endBlock()
+}
+
+func (g *generator) setupPair() error {
+	if g.pair != (common.Address{}) {
+		return nil
+	}
+	log.Info("setup token pair")
+	var err error
+	for i := range g.tokens {
+		g.tokens[i], err = g.deployToken(g.tokenSupply(), tokens(1000000))
+		if err != nil {
+			return err
+		}
+	}
+	g.pair, err = g.deploy(pairCode(g.tokens[0], g.tokens[1]))
+	if err != nil {
+		return err
+	}
+	// provide liquidity
+	for i := range g.tokens {
+		g.reserves[i] = tokens(1000000)
+		data := callData(transferSel, g.pair, g.reserves[i])
+		if _, err := g.setup(g.accounts[0], &g.tokens[i], erc20Gas, data); err != nil {
+			return err
+		}
+	}
+	if _, err := g.setup(g.accounts[0], &g.pair, erc20Gas, syncSel); err != nil {
+		return err
+	}
+	return g.endBlock()
+}
+
+func (g *generator) setupHasher() error {
+	if g.hasher != (common.Address{}) {
+		return nil
+	}
+	log.Info("setup hasher")
+	var err error
+	g.hasher, err = g.deploy(hasherCode())
+	if err != nil {
+		return err
+	}
+	return g.endBlock()
+}
+
+// randAccount returns a random account.
+func (g *generator) randAccount() *account {
+	return g.accounts[g.rnd.Intn(len(g.accounts))]
+}
+
+// randAmount returns a random amount in [1, max].
+func (g *generator) randAmount(max *big.Int) *big.Int {
+	n := new(big.Int).Rand(g.rnd, max)
+	return n.Add(n, common.Big1)
+}
+
+// swapOut returns the output amount of a swap of amountIn with the reserves
+// reserveIn and reserveOut (with a fee of 0.3%, like Uniswap V2).
+func swapOut(amountIn, reserveIn, reserveOut *big.Int) *big.Int {
+	in := new(big.Int).Mul(amountIn, big.NewInt(997))
+	num := new(big.Int).Mul(in, reserveOut)
+	den := new(big.Int).Mul(reserveIn, big.NewInt(1000))
+	den.Add(den, in)
+	return num.Div(num, den)
+}
+
+// swap sends a random swap from account a.
+func (g *generator) swap(a *account) error {
+	in := g.rnd.Intn(2)
+	out := 1 - in
+	amountIn := g.randAmount(tokens(1000))
+	amountOut := swapOut(amountIn, g.reserves[in], g.reserves[out])
+	data := callData(transferSel, g.pair, amountIn)
+	if _, err := g.send(a, &g.tokens[in], new(big.Int), erc20Gas, data); err != nil {
+		return err
+	}
+	amounts := [2]*big.Int{new(big.Int), new(big.Int)}
+	amounts[out] = amountOut
+	data = callData(swapSel, amounts[0], amounts[1], a.addr)
+	if _, err := g.send(a, &g.pair, new(big.Int), swapGas, data); err != nil {
+		return err
+	}
+	g.reserves[in].Add(g.reserves[in], amountIn)
+	g.reserves[out].Sub(g.reserves[out], amountOut)
+	return nil
+}
+
+// memoryGas returns the gas cost of size bytes of memory.
+func memoryGas(size uint64) uint64 {
+	words := (size + 31) / 32
+	return words*params.MemoryGas + words*words/params.QuadCoeffDiv
+}
+
+// tx sends a random transaction of the given step kind.
+func (g *generator) tx(step *Step) error {
+	a := g.randAccount()
+	var err error
+	switch step.Kind {
+	case KindTransfer:
+		to := g.randAccount().addr
+		_, err = g.send(a, &to, g.randAmount(big.NewInt(tokenUnit)), transferGas, nil)
+	case KindERC20:
+		to := g.randAccount().addr
+		data := callData(transferSel, to, g.randAmount(tokens(1)))
+		_, err = g.send(a, &g.token, new(big.Int), erc20Gas, data)
+	case KindSwap:
+		err = g.swap(a)
+	case KindCalldata:
+		data := make([]byte, step.Size)
+		g.rnd.Read(data)
+		var gas uint64
+		gas, err = core.IntrinsicGas(data, nil, false, true, true)
+		if err != nil {
+			return err
+		}
+		words := uint64(len(data)+31) / 32
+		gas += calldataGas + 2*memoryGas(uint64(len(data))) + 2*words*params.CopyGas
+		_, err = g.send(a, &g.hasher, new(big.Int), gas, data)
+	case KindCreate:
+		supply := tokens(g.rnd.Int63n(1000000) + 1)
+		_, err = g.send(a, nil, new(big.Int), createGas, erc20Code(supply))
+	}
+	return err
+}
+
+// step generates the blocks of step (after the necessary setup blocks).
+func (g *generator) step(step *Step) error {
+	var err error
+	switch step.Kind {
+	case KindERC20:
+		err = g.setupERC20()
+	case KindSwap:
+		err = g.setupPair()
+	case KindCalldata:
+		err = g.setupHasher()
+	}
+	if err != nil {
+		return err
+	}
+	for i := 0; i < step.Blocks; i++ {
+		for j := 0; j < step.Txs; j++ {
+			if err := g.tx(step); err != nil {
+				return err
+			}
+		}
+		if err := g.endBlock(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package gen
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/aurora-is-near/evm-bully/network"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+)
+
+var testChainID = big.NewInt(1313161556)
+
+// setTestHome sets $HOME to a temporary directory for the duration of the
+// test.
+func setTestHome(t *testing.T) {
+	home := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	t.Cleanup(func() { os.Setenv("HOME", oldHome) })
+}
+
+func testScenario() *Scenario {
+	s := &Scenario{Accounts: 5, Balance: DefaultBalance}
+	for _, kind := range kinds {
+		s.Steps = append(s.Steps, &Step{Kind: kind, Blocks: 2, Txs: 3, Size: 1000})
+	}
+	return s
+}
+
+// readDump reads all blocks of the dump of network name.
+func readDump(t *testing.T, name string) []*db.Block {
+	r, err := db.NewReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var blocks []*db.Block
+	for {
+		b, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == nil {
+			return blocks
+		}
+		blocks = append(blocks, b)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	setTestHome(t)
+	s := testScenario()
+	if err := s.validate(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := Generate("gentest", testChainID, s, 1, db.CodecNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := network.Lookup("gentest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ChainID().Cmp(testChainID) != 0 || len(l.Genesis.Alloc) != s.Accounts {
+		t.Errorf("network has chain ID %s and %d accounts", l.ChainID(), len(l.Genesis.Alloc))
+	}
+
+	blocks := readDump(t, "gentest")
+	if uint64(len(blocks)) != n.Block+1 {
+		t.Fatalf("dump has %d blocks, expected %d", len(blocks), n.Block+1)
+	}
+	if blocks[len(blocks)-1].Hash.Hex() != n.Hash {
+		t.Errorf("tip has hash %s, network defines %s", blocks[len(blocks)-1].Hash.Hex(), n.Hash)
+	}
+	if blocks[0].Hash != l.Genesis.ToBlock(nil).Hash() {
+		t.Error("first block is not the genesis block")
+	}
+	signer := types.LatestSignerForChainID(testChainID)
+	var creations int
+	for i, b := range blocks {
+		if b.Header.Number.Uint64() != uint64(i) {
+			t.Fatalf("block %d has number %s", i, b.Header.Number)
+		}
+		if i > 0 && b.Header.ParentHash != blocks[i-1].Hash {
+			t.Fatalf("block %d has wrong parent hash", i)
+		}
+		for _, tx := range b.Transactions {
+			var etx types.Transaction
+			if err := etx.UnmarshalBinary(tx.RLP); err != nil {
+				t.Fatal(err)
+			}
+			from, err := types.Sender(signer, &etx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := l.Genesis.Alloc[from]; !ok {
+				t.Errorf("transaction from unknown account %s", from.Hex())
+			}
+			if tx.Receipt == nil || tx.Receipt.Status != types.ReceiptStatusSuccessful {
+				t.Errorf("transaction in block %d has no successful receipt", i)
+			}
+			if tx.To == nil {
+				creations++
+			}
+		}
+	}
+	// 4 setup deployments (token, 2 pair tokens, pair), hasher, 6 creations
+	if creations != 4+1+6 {
+		t.Errorf("dump contains %d contract creations", creations)
+	}
+	// generation is deterministic
+	if _, err := Generate("gentest2", testChainID, testScenario(), 1, db.CodecZstd); err != nil {
+		t.Fatal(err)
+	}
+	blocks2 := readDump(t, "gentest2")
+	if blocks2[len(blocks2)-1].Hash != blocks[len(blocks)-1].Hash {
+		t.Error("generation with the same seed is not deterministic")
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	for _, name := range Builtins() {
+		if _, err := LoadScenario(name); err != nil {
+			t.Errorf("LoadScenario(%s): %s", name, err)
+		}
+	}
+	if _, err := LoadScenario("unknown"); err == nil {
+		t.Error("LoadScenario() should fail for unknown scenario")
+	}
+	filename := t.TempDir() + "/scenario.json"
+	err := os.WriteFile(filename, []byte(`{"accounts": 10, "steps": [{"kind": "calldata", "blocks": 1, "txs": 1}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadScenario(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Balance != DefaultBalance || s.Steps[0].Size != DefaultCalldataSize {
+		t.Error("LoadScenario() does not set defaults")
+	}
+	for _, jsn := range []string{
+		`{"accounts": 1, "steps": [{"kind": "transfer", "blocks": 1, "txs": 1}]}`,
+		`{"accounts": 10, "steps": [{"kind": "unknown", "blocks": 1, "txs": 1}]}`,
+		`{"accounts": 10, "steps": [{"kind": "transfer", "blocks": 0, "txs": 1}]}`,
+		`{"accounts": 10, "balance": "x", "steps": [{"kind": "transfer", "blocks": 1, "txs": 1}]}`,
+		`{"accounts": 10, "steps": []}`,
+	} {
+		if err := os.WriteFile(filename, []byte(jsn), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadScenario(filename); err == nil {
+			t.Errorf("LoadScenario() should fail for %s", jsn)
+		}
+	}
+}
+
+// newRuntime returns a runtime config with an empty state.
+func newRuntime(t *testing.T, origin common.Address) *runtime.Config {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &runtime.Config{
+		ChainConfig: chainConfig(testChainID),
+		Origin:      origin,
+		State:       statedb,
+	}
+}
+
+func balanceOf(t *testing.T, cfg *runtime.Config, token, addr common.Address) *big.Int {
+	ret, _, err := runtime.Call(token, callData(balanceOfSel, addr), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(ret)
+}
+
+func TestERC20(t *testing.T) {
+	alice := common.HexToAddress("0xa11ce")
+	bob := common.HexToAddress("0xb0b")
+	cfg := newRuntime(t, alice)
+	_, token, _, err := runtime.Create(erc20Code(big.NewInt(1000)), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, _, err := runtime.Call(token, callData(transferSel, bob, big.NewInt(300)), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret, common.LeftPadBytes([]byte{1}, 32)) {
+		t.Errorf("transfer() returned %x", ret)
+	}
+	logs := cfg.State.Logs()
+	if len(logs) != 1 || logs[0].Topics[0] != transferTopic ||
+		common.BytesToAddress(logs[0].Topics[1].Bytes()) != alice ||
+		common.BytesToAddress(logs[0].Topics[2].Bytes()) != bob {
+		t.Errorf("transfer() logged %v", logs)
+	}
+	if b := balanceOf(t, cfg, token, alice); b.Int64() != 700 {
+		t.Errorf("balance of alice is %s, expected 700", b)
+	}
+	if b := balanceOf(t, cfg, token, bob); b.Int64() != 300 {
+		t.Errorf("balance of bob is %s, expected 300", b)
+	}
+	// self transfer
+	if _, _, err := runtime.Call(token, callData(transferSel, alice, big.NewInt(700)), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if b := balanceOf(t, cfg, token, alice); b.Int64() != 700 {
+		t.Errorf("balance of alice is %s after self transfer, expected 700", b)
+	}
+	if _, _, err := runtime.Call(token, callData(transferSel, bob, big.NewInt(701)), cfg); err == nil {
+		t.Error("transfer() of more than the balance should fail")
+	}
+	if _, _, err := runtime.Call(token, []byte{1, 2, 3, 4}, cfg); err == nil {
+		t.Error("call of unknown function should fail")
+	}
+}
+
+func TestPair(t *testing.T) {
+	alice := common.HexToAddress("0xa11ce")
+	cfg := newRuntime(t, alice)
+	var tokens [2]common.Address
+	for i := range tokens {
+		var err error
+		_, tokens[i], _, err = runtime.Create(erc20Code(big.NewInt(1000000)), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, pair, _, err := runtime.Create(pairCode(tokens[0], tokens[1]), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(to common.Address, data []byte) error {
+		_, _, err := runtime.Call(to, data, cfg)
+		return err
+	}
+	for _, token := range tokens {
+		if err := call(token, callData(transferSel, pair, big.NewInt(10000))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := call(pair, syncSel); err != nil {
+		t.Fatal(err)
+	}
+	// swap 1000 of token 0 for token 1
+	out := swapOut(big.NewInt(1000), big.NewInt(10000), big.NewInt(10000))
+	if out.Int64() != 906 {
+		t.Errorf("swapOut() = %s, expected 906", out)
+	}
+	if err := call(tokens[0], callData(transferSel, pair, big.NewInt(1000))); err != nil {
+		t.Fatal(err)
+	}
+	// taking more violates the constant product
+	tooMuch := new(big.Int).Add(out, big.NewInt(100))
+	if err := call(pair, callData(swapSel, new(big.Int), tooMuch, alice)); err == nil {
+		t.Error("swap() should fail, if the product of the reserves decreases")
+	}
+	if err := call(pair, callData(swapSel, new(big.Int), out, alice)); err != nil {
+		t.Fatal(err)
+	}
+	if b := balanceOf(t, cfg, tokens[1], alice); b.Int64() != 1000000-10000+906 {
+		t.Errorf("balance of alice is %s", b)
+	}
+	for i, exp := range []int64{11000, 10000 - 906} {
+		r := cfg.State.GetState(pair, common.BigToHash(big.NewInt(int64(i)))).Big()
+		if r.Int64() != exp {
+			t.Errorf("reserve %d is %s, expected %d", i, r, exp)
+		}
+	}
+}
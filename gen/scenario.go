@@ -0,0 +1,148 @@
+package gen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Step kinds.
+const (
+	KindTransfer = "transfer" // Ether transfers between accounts
+	KindERC20    = "erc20"    // ERC-20 token transfers between accounts
+	KindSwap     = "swap"     // swaps with a Uniswap V2 style pair
+	KindCalldata = "calldata" // calls with large call data
+	KindCreate   = "create"   // ERC-20 token contract creations
+)
+
+var kinds = []string{KindTransfer, KindERC20, KindSwap, KindCalldata, KindCreate}
+
+// DefaultCalldataSize is the default call data size of KindCalldata steps.
+const DefaultCalldataSize = 32 * 1024
+
+// A Scenario defines the workload of a generated network.
+type Scenario struct {
+	Accounts int     `json:"accounts"` // number of funded accounts in genesis
+	Balance  string  `json:"balance"`  // genesis balance of each account (in wei)
+	Steps    []*Step `json:"steps"`
+}
+
+// A Step defines a number of blocks with transactions of the same kind.
+// Swaps consist of two transactions each (a token transfer to the pair and
+// the swap call).
+type Step struct {
+	Kind   string `json:"kind"`
+	Blocks int    `json:"blocks"`
+	Txs    int    `json:"txs"`            // number of transactions (or swaps) per block
+	Size   int    `json:"size,omitempty"` // call data size for KindCalldata
+}
+
+var builtins = map[string]func() *Scenario{
+	"mixed": func() *Scenario {
+		return &Scenario{
+			Accounts: 100,
+			Steps: []*Step{
+				{Kind: KindTransfer, Blocks: 20, Txs: 20},
+				{Kind: KindERC20, Blocks: 20, Txs: 20},
+				{Kind: KindSwap, Blocks: 20, Txs: 10},
+				{Kind: KindCalldata, Blocks: 10, Txs: 2},
+				{Kind: KindCreate, Blocks: 10, Txs: 10},
+			},
+		}
+	},
+}
+
+func init() {
+	// a built-in scenario for each kind
+	for _, kind := range kinds {
+		kind := kind
+		builtins[kind] = func() *Scenario {
+			return &Scenario{
+				Accounts: 100,
+				Steps:    []*Step{{Kind: kind, Blocks: 100, Txs: 20}},
+			}
+		}
+	}
+}
+
+// Builtins returns the sorted names of the built-in scenarios.
+func Builtins() []string {
+	var names []string
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultBalance is the default genesis balance of accounts (10^6 Ether).
+const DefaultBalance = "1000000000000000000000000"
+
+// LoadScenario returns the built-in scenario with the given name or loads
+// the scenario from the JSON file name, if no such built-in exists.
+func LoadScenario(name string) (*Scenario, error) {
+	var s *Scenario
+	if builtin, ok := builtins[name]; ok {
+		s = builtin()
+	} else {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("gen: unknown scenario '%s' (built-in scenarios: %s)",
+					name, strings.Join(Builtins(), ", "))
+			}
+			return nil, err
+		}
+		s = new(Scenario)
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("gen: cannot parse scenario '%s': %s", name, err)
+		}
+	}
+	if s.Balance == "" {
+		s.Balance = DefaultBalance
+	}
+	for _, step := range s.Steps {
+		if step.Kind == KindCalldata && step.Size == 0 {
+			step.Size = DefaultCalldataSize
+		}
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Scenario) validate() error {
+	if s.Accounts < 2 {
+		return errors.New("gen: scenario needs at least 2 accounts")
+	}
+	if b, ok := new(big.Int).SetString(s.Balance, 10); !ok || b.Sign() <= 0 {
+		return fmt.Errorf("gen: invalid balance '%s'", s.Balance)
+	}
+	if len(s.Steps) == 0 {
+		return errors.New("gen: scenario has no steps")
+	}
+	for i, step := range s.Steps {
+		known := false
+		for _, kind := range kinds {
+			if step.Kind == kind {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("gen: step %d has unknown kind '%s' (kinds: %s)",
+				i, step.Kind, strings.Join(kinds, ", "))
+		}
+		if step.Blocks <= 0 || step.Txs < 0 {
+			return fmt.Errorf("gen: step %d has invalid number of blocks or transactions", i)
+		}
+		if step.Size < 0 {
+			return fmt.Errorf("gen: step %d has negative call data size", i)
+		}
+	}
+	return nil
+}
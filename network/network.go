@@ -0,0 +1,196 @@
+// Package network defines the Ethereum networks which can be dumped and
+// replayed.
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/frankbraun/codechain/util/file"
+	"github.com/frankbraun/codechain/util/homedir"
+)
+
+// DefaultFilename is the name of the network file stored in the cache
+// directory of a network.
+const DefaultFilename = "network.json"
+
+// Network defines an Ethereum network.
+type Network struct {
+	Name    string        `json:"name"`  // name of the network (and its cache directory)
+	Block   uint64        `json:"block"` // default block height
+	Hash    string        `json:"hash"`  // default block hash (empty, if undefined)
+	Genesis *core.Genesis `json:"genesis"`
+}
+
+var builtins = map[string]func() *Network{
+	"goerli": func() *Network {
+		return &Network{
+			Name: "goerli",
+			// 2021-05-07
+			Block:   4747554,
+			Hash:    "0xca3f0a8bcbfadf60994423da4009b9519ccab6e1e91c637888d313ecf24f0a1a",
+			Genesis: core.DefaultGoerliGenesisBlock(),
+		}
+	},
+	"rinkeby": func() *Network {
+		return &Network{
+			Name: "rinkeby",
+			// 2021-05-07
+			Block:   8541193,
+			Hash:    "0x9afd56145c5a771967b0d86800338694214e9c83d9d89d52215d916b555d9cd5",
+			Genesis: core.DefaultRinkebyGenesisBlock(),
+		}
+	},
+	"ropsten": func() *Network {
+		return &Network{
+			Name: "ropsten",
+			// 2021-05-07
+			Block:   10187164,
+			Hash:    "0x000f6b7fc929f6f3a493cad3cee9d65274e51228a05970cf03ad7fb6664007a6",
+			Genesis: core.DefaultRopstenGenesisBlock(),
+		}
+	},
+}
+
+// Builtins returns the sorted names of the built-in networks.
+func Builtins() []string {
+	var names []string
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ChainID returns the chain ID of network n.
+func (n *Network) ChainID() *big.Int {
+	return n.Genesis.Config.ChainID
+}
+
+func (n *Network) validate() error {
+	if n.Name == "" || filepath.Base(n.Name) != n.Name || strings.HasPrefix(n.Name, ".") {
+		return fmt.Errorf("network: invalid network name '%s'", n.Name)
+	}
+	if n.Genesis == nil {
+		return fmt.Errorf("network: network '%s' has no genesis", n.Name)
+	}
+	if n.Genesis.Config == nil || n.Genesis.Config.ChainID == nil {
+		return fmt.Errorf("network: genesis of network '%s' has no chain ID", n.Name)
+	}
+	return nil
+}
+
+func filename(name string) string {
+	return filepath.Join(homedir.Get("evm-bully"), name, DefaultFilename)
+}
+
+// Lookup returns the network with the given name. It is either a built-in
+// network or a network saved in the evm-bully cache directory:
+//
+//	~/.config/evm-bully/name/network.json
+func Lookup(name string) (*Network, error) {
+	if builtin, ok := builtins[name]; ok {
+		return builtin(), nil
+	}
+	fn := filename(name)
+	exists, err := file.Exists(fn)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("network: unknown network '%s' (built-in networks: %s)",
+			name, strings.Join(Builtins(), ", "))
+	}
+	n, err := Load(fn)
+	if err != nil {
+		return nil, err
+	}
+	if n.Name != name {
+		return nil, fmt.Errorf("network: '%s' defines network '%s', expected '%s'",
+			fn, n.Name, name)
+	}
+	return n, nil
+}
+
+// Load loads a network file (a JSON encoded Network) from filename.
+func Load(filename string) (*Network, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var n Network
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("network: cannot parse '%s': %s", filename, err)
+	}
+	if err := n.validate(); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// LoadGenesis loads the network with the given name from filename, which
+// either contains a network file or a genesis file (as used by geth). If name
+// is empty, the name from the network file or the basename of the genesis
+// file (without extension) is used.
+func LoadGenesis(filename, name string) (*Network, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("network: cannot parse '%s': %s", filename, err)
+	}
+	var n *Network
+	if _, ok := probe["genesis"]; ok {
+		// network file
+		n, err = Load(filename)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// genesis file
+		var g core.Genesis
+		if err := json.Unmarshal(data, &g); err != nil {
+			return nil, fmt.Errorf("network: cannot parse genesis '%s': %s", filename, err)
+		}
+		n = &Network{
+			Name:    strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)),
+			Genesis: &g,
+		}
+	}
+	if name != "" {
+		n.Name = name
+	}
+	if _, ok := builtins[n.Name]; ok {
+		return nil, fmt.Errorf("network: cannot redefine built-in network '%s'", n.Name)
+	}
+	if err := n.validate(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Save saves network n in its cache directory, which allows to look it up by
+// name afterwards.
+func (n *Network) Save() error {
+	if _, ok := builtins[n.Name]; ok {
+		return errors.New("network: cannot save built-in network")
+	}
+	if _, err := util.DetermineCacheDir(n.Name); err != nil {
+		return err
+	}
+	jsn, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename(n.Name), jsn, 0644)
+}
@@ -0,0 +1,53 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testGenesis = `{
+  "config": {"chainId": 1337},
+  "difficulty": "0x1",
+  "gasLimit": "0x1000000",
+  "alloc": {
+    "0x0000000000000000000000000000000000000001": {"balance": "0x100"}
+  }
+}`
+
+func TestLoadGenesis(t *testing.T) {
+	tmpdir := t.TempDir()
+	fn := filepath.Join(tmpdir, "devnet.json")
+	if err := os.WriteFile(fn, []byte(testGenesis), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := LoadGenesis(fn, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Name != "devnet" {
+		t.Errorf("name: got %s, want devnet", n.Name)
+	}
+	if n.ChainID().Uint64() != 1337 {
+		t.Errorf("chain ID: got %s, want 1337", n.ChainID())
+	}
+	if len(n.Genesis.Alloc) != 1 {
+		t.Errorf("alloc: got %d accounts, want 1", len(n.Genesis.Alloc))
+	}
+	if _, err := LoadGenesis(fn, "goerli"); err == nil {
+		t.Error("redefining built-in network should fail")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	n, err := Lookup("goerli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ChainID().Uint64() != 5 {
+		t.Errorf("chain ID: got %s, want 5", n.ChainID())
+	}
+	if _, err := Lookup("unknown-network-for-test"); err == nil {
+		t.Error("looking up unknown network should fail")
+	}
+}
@@ -37,7 +37,10 @@ func genesisAlloc(g *core.Genesis) ([]AccountBalance, error) {
 func (r *Replayer) beginChainTx(g *core.Genesis) *Tx {
 	var args BeginChainArgs
 	var err error
-	args.ChainID[31] = r.ChainID
+	args.ChainID, err = bigIntToRawU256(r.ChainID)
+	if err != nil {
+		return &Tx{Error: err}
+	}
 	args.GenesisAlloc, err = genesisAlloc(g)
 	if err != nil {
 		return &Tx{Error: err}
@@ -47,6 +50,7 @@ func (r *Replayer) beginChainTx(g *core.Genesis) *Tx {
 		return &Tx{Error: err}
 	}
 	return &Tx{
+		BlockNum:   -1,
 		Comment:    fmt.Sprintf("begin_chain()"),
 		MethodName: "begin_chain",
 		Args:       data,
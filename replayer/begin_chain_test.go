@@ -0,0 +1,58 @@
+package replayer
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/near/borsh-go"
+)
+
+func TestBeginChainChainID(t *testing.T) {
+	chainIDs := []struct {
+		chainID  *big.Int
+		expected []byte // big-endian suffix of the RawU256
+	}{
+		{big.NewInt(5), []byte{0x05}},
+		{big.NewInt(1313161554), []byte{0x4e, 0x45, 0x41, 0x52}}, // "NEAR"
+		{big.NewInt(1313161555), []byte{0x4e, 0x45, 0x41, 0x53}},
+		{big.NewInt(1313161556), []byte{0x4e, 0x45, 0x41, 0x54}},
+	}
+	g := &core.Genesis{
+		Config: params.GoerliChainConfig,
+		Alloc:  core.GenesisAlloc{},
+	}
+	for _, c := range chainIDs {
+		r := Replayer{ChainID: c.chainID}
+		tx := r.beginChainTx(g)
+		if tx.Error != nil {
+			t.Fatal(tx.Error)
+		}
+		var args BeginChainArgs
+		if err := borsh.Deserialize(&args, tx.Args); err != nil {
+			t.Fatal(err)
+		}
+		var expected RawU256
+		copy(expected[32-len(c.expected):], c.expected)
+		if args.ChainID != expected {
+			t.Errorf("chain ID %s encoded as %x, expected %x", c.chainID,
+				args.ChainID, expected)
+		}
+		// the chain ID is the first field of the borsh encoding
+		if !bytes.Equal(tx.Args[:32], expected[:]) {
+			t.Errorf("chain ID %s serialized as %x, expected %x", c.chainID,
+				tx.Args[:32], expected)
+		}
+	}
+}
+
+func TestBeginChainChainIDOverflow(t *testing.T) {
+	chainID := new(big.Int).Lsh(big.NewInt(1), 256)
+	r := Replayer{ChainID: chainID}
+	tx := r.beginChainTx(&core.Genesis{Alloc: core.GenesisAlloc{}})
+	if tx.Error == nil {
+		t.Fatalf("beginChainTx with chain ID 2^256 has to return error, but didn't")
+	}
+}
@@ -0,0 +1,172 @@
+package replayer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/aurora-is-near/evm-bully/util/git"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// engineContract is the EVM contract built by 'make evm-bully=yes' in the
+// aurora-engine directory.
+const engineContract = "release.wasm"
+
+// errUntestable is wrapped by the errors of the test function of bisect for
+// commits which cannot be tested (for example, because they do not build).
+var errUntestable = errors.New("replayer: commit cannot be tested")
+
+// bisect performs a binary search for the first bad commit in commits
+// (oldest first). good is the commit before commits. isBad reports whether a
+// commit is bad. The good commit and the last commit are tested first, good
+// must be good and the last commit must be bad. Commits which cannot be tested
+// (isBad returns an error wrapping errUntestable) are skipped like with 'git
+// bisect skip' and the search continues with a commit next to them.
+func bisect(
+	good string,
+	commits []string,
+	isBad func(commit string) (bool, error),
+) (string, error) {
+	if len(commits) == 0 {
+		return "", errors.New("replayer: no commits to bisect")
+	}
+	// check endpoints
+	bad, err := isBad(good)
+	if err != nil {
+		return "", err
+	}
+	if bad {
+		return "", fmt.Errorf("replayer: good commit %s is bad", good)
+	}
+	fmt.Printf("bisect: %s is good\n", good)
+	bad, err = isBad(commits[len(commits)-1])
+	if err != nil {
+		return "", err
+	}
+	if !bad {
+		return "", fmt.Errorf("replayer: bad commit %s is good", commits[len(commits)-1])
+	}
+	fmt.Printf("bisect: %s is bad\n", commits[len(commits)-1])
+	// search
+	lo, hi := 0, len(commits)-1 // commits[hi] is bad
+	skipped := make(map[int]bool)
+	for lo < hi {
+		mid := nextCommit(lo, hi, skipped)
+		if mid < 0 {
+			return "", fmt.Errorf("replayer: only skipped commits left to test, "+
+				"the first bad commit could be any of: %s",
+				strings.Join(commits[lo:hi+1], " "))
+		}
+		fmt.Printf("bisect: %d commits left to test\n", hi-lo)
+		bad, err := isBad(commits[mid])
+		if errors.Is(err, errUntestable) {
+			fmt.Printf("bisect: %s is skipped: %s\n", commits[mid], err)
+			skipped[mid] = true
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if bad {
+			fmt.Printf("bisect: %s is bad\n", commits[mid])
+			hi = mid
+		} else {
+			fmt.Printf("bisect: %s is good\n", commits[mid])
+			lo = mid + 1
+		}
+	}
+	return commits[lo], nil
+}
+
+// nextCommit returns the index of the next commit to test in [lo, hi), the
+// untested commit closest to the middle which is not skipped. It returns -1 if
+// all commits in [lo, hi) are skipped.
+func nextCommit(lo, hi int, skipped map[int]bool) int {
+	mid := (lo + hi) / 2
+	for d := 0; mid-d >= lo || mid+d < hi; d++ {
+		if mid-d >= lo && !skipped[mid-d] {
+			return mid - d
+		}
+		if mid+d < hi && !skipped[mid+d] {
+			return mid + d
+		}
+	}
+	return -1
+}
+
+// Bisect finds the first bad aurora-engine commit in the range from good to
+// bad (in ../aurora-engine) for the transaction in breakpoint (a breakpoint
+// directory or archive). The good commit must pass and the bad commit must
+// fail, otherwise an error is returned. For every tested commit the
+// aurora-engine is built, the local neard state is restored from the
+// breakpoint, the EVM contract is upgraded, and the transaction is replayed.
+// A commit is bad if the transaction fails. Commits which fail to build are
+// skipped. If build is true, the nearcore head recorded in the breakpoint is
+// built first. Afterwards the branch (or commit) checked out before is
+// restored in ../aurora-engine.
+func Bisect(
+	breakpoint string,
+	good, bad string,
+	build bool,
+	release bool,
+	gas uint64,
+) (first string, err error) {
+	breakpointDir, cleanup, err := openBreakpoint(breakpoint)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	bp, err := loadBreakpoint(breakpointDir)
+	if err != nil {
+		return "", err
+	}
+	engineDir := filepath.Join("..", "aurora-engine")
+	commits, err := git.RevList(engineDir, good, bad)
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("replayer: %s is not an ancestor of %s", good, bad)
+	}
+
+	// restore aurora-engine branch (or detached head) afterwards
+	head, err := git.Branch(engineDir)
+	if err != nil {
+		return "", err
+	}
+	if head == "" {
+		if head, err = git.Head(engineDir); err != nil {
+			return "", err
+		}
+	}
+	defer func() {
+		if e := git.Checkout(engineDir, head); e != nil {
+			e = fmt.Errorf("replayer: cannot restore aurora-engine %s: %w", head, e)
+			if err == nil {
+				err = e
+			} else {
+				log.Error(e.Error())
+			}
+		}
+	}()
+
+	nearDaemon, err := loadBreakpointNeard(bp, build, release)
+	if err != nil {
+		return "", err
+	}
+	contract := filepath.Join(engineDir, engineContract)
+	return bisect(good, commits, func(commit string) (bool, error) {
+		log.Info(fmt.Sprintf("test aurora-engine commit %s", commit))
+		if err := buildAuroraEngine(commit); err != nil {
+			return false, fmt.Errorf("%w: cannot build aurora-engine commit %s: %s",
+				errUntestable, commit, err)
+		}
+		txResult, err := replayBreakpointTx(nearDaemon, breakpointDir, bp, contract, gas)
+		if err != nil {
+			return false, err
+		}
+		return txFailed(txResult), nil
+	})
+}
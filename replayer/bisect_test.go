@@ -0,0 +1,136 @@
+package replayer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testCommits returns n test commits (c0, ..., c<n-1>).
+func testCommits(n int) []string {
+	var commits []string
+	for i := 0; i < n; i++ {
+		commits = append(commits, fmt.Sprintf("c%d", i))
+	}
+	return commits
+}
+
+// testIsBad returns a test function for bisect where all commits starting
+// with firstBad are bad and the commits in untestable cannot be tested. The
+// tested commits (except the endpoints) are recorded in tested.
+func testIsBad(
+	t *testing.T,
+	firstBad int,
+	untestable map[string]bool,
+	tested map[string]bool,
+) func(string) (bool, error) {
+	return func(commit string) (bool, error) {
+		if commit == "good" {
+			return false, nil
+		}
+		var i int
+		fmt.Sscanf(commit, "c%d", &i)
+		if commit != "c12" {
+			if tested[commit] {
+				t.Errorf("commit %s tested twice", commit)
+			}
+			tested[commit] = true
+		}
+		if untestable[commit] {
+			return false, fmt.Errorf("%w: build failed", errUntestable)
+		}
+		return i >= firstBad, nil
+	}
+}
+
+func TestBisect(t *testing.T) {
+	commits := testCommits(13)
+	for firstBad := 0; firstBad < len(commits); firstBad++ {
+		tested := make(map[string]bool)
+		commit, err := bisect("good", commits, testIsBad(t, firstBad, nil, tested))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit != commits[firstBad] {
+			t.Errorf("bisect() = %s, expected %s", commit, commits[firstBad])
+		}
+		if len(tested) > 4 {
+			t.Errorf("bisect() tested %d commits, expected at most 4", len(tested))
+		}
+	}
+}
+
+func TestBisectSkip(t *testing.T) {
+	commits := testCommits(13)
+	untestable := map[string]bool{"c5": true, "c6": true, "c9": true}
+	for firstBad := 0; firstBad < len(commits); firstBad++ {
+		// the first bad commit cannot be determined if it follows a skipped
+		// commit or is skipped itself
+		var candidates string
+		switch firstBad {
+		case 5, 6, 7:
+			candidates = "c5 c6 c7"
+		case 9, 10:
+			candidates = "c9 c10"
+		}
+		tested := make(map[string]bool)
+		commit, err := bisect("good", commits, testIsBad(t, firstBad, untestable, tested))
+		if candidates != "" {
+			if err == nil || !strings.HasSuffix(err.Error(), "could be any of: "+candidates) {
+				t.Errorf("bisect() with first bad commit c%d returned %v, expected candidates %s",
+					firstBad, err, candidates)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit != commits[firstBad] {
+			t.Errorf("bisect() = %s, expected %s", commit, commits[firstBad])
+		}
+	}
+}
+
+func TestBisectEndpoints(t *testing.T) {
+	commits := testCommits(3)
+	_, err := bisect("good", commits, func(string) (bool, error) {
+		return true, nil
+	})
+	if err == nil || err.Error() != "replayer: good commit good is bad" {
+		t.Errorf("bisect() with bad good commit returned %v", err)
+	}
+	_, err = bisect("good", commits, func(string) (bool, error) {
+		return false, nil
+	})
+	if err == nil || err.Error() != "replayer: bad commit c2 is good" {
+		t.Errorf("bisect() with good bad commit returned %v", err)
+	}
+	// endpoints cannot be skipped
+	errBuild := fmt.Errorf("%w: build failed", errUntestable)
+	_, err = bisect("good", commits, func(commit string) (bool, error) {
+		return commit == "c2", errBuild
+	})
+	if err != errBuild {
+		t.Errorf("bisect() returned %v, expected %v", err, errBuild)
+	}
+}
+
+func TestBisectError(t *testing.T) {
+	if _, err := bisect("good", nil, nil); err == nil {
+		t.Error("bisect() without commits should fail")
+	}
+	errReplay := errors.New("replay failed")
+	_, err := bisect("good", []string{"a", "b", "c"}, func(commit string) (bool, error) {
+		switch commit {
+		case "good":
+			return false, nil
+		case "c":
+			return true, nil
+		}
+		return false, errReplay
+	})
+	if err != errReplay {
+		t.Errorf("bisect() returned %v, expected %v", err, errReplay)
+	}
+}
@@ -0,0 +1,61 @@
+package replayer
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/util/nearmock"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/aurora-is-near/near-api-go/keystore"
+)
+
+func TestCreateAccount(t *testing.T) {
+	home := setTestHome(t)
+	srv := nearmock.NewServer()
+	defer srv.Close()
+	kp, err := keystore.GenerateEd25519KeyPair("test.near")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".near-credentials", "local"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Write("local"); err != nil {
+		t.Fatal(err)
+	}
+	srv.AddAccount("test.near", kp.PublicKey)
+
+	cfg := &near.Config{NetworkID: "local", NodeURL: srv.URL}
+	ca := CreateAccount{
+		Config:         cfg,
+		InitialBalance: "100",
+		MasterAccount:  "test.near",
+	}
+	if err := ca.Create(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	conn := near.NewConnection(srv.URL)
+	state, err := conn.GetAccountState(testEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state["amount"] != "100000000000000000000000000" {
+		t.Errorf("account has balance %v, expected 100 NEAR", state["amount"])
+	}
+	// the saved key can be used for the new account
+	a, err := near.LoadAccount(conn, cfg, testEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.SendMoney("test.near", *big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Create(testEngine); err == nil {
+		t.Error("Create() should fail for existing account")
+	}
+	if err := ca.Create("aurora.other.near"); err == nil {
+		t.Error("Create() should fail for account of other master account")
+	}
+}
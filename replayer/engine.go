@@ -0,0 +1,48 @@
+package replayer
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/aurora-is-near/evm-bully/util/aurora"
+	"github.com/aurora-is-near/evm-bully/util/nearvm"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Backends executing the engine.
+const (
+	BackendNeard = "neard" // engine installed on a NEAR node (accessed via RPC)
+	BackendWasm  = "wasm"  // engine executed in an embedded wasm runtime
+)
+
+// A caller sends calls to the engine. It is implemented by *near.Account and
+// by *nearvm.Account.
+type caller interface {
+	FunctionCall(
+		contractID, methodName string,
+		args []byte,
+		gas uint64,
+		amount big.Int,
+	) (map[string]interface{}, error)
+	SignAndSendTransaction(
+		receiverID string,
+		actions []near.Action,
+	) (map[string]interface{}, error)
+}
+
+// startEngine installs r.Contract to evmContract in a new embedded runtime
+// and returns the account signing the calls (r.Breakpoint.AccountID) and the
+// hash of the installed contract.
+func (r *Replayer) startEngine(evmContract string) (caller, string, error) {
+	log.Info(fmt.Sprintf("start embedded engine '%s'", evmContract))
+	rt := nearvm.New()
+	rt.AddAccount(evmContract)
+	rt.AddAccount(r.Breakpoint.AccountID)
+	err := aurora.Install(rt.Account(evmContract), evmContract, "", r.ChainID, r.Contract)
+	if err != nil {
+		return nil, "", err
+	}
+	r.runtime = rt
+	return rt.Account(r.Breakpoint.AccountID), rt.CodeHash(evmContract), nil
+}
@@ -0,0 +1,144 @@
+package replayer
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/aurora-is-near/evm-bully/util/wasm/wasmtest"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+)
+
+// testEngineMethods are the methods of the test engine contract.
+var testEngineMethods = []string{"new", "new_eth_connector", "begin_chain", "begin_block", "submit"}
+
+// writeTestEngine writes a contract which stores the input of each method as
+// key with the method name as value and returns its filename. If failSubmit
+// is true, 'submit' traps instead.
+func writeTestEngine(t *testing.T, failSubmit bool) string {
+	const namesPtr = 60000 // method names are stored after the input
+	k := wasmtest.I64Const
+	call := wasmtest.Call
+	i64 := byte(wasmtest.I64)
+	var funcs, exports, bodies, datas [][]byte
+	ptr := int64(namesPtr)
+	for i, method := range testEngineMethods {
+		funcs = append(funcs, wasmtest.ULEB(4))
+		exports = append(exports, wasmtest.Export(method, 0, uint64(4+i)))
+		body := wasmtest.Body(nil,
+			k(0), call(0), // input(0)
+			k(0), k(0), call(2), // read_register(0, 0)
+			k(0), call(1), k(0), k(int64(len(method))), k(ptr), k(1), call(3), []byte{0x1a}, // storage_write
+			[]byte{0x0b})
+		if method == "submit" && failSubmit {
+			body = wasmtest.Body(nil, []byte{0x00, 0x0b}) // unreachable
+		}
+		bodies = append(bodies, body)
+		datas = append(datas, wasmtest.Data(int32(ptr), []byte(method)))
+		ptr += int64(len(method))
+	}
+	code := wasmtest.Module(
+		wasmtest.Section(wasmtest.SectionType,
+			wasmtest.FuncType([]byte{i64}, nil),
+			wasmtest.FuncType([]byte{i64}, []byte{i64}),
+			wasmtest.FuncType([]byte{i64, i64}, nil),
+			wasmtest.FuncType([]byte{i64, i64, i64, i64, i64}, []byte{i64}),
+			wasmtest.FuncType(nil, nil)),
+		wasmtest.Section(wasmtest.SectionImport,
+			wasmtest.Import("env", "input", 0),
+			wasmtest.Import("env", "register_len", 1),
+			wasmtest.Import("env", "read_register", 2),
+			wasmtest.Import("env", "storage_write", 3)),
+		wasmtest.Section(wasmtest.SectionFunction, funcs...),
+		wasmtest.Section(wasmtest.SectionMemory, []byte{0x00, 1}),
+		wasmtest.Section(wasmtest.SectionExport, exports...),
+		wasmtest.Section(wasmtest.SectionCode, bodies...),
+		wasmtest.Section(wasmtest.SectionData, datas...),
+	)
+	filename := filepath.Join(t.TempDir(), "engine.wasm")
+	if err := os.WriteFile(filename, code, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// newTestWasmReplayer returns a replayer for the synthetic dump with the
+// embedded wasm backend.
+func newTestWasmReplayer(t *testing.T, failSubmit bool) (*Replayer, [][][]byte) {
+	setTestHome(t)
+	rlps := writeTestDump(t)
+	r := &Replayer{
+		Config:     &near.Config{NetworkID: "local"},
+		ChainID:    testChainID,
+		Gas:        300000000000000,
+		Testnet:    testTestnet,
+		Genesis:    &core.Genesis{Alloc: core.GenesisAlloc{common.Address{2}: {Balance: big.NewInt(1)}}},
+		BatchSize:  10,
+		BreakBlock: -1,
+		Breakpoint: Breakpoint{AccountID: testEngine},
+		Contract:   writeTestEngine(t, failSubmit),
+		Backend:    BackendWasm,
+	}
+	return r, rlps
+}
+
+func TestReplayWasm(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		r, rlps := newTestWasmReplayer(t, false)
+		r.Batch = batch
+		r.BatchSize = 3
+		if err := r.Replay(testEngine); err != nil {
+			t.Fatal(err)
+		}
+		for height, txs := range rlps {
+			for i, rlp := range txs {
+				if v := r.runtime.State(testEngine, rlp); string(v) != "submit" {
+					t.Errorf("batch=%v: transaction %d in block %d stored by %q", batch, i, height, v)
+				}
+			}
+		}
+		// one block per transaction: install and 11 calls (or 4 batches)
+		exp := uint64(12)
+		if batch {
+			exp = 5
+		}
+		if h := r.runtime.Height(); h != exp {
+			t.Errorf("batch=%v: Height() = %d, expected %d", batch, h, exp)
+		}
+		// the journal records the hash of the installed contract
+		cacheDir, err := util.DetermineCacheDir(testTestnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, cp, err := readJournal(filepath.Join(cacheDir, JournalFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.ContractHash != r.runtime.CodeHash(testEngine) {
+			t.Errorf("batch=%v: journal contains contract hash %s, expected %s",
+				batch, s.ContractHash, r.runtime.CodeHash(testEngine))
+		}
+		if cp == nil || cp.Block != 5 || cp.Tx != 0 {
+			t.Errorf("batch=%v: last checkpoint %+v, expected block 5, tx 0", batch, cp)
+		}
+	}
+}
+
+func TestReplayWasmFailure(t *testing.T) {
+	r, _ := newTestWasmReplayer(t, true)
+	blockNum, txNum, errormsg, err := r.replay(testEngine)
+	if err == nil {
+		t.Fatal("replay() should fail")
+	}
+	if blockNum != 1 || txNum != 0 {
+		t.Errorf("replay() failed at block %d, tx %d, expected block 1, tx 0", blockNum, txNum)
+	}
+	if !strings.Contains(string(errormsg), "WebAssembly trap") {
+		t.Errorf("error message %s does not contain execution error", errormsg)
+	}
+}
@@ -0,0 +1,192 @@
+package replayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Event types written to the event log.
+const (
+	EventBlockBegin = "block_begin" // 'begin_block' call sent
+	EventSubmit     = "submit"      // 'submit' call sent
+	EventCall       = "call"        // other call sent (e.g., 'begin_chain')
+	EventBatch      = "batch"       // batch of calls sent
+	EventResult     = "result"      // result of a call or batch received
+	EventSkipRange  = "skip_range"  // range of blocks skipped
+	EventError      = "error"       // replay aborted with error
+)
+
+// Outcomes of calls recorded in result events.
+const (
+	OutcomeSuccess  = "success"   // NEAR transaction succeeded
+	OutcomeFailure  = "failure"   // NEAR transaction failed
+	OutcomeRPCError = "rpc_error" // RPC call failed
+)
+
+// An Event is a single line of the event log.
+type Event struct {
+	Time       time.Time  `json:"time"`
+	Type       string     `json:"type"`
+	Block      int        `json:"block"`                // block number (-1 if undefined)
+	Tx         int        `json:"tx"`                   // transaction number (of last transaction in batch)
+	Method     string     `json:"method,omitempty"`     // Aurora Engine method
+	Txs        int        `json:"txs,omitempty"`        // number of Ethereum transactions (in batch)
+	TxHash     string     `json:"txHash,omitempty"`     // NEAR transaction hash
+	Outcome    string     `json:"outcome,omitempty"`    // outcome of call
+	DurationMs float64    `json:"durationMs,omitempty"` // wall-clock latency of call
+	GasBurnt   uint64     `json:"gasBurnt,omitempty"`   // NEAR gas burnt (including receipts)
+	Skip       *SkipRange `json:"skip,omitempty"`       // skipped blocks
+	Message    string     `json:"message,omitempty"`    // comment or error message
+}
+
+// eventLog writes events as JSON lines. It is safe for concurrent use and all
+// methods can be called on a nil eventLog (if no event log is written).
+type eventLog struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+// createEventLog creates the event log filename.
+func createEventLog(filename string) (*eventLog, error) {
+	fp, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newEventLog(fp), nil
+}
+
+func newEventLog(w io.WriteCloser) *eventLog {
+	return &eventLog{w: w, enc: json.NewEncoder(w)}
+}
+
+// emit writes event e (the time is set automatically).
+func (l *eventLog) emit(e *Event) {
+	if l == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil {
+		log.Error(fmt.Sprintf("cannot write event: %s", err))
+	}
+}
+
+// Close the event log.
+func (l *eventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.w.Close()
+}
+
+// nearTxHash returns the NEAR transaction hash from txResult (empty, if
+// undefined).
+func nearTxHash(txResult map[string]interface{}) string {
+	tx, ok := txResult["transaction"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	hash, _ := tx["hash"].(string)
+	return hash
+}
+
+// emitCall emits the event for sending a call of method for tx.
+func (l *eventLog) emitCall(method string, tx *Tx) {
+	typ := EventCall
+	switch method {
+	case "begin_block":
+		typ = EventBlockBegin
+	case "submit":
+		typ = EventSubmit
+	}
+	l.emit(&Event{
+		Type:    typ,
+		Block:   tx.BlockNum,
+		Tx:      tx.TxNum,
+		Method:  method,
+		Txs:     ethTxs(tx),
+		Message: tx.Comment,
+	})
+}
+
+// emitBatch emits the event for sending a batch of n calls, which contains
+// txs Ethereum transactions and ends with tx.
+func (l *eventLog) emitBatch(tx *Tx, n, txs int) {
+	l.emit(&Event{
+		Type:    EventBatch,
+		Block:   tx.BlockNum,
+		Tx:      tx.TxNum,
+		Txs:     txs,
+		Message: fmt.Sprintf("batch of %d calls", n),
+	})
+}
+
+// emitResult emits the result event of a call of method (see
+// metrics.observe).
+func (l *eventLog) emitResult(
+	method string,
+	tx *Tx,
+	txs int,
+	latency time.Duration,
+	txResult map[string]interface{},
+	err error,
+) {
+	if l == nil {
+		return
+	}
+	e := &Event{
+		Type:       EventResult,
+		Block:      tx.BlockNum,
+		Tx:         tx.TxNum,
+		Method:     method,
+		Txs:        txs,
+		DurationMs: float64(latency) / float64(time.Millisecond),
+	}
+	if err != nil {
+		e.Outcome = OutcomeRPCError
+		e.Message = err.Error()
+	} else {
+		e.TxHash = nearTxHash(txResult)
+		e.GasBurnt = newCallStats(method, tx, txs, latency, txResult).GasBurnt
+		if txFailed(txResult) {
+			e.Outcome = OutcomeFailure
+			if status, ok := txResult["status"].(map[string]interface{}); ok {
+				if jsn, err := json.Marshal(status["Failure"]); err == nil {
+					e.Message = string(jsn)
+				}
+			}
+		} else {
+			e.Outcome = OutcomeSuccess
+		}
+	}
+	l.emit(e)
+}
+
+// emitSkipRange emits the event for the blocks skipped by tx.
+func (l *eventLog) emitSkipRange(tx *Tx) {
+	l.emit(&Event{
+		Type:    EventSkipRange,
+		Block:   -1,
+		Tx:      -1,
+		Skip:    tx.Skip,
+		Message: tx.Comment,
+	})
+}
+
+// emitError emits the error event for err which occurred at block and tx.
+func (l *eventLog) emitError(block, tx int, err error) {
+	l.emit(&Event{
+		Type:    EventError,
+		Block:   block,
+		Tx:      tx,
+		Message: err.Error(),
+	})
+}
@@ -0,0 +1,70 @@
+package replayer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aurora-is-near/evm-bully/db"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestEventLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := newEventLog(nopCloser{&buf})
+	txResult := decodeTestTxResult(t)
+	tx := &Tx{BlockNum: 3, TxNum: 1, Comment: "submit(3, tx=1)", EthTx: &db.Transaction{}}
+	l.emitCall("begin_block", &Tx{BlockNum: 3})
+	l.emitCall("submit", tx)
+	l.emitResult("submit", tx, 1, 1500*time.Microsecond, txResult, nil)
+	l.emitResult("submit", tx, 1, time.Second, nil, errors.New("timeout"))
+	l.emitSkipRange(&Tx{BlockNum: -1, Skip: &SkipRange{First: 0, Last: 9}})
+	l.emitError(3, 1, errors.New("replayer: transaction failed"))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var e Event
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 6 {
+		t.Fatalf("got %d events, expected 6", len(events))
+	}
+	types := []string{EventBlockBegin, EventSubmit, EventResult, EventResult,
+		EventSkipRange, EventError}
+	for i, typ := range types {
+		if events[i].Type != typ {
+			t.Errorf("event %d has type %s, expected %s", i, events[i].Type, typ)
+		}
+	}
+	res := events[2]
+	if res.Block != 3 || res.Tx != 1 || res.Outcome != OutcomeSuccess ||
+		res.TxHash != "9BTa" || res.DurationMs != 1.5 || res.GasBurnt == 0 {
+		t.Errorf("unexpected result event: %+v", res)
+	}
+	if events[3].Outcome != OutcomeRPCError || events[3].Message != "timeout" {
+		t.Errorf("unexpected result event: %+v", events[3])
+	}
+	if skip := events[4].Skip; skip == nil || skip.First != 0 || skip.Last != 9 {
+		t.Errorf("unexpected skip range: %+v", skip)
+	}
+
+	// nil event logs are ignored
+	var nl *eventLog
+	nl.emitCall("submit", tx)
+	nl.emitError(0, 0, errors.New("error"))
+}
@@ -0,0 +1,330 @@
+package replayer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// A TxFilter selects the Ethereum transactions to replay. A transaction
+// matches, if it matches all defined criteria (a criterion with several
+// values matches, if one of the values matches).
+type TxFilter struct {
+	Senders    []common.Address
+	Recipients []common.Address
+	CreateOnly bool      // only contract creations
+	Selectors  [][4]byte // function selectors (first 4 bytes of call data)
+	Types      []uint8   // transaction types
+	MinSize    int       // minimum size of the RLP encoded transaction
+	MaxSize    int       // maximum size of the RLP encoded transaction (0: unlimited)
+	// Include the transitive dependencies of matching transactions: all
+	// earlier transactions which touch the accounts (sender, recipient,
+	// created contract, and log addresses) of included transactions.
+	Dependencies bool
+}
+
+// ParseTxFilter parses the filter expression expr. The expression is a
+// comma-separated list of terms, alternative values of a term are separated
+// by '|':
+//
+//	from=<address>|...    sender
+//	to=<address>|...      recipient
+//	create                contract creations only
+//	selector=<hex>|...    4-byte function selector of the call data
+//	type=<n>|...          transaction type (0: legacy, 1: access list, 2: dynamic fee)
+//	size=<min>-<max>      size range of RLP encoded transaction (both optional)
+func ParseTxFilter(expr string) (*TxFilter, error) {
+	var f TxFilter
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		if term == "create" {
+			f.CreateOnly = true
+			continue
+		}
+		parts := strings.SplitN(term, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("replayer: invalid filter term '%s'", term)
+		}
+		key, value := parts[0], parts[1]
+		if key == "size" {
+			if err := f.parseSize(value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, v := range strings.Split(value, "|") {
+			if err := f.addValue(key, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &f, nil
+}
+
+func (f *TxFilter) addValue(key, v string) error {
+	switch key {
+	case "from", "to":
+		if !common.IsHexAddress(v) {
+			return fmt.Errorf("replayer: invalid address in filter: %s", v)
+		}
+		if key == "from" {
+			f.Senders = append(f.Senders, common.HexToAddress(v))
+		} else {
+			f.Recipients = append(f.Recipients, common.HexToAddress(v))
+		}
+	case "selector":
+		b, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+		if err != nil || len(b) != 4 {
+			return fmt.Errorf("replayer: invalid selector in filter: %s", v)
+		}
+		var sel [4]byte
+		copy(sel[:], b)
+		f.Selectors = append(f.Selectors, sel)
+	case "type":
+		t, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return fmt.Errorf("replayer: invalid transaction type in filter: %s", v)
+		}
+		f.Types = append(f.Types, uint8(t))
+	default:
+		return fmt.Errorf("replayer: unknown filter term '%s'", key)
+	}
+	return nil
+}
+
+func (f *TxFilter) parseSize(v string) error {
+	parts := strings.SplitN(v, "-", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("replayer: invalid size range in filter: %s", v)
+	}
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return fmt.Errorf("replayer: invalid size range in filter: %s", v)
+		}
+		if i == 0 {
+			f.MinSize = n
+		} else {
+			f.MaxSize = n
+		}
+	}
+	if f.MaxSize != 0 && f.MaxSize < f.MinSize {
+		return fmt.Errorf("replayer: empty size range in filter: %s", v)
+	}
+	return nil
+}
+
+func containsAddress(addrs []common.Address, a *common.Address) bool {
+	if a == nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr == *a {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTx is an Ethereum transaction decoded for filtering.
+type filterTx struct {
+	tx   *db.Transaction
+	etx  *types.Transaction
+	from common.Address
+}
+
+// decodeFilterTx decodes tx and recovers its sender, if sender is true.
+func decodeFilterTx(tx *db.Transaction, sender bool) (*filterTx, error) {
+	var etx types.Transaction
+	if err := etx.UnmarshalBinary(tx.RLP); err != nil {
+		return nil, err
+	}
+	if !sender {
+		return &filterTx{tx: tx, etx: &etx}, nil
+	}
+	var signer types.Signer = types.HomesteadSigner{}
+	if etx.Protected() {
+		signer = types.LatestSignerForChainID(etx.ChainId())
+	}
+	from, err := types.Sender(signer, &etx)
+	if err != nil {
+		return nil, err
+	}
+	return &filterTx{tx: tx, etx: &etx, from: from}, nil
+}
+
+// accounts returns the accounts touched by ft: the sender, the recipient (or
+// the created contract), and the addresses of the logs (if the dump contains
+// receipts).
+func (ft *filterTx) accounts() []common.Address {
+	accounts := []common.Address{ft.from}
+	if ft.tx.To != nil {
+		accounts = append(accounts, *ft.tx.To)
+	} else {
+		accounts = append(accounts, crypto.CreateAddress(ft.from, ft.tx.Nonce))
+	}
+	if ft.tx.Receipt != nil {
+		for _, l := range ft.tx.Receipt.Logs {
+			accounts = append(accounts, l.Address)
+		}
+	}
+	return accounts
+}
+
+// match returns true, if ft matches filter f (without dependencies). The
+// sender of ft must be recovered, if f selects senders.
+func (f *TxFilter) match(ft *filterTx) bool {
+	tx := ft.tx
+	if len(f.Senders) > 0 && !containsAddress(f.Senders, &ft.from) {
+		return false
+	}
+	if len(f.Recipients) > 0 && !containsAddress(f.Recipients, tx.To) {
+		return false
+	}
+	if f.CreateOnly && tx.To != nil {
+		return false
+	}
+	if len(f.Selectors) > 0 {
+		if len(tx.Data) < 4 {
+			return false
+		}
+		found := false
+		for _, sel := range f.Selectors {
+			if string(sel[:]) == string(tx.Data[:4]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == ft.etx.Type() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(tx.RLP) < f.MinSize || (f.MaxSize != 0 && len(tx.RLP) > f.MaxSize) {
+		return false
+	}
+	return true
+}
+
+// txPos is the position of a transaction in the dump.
+type txPos struct {
+	block int
+	tx    int
+}
+
+// scannedTx is a transaction recorded during the dependency scan.
+type scannedTx struct {
+	pos      txPos
+	match    bool
+	accounts []common.Address
+}
+
+// scanDependencies scans the dump from r.StartBlock up to r.BreakBlock (or
+// the end) and returns the positions of the transactions matching r.Filter
+// together with their transitive dependencies.
+func (r *Replayer) scanDependencies() (map[txPos]bool, error) {
+	reader, err := db.NewReader(r.Testnet)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if err := reader.Seek(uint64(r.StartBlock)); err != nil {
+		return nil, err
+	}
+	log.Info("scan transaction dependencies")
+	var txs []scannedTx
+	for height := r.StartBlock; r.BreakBlock == -1 || height <= r.BreakBlock; height++ {
+		b, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			break
+		}
+		for i, tx := range b.Transactions {
+			ft, err := decodeFilterTx(tx, true)
+			if err != nil {
+				return nil, fmt.Errorf("replayer: cannot decode transaction %d in block %d: %w",
+					i, height, err)
+			}
+			txs = append(txs, scannedTx{
+				pos:      txPos{block: height, tx: i},
+				match:    r.Filter.match(ft),
+				accounts: ft.accounts(),
+			})
+		}
+	}
+	// walk backwards: a transaction is included, if it matches or touches an
+	// account touched by an included (later) transaction
+	included := make(map[txPos]bool)
+	touched := make(map[common.Address]bool)
+	for i := len(txs) - 1; i >= 0; i-- {
+		tx := &txs[i]
+		include := tx.match
+		for _, a := range tx.accounts {
+			if include {
+				break
+			}
+			include = touched[a]
+		}
+		if !include {
+			continue
+		}
+		included[tx.pos] = true
+		for _, a := range tx.accounts {
+			touched[a] = true
+		}
+	}
+	log.Info(fmt.Sprintf("%d of %d transactions included (with dependencies)", len(included), len(txs)))
+	return included, nil
+}
+
+// filterTxs returns which transactions of the block at height are selected by
+// r.Filter (all, if r.Filter is nil) and the number of selected transactions.
+func (r *Replayer) filterTxs(height int, txs []*db.Transaction) ([]bool, int, error) {
+	selected := make([]bool, len(txs))
+	n := 0
+	for i, tx := range txs {
+		switch {
+		case r.Filter == nil:
+			selected[i] = true
+		case r.Filter.Dependencies:
+			selected[i] = r.filterSet[txPos{block: height, tx: i}]
+		default:
+			ft, err := decodeFilterTx(tx, len(r.Filter.Senders) > 0)
+			if err != nil {
+				return nil, 0, fmt.Errorf("replayer: cannot decode transaction %d in block %d: %w",
+					i, height, err)
+			}
+			selected[i] = r.Filter.match(ft)
+		}
+		if selected[i] {
+			n++
+		}
+	}
+	return selected, n, nil
+}
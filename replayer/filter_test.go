@@ -0,0 +1,210 @@
+package replayer
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestParseTxFilter(t *testing.T) {
+	f, err := ParseTxFilter("from=0x0000000000000000000000000000000000000001|0x0000000000000000000000000000000000000002, " +
+		"to=0x0000000000000000000000000000000000000003,create,selector=0xa9059cbb,type=0|2,size=100-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Senders) != 2 || f.Senders[1] != (common.Address{19: 2}) {
+		t.Errorf("wrong senders: %v", f.Senders)
+	}
+	if len(f.Recipients) != 1 || f.Recipients[0] != (common.Address{19: 3}) {
+		t.Errorf("wrong recipients: %v", f.Recipients)
+	}
+	if !f.CreateOnly {
+		t.Error("create not set")
+	}
+	if len(f.Selectors) != 1 || f.Selectors[0] != [4]byte{0xa9, 0x05, 0x9c, 0xbb} {
+		t.Errorf("wrong selectors: %x", f.Selectors)
+	}
+	if len(f.Types) != 2 || f.Types[1] != 2 {
+		t.Errorf("wrong types: %v", f.Types)
+	}
+	if f.MinSize != 100 || f.MaxSize != 0 {
+		t.Errorf("wrong size range: %d-%d", f.MinSize, f.MaxSize)
+	}
+	for _, expr := range []string{
+		"from=0x01",
+		"selector=0xa9059c",
+		"type=256",
+		"size=100",
+		"size=200-100",
+		"value=1",
+		"to",
+	} {
+		if _, err := ParseTxFilter(expr); err == nil {
+			t.Errorf("ParseTxFilter(%s) should fail", expr)
+		}
+	}
+}
+
+// testFilterTx returns a signed transaction from key to (contract creation,
+// if to is nil) with the given data.
+func testFilterTx(t *testing.T, key *ecdsa.PrivateKey, nonce uint64, to *common.Address, data []byte) *db.Transaction {
+	signer := types.LatestSignerForChainID(testChainID)
+	tx, err := types.SignNewTx(key, signer, &types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: big.NewInt(1),
+		Gas:      100000,
+		To:       to,
+		Value:    new(big.Int),
+		Data:     data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rlp, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &db.Transaction{RLP: rlp, Nonce: nonce, To: to, Data: data}
+}
+
+func TestTxFilterMatch(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.Address{3}
+	call := testFilterTx(t, key, 0, &to, []byte{0xa9, 0x05, 0x9c, 0xbb, 1})
+	create := testFilterTx(t, key, 1, nil, []byte{0x60, 0x00})
+	tests := []struct {
+		f      TxFilter
+		call   bool
+		create bool
+	}{
+		{TxFilter{}, true, true},
+		{TxFilter{Senders: []common.Address{from}}, true, true},
+		{TxFilter{Senders: []common.Address{to}}, false, false},
+		{TxFilter{Recipients: []common.Address{to}}, true, false},
+		{TxFilter{CreateOnly: true}, false, true},
+		{TxFilter{Selectors: [][4]byte{{0xa9, 0x05, 0x9c, 0xbb}}}, true, false},
+		{TxFilter{Selectors: [][4]byte{{1, 2, 3, 4}}}, false, false},
+		{TxFilter{Types: []uint8{types.LegacyTxType}}, true, true},
+		{TxFilter{Types: []uint8{types.DynamicFeeTxType}}, false, false},
+		{TxFilter{MinSize: len(call.RLP)}, true, false},
+		{TxFilter{MaxSize: len(create.RLP)}, false, true},
+	}
+	for i, test := range tests {
+		for _, c := range []struct {
+			tx  *db.Transaction
+			exp bool
+		}{{call, test.call}, {create, test.create}} {
+			ft, err := decodeFilterTx(c.tx, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ft.from != from {
+				t.Fatalf("recovered sender %s, expected %s", ft.from.Hex(), from.Hex())
+			}
+			if m := test.f.match(ft); m != c.exp {
+				t.Errorf("test %d: match() = %v, expected %v", i, m, c.exp)
+			}
+		}
+	}
+}
+
+func TestScanDependencies(t *testing.T) {
+	setTestHome(t)
+	var keys [4]*ecdsa.PrivateKey
+	for i := range keys {
+		var err error
+		if keys[i], err = crypto.GenerateKey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, q, r, s := common.Address{0x10}, common.Address{0x11}, common.Address{0x12}, common.Address{0x13}
+	// blocks with the transactions (key index, recipient, selector)
+	type testTx struct {
+		key int
+		to  common.Address
+		sel byte
+	}
+	blocks := [][]testTx{
+		{},
+		{{0, p, 0xaa}, {1, q, 0xaa}},
+		{{2, r, 0xaa}, {0, s, 0xaa}},
+		{{3, p, 0xbb}},
+		{{1, q, 0xaa}},
+	}
+	cacheDir, err := util.DetermineCacheDir(testTestnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := db.NewWriter(filepath.Join(cacheDir, "dump.db"), db.CodecNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonces [4]uint64
+	for height, txs := range blocks {
+		b := &db.Block{Header: &types.Header{Number: big.NewInt(int64(height))}}
+		for _, tx := range txs {
+			to := tx.to
+			b.Transactions = append(b.Transactions,
+				testFilterTx(t, keys[tx.key], nonces[tx.key], &to, []byte{tx.sel, tx.sel, tx.sel, tx.sel}))
+			nonces[tx.key]++
+		}
+		if err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction in block 3 depends on the first transaction in block 1
+	// (same recipient), which depends on nothing (the later transaction of
+	// the same sender in block 2 is no dependency)
+	rep := &Replayer{
+		Testnet:    testTestnet,
+		BreakBlock: -1,
+		Filter:     &TxFilter{Selectors: [][4]byte{{0xbb, 0xbb, 0xbb, 0xbb}}, Dependencies: true},
+	}
+	set, err := rep.scanDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[txPos]bool{{block: 1, tx: 0}: true, {block: 3, tx: 0}: true}
+	if len(set) != len(exp) {
+		t.Fatalf("got %d transactions %v, expected %v", len(set), set, exp)
+	}
+	for pos := range exp {
+		if !set[pos] {
+			t.Errorf("transaction %d in block %d not included", pos.tx, pos.block)
+		}
+	}
+
+	// transactions after the break block are ignored
+	rep.Filter = &TxFilter{Recipients: []common.Address{q}, Dependencies: true}
+	rep.BreakBlock = 3
+	set, err = rep.scanDependencies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 1 || !set[txPos{block: 1, tx: 1}] {
+		t.Errorf("got transactions %v, expected only transaction 1 in block 1", set)
+	}
+	rep.filterSet = set
+	selected, n, err := rep.filterTxs(1, make([]*db.Transaction, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || selected[0] || !selected[1] {
+		t.Errorf("filterTxs() = %v, %d", selected, n)
+	}
+}
@@ -8,19 +8,6 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 )
 
-func getGenesisBlock(net string) *core.Genesis {
-	switch net {
-	case "goerli":
-		return core.DefaultGoerliGenesisBlock()
-	case "rinkeby":
-		return core.DefaultRinkebyGenesisBlock()
-	case "ropsten":
-		return core.DefaultRopstenGenesisBlock()
-	default:
-		return core.DefaultGenesisBlock()
-	}
-}
-
 // AddrSlice is an array of addresses.
 type AddrSlice []common.Address
 
@@ -42,8 +29,7 @@ func dumpAccounts(g *core.Genesis) error {
 	return nil
 }
 
-// ProcGenesisBlock processes the genesis block for the given testnet.
-func ProcGenesisBlock(testnet string) error {
-	g := getGenesisBlock(testnet)
+// ProcGenesisBlock processes the genesis block g.
+func ProcGenesisBlock(g *core.Genesis) error {
 	return dumpAccounts(g)
 }
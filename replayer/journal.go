@@ -0,0 +1,222 @@
+package replayer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// JournalFilename is the name of the checkpoint journal in the cache
+// directory of a testnet.
+const JournalFilename = "replay.journal"
+
+// ErrNothingToResume is returned if a replay cannot be resumed because the
+// checkpoint journal contains no confirmed transactions.
+var ErrNothingToResume = errors.New("replayer: checkpoint journal contains no confirmed transactions")
+
+// journalSession records the start of a replay (or a resumed replay).
+type journalSession struct {
+	Time          time.Time   `json:"time"`
+	EngineAccount string      `json:"engine-account"`
+	ContractHash  string      `json:"contract-hash"`
+	NearcoreHead  string      `json:"nearcore,omitempty"`
+	Start         *checkpoint `json:"start,omitempty"` // first replayed transaction (nil, if replayed from genesis)
+}
+
+// checkpoint records a confirmed transaction.
+type checkpoint struct {
+	Block int          `json:"block"`
+	Tx    int          `json:"tx"`
+	done  map[int]bool // confirmed transactions in Block (set by readJournal)
+}
+
+// journalRecord is a single line of the checkpoint journal.
+type journalRecord struct {
+	Session   *journalSession `json:"session,omitempty"`
+	Confirmed *checkpoint     `json:"confirmed,omitempty"`
+}
+
+// A journal is an append-only checkpoint journal (JSON lines) which records
+// the confirmed 'submit' calls of a replay.
+type journal struct {
+	fp  *os.File
+	enc *json.Encoder
+}
+
+// openJournal opens the checkpoint journal filename and starts a new session
+// s. If resume is false, an existing journal is truncated.
+func openJournal(filename string, s *journalSession, resume bool) (*journal, error) {
+	flag := os.O_WRONLY | os.O_CREATE
+	if resume {
+		flag |= os.O_APPEND
+	} else {
+		flag |= os.O_TRUNC
+	}
+	fp, err := os.OpenFile(filename, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{fp: fp, enc: json.NewEncoder(fp)}
+	if err := j.enc.Encode(&journalRecord{Session: s}); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// confirm records tx as confirmed. Only 'submit' calls are recorded.
+func (j *journal) confirm(tx *Tx) error {
+	if j == nil || tx.EthTx == nil {
+		return nil
+	}
+	return j.enc.Encode(&journalRecord{
+		Confirmed: &checkpoint{Block: tx.BlockNum, Tx: tx.TxNum},
+	})
+}
+
+// Close the journal.
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.fp.Close()
+}
+
+// readJournal reads the checkpoint journal filename and returns its last
+// session and its last checkpoint in block order (nil, if no transaction was
+// confirmed). The transactions of a block are confirmed out of order in
+// pipelined mode, the checkpoint therefore records all confirmed transactions
+// of its block.
+func readJournal(filename string) (*journalSession, *checkpoint, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fp.Close()
+	var (
+		session *journalSession
+		cp      *checkpoint
+	)
+	s := bufio.NewScanner(fp)
+	for s.Scan() {
+		var rec journalRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			// a partially written last line is expected after a crash
+			log.Info(fmt.Sprintf("ignoring corrupt journal line: %s", err))
+			continue
+		}
+		if rec.Session != nil {
+			session = rec.Session
+		}
+		if c := rec.Confirmed; c != nil {
+			switch {
+			case cp == nil || c.Block > cp.Block:
+				c.done = map[int]bool{c.Tx: true}
+				cp = c
+			case c.Block == cp.Block:
+				cp.done[c.Tx] = true
+				if c.Tx > cp.Tx {
+					cp.Tx = c.Tx
+				}
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+	if session == nil {
+		return nil, nil, fmt.Errorf("replayer: checkpoint journal '%s' contains no session", filename)
+	}
+	return session, cp, nil
+}
+
+// contractHash returns the hash of the contract code deployed to accountID.
+func contractHash(conn *near.Connection, accountID string) (string, error) {
+	res, err := conn.GetContractCode(accountID)
+	if err != nil {
+		return "", err
+	}
+	hash, ok := res["hash"].(string)
+	if !ok {
+		return "", fmt.Errorf("replayer: view_code of '%s' returned no hash", accountID)
+	}
+	return hash, nil
+}
+
+// nodeVersion returns the version of the node conn is connected to, as
+// reported by its status (empty, if the node reports no version).
+func nodeVersion(conn *near.Connection) (string, error) {
+	status, err := conn.GetNodeStatus()
+	if err != nil {
+		return "", err
+	}
+	v, _ := status["version"].(map[string]interface{})
+	version, _ := v["version"].(string)
+	build, _ := v["build"].(string)
+	if version == "" && build == "" {
+		return "", nil
+	}
+	return fmt.Sprintf("%s (build %s)", version, build), nil
+}
+
+// startJournal starts a new session in the checkpoint journal for
+// evmContract with the contract hash, replayed on the given nearcore version
+// (empty, if unknown). If r.Resume is set, the session must match the last
+// session in the journal and the replay continues in the block of the last
+// checkpoint, skipping its confirmed transactions. A changed nearcore version
+// is only reported.
+func (r *Replayer) startJournal(evmContract, hash, nearcore string) error {
+	cacheDir, err := util.DetermineCacheDir(r.Testnet)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(cacheDir, JournalFilename)
+	if r.Resume {
+		last, cp, err := readJournal(filename)
+		if err != nil {
+			return err
+		}
+		if last.EngineAccount != evmContract {
+			return fmt.Errorf("replayer: cannot resume, engine account changed from '%s' to '%s'",
+				last.EngineAccount, evmContract)
+		}
+		if last.ContractHash != hash {
+			return fmt.Errorf("replayer: cannot resume, contract hash changed from %s to %s",
+				last.ContractHash, hash)
+		}
+		if last.NearcoreHead != "" && nearcore != "" && last.NearcoreHead != nearcore {
+			log.Warn(fmt.Sprintf("resume with nearcore %s, journal was recorded with %s",
+				nearcore, last.NearcoreHead))
+		}
+		if cp == nil {
+			return ErrNothingToResume
+		}
+		r.StartBlock = cp.Block
+		r.StartTx = 0
+		if last.Start != nil && last.Start.Block == cp.Block {
+			r.StartTx = last.Start.Tx
+		}
+		r.confirmed = cp.done
+		log.Info(fmt.Sprintf("resume in block %d (%d transactions confirmed, last %d)",
+			cp.Block, len(cp.done), cp.Tx))
+	}
+	s := &journalSession{
+		Time:          time.Now().UTC(),
+		EngineAccount: evmContract,
+		ContractHash:  hash,
+		NearcoreHead:  nearcore,
+	}
+	if r.StartBlock != 0 || r.StartTx != 0 {
+		s.Start = &checkpoint{Block: r.StartBlock, Tx: r.StartTx}
+	}
+	r.journal, err = openJournal(filename, s, r.Resume)
+	return err
+}
@@ -0,0 +1,111 @@
+package replayer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/db"
+)
+
+func TestJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), JournalFilename)
+	s := &journalSession{EngineAccount: "evm.test.near", ContractHash: "hash"}
+	j, err := openJournal(filename, s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only 'submit' calls are recorded
+	if err := j.confirm(&Tx{BlockNum: 1, TxNum: 0, EthTx: nil}); err != nil {
+		t.Fatal(err)
+	}
+	if _, cp, err := readJournal(filename); err != nil {
+		t.Fatal(err)
+	} else if cp != nil {
+		t.Fatalf("readJournal() returned checkpoint %v, expected none", cp)
+	}
+	if err := j.confirm(&Tx{BlockNum: 3, TxNum: 1, EthTx: &db.Transaction{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.confirm(&Tx{BlockNum: 4, TxNum: 0, EthTx: &db.Transaction{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate crash during write
+	fp, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fp.WriteString(`{"confirmed":{"blo`); err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+
+	session, cp, err := readJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *session != *s {
+		t.Errorf("readJournal() returned session %v, expected %v", session, s)
+	}
+	if cp == nil || cp.Block != 4 || cp.Tx != 0 {
+		t.Errorf("readJournal() returned checkpoint %v, expected {4 0}", cp)
+	}
+
+	// a resumed session is appended
+	j, err = openJournal(filename, s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if _, cp, err := readJournal(filename); err != nil {
+		t.Fatal(err)
+	} else if cp == nil || cp.Block != 4 {
+		t.Errorf("checkpoint lost after resume: %v", cp)
+	}
+
+	// a new session truncates the journal
+	j, err = openJournal(filename, s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if _, cp, err := readJournal(filename); err != nil {
+		t.Fatal(err)
+	} else if cp != nil {
+		t.Errorf("readJournal() returned checkpoint %v after truncation", cp)
+	}
+}
+
+func TestJournalOutOfOrder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), JournalFilename)
+	s := &journalSession{EngineAccount: "evm.test.near", Start: &checkpoint{Block: 2, Tx: 1}}
+	j, err := openJournal(filename, s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pipelined mode confirms the transactions of a block out of order
+	for _, c := range []checkpoint{{Block: 2, Tx: 1}, {Block: 3, Tx: 4}, {Block: 3, Tx: 1}, {Block: 3, Tx: 2}} {
+		if err := j.confirm(&Tx{BlockNum: c.Block, TxNum: c.Tx, EthTx: &db.Transaction{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+	session, cp, err := readJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Start == nil || session.Start.Block != 2 || session.Start.Tx != 1 {
+		t.Errorf("readJournal() returned session start %v, expected {2 1}", session.Start)
+	}
+	if cp == nil || cp.Block != 3 || cp.Tx != 4 {
+		t.Fatalf("readJournal() returned checkpoint %v, expected {3 4}", cp)
+	}
+	if expected := map[int]bool{1: true, 2: true, 4: true}; !reflect.DeepEqual(cp.done, expected) {
+		t.Errorf("checkpoint contains confirmed transactions %v, expected %v", cp.done, expected)
+	}
+}
@@ -0,0 +1,184 @@
+package replayer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace is the namespace of all Prometheus metrics.
+const metricsNamespace = "evm_bully"
+
+// metrics contains the Prometheus metrics of a replay. All methods can be
+// called on a nil metrics (if no metrics are served).
+type metrics struct {
+	registry     *prometheus.Registry
+	blockHeight  prometheus.Gauge
+	txsSubmitted prometheus.Counter
+	txsSucceeded prometheus.Counter
+	txsFailed    prometheus.Counter
+	gasBurnt     prometheus.Counter
+	rpcLatency   *prometheus.HistogramVec
+	batchFill    prometheus.Gauge
+	txBacklog    prometheus.Gauge
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		blockHeight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "block_height",
+			Help:      "Ethereum block height of the last submitted transaction.",
+		}),
+		txsSubmitted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "txs_submitted_total",
+			Help:      "Number of submitted Ethereum transactions.",
+		}),
+		txsSucceeded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "txs_succeeded_total",
+			Help:      "Number of Ethereum transactions whose NEAR transaction succeeded.",
+		}),
+		txsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "txs_failed_total",
+			Help:      "Number of Ethereum transactions whose NEAR transaction failed.",
+		}),
+		gasBurnt: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "near_gas_burnt_total",
+			Help:      "NEAR gas burnt (including receipts).",
+		}),
+		rpcLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "rpc_latency_seconds",
+			Help:      "Latency of NEAR RPC calls (until the transaction is complete).",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14), // 10ms to ~82s
+		}, []string{"method"}),
+		batchFill: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "batch_fill_ratio",
+			Help:      "Fill level of the current batch (between 0 and 1).",
+		}),
+		txBacklog: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "tx_backlog",
+			Help:      "Number of transactions waiting in the channel of the transaction generator.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.blockHeight,
+		m.txsSubmitted,
+		m.txsSucceeded,
+		m.txsFailed,
+		m.gasBurnt,
+		m.rpcLatency,
+		m.batchFill,
+		m.txBacklog,
+	)
+	return m
+}
+
+// serveMetrics serves the metrics m via HTTP on addr and returns the address
+// of the listener and a function to stop the server.
+func serveMetrics(m *metrics, addr string) (net.Addr, func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(fmt.Sprintf("metrics server: %s", err))
+		}
+	}()
+	log.Info(fmt.Sprintf("serving metrics on http://%s/metrics", ln.Addr()))
+	return ln.Addr(), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+// observe records a NEAR call of method for tx, which contains txs Ethereum
+// transactions, with given latency and result (err is the error of the call).
+func (m *metrics) observe(
+	method string,
+	tx *Tx,
+	txs int,
+	latency time.Duration,
+	txResult map[string]interface{},
+	err error,
+) {
+	if m == nil {
+		return
+	}
+	m.rpcLatency.WithLabelValues(method).Observe(latency.Seconds())
+	if txs > 0 {
+		if tx.EthTx != nil {
+			m.blockHeight.Set(float64(tx.BlockNum))
+		}
+		m.txsSubmitted.Add(float64(txs))
+		if err != nil || txFailed(txResult) {
+			m.txsFailed.Add(float64(txs))
+		} else {
+			m.txsSucceeded.Add(float64(txs))
+		}
+	}
+	if err == nil {
+		m.gasBurnt.Add(float64(newCallStats(method, tx, txs, latency, txResult).GasBurnt))
+	}
+}
+
+// setBatchFill sets the fill level of the current batch with n of size
+// actions.
+func (m *metrics) setBatchFill(n, size int) {
+	if m == nil {
+		return
+	}
+	m.batchFill.Set(float64(n) / float64(size))
+}
+
+// setBacklog sets the backlog of the transaction generator.
+func (m *metrics) setBacklog(n int) {
+	if m == nil {
+		return
+	}
+	m.txBacklog.Set(float64(n))
+}
+
+// recordCall records a NEAR call of method for tx in the benchmark, the
+// metrics, and the event log (see metrics.observe).
+func (r *Replayer) recordCall(
+	method string,
+	tx *Tx,
+	txs int,
+	latency time.Duration,
+	txResult map[string]interface{},
+	err error,
+) {
+	r.metrics.observe(method, tx, txs, latency, txResult, err)
+	r.events.emitResult(method, tx, txs, latency, txResult, err)
+	if err == nil && txs > 0 {
+		r.bench.record(method, tx, txs, latency, txResult)
+	}
+}
+
+// ethTxs returns the number of Ethereum transactions contained in tx.
+func ethTxs(tx *Tx) int {
+	if tx.EthTx == nil {
+		return 0
+	}
+	return 1
+}
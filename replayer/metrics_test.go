@@ -0,0 +1,73 @@
+package replayer
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsObserve(t *testing.T) {
+	m := newMetrics()
+	txResult := decodeTestTxResult(t)
+	tx := &Tx{BlockNum: 42, TxNum: 1, EthTx: &db.Transaction{}}
+	m.observe("submit", tx, 1, time.Second, txResult, nil)
+	m.observe("submit", tx, 1, time.Second, nil, errors.New("timeout"))
+	m.observe("begin_block", &Tx{}, 0, time.Second, txResult, nil)
+	failed := map[string]interface{}{
+		"status": map[string]interface{}{"Failure": "error"},
+	}
+	m.observe("batch", tx, 10, time.Second, failed, nil)
+
+	if v := testutil.ToFloat64(m.blockHeight); v != 42 {
+		t.Errorf("block height: %f", v)
+	}
+	if v := testutil.ToFloat64(m.txsSubmitted); v != 12 {
+		t.Errorf("txs submitted: %f", v)
+	}
+	if v := testutil.ToFloat64(m.txsSucceeded); v != 1 {
+		t.Errorf("txs succeeded: %f", v)
+	}
+	if v := testutil.ToFloat64(m.txsFailed); v != 11 {
+		t.Errorf("txs failed: %f", v)
+	}
+	if v := testutil.ToFloat64(m.gasBurnt); v != 2*(2428000000000+5000000000000+223182562500) {
+		t.Errorf("gas burnt: %f", v)
+	}
+	if n := testutil.CollectAndCount(m.rpcLatency); n != 3 {
+		t.Errorf("latency histograms: %d", n)
+	}
+
+	// nil metrics are ignored
+	var nm *metrics
+	nm.observe("submit", tx, 1, time.Second, txResult, nil)
+	nm.setBacklog(1)
+	nm.setBatchFill(1, 2)
+}
+
+func TestServeMetrics(t *testing.T) {
+	m := newMetrics()
+	m.setBacklog(7)
+	addr, stop, err := serveMetrics(m, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "evm_bully_tx_backlog 7") {
+		t.Errorf("metrics:\n%s", body)
+	}
+}
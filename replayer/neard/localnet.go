@@ -0,0 +1,112 @@
+package neard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// LocalnetMasterAccount is the master account of a localnet (the account of
+// the first validator node).
+const LocalnetMasterAccount = "node0"
+
+// MasterAccount returns the master account of a NEARDaemon with the given
+// number of nodes and shards: test.near for a single node and
+// LocalnetMasterAccount for a localnet.
+func MasterAccount(nodes, shards int) string {
+	if nodes > 1 || shards > 1 {
+		return LocalnetMasterAccount
+	}
+	return "test.near"
+}
+
+// localnet returns true, if the NEARDaemon runs a localnet.
+func (daemon *NEARDaemon) localnet() bool {
+	return daemon.Nodes > 1 || daemon.Shards > 1
+}
+
+// localnetArgs returns the arguments of 'neard localnet', which creates the
+// homes (and the common genesis) of the localnet nodes node0, node1, ...
+func (daemon *NEARDaemon) localnetArgs() []string {
+	nodes, shards := daemon.Nodes, daemon.Shards
+	if nodes < 1 {
+		nodes = 1
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	return []string{
+		"--home=" + daemon.localDir,
+		"--verbose=true",
+		"localnet",
+		"--v", strconv.Itoa(nodes),
+		"--shards", strconv.Itoa(shards),
+		"--prefix", "node",
+	}
+}
+
+// allShards returns the JSON list of all shards of the localnet.
+func (daemon *NEARDaemon) allShards() string {
+	shards := make([]string, 0, daemon.Shards)
+	for i := 0; i < daemon.Shards || i == 0; i++ {
+		shards = append(shards, strconv.Itoa(i))
+	}
+	return "[" + strings.Join(shards, ",") + "]"
+}
+
+// findNodes determines the nodes of the local data. The local data of a
+// localnet contains a home directory for each node (node0, node1, ...).
+func (daemon *NEARDaemon) findNodes() error {
+	daemon.nodes = nil
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("node%d", i)
+		dir := filepath.Join(daemon.localDir, name)
+		if _, err := os.Stat(filepath.Join(dir, "config.json")); err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return err
+		}
+		daemon.nodes = append(daemon.nodes, &node{name: name, dir: dir})
+	}
+	if len(daemon.nodes) == 0 {
+		if daemon.localnet() {
+			return fmt.Errorf("neard: localnet in '%s' has no nodes", daemon.localDir)
+		}
+		daemon.nodes = []*node{{dir: daemon.localDir}}
+	}
+	return nil
+}
+
+// setBootNodes sets the first node as the boot node of all other nodes of
+// the localnet.
+func (daemon *NEARDaemon) setBootNodes() error {
+	var key struct {
+		PublicKey string `json:"public_key"`
+	}
+	data, err := os.ReadFile(filepath.Join(daemon.nodes[0].dir, "node_key.json"))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return fmt.Errorf("neard: cannot parse node key: %w", err)
+	}
+	bootNode := fmt.Sprintf("%s@127.0.0.1:%d", key.PublicKey, daemon.nodes[0].networkPort)
+	log.Info(fmt.Sprintf("neard boot node: %s", bootNode))
+	for i, n := range daemon.nodes {
+		value := `""`
+		if i > 0 {
+			value = strconv.Quote(bootNode)
+		}
+		edits := []*jsonEdit{{[]string{"network", "boot_nodes"}, value}}
+		if err := editJSONFile(filepath.Join(n.dir, "config.json"), edits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package neard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// localnetScript emulates 'neard localnet' (creating the node homes with
+// minimal config, genesis, and key files) and 'neard run' (which runs until
+// it is terminated, node1 crashes, if crash is set).
+const localnetScript = `
+home=${1#--home=}
+case $3 in
+localnet)
+	i=0
+	while [ $i -lt $5 ]; do
+		mkdir -p $home/node$i
+		echo '{"rpc": {"addr": "0.0.0.0:3030"}, "network": {"addr": "0.0.0.0:24567", "boot_nodes": "x"}}' > $home/node$i/config.json
+		echo '{"epoch_length": 60, "num_block_producer_seats": 100}' > $home/node$i/genesis.json
+		echo '{"public_key": "ed25519:node'$i'"}' > $home/node$i/node_key.json
+		echo '{"account_id": "node'$i'"}' > $home/node$i/validator_key.json
+		i=$((i+1))
+	done
+	echo "$@" > $home/args
+	;;
+run)
+	echo "running $home"
+	if [ -n "$CRASH" ] && [ "${home%node1}" != "$home" ]; then
+		echo "node1 panicked"
+		exit 1
+	fi
+	exec sleep 60
+	;;
+esac
+`
+
+func readJSON(t *testing.T, filename string) map[string]interface{} {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLocalnet(t *testing.T) {
+	d := newScriptDaemon(t, localnetScript)
+	d.Nodes = 4
+	d.Shards = 2
+	d.Profile = &Profile{
+		Name:    "test",
+		Genesis: map[string]interface{}{"epoch_length": 100},
+	}
+	if err := d.SetupLocalData(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.RemoveLocalData()
+	args, err := os.ReadFile(filepath.Join(d.LocalDir(), "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(args), "localnet --v 4 --shards 2 --prefix node\n") {
+		t.Errorf("neard called with %s", args)
+	}
+	if len(d.nodes) != 4 {
+		t.Fatalf("localnet has %d nodes", len(d.nodes))
+	}
+	if d.MasterAccount() != LocalnetMasterAccount ||
+		d.ValidatorKeyPath() != filepath.Join(d.LocalDir(), "node0", "validator_key.json") {
+		t.Errorf("wrong master account %s with key %s", d.MasterAccount(), d.ValidatorKeyPath())
+	}
+	ports := make(map[string]bool)
+	for i, n := range d.nodes {
+		config := readJSON(t, filepath.Join(n.dir, "config.json"))
+		network := config["network"].(map[string]interface{})
+		rpc := config["rpc"].(map[string]interface{})
+		for _, addr := range []string{network["addr"].(string), rpc["addr"].(string)} {
+			if ports[addr] {
+				t.Errorf("address %s used twice", addr)
+			}
+			ports[addr] = true
+		}
+		bootNodes := ""
+		if i > 0 {
+			bootNodes = fmt.Sprintf("ed25519:node0@127.0.0.1:%d", d.nodes[0].networkPort)
+		}
+		if network["boot_nodes"] != bootNodes {
+			t.Errorf("node %d has boot nodes '%s', expected '%s'", i, network["boot_nodes"], bootNodes)
+		}
+		if shards := fmt.Sprint(config["tracked_shards"]); shards != "[0 1]" {
+			t.Errorf("node %d tracks shards %s", i, shards)
+		}
+		genesis := readJSON(t, filepath.Join(n.dir, "genesis.json"))
+		if genesis["epoch_length"] != float64(100) || genesis["num_block_producer_seats"] != float64(100) {
+			t.Errorf("profile not applied to genesis of node %d: %v", i, genesis)
+		}
+	}
+
+	// start and stop as a unit
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range d.nodes {
+		for {
+			lines, err := tailLines(n.logFile, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) == 1 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := d.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range d.nodes {
+		if n.cmd.ProcessState == nil {
+			t.Errorf("%s not reaped", n.name)
+		}
+	}
+	lines, err := d.LogTail(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 8 || !strings.HasSuffix(lines[2], "-node1.log <==") ||
+		lines[3] != "running "+filepath.Join(d.LocalDir(), "node1") {
+		t.Errorf("wrong log tail: %q", lines)
+	}
+
+	// restored local data is detected as localnet
+	source := d.LocalDir()
+	r := newScriptDaemon(t, localnetScript)
+	if err := r.RestoreLocalData(source); err != nil {
+		t.Fatal(err)
+	}
+	defer r.RemoveLocalData()
+	if len(r.nodes) != 4 || r.nodes[0].networkPort == d.nodes[0].networkPort {
+		t.Errorf("restored localnet has %d nodes", len(r.nodes))
+	}
+
+	// crash of a single node
+	os.Setenv("CRASH", "1")
+	defer os.Unsetenv("CRASH")
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-r.Exited():
+	case <-time.After(10 * time.Second):
+		t.Fatal("node1 did not crash")
+	}
+	var exitErr *ExitError
+	if err := r.Err(); !errors.As(err, &exitErr) || exitErr.Node != "node1" ||
+		!strings.HasSuffix(err.Error(), "node1 panicked") {
+		t.Fatalf("Err() = %v", err)
+	}
+	if err := r.Stop(); !errors.As(err, &exitErr) {
+		t.Errorf("Stop() = %v, expected *ExitError", err)
+	}
+	for _, n := range r.nodes {
+		if n.cmd.ProcessState == nil {
+			t.Errorf("%s not reaped", n.name)
+		}
+	}
+}
+
+func TestMasterAccount(t *testing.T) {
+	if a := MasterAccount(1, 1); a != "test.near" {
+		t.Errorf("master account of single node is %s", a)
+	}
+	if a := MasterAccount(1, 2); a != LocalnetMasterAccount {
+		t.Errorf("master account of localnet is %s", a)
+	}
+}
@@ -2,31 +2,68 @@
 package neard
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/big"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 
 	"github.com/aurora-is-near/evm-bully/util/git"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/frankbraun/codechain/util/file"
 )
 
-// NEARDaemon wraps a neard.
+// NEARDaemon wraps a neard. Each NEARDaemon runs in its own temporary home
+// directory with its own RPC and network ports, which allows to run several
+// of them side by side. If Nodes or Shards is larger than 1, the NEARDaemon
+// runs a localnet of several neard nodes as a unit (see SetupLocalData).
 type NEARDaemon struct {
 	Head       string
+	LogDir     string   // directory of the per-run log files (default: os.TempDir())
+	Profile    *Profile // overrides applied by SetupLocalData (default: DefaultProfile)
+	Nodes      int      // number of validator nodes of localnet
+	Shards     int      // number of shards of localnet
 	binaryPath string
-	localDir   string
-	nearDaemon *exec.Cmd
+	tmpDir     string  // temporary directory containing localDir
+	localDir   string  // home directory of neard (or of the localnet nodes)
+	nodes      []*node // nodes of the local data
+	exited     chan struct{}
+	stopping   bool // Stop has been called
 }
 
-func getDefaultLocalDir() (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
+// A node is a single neard of a NEARDaemon.
+type node struct {
+	name        string // name of localnet node (empty for single node)
+	dir         string // home directory of neard
+	rpcPort     int
+	networkPort int
+	cmd         *exec.Cmd
+	logFile     string        // log file of the current run
+	done        chan struct{} // closed when the neard process has exited
+	exitErr     error         // result of Wait (valid after done is closed)
+}
+
+// reservePorts returns n distinct TCP ports which are currently not in use.
+// They stay reserved (by listening on them) until release is called.
+func reservePorts(n int) (ports []int, release func(), err error) {
+	var listeners []net.Listener
+	release = func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		listeners = append(listeners, l)
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
 	}
-	return filepath.Join(home, ".near", "local"), nil
+	return ports, release, nil
 }
 
 // LoadFromBinary loads NEARDaemon from existing binary.
@@ -37,15 +74,9 @@ func LoadFromBinary(binaryPath string, head string) (*NEARDaemon, error) {
 		return nil, fmt.Errorf("can't access neard binary on path %v: %v", binaryPath, err)
 	}
 
-	localDir, err := getDefaultLocalDir()
-	if err != nil {
-		return nil, err
-	}
-
 	return &NEARDaemon{
 		Head:       head,
 		binaryPath: binaryPath,
-		localDir:   localDir,
 	}, nil
 }
 
@@ -91,65 +122,148 @@ func LoadFromRepo(repoPath string, release bool, build bool) (*NEARDaemon, error
 	return LoadFromBinary(binaryPath, head)
 }
 
-// Backup local data if it exists
-func (daemon *NEARDaemon) backupLocalData() error {
-	exists, err := file.Exists(daemon.localDir)
+// LocalDir returns the home directory of the NEARDaemon (empty, if no local
+// data has been set up).
+func (daemon *NEARDaemon) LocalDir() string {
+	return daemon.localDir
+}
+
+// NodeURL returns the URL of the RPC interface of the NEARDaemon (of the
+// first node of a localnet).
+func (daemon *NEARDaemon) NodeURL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", daemon.nodes[0].rpcPort)
+}
+
+// ValidatorKeyPath returns the path of the validator key of the NEARDaemon,
+// which is the key of the master account (see MasterAccount).
+func (daemon *NEARDaemon) ValidatorKeyPath() string {
+	return filepath.Join(daemon.nodes[0].dir, "validator_key.json")
+}
+
+// MasterAccount returns the master account of the NEARDaemon.
+func (daemon *NEARDaemon) MasterAccount() string {
+	return MasterAccount(daemon.Nodes, daemon.Shards)
+}
+
+// newLocalDir removes existing local data and creates a new temporary
+// directory for the local data (the home directory itself is not created).
+func (daemon *NEARDaemon) newLocalDir() error {
+	if err := daemon.RemoveLocalData(); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "neard-")
 	if err != nil {
 		return err
 	}
-	if exists {
-		localOld := strings.TrimSuffix(daemon.localDir, "/") + "_old"
-		log.Info(fmt.Sprintf("mv %s %s", daemon.localDir, localOld))
-		// remove old backup directory
-		if err := os.RemoveAll(localOld); err != nil {
-			return err
+	daemon.tmpDir = tmpDir
+	daemon.localDir = filepath.Join(tmpDir, "local")
+	log.Info(fmt.Sprintf("neard home: %s", daemon.localDir))
+	return nil
+}
+
+// RemoveLocalData removes the local data of the NEARDaemon (if any).
+func (daemon *NEARDaemon) RemoveLocalData() error {
+	if daemon.tmpDir == "" {
+		return nil
+	}
+	if err := os.RemoveAll(daemon.tmpDir); err != nil {
+		return err
+	}
+	daemon.tmpDir = ""
+	daemon.localDir = ""
+	daemon.nodes = nil
+	return nil
+}
+
+// setPorts chooses free RPC and network ports for all nodes and writes them
+// into their config.json files. The ports are reserved together until all
+// nodes are configured, which makes them distinct.
+func (daemon *NEARDaemon) setPorts() error {
+	ports, release, err := reservePorts(2 * len(daemon.nodes))
+	if err != nil {
+		return err
+	}
+	defer release()
+	for i, n := range daemon.nodes {
+		n.rpcPort, n.networkPort = ports[2*i], ports[2*i+1]
+		log.Info(fmt.Sprintf("neard %sports: rpc=%d network=%d", n.prefix(), n.rpcPort, n.networkPort))
+		edits := []*jsonEdit{
+			{[]string{"rpc", "addr"}, fmt.Sprintf(`"0.0.0.0:%d"`, n.rpcPort)},
+			{[]string{"network", "addr"}, fmt.Sprintf(`"0.0.0.0:%d"`, n.networkPort)},
 		}
-		// move
-		if err := os.Rename(daemon.localDir, localOld); err != nil {
+		if err := editJSONFile(filepath.Join(n.dir, "config.json"), edits); err != nil {
 			return err
 		}
-	} else {
-		log.Info(fmt.Sprintf("directory '%s' does not exist", daemon.localDir))
+	}
+	if len(daemon.nodes) > 1 {
+		return daemon.setBootNodes()
 	}
 	return nil
 }
 
+// prefix returns the prefix of log messages for n.
+func (n *node) prefix() string {
+	if n.name == "" {
+		return ""
+	}
+	return n.name + " "
+}
+
 func (daemon *NEARDaemon) init() error {
-	cmd := exec.Command(daemon.binaryPath, "--home="+daemon.localDir, "--verbose=true", "init")
+	args := []string{"--home=" + daemon.localDir, "--verbose=true", "init"}
+	if daemon.localnet() {
+		args = daemon.localnetArgs()
+	}
+	cmd := exec.Command(daemon.binaryPath, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
 func (daemon *NEARDaemon) editConfig() error {
-	filename := filepath.Join(daemon.localDir, "config.json")
-	backup := filepath.Join(daemon.localDir, "config_old.json")
-	if err := file.Copy(filename, backup); err != nil {
-		return err
+	// apply profile
+	if daemon.Profile == nil {
+		p, err := LoadProfile(DefaultProfile)
+		if err != nil {
+			return err
+		}
+		daemon.Profile = p
 	}
-
-	edits := []*jsonEdit{
-		{[]string{"rpc", "polling_config", "polling_interval", "secs"}, "0"},
-		{[]string{"rpc", "polling_config", "polling_interval", "nanos"}, "5000000"},
-		{[]string{"consensus", "block_production_tracking_delay", "secs"}, "0"},
-		{[]string{"consensus", "block_production_tracking_delay", "nanos"}, "10000000"},
-		{[]string{"consensus", "min_block_production_delay", "secs"}, "0"},
-		{[]string{"consensus", "min_block_production_delay", "nanos"}, "10000000"},
-		{[]string{"consensus", "max_block_production_delay", "secs"}, "0"},
-		{[]string{"consensus", "max_block_production_delay", "nanos"}, "50000000"},
-		{[]string{"consensus", "catchup_step_period", "secs"}, "0"},
-		{[]string{"consensus", "catchup_step_period", "nanos"}, "10000000"},
-		{[]string{"consensus", "doomslug_step_period", "secs"}, "0"},
-		{[]string{"consensus", "doomslug_step_period", "nanos"}, "10000000"},
+	log.Info(fmt.Sprintf("apply neard profile '%s'", daemon.Profile.Name))
+	for _, n := range daemon.nodes {
+		filename := filepath.Join(n.dir, "config.json")
+		backup := filepath.Join(n.dir, "config_old.json")
+		if err := file.Copy(filename, backup); err != nil {
+			return err
+		}
+		edits := []*jsonEdit{
+			// allow to export large contract states via view_state
+			{[]string{"trie_viewer_state_size_limit"}, "null"},
+		}
+		if daemon.localnet() {
+			// track all shards to serve all accounts via RPC
+			edits = append(edits, &jsonEdit{[]string{"tracked_shards"}, daemon.allShards()})
+		}
+		if err := editJSONFile(filename, edits); err != nil {
+			return err
+		}
+		if err := overrideJSONFile(filename, daemon.Profile.Config); err != nil {
+			return err
+		}
+		genesis := filepath.Join(n.dir, "genesis.json")
+		if err := overrideJSONFile(genesis, daemon.Profile.Genesis); err != nil {
+			return err
+		}
 	}
-	return editJSONFile(filename, edits)
+	return daemon.setPorts()
 }
 
-// SetupLocalData initializes local data of a NEARDaemon.
+// SetupLocalData initializes local data of a NEARDaemon in a new temporary
+// home directory (existing local data is removed) and applies the Profile.
 func (daemon *NEARDaemon) SetupLocalData() error {
 	log.Info("setup neard local data")
 
-	if err := daemon.backupLocalData(); err != nil {
+	if err := daemon.newLocalDir(); err != nil {
 		return err
 	}
 
@@ -157,8 +271,11 @@ func (daemon *NEARDaemon) SetupLocalData() error {
 	if err := daemon.init(); err != nil {
 		return err
 	}
+	if err := daemon.findNodes(); err != nil {
+		return err
+	}
 
-	// edit genesis.json
+	// edit config.json and genesis.json
 	if err := daemon.editConfig(); err != nil {
 		return err
 	}
@@ -166,23 +283,68 @@ func (daemon *NEARDaemon) SetupLocalData() error {
 	return nil
 }
 
-// RestoreLocalData restores local data of a NEARDaemon from given directory.
-func (daemon *NEARDaemon) RestoreLocalData(source string) error {
-	log.Info("restore neard local data")
-	return file.CopyDir(source, daemon.localDir)
+// AddGenesisRecords adds the given state records to the genesis file of the
+// local data of a NEARDaemon (see SetupLocalData) and increases the total
+// supply by supply (the tokens of all added accounts).
+func (daemon *NEARDaemon) AddGenesisRecords(records []json.RawMessage, supply *big.Int) error {
+	log.Info(fmt.Sprintf("add %d genesis records", len(records)))
+	for _, n := range daemon.nodes {
+		if err := addGenesisRecords(filepath.Join(n.dir, "genesis.json"), records, supply); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Start NEARDaemon.
-func (daemon *NEARDaemon) Start() error {
-	log.Info("start neard")
-	daemon.nearDaemon = exec.Command(daemon.binaryPath, "--home="+daemon.localDir, "--verbose=true", "run")
-	daemon.nearDaemon.Stdout = os.Stdout
-	daemon.nearDaemon.Stderr = os.Stderr
-	return daemon.nearDaemon.Start()
+func addGenesisRecords(filename string, records []json.RawMessage, supply *big.Int) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var genesis map[string]json.RawMessage
+	if err := json.Unmarshal(data, &genesis); err != nil {
+		return err
+	}
+	var existing []json.RawMessage
+	if err := json.Unmarshal(genesis["records"], &existing); err != nil {
+		return fmt.Errorf("neard: cannot parse genesis records: %w", err)
+	}
+	var totalSupply string
+	if err := json.Unmarshal(genesis["total_supply"], &totalSupply); err != nil {
+		return fmt.Errorf("neard: cannot parse genesis total supply: %w", err)
+	}
+	total, ok := new(big.Int).SetString(totalSupply, 10)
+	if !ok {
+		return fmt.Errorf("neard: invalid genesis total supply: %s", totalSupply)
+	}
+	total.Add(total, supply)
+	if genesis["records"], err = json.Marshal(append(existing, records...)); err != nil {
+		return err
+	}
+	if genesis["total_supply"], err = json.Marshal(total.String()); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
 }
 
-// Stop NEARDaemon.
-func (daemon *NEARDaemon) Stop() error {
-	log.Info("stop neard")
-	return daemon.nearDaemon.Process.Kill()
+// RestoreLocalData restores local data of a NEARDaemon from given directory
+// into a new temporary home directory (see SetupLocalData), which allows to
+// restore the same data repeatedly. The ports in config.json are replaced by
+// free ones. Local data of a localnet is detected automatically.
+func (daemon *NEARDaemon) RestoreLocalData(source string) error {
+	log.Info("restore neard local data")
+	if err := daemon.newLocalDir(); err != nil {
+		return err
+	}
+	if err := file.CopyDir(source, daemon.localDir); err != nil {
+		return err
+	}
+	if err := daemon.findNodes(); err != nil {
+		return err
+	}
+	return daemon.setPorts()
 }
@@ -0,0 +1,101 @@
+package neard
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+)
+
+func TestRestoreLocalData(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "neard")
+	if err := os.WriteFile(binary, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	source := t.TempDir()
+	config := `{"rpc": {"addr": "0.0.0.0:3030"}, "network": {"addr": "0.0.0.0:24567"}}`
+	if err := os.WriteFile(filepath.Join(source, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// two daemons restored from the same data run side by side
+	var daemons [2]*NEARDaemon
+	for i := range daemons {
+		d, err := LoadFromBinary(binary, "head")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.RestoreLocalData(source); err != nil {
+			t.Fatal(err)
+		}
+		defer d.RemoveLocalData()
+		daemons[i] = d
+	}
+	if daemons[0].LocalDir() == daemons[1].LocalDir() {
+		t.Error("daemons share home directory")
+	}
+	if daemons[0].NodeURL() == daemons[1].NodeURL() {
+		t.Error("daemons share RPC port")
+	}
+	for _, d := range daemons {
+		data, err := os.ReadFile(filepath.Join(d.LocalDir(), "config.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := jsonparser.GetString(data, "rpc", "addr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := addr[strings.LastIndex(addr, ":"):]
+		if !strings.HasSuffix(d.NodeURL(), port) {
+			t.Errorf("config.json has RPC address %s, node URL is %s", addr, d.NodeURL())
+		}
+		if addr, _ := jsonparser.GetString(data, "network", "addr"); addr == "0.0.0.0:24567" {
+			t.Error("network port not changed")
+		}
+	}
+
+	// restoring again replaces the home directory
+	d := daemons[0]
+	old := d.LocalDir()
+	if err := d.RestoreLocalData(source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old home directory %s not removed", old)
+	}
+	if err := d.RemoveLocalData(); err != nil {
+		t.Fatal(err)
+	}
+	if d.LocalDir() != "" {
+		t.Error("local data not removed")
+	}
+}
+
+func TestReservePorts(t *testing.T) {
+	ports, release, err := reservePorts(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	for _, port := range ports {
+		if seen[port] {
+			t.Errorf("port %d returned twice", port)
+		}
+		seen[port] = true
+		if l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+			l.Close()
+			t.Errorf("port %d not reserved", port)
+		}
+	}
+	release()
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", ports[0]))
+	if err != nil {
+		t.Fatalf("port %d not released: %v", ports[0], err)
+	}
+	l.Close()
+}
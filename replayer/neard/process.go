@@ -0,0 +1,374 @@
+package neard
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// LogTailLines is the number of log lines contained in an ExitError.
+const LogTailLines = 50
+
+// stopTimeout is the time Stop waits for neard to terminate after SIGTERM
+// before it is killed with SIGKILL.
+var stopTimeout = 10 * time.Second
+
+// maxLogSize is the size at which a neard log file is rotated: it is renamed
+// to the log file name followed by ".1" (replacing the previous one) and a new
+// log file is started.
+var maxLogSize int64 = 256 << 20
+
+// rotatingLog is a log file which is rotated at maxLogSize.
+type rotatingLog struct {
+	filename string
+	fp       *os.File
+	size     int64
+}
+
+func createLog(filename string) (*rotatingLog, error) {
+	fp, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingLog{filename: filename, fp: fp}, nil
+}
+
+// Write implements the io.Writer interface. Log files are rotated at line
+// ends, lines longer than maxLogSize are not split.
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	written := 0
+	for l.size+int64(len(p)) > maxLogSize {
+		// fill the current log file up to a line end
+		if room := maxLogSize - l.size; room > 0 {
+			if i := bytes.LastIndexByte(p[:room], '\n'); i >= 0 {
+				n, err := l.write(p[:i+1])
+				written += n
+				if err != nil {
+					return written, err
+				}
+				p = p[i+1:]
+			}
+		}
+		if l.size == 0 {
+			break // long line
+		}
+		if err := l.rotate(); err != nil {
+			return written, err
+		}
+	}
+	n, err := l.write(p)
+	return written + n, err
+}
+
+func (l *rotatingLog) write(p []byte) (int, error) {
+	n, err := l.fp.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *rotatingLog) rotate() error {
+	if err := l.fp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.filename, l.filename+".1"); err != nil {
+		return err
+	}
+	fp, err := os.Create(l.filename)
+	if err != nil {
+		return err
+	}
+	l.fp = fp
+	l.size = 0
+	return nil
+}
+
+// Close closes the current log file.
+func (l *rotatingLog) Close() error {
+	return l.fp.Close()
+}
+
+// ExitError reports an unexpected exit of neard (before Stop was called).
+type ExitError struct {
+	Node    string   // name of the localnet node (empty for single node)
+	Err     error    // error returned by Wait (nil, if neard exited with status 0)
+	LogFile string   // log file of the run
+	LogTail []string // last lines of the log file
+}
+
+// Error implements the error interface.
+func (e *ExitError) Error() string {
+	status := "with status 0"
+	if e.Err != nil {
+		status = e.Err.Error()
+	}
+	name := "neard"
+	if e.Node != "" {
+		name += " " + e.Node
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: exited unexpectedly (%s), last lines of '%s':", name, status, e.LogFile)
+	for _, line := range e.LogTail {
+		b.WriteString("\n")
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// Unwrap returns the error returned by Wait.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Start NEARDaemon (all nodes of a localnet). The output of each neard is
+// written to a new log file in LogDir (see LogFile), which is rotated when it
+// exceeds 256 MiB.
+func (daemon *NEARDaemon) Start() error {
+	log.Info("start neard")
+	logDir := daemon.LogDir
+	if logDir == "" {
+		logDir = os.TempDir()
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	prefix := fmt.Sprintf("neard-%s-%d", time.Now().UTC().Format("20060102-150405.000"), os.Getpid())
+	exited := make(chan struct{})
+	var once sync.Once
+	daemon.exited = exited
+	daemon.stopping = false
+	for _, n := range daemon.nodes {
+		n.done = nil
+	}
+	for _, n := range daemon.nodes {
+		err := n.start(daemon.binaryPath, filepath.Join(logDir, prefix), func() {
+			once.Do(func() { close(exited) })
+		})
+		if err != nil {
+			daemon.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// start starts neard for node n, the log file name is prefix followed by
+// the node name, exited is called when the process has exited.
+func (n *node) start(binaryPath, prefix string, exited func()) error {
+	n.logFile = prefix + ".log"
+	if n.name != "" {
+		n.logFile = prefix + "-" + n.name + ".log"
+	}
+	fp, err := createLog(n.logFile)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("neard %slog: %s", n.prefix(), n.logFile))
+	cmd := exec.Command(binaryPath, "--home="+n.dir, "--verbose=true", "run")
+	cmd.Stdout = fp
+	cmd.Stderr = fp
+	if err := cmd.Start(); err != nil {
+		fp.Close()
+		return err
+	}
+	// reap process and record its exit
+	done := make(chan struct{})
+	n.cmd = cmd
+	n.done = done
+	n.exitErr = nil
+	go func() {
+		n.exitErr = cmd.Wait()
+		fp.Close()
+		close(done)
+		exited()
+	}()
+	return nil
+}
+
+// LogFile returns the log file of the current (or last) run of the
+// NEARDaemon (of the first node of a localnet).
+func (daemon *NEARDaemon) LogFile() string {
+	if len(daemon.nodes) == 0 {
+		return ""
+	}
+	return daemon.nodes[0].logFile
+}
+
+// LogTail returns the last n lines of the log file of the current (or last)
+// run of the NEARDaemon. For a localnet the last n lines of each node are
+// returned, each preceded by a line with the name of the log file.
+func (daemon *NEARDaemon) LogTail(n int) ([]string, error) {
+	var lines []string
+	for _, nd := range daemon.nodes {
+		if nd.logFile == "" {
+			continue
+		}
+		tail, err := tailLog(nd.logFile, n)
+		if err != nil {
+			return nil, err
+		}
+		if len(daemon.nodes) > 1 {
+			lines = append(lines, fmt.Sprintf("==> %s <==", nd.logFile))
+		}
+		lines = append(lines, tail...)
+	}
+	return lines, nil
+}
+
+// Exited returns a channel which is closed when neard (any node of a
+// localnet) has exited.
+func (daemon *NEARDaemon) Exited() <-chan struct{} {
+	return daemon.exited
+}
+
+// Err returns an *ExitError, if neard (any node of a localnet) has exited
+// without being stopped with Stop, and nil otherwise.
+func (daemon *NEARDaemon) Err() error {
+	if daemon.exited == nil || daemon.stopping {
+		return nil
+	}
+	for _, n := range daemon.nodes {
+		if n.done == nil {
+			continue
+		}
+		select {
+		case <-n.done:
+		default:
+			continue
+		}
+		tail, err := tailLog(n.logFile, LogTailLines)
+		if err != nil {
+			log.Warn(fmt.Sprintf("cannot read neard log: %s", err))
+		}
+		return &ExitError{
+			Node:    n.name,
+			Err:     n.exitErr,
+			LogFile: n.logFile,
+			LogTail: tail,
+		}
+	}
+	return nil
+}
+
+// Stop NEARDaemon (all nodes of a localnet). neard is terminated with SIGTERM
+// and killed with SIGKILL, if it does not exit in time. Stop waits until all
+// processes have been reaped and their logs are closed, also if killing fails.
+// It returns the first error of killing a process or an *ExitError, if neard
+// exited unexpectedly before.
+func (daemon *NEARDaemon) Stop() error {
+	if daemon.exited == nil {
+		return nil
+	}
+	exitErr := daemon.Err()
+	log.Info("stop neard")
+	daemon.stopping = true
+	daemon.exited = nil
+	var running []*node
+	for _, n := range daemon.nodes {
+		if n.done == nil {
+			continue
+		}
+		err := n.cmd.Process.Signal(syscall.SIGTERM)
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
+			log.Warn(fmt.Sprintf("cannot terminate neard %s: %s", n.name, err))
+		}
+		running = append(running, n)
+	}
+	timeout := time.NewTimer(stopTimeout)
+	defer timeout.Stop()
+	expired := false
+	var killErr error
+	for _, n := range running {
+		if !expired {
+			select {
+			case <-n.done:
+				continue
+			case <-timeout.C:
+				expired = true
+			}
+		}
+		select {
+		case <-n.done:
+			continue
+		default:
+		}
+		log.Warn(fmt.Sprintf("neard %sdid not terminate after %s, kill it", n.prefix(), stopTimeout))
+		err := n.cmd.Process.Kill()
+		if err != nil && !errors.Is(err, os.ErrProcessDone) && killErr == nil {
+			killErr = fmt.Errorf("neard: cannot kill %s: %w", n.cmd.Path, err)
+		}
+		<-n.done // the log is closed after the process has been reaped
+	}
+	if killErr != nil {
+		return killErr
+	}
+	return exitErr
+}
+
+// tailLog returns the last n lines of the log file filename (including the
+// rotated log file, if the current one has fewer lines).
+func tailLog(filename string, n int) ([]string, error) {
+	lines, err := tailLines(filename, n)
+	if err != nil || len(lines) >= n {
+		return lines, err
+	}
+	prev, err := tailLines(filename+".1", n-len(lines))
+	if errors.Is(err, os.ErrNotExist) {
+		return lines, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return append(prev, lines...), nil
+}
+
+// tailBytes is the maximum number of bytes read from the end of a file by
+// tailLines.
+const tailBytes = 64 * 1024
+
+// tailLines returns the last n lines of filename.
+func tailLines(filename string, n int) ([]string, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := fi.Size() - tailBytes
+	if offset > 0 {
+		if _, err := fp.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	var lines []string
+	s := bufio.NewScanner(fp)
+	s.Buffer(make([]byte, 0, tailBytes), tailBytes)
+	first := offset > 0
+	for s.Scan() {
+		if first {
+			// skip partial line
+			first = false
+			continue
+		}
+		lines = append(lines, s.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
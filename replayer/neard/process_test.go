@@ -0,0 +1,163 @@
+package neard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newScriptDaemon returns a NEARDaemon running the shell script instead of
+// neard.
+func newScriptDaemon(t *testing.T, script string) *NEARDaemon {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "neard")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d, err := LoadFromBinary(binary, "head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.LogDir = dir
+	d.localDir = dir
+	d.nodes = []*node{{dir: dir}}
+	return d
+}
+
+func TestCrash(t *testing.T) {
+	var script strings.Builder
+	for i := 0; i < LogTailLines+10; i++ {
+		fmt.Fprintf(&script, "echo line %d\n", i)
+	}
+	script.WriteString("echo panic >&2\nexit 3\n")
+	d := newScriptDaemon(t, script.String())
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-d.Exited():
+	case <-time.After(10 * time.Second):
+		t.Fatal("neard did not exit")
+	}
+	err := d.Err()
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Err() = %v, expected *ExitError", err)
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 3 {
+		t.Errorf("ExitError does not wrap exit status 3: %v", exitErr.Err)
+	}
+	if exitErr.LogFile != d.LogFile() || filepath.Dir(d.LogFile()) != d.LogDir {
+		t.Errorf("wrong log file %s", exitErr.LogFile)
+	}
+	if len(exitErr.LogTail) != LogTailLines || exitErr.LogTail[LogTailLines-1] != "panic" ||
+		exitErr.LogTail[0] != "line 11" {
+		t.Errorf("wrong log tail: %q", exitErr.LogTail)
+	}
+	if !strings.Contains(err.Error(), "exit status 3") || !strings.HasSuffix(err.Error(), "\npanic") {
+		t.Errorf("wrong error message: %s", err)
+	}
+	if err := d.Stop(); !errors.As(err, &exitErr) {
+		t.Errorf("Stop() = %v, expected *ExitError", err)
+	}
+	if err := d.Stop(); err != nil {
+		t.Errorf("second Stop() = %v", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	// terminates on SIGTERM
+	d := newScriptDaemon(t, "echo started\nexec sleep 60\n")
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Err(); err != nil {
+		t.Fatalf("Err() of running neard = %v", err)
+	}
+	start := time.Now()
+	if err := d.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > stopTimeout/2 {
+		t.Error("neard was not terminated with SIGTERM")
+	}
+	if err := d.Err(); err != nil {
+		t.Errorf("Err() of stopped neard = %v", err)
+	}
+	if d.nodes[0].cmd.ProcessState == nil {
+		t.Error("process not reaped")
+	}
+
+	// ignores SIGTERM
+	oldTimeout := stopTimeout
+	stopTimeout = 100 * time.Millisecond
+	defer func() { stopTimeout = oldTimeout }()
+	d = newScriptDaemon(t, "trap '' TERM\necho started\nwhile true; do sleep 1; done\n")
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// wait until the trap is set
+	for {
+		lines, err := d.LogTail(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := d.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	ws, ok := d.nodes[0].cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || ws.Signal() != syscall.SIGKILL {
+		t.Error("neard was not killed")
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	oldSize := maxLogSize
+	maxLogSize = 512
+	defer func() { maxLogSize = oldSize }()
+	var script strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&script, "echo line %d\n", i)
+	}
+	script.WriteString("exit 3\n")
+	d := newScriptDaemon(t, script.String())
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	<-d.Exited()
+	var exitErr *ExitError
+	if err := d.Stop(); !errors.As(err, &exitErr) {
+		t.Fatalf("Stop() = %v, expected *ExitError", err)
+	}
+	if _, err := os.Stat(d.LogFile() + ".1"); err != nil {
+		t.Fatalf("log not rotated: %v", err)
+	}
+	fi, err := os.Stat(d.LogFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() >= 100*int64(len("line 99\n")) {
+		t.Errorf("log file has %d bytes, expected it to be rotated", fi.Size())
+	}
+	// the tail continues in the rotated log
+	if len(exitErr.LogTail) != LogTailLines {
+		t.Fatalf("got %d lines of log tail, expected %d", len(exitErr.LogTail), LogTailLines)
+	}
+	for i, line := range exitErr.LogTail {
+		if exp := fmt.Sprintf("line %d", 100-LogTailLines+i); line != exp {
+			t.Errorf("log tail line %d is %q, expected %q", i, line, exp)
+		}
+	}
+}
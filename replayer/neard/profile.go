@@ -0,0 +1,190 @@
+package neard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultProfile is the name of the profile used, if no profile is given.
+const DefaultProfile = "fast-local"
+
+// A Profile defines overrides of the config.json and genesis.json files of
+// neard. Objects are merged recursively into the corresponding objects of
+// the files, all other values replace the values in the files.
+type Profile struct {
+	Name    string                 `json:"name"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+	Genesis map[string]interface{} `json:"genesis,omitempty"`
+}
+
+var builtinProfiles = map[string]string{
+	// short block production and polling delays for fast local replays
+	"fast-local": `
+config:
+  rpc:
+    polling_config:
+      polling_interval: {secs: 0, nanos: 5000000}
+  consensus:
+    block_production_tracking_delay: {secs: 0, nanos: 10000000}
+    min_block_production_delay: {secs: 0, nanos: 10000000}
+    max_block_production_delay: {secs: 0, nanos: 50000000}
+    catchup_step_period: {secs: 0, nanos: 10000000}
+    doomslug_step_period: {secs: 0, nanos: 10000000}
+`,
+	// block production delays, epoch length, and gas limit of NEAR mainnet
+	"realistic": `
+config:
+  rpc:
+    polling_config:
+      polling_interval: {secs: 0, nanos: 500000000}
+  consensus:
+    block_production_tracking_delay: {secs: 0, nanos: 100000000}
+    min_block_production_delay: {secs: 1, nanos: 300000000}
+    max_block_production_delay: {secs: 3, nanos: 0}
+    catchup_step_period: {secs: 0, nanos: 100000000}
+    doomslug_step_period: {secs: 0, nanos: 100000000}
+genesis:
+  epoch_length: 43200
+  gas_limit: 1000000000000000
+`,
+}
+
+// ProfileBuiltins returns the sorted names of the built-in profiles.
+func ProfileBuiltins() []string {
+	var names []string
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfile returns the built-in profile with the given name or loads the
+// profile from the YAML or JSON file name, if no such built-in exists.
+// Profiles loaded from files are named after the file (without extension),
+// if they do not define a name.
+func LoadProfile(name string) (*Profile, error) {
+	data := []byte(builtinProfiles[name])
+	if len(data) == 0 {
+		var err error
+		data, err = os.ReadFile(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("neard: unknown profile '%s' (built-in profiles: %s)",
+					name, strings.Join(ProfileBuiltins(), ", "))
+			}
+			return nil, err
+		}
+	}
+	p, err := parseProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("neard: cannot parse profile '%s': %s", name, err)
+	}
+	if p.Name == "" {
+		if _, ok := builtinProfiles[name]; ok {
+			p.Name = name
+		} else {
+			p.Name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		}
+	}
+	return p, nil
+}
+
+// parseProfile parses a profile in YAML (or JSON, which is a subset).
+func parseProfile(data []byte) (*Profile, error) {
+	var v interface{}
+	if err := yaml.UnmarshalStrict(data, &v); err != nil {
+		return nil, err
+	}
+	v, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	jsn, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var p Profile
+	dec := json.NewDecoder(bytes.NewReader(jsn))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// jsonValue converts the YAML value v into a value which can be marshaled
+// as JSON (YAML maps can have non-string keys).
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("non-string key %v", key)
+			}
+			var err error
+			if m[k], err = jsonValue(value); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		for i := range v {
+			var err error
+			if v[i], err = jsonValue(v[i]); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// mergeJSON merges the overrides into dst.
+func mergeJSON(dst, overrides map[string]interface{}) {
+	for k, v := range overrides {
+		src, ok := v.(map[string]interface{})
+		if ok {
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				mergeJSON(d, src)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// overrideJSONFile merges the overrides into the JSON object in filename.
+func overrideJSONFile(filename string, overrides map[string]interface{}) error {
+	if len(overrides) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keep large numbers exact
+	if err := dec.Decode(&m); err != nil {
+		return fmt.Errorf("neard: cannot parse '%s': %w", filename, err)
+	}
+	mergeJSON(m, overrides)
+	data, err = json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
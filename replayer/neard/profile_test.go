@@ -0,0 +1,105 @@
+package neard
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProfile(t *testing.T) {
+	for _, name := range ProfileBuiltins() {
+		p, err := LoadProfile(name)
+		if err != nil {
+			t.Fatalf("LoadProfile(%s): %s", name, err)
+		}
+		if p.Name != name || len(p.Config) == 0 {
+			t.Errorf("built-in profile %s has name '%s' and %d config overrides", name, p.Name, len(p.Config))
+		}
+	}
+	if _, err := LoadProfile("unknown"); err == nil {
+		t.Error("LoadProfile() should fail for unknown profile")
+	}
+
+	dir := t.TempDir()
+	for filename, data := range map[string]string{
+		"shards.yaml": "config:\n  tracked_shards: [0, 1]\ngenesis:\n  epoch_length: 100\n",
+		"shards.json": `{"config": {"tracked_shards": [0, 1]}, "genesis": {"epoch_length": 100}}`,
+	} {
+		filename = filepath.Join(dir, filename)
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := LoadProfile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jsn, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := `{"name":"shards","config":{"tracked_shards":[0,1]},"genesis":{"epoch_length":100}}`
+		if string(jsn) != exp {
+			t.Errorf("%s: profile is %s, expected %s", filename, jsn, exp)
+		}
+	}
+
+	filename := filepath.Join(dir, "invalid.yaml")
+	for _, data := range []string{
+		"config: [1]\n",
+		"configs: {}\n",
+		"config:\n  1: 2\n",
+	} {
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadProfile(filename); err == nil {
+			t.Errorf("LoadProfile() should fail for %q", data)
+		}
+	}
+}
+
+func TestOverrideJSONFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	config := `{"consensus": {"min_block_production_delay": {"secs": 1, "nanos": 0}, "other": true},
+		"total_supply": 123456789012345678901234567890, "tracked_shards": [0]}`
+	if err := os.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := parseProfile([]byte(`
+config:
+  consensus:
+    min_block_production_delay: {secs: 0, nanos: 5}
+  tracked_shards: [1, 2]
+  store: {path: data}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := overrideJSONFile(filename, p.Config); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		"consensus":      `{"min_block_production_delay":{"nanos":5,"secs":0},"other":true}`,
+		"total_supply":   `123456789012345678901234567890`,
+		"tracked_shards": `[1,2]`,
+		"store":          `{"path":"data"}`,
+	}
+	for k, v := range exp {
+		b, err := json.Marshal(m[k]) // compacts the value
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != v {
+			t.Errorf("%s is %s, expected %s", k, b, v)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package replayer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// txSender recovers the sender of the Ethereum transaction tx.
+func txSender(signer types.Signer, tx *db.Transaction) (common.Address, error) {
+	var t types.Transaction
+	if err := t.UnmarshalBinary(tx.RLP); err != nil {
+		return common.Address{}, err
+	}
+	return types.Sender(signer, &t)
+}
+
+// senderGroup contains the (indices of the) pending transactions of a single
+// Ethereum sender, in block order.
+type senderGroup []int
+
+// groupBySender groups the 'submit' transactions txs by Ethereum sender. The
+// groups are ordered by the first transaction of each sender.
+func groupBySender(signer types.Signer, txs []*Tx) ([]senderGroup, error) {
+	var groups []senderGroup
+	bySender := make(map[common.Address]int)
+	for i, tx := range txs {
+		from, err := txSender(signer, tx.EthTx)
+		if err != nil {
+			return nil, fmt.Errorf("replayer: cannot recover sender of transaction %d (in block %d): %s",
+				tx.TxNum, tx.BlockNum, err)
+		}
+		g, ok := bySender[from]
+		if !ok {
+			g = len(groups)
+			bySender[from] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups, nil
+}
+
+// relayerID returns the ID of the i-th relayer account created for the
+// engine account accountID.
+func relayerID(accountID string, i int) string {
+	parts := strings.SplitN(accountID, ".", 2)
+	if len(parts) == 1 {
+		return fmt.Sprintf("relayer%d-%s", i, accountID)
+	}
+	return fmt.Sprintf("relayer%d-%s.%s", i, parts[0], parts[1])
+}
+
+// createRelayers creates n relayer accounts with ca and returns their IDs.
+func (r *Replayer) createRelayers(ca *CreateAccount, n int) ([]string, error) {
+	var ids []string
+	for i := 0; i < n; i++ {
+		id := relayerID(r.Breakpoint.AccountID, i)
+		log.Info(fmt.Sprintf("create relayer account %s", id))
+		if err := ca.Create(id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// loadSigners loads the NEAR accounts for the signer IDs, which sign the
+// 'submit' calls in pipelined mode. Account a is reused for its own ID, which
+// keeps the nonces of its access key consistent.
+func (r *Replayer) loadSigners(
+	conn *near.Connection,
+	a *near.Account,
+	ids []string,
+) ([]*near.Account, error) {
+	if len(ids) < r.Concurrency {
+		return nil, fmt.Errorf("replayer: concurrency %d requires at least %d signers (have %d)",
+			r.Concurrency, r.Concurrency, len(ids))
+	}
+	var signers []*near.Account
+	seen := make(map[string]bool)
+	for _, id := range ids[:r.Concurrency] {
+		if seen[id] {
+			return nil, fmt.Errorf("replayer: duplicate signer '%s'", id)
+		}
+		seen[id] = true
+		if id == r.Breakpoint.AccountID {
+			signers = append(signers, a)
+			continue
+		}
+		s, err := near.LoadAccount(conn, r.Config, id)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, s)
+	}
+	return signers, nil
+}
+
+// txFailed returns true, if the NEAR transaction with txResult failed.
+func txFailed(txResult map[string]interface{}) bool {
+	status, ok := txResult["status"].(map[string]interface{})
+	return !ok || status["Failure"] != nil
+}
+
+type submitResult struct {
+	txResult map[string]interface{}
+	err      error
+	done     bool // false, if the transaction was not submitted
+}
+
+// submitPending submits the pending 'submit' transactions of a block to
+// evmContract. Transactions of different Ethereum senders are submitted
+// concurrently by the signers, transactions of the same sender in block order
+// by a single signer. After a failure no further transactions are submitted.
+// The results are processed in block order afterwards: all successful
+// transactions are confirmed in the journal (also those after a failure,
+// which have been executed already) and the first failure is returned.
+func (r *Replayer) submitPending(
+	evmContract string,
+	signers []*near.Account,
+	pending []*Tx,
+) (blockNum int, txNum int, errormsg []byte, err error) {
+	if len(pending) == 0 {
+		return -1, -1, nil, nil
+	}
+	signer := types.LatestSignerForChainID(r.Genesis.Config.ChainID)
+	groups, err := groupBySender(signer, pending)
+	if err != nil {
+		return -1, -1, nil, err
+	}
+	zeroAmount := big.NewInt(0)
+	results := make([]submitResult, len(pending))
+	jobs := make(chan senderGroup)
+	var (
+		wg     sync.WaitGroup
+		failed int32 // set to 1 after the first failure
+	)
+	for i := 0; i < len(signers) && i < len(groups); i++ {
+		wg.Add(1)
+		go func(a *near.Account) {
+			defer wg.Done()
+			for g := range jobs {
+				for _, j := range g {
+					if atomic.LoadInt32(&failed) != 0 {
+						break
+					}
+					tx := pending[j]
+					r.events.emitCall(tx.MethodName, tx)
+					start := time.Now()
+					res, err := a.FunctionCall(evmContract, tx.MethodName, tx.Args, r.Gas, *zeroAmount)
+					r.recordCall(tx.MethodName, tx, 1, time.Since(start), res, err)
+					results[j] = submitResult{txResult: res, err: err, done: true}
+					if err != nil || txFailed(res) {
+						// later transactions of the same sender depend on this one
+						atomic.StoreInt32(&failed, 1)
+						break
+					}
+				}
+			}
+		}(signers[i])
+	}
+	for _, g := range groups {
+		jobs <- g
+	}
+	close(jobs)
+	wg.Wait()
+
+	// process results in block order
+	blockNum, txNum = -1, -1
+	setErr := func(b, t int, msg []byte, e error) {
+		if err == nil {
+			blockNum, txNum, errormsg, err = b, t, msg, e
+		}
+	}
+	for j, tx := range pending {
+		res := results[j]
+		if !res.done {
+			continue // not submitted after a failure
+		}
+		if tx.Comment != "" {
+			fmt.Println(tx.Comment)
+		}
+		if res.err != nil {
+			setErr(-1, -1, nil, res.err)
+			continue
+		}
+		if msg, e := procTxResult(false, tx.EthTx, res.txResult); e != nil {
+			setErr(tx.BlockNum, tx.TxNum, msg, e)
+			continue
+		}
+		// the transaction has been executed, even if verification fails
+		if e := r.journal.confirm(tx); e != nil {
+			setErr(-1, -1, nil, e)
+		}
+		if r.Verify && tx.EthTx != nil {
+			if msg, e := verifyTxResult(tx.EthTx, res.txResult); e != nil {
+				setErr(tx.BlockNum, tx.TxNum, msg, e)
+			}
+		}
+	}
+	return blockNum, txNum, errormsg, err
+}
+
+// replayPipelined replays the transactions from c with multiple transactions
+// in flight. Calls other than 'submit' (like 'begin_block') are sent with
+// account a after all pending 'submit' calls have been completed, which
+// preserves the block order.
+func (r *Replayer) replayPipelined(
+	evmContract string,
+	a *near.Account,
+	signers []*near.Account,
+	c chan *Tx,
+) (blockNum int, txNum int, errormsg []byte, err error) {
+	if r.Genesis == nil {
+		return -1, -1, nil, errors.New("replayer: pipelined mode requires genesis")
+	}
+	zeroAmount := big.NewInt(0)
+	var pending []*Tx
+	for tx := range c {
+		r.metrics.setBacklog(len(c))
+		if tx.Error != nil {
+			return -1, -1, nil, tx.Error
+		}
+		if tx.MethodName == "submit" && tx.EthTx != nil {
+			pending = append(pending, tx)
+			continue
+		}
+		// barrier
+		blockNum, txNum, errormsg, err := r.submitPending(evmContract, signers, pending)
+		if err != nil {
+			return blockNum, txNum, errormsg, err
+		}
+		pending = pending[:0]
+		if tx.Comment != "" {
+			fmt.Println(tx.Comment)
+		}
+		if tx.Skip != nil {
+			r.events.emitSkipRange(tx)
+		}
+		if tx.MethodName != "" {
+			r.events.emitCall(tx.MethodName, tx)
+			start := time.Now()
+			txResult, err := a.FunctionCall(evmContract, tx.MethodName, tx.Args, r.Gas, *zeroAmount)
+			r.recordCall(tx.MethodName, tx, 0, time.Since(start), txResult, err)
+			if err != nil {
+				return -1, -1, nil, err
+			}
+			if errormsg, err := procTxResult(false, tx.EthTx, txResult); err != nil {
+				return tx.BlockNum, tx.TxNum, errormsg, err
+			}
+		}
+	}
+	return r.submitPending(evmContract, signers, pending)
+}
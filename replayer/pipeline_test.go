@@ -0,0 +1,231 @@
+package replayer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/aurora-is-near/evm-bully/util/nearmock"
+	"github.com/aurora-is-near/near-api-go/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestGroupBySender(t *testing.T) {
+	chainID := big.NewInt(5)
+	signer := types.LatestSignerForChainID(chainID)
+	type account struct {
+		key   *ecdsa.PrivateKey
+		nonce uint64
+	}
+	var keys []*account
+	for i := 0; i < 3; i++ {
+		k, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, &account{key: k})
+	}
+	// senders of the transactions in block order
+	senders := []int{0, 1, 0, 2, 1, 0}
+	var txs []*Tx
+	for i, s := range senders {
+		k := keys[s]
+		to := common.Address{1}
+		tx, err := types.SignTx(types.NewTransaction(k.nonce, to, big.NewInt(1), 21000,
+			big.NewInt(1), nil), signer, k.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k.nonce++
+		rlp, err := tx.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, &Tx{
+			BlockNum:   1,
+			TxNum:      i,
+			MethodName: "submit",
+			Args:       rlp,
+			EthTx:      &db.Transaction{RLP: rlp},
+		})
+	}
+	groups, err := groupBySender(signer, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []senderGroup{{0, 2, 5}, {1, 4}, {3}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("groupBySender() = %v, expected %v", groups, expected)
+	}
+}
+
+func TestRelayerID(t *testing.T) {
+	id := relayerID("0123456789abcdef0123456789abcdef.test.near", 2)
+	if id != "relayer2-0123456789abcdef0123456789abcdef.test.near" {
+		t.Errorf("relayerID() = %s", id)
+	}
+}
+
+// testPipelineSenders are the Ethereum senders of the transactions of the
+// synthetic dump used in pipelined mode.
+var testPipelineSenders = [][]int{{}, {0, 1, 2, 0, 1, 2}, {2, 0}}
+
+// newTestPipelinedReplayer returns a replayer in pipelined mode with three
+// relayer accounts for a dump with testPipelineSenders.
+func newTestPipelinedReplayer(t *testing.T) (*Replayer, *nearmock.Server, [][][]byte) {
+	r, srv, _ := newTestReplayer(t)
+	rlps := writeTestDumpWithSenders(t, testPipelineSenders)
+	r.Genesis.Config = &params.ChainConfig{ChainID: testChainID}
+	r.Concurrency = 3
+	for i := 0; i < r.Concurrency; i++ {
+		id := relayerID(testEngine, i)
+		kp, err := keystore.GenerateEd25519KeyPair(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := kp.Write("local"); err != nil {
+			t.Fatal(err)
+		}
+		srv.AddAccount(id, kp.PublicKey)
+		r.Signers = append(r.Signers, id)
+	}
+	srv.SetLatency(10 * time.Millisecond)
+	return r, srv, rlps
+}
+
+// submitCalls returns the index of the 'submit' call of each transaction
+// (identified by its RLP encoding) in calls.
+func submitCalls(t *testing.T, calls []*nearmock.Call) map[string][]int {
+	t.Helper()
+	m := make(map[string][]int)
+	for i, c := range calls {
+		if c.MethodName == "submit" {
+			m[string(c.Args)] = append(m[string(c.Args)], i)
+		}
+	}
+	return m
+}
+
+func TestReplayPipelined(t *testing.T) {
+	r, srv, rlps := newTestPipelinedReplayer(t)
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	calls := srv.Calls()
+	submits := submitCalls(t, calls)
+	beginBlock := make(map[int]int) // block -> call index
+	for i, c := range calls {
+		if c.MethodName == "begin_block" {
+			beginBlock[len(beginBlock)] = i
+		}
+	}
+	for height, txs := range rlps {
+		for i, rlp := range txs {
+			idx := submits[string(rlp)]
+			if len(idx) != 1 {
+				t.Fatalf("transaction %d in block %d submitted %d times", i, height, len(idx))
+			}
+			// submitted between begin_block of its block and the next one
+			if idx[0] < beginBlock[height] ||
+				(height+1 < len(rlps) && idx[0] > beginBlock[height+1]) {
+				t.Errorf("transaction %d in block %d submitted out of block order", i, height)
+			}
+		}
+	}
+	// transactions of the same sender are submitted in order by the same signer
+	for i := 0; i < 3; i++ {
+		first, second := calls[submits[string(rlps[1][i])][0]], calls[submits[string(rlps[1][i+3])][0]]
+		if first.Tx > second.Tx || first.SignerID != second.SignerID {
+			t.Errorf("transactions of sender %d submitted out of order (or by different signers)", i)
+		}
+	}
+	// all transactions are confirmed
+	cacheDir, err := util.DetermineCacheDir(testTestnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cp, err := readJournal(filepath.Join(cacheDir, JournalFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Block != 2 || !reflect.DeepEqual(cp.done, map[int]bool{0: true, 1: true}) {
+		t.Errorf("readJournal() returned checkpoint %+v, expected block 2 with transactions 0 and 1", cp)
+	}
+}
+
+func TestReplayPipelinedFailure(t *testing.T) {
+	r, srv, rlps := newTestPipelinedReplayer(t)
+	failing := rlps[1][0]
+	srv.SetOutcome(func(c *nearmock.Call) nearmock.Outcome {
+		if c.MethodName == "submit" && bytes.Equal(c.Args, failing) {
+			return nearmock.Outcome{Failure: "ERR_INCORRECT_NONCE"}
+		}
+		return nearmock.Outcome{}
+	})
+	blockNum, txNum, _, err := r.replay(testEngine)
+	if err == nil {
+		t.Fatal("replay() should fail")
+	}
+	if blockNum != 1 || txNum != 0 {
+		t.Errorf("replay() failed at block %d, tx %d, expected block 1, tx 0", blockNum, txNum)
+	}
+	// all successful transactions are confirmed (also those submitted after
+	// the failure)
+	submits := submitCalls(t, srv.Calls())
+	expected := make(map[int]bool)
+	for i, rlp := range rlps[1] {
+		if len(submits[string(rlp)]) > 0 && !bytes.Equal(rlp, failing) {
+			expected[i] = true
+		}
+	}
+	if submits[string(rlps[1][3])] != nil {
+		t.Error("transaction after failed transaction of the same sender submitted")
+	}
+	cacheDir, err := util.DetermineCacheDir(testTestnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cp, err := readJournal(filepath.Join(cacheDir, JournalFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected) == 0 {
+		if cp != nil {
+			t.Errorf("readJournal() returned checkpoint %+v, expected none", cp)
+		}
+		return // nothing to resume
+	}
+	if cp == nil || cp.Block != 1 || !reflect.DeepEqual(cp.done, expected) {
+		t.Fatalf("readJournal() returned checkpoint %+v, expected block 1 with %v", cp, expected)
+	}
+
+	// resuming submits every other transaction exactly once
+	srv.SetOutcome(nil)
+	srv.ResetCalls()
+	r.Resume = true
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	resubmits := submitCalls(t, srv.Calls())
+	for height, txs := range rlps {
+		for i, rlp := range txs {
+			n := len(resubmits[string(rlp)])
+			if height == 1 && expected[i] {
+				if n != 0 {
+					t.Errorf("confirmed transaction %d in block %d submitted again", i, height)
+				}
+			} else if n != 1 {
+				t.Errorf("transaction %d in block %d submitted %d times after resume", i, height, n)
+			}
+		}
+	}
+}
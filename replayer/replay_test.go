@@ -0,0 +1,554 @@
+package replayer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/aurora-is-near/evm-bully/util/git"
+	"github.com/aurora-is-near/evm-bully/util/nearmock"
+	"github.com/aurora-is-near/evm-bully/util/tar"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/aurora-is-near/near-api-go/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/near/borsh-go"
+)
+
+const (
+	testTestnet = "testnet"
+	testEngine  = "aurora.test.near"
+)
+
+var testChainID = big.NewInt(1313161556)
+
+// testTxsPerBlock defines the synthetic dump: the number of transactions in
+// each block (blocks 0, 2, and 3 are empty).
+var testTxsPerBlock = []int{0, 2, 0, 0, 1, 1}
+
+// setTestHome sets $HOME to a temporary directory and shortens the delays of
+// the replayer for the duration of the test.
+func setTestHome(t *testing.T) string {
+	home := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	oldInstallDelay, oldBreakDelay := installDelay, breakDelay
+	os.Setenv("HOME", home)
+	installDelay, breakDelay = 0, 0
+	t.Cleanup(func() {
+		os.Setenv("HOME", oldHome)
+		installDelay, breakDelay = oldInstallDelay, oldBreakDelay
+	})
+	return home
+}
+
+// writeTestDump writes the synthetic dump for testTestnet and returns the
+// RLP encoded transactions of each block.
+func writeTestDump(t *testing.T) [][][]byte {
+	senders := make([][]int, len(testTxsPerBlock))
+	for height, n := range testTxsPerBlock {
+		senders[height] = make([]int, n)
+	}
+	return writeTestDumpWithSenders(t, senders)
+}
+
+// writeTestDumpWithSenders writes a synthetic dump for testTestnet with one
+// block per entry of senders, which lists the (indices of the) Ethereum
+// senders of the transactions in the block. It returns the RLP encoded
+// transactions of each block.
+func writeTestDumpWithSenders(t *testing.T, senders [][]int) [][][]byte {
+	cacheDir, err := util.DetermineCacheDir(testTestnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := db.NewWriter(filepath.Join(cacheDir, "dump.db"), db.CodecNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		keys   []*ecdsa.PrivateKey
+		nonces []uint64
+	)
+	signer := types.LatestSignerForChainID(testChainID)
+	rlps := make([][][]byte, len(senders))
+	for height, txSenders := range senders {
+		b := &db.Block{
+			Header: &types.Header{
+				Number:     big.NewInt(int64(height)),
+				Difficulty: big.NewInt(1),
+				GasLimit:   8000000,
+			},
+			Time: uint64(1600000000 + height),
+			Hash: common.BigToHash(big.NewInt(int64(1000 + height))),
+		}
+		for _, sender := range txSenders {
+			for len(keys) <= sender {
+				key, err := crypto.GenerateKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				keys = append(keys, key)
+				nonces = append(nonces, 0)
+			}
+			tx, err := types.SignTx(types.NewTransaction(nonces[sender], common.Address{1},
+				big.NewInt(1), 21000, big.NewInt(1), nil), signer, keys[sender])
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonces[sender]++
+			rlp, err := tx.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Transactions = append(b.Transactions, &db.Transaction{
+				RLP:      rlp,
+				Nonce:    tx.Nonce(),
+				GasPrice: tx.GasPrice(),
+				GasLimit: tx.Gas(),
+				To:       tx.To(),
+				Value:    tx.Value(),
+				Receipt:  testReceipt(),
+			})
+			rlps[height] = append(rlps[height], rlp)
+		}
+		if err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return rlps
+}
+
+// newTestReplayer sets up a fake NEAR RPC server with an engine account, the
+// synthetic dump, and returns a replayer for it.
+func newTestReplayer(t *testing.T) (*Replayer, *nearmock.Server, [][][]byte) {
+	setTestHome(t)
+	rlps := writeTestDump(t)
+	srv := nearmock.NewServer()
+	t.Cleanup(srv.Close)
+	kp, err := keystore.GenerateEd25519KeyPair(testEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(os.Getenv("HOME"), ".near-credentials", "local", testEngine+".json")
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Write("local"); err != nil {
+		t.Fatal(err)
+	}
+	srv.AddAccount(testEngine, kp.PublicKey)
+	srv.SetCode(testEngine, []byte("\x00asm engine"))
+	r := &Replayer{
+		Config:     &near.Config{NetworkID: "local", NodeURL: srv.URL},
+		ChainID:    testChainID,
+		Gas:        300000000000000,
+		Testnet:    testTestnet,
+		Genesis:    &core.Genesis{Alloc: core.GenesisAlloc{common.Address{2}: {Balance: big.NewInt(1)}}},
+		BatchSize:  10,
+		BreakBlock: -1,
+		Breakpoint: Breakpoint{AccountID: testEngine},
+	}
+	return r, srv, rlps
+}
+
+// testCall is an expected function call.
+type testCall struct {
+	method string
+	block  int    // block number for 'begin_block'
+	rlp    []byte // transaction for 'submit'
+}
+
+// expectedCalls returns the expected calls for replaying the synthetic dump
+// until before transaction breakTx in block breakBlock (if breakBlock != -1).
+// If skip is true, empty blocks are skipped.
+func expectedCalls(rlps [][][]byte, skip bool, breakBlock, breakTx int) []testCall {
+	calls := []testCall{{method: "begin_chain"}}
+	for height, txs := range rlps {
+		if height == breakBlock && breakTx == 0 {
+			break
+		}
+		if len(txs) == 0 && skip {
+			continue
+		}
+		calls = append(calls, testCall{method: "begin_block", block: height})
+		for i, rlp := range txs {
+			if height == breakBlock && i == breakTx {
+				return calls
+			}
+			calls = append(calls, testCall{method: "submit", rlp: rlp})
+		}
+	}
+	return calls
+}
+
+func checkCalls(t *testing.T, calls []*nearmock.Call, expected []testCall) {
+	t.Helper()
+	if len(calls) != len(expected) {
+		t.Fatalf("got %d calls, expected %d", len(calls), len(expected))
+	}
+	for i, c := range calls {
+		e := expected[i]
+		if c.MethodName != e.method {
+			t.Errorf("call %d: method %s, expected %s", i, c.MethodName, e.method)
+			continue
+		}
+		if c.ReceiverID != testEngine {
+			t.Errorf("call %d: receiver %s, expected %s", i, c.ReceiverID, testEngine)
+		}
+		switch e.method {
+		case "begin_chain":
+			var args BeginChainArgs
+			if err := borsh.Deserialize(&args, c.Args); err != nil {
+				t.Error(err)
+			} else if new(big.Int).SetBytes(args.ChainID[:]).Cmp(testChainID) != 0 {
+				t.Errorf("call %d: wrong chain ID", i)
+			}
+		case "begin_block":
+			var args BeginBlockArgs
+			if err := borsh.Deserialize(&args, c.Args); err != nil {
+				t.Error(err)
+			} else if args.Number[0] != byte(e.block) {
+				t.Errorf("call %d: begin_block(%d), expected begin_block(%d)",
+					i, args.Number[0], e.block)
+			}
+		case "submit":
+			if !bytes.Equal(c.Args, e.rlp) {
+				t.Errorf("call %d: submit with wrong transaction", i)
+			}
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	r, srv, rlps := newTestReplayer(t)
+	r.EventLog = filepath.Join(t.TempDir(), "events.jsonl")
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	calls := srv.Calls()
+	checkCalls(t, calls, expectedCalls(rlps, false, -1, 0))
+	// one transaction per call
+	for i, c := range calls {
+		if c.Tx != i {
+			t.Errorf("call %d sent in transaction %d", i, c.Tx)
+		}
+	}
+	// benchmark report
+	cacheDir, err := util.DetermineCacheDir(testTestnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep Report
+	if err := readJSONFile(filepath.Join(cacheDir, ReportFilename+".json"), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Txs != 4 {
+		t.Errorf("report contains %d transactions, expected 4", rep.Txs)
+	}
+	if rep.SubmitLatency == nil || rep.SubmitLatency.Count != 4 {
+		t.Errorf("report contains wrong submit latency: %+v", rep.SubmitLatency)
+	}
+	// event log
+	var results int
+	for _, e := range readTestEvents(t, r.EventLog) {
+		if e.Type == EventResult {
+			results++
+			if e.Outcome != OutcomeSuccess {
+				t.Errorf("event %+v is not successful", e)
+			}
+		}
+	}
+	if results != len(calls) {
+		t.Errorf("event log contains %d results, expected %d", results, len(calls))
+	}
+}
+
+func TestReplayBatch(t *testing.T) {
+	r, srv, rlps := newTestReplayer(t)
+	r.Batch = true
+	r.BatchSize = 3
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	calls := srv.Calls()
+	checkCalls(t, calls, expectedCalls(rlps, false, -1, 0))
+	for i, c := range calls {
+		if c.Tx != i/r.BatchSize || c.Action != i%r.BatchSize {
+			t.Errorf("call %d sent as action %d of transaction %d", i, c.Action, c.Tx)
+		}
+	}
+}
+
+func TestReplaySkip(t *testing.T) {
+	r, srv, rlps := newTestReplayer(t)
+	r.Skip = true
+	r.EventLog = filepath.Join(t.TempDir(), "events.jsonl")
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, srv.Calls(), expectedCalls(rlps, true, -1, 0))
+	var skips []SkipRange
+	for _, e := range readTestEvents(t, r.EventLog) {
+		if e.Type == EventSkipRange {
+			skips = append(skips, *e.Skip)
+		}
+	}
+	expected := []SkipRange{{First: 0, Last: 0}, {First: 2, Last: 3}}
+	if len(skips) != len(expected) {
+		t.Fatalf("skip ranges %v, expected %v", skips, expected)
+	}
+	for i := range skips {
+		if skips[i] != expected[i] {
+			t.Errorf("skip range %v, expected %v", skips[i], expected[i])
+		}
+	}
+}
+
+func TestReplayFailure(t *testing.T) {
+	r, srv, rlps := newTestReplayer(t)
+	failing := rlps[4][0]
+	srv.SetOutcome(func(c *nearmock.Call) nearmock.Outcome {
+		if c.MethodName == "submit" && bytes.Equal(c.Args, failing) {
+			return nearmock.Outcome{Failure: "ERR_INCORRECT_NONCE"}
+		}
+		return nearmock.Outcome{}
+	})
+	blockNum, txNum, errormsg, err := r.replay(testEngine)
+	if err == nil {
+		t.Fatal("replay() should fail")
+	}
+	if blockNum != 4 || txNum != 0 {
+		t.Errorf("replay() failed at block %d, tx %d, expected block 4, tx 0", blockNum, txNum)
+	}
+	if !bytes.Contains(errormsg, []byte("ERR_INCORRECT_NONCE")) {
+		t.Errorf("error message %s does not contain execution error", errormsg)
+	}
+}
+
+func TestReplayResume(t *testing.T) {
+	r, srv, rlps := newTestReplayer(t)
+	failing := rlps[4][0]
+	srv.SetOutcome(func(c *nearmock.Call) nearmock.Outcome {
+		if c.MethodName == "submit" && bytes.Equal(c.Args, failing) {
+			return nearmock.Outcome{Failure: "ERR_INCORRECT_NONCE"}
+		}
+		return nearmock.Outcome{}
+	})
+	if _, _, _, err := r.replay(testEngine); err == nil {
+		t.Fatal("replay() should fail")
+	}
+	cacheDir, err := util.DetermineCacheDir(testTestnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(cacheDir, JournalFilename)
+	session, cp, err := readJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without -setup and -neardhead the version of the node is recorded
+	if v := nearmock.DefaultVersion + " (build " + nearmock.DefaultBuild + ")"; session.NearcoreHead != v {
+		t.Errorf("journal records nearcore %q, expected %q", session.NearcoreHead, v)
+	}
+	if cp == nil || cp.Block != 1 || cp.Tx != 1 {
+		t.Fatalf("readJournal() returned checkpoint %v, expected {1 1}", cp)
+	}
+
+	// resume in block 1 (skipping its confirmed transactions)
+	srv.SetOutcome(nil)
+	srv.ResetCalls()
+	r.Resume = true
+	r.NeardHead = "0123456789abcdef"
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	var expected []testCall
+	for _, c := range expectedCalls(rlps, false, -1, 0) {
+		if c.method == "begin_chain" || c.method == "begin_block" && c.block < 1 ||
+			c.method == "submit" && (bytes.Equal(c.rlp, rlps[1][0]) || bytes.Equal(c.rlp, rlps[1][1])) {
+			continue
+		}
+		expected = append(expected, c)
+	}
+	checkCalls(t, srv.Calls(), expected)
+	session, cp, err = readJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.NearcoreHead != r.NeardHead {
+		t.Errorf("journal records nearcore %q, expected %q", session.NearcoreHead, r.NeardHead)
+	}
+	if session.Start == nil || session.Start.Block != 1 || session.Start.Tx != 0 {
+		t.Errorf("journal records session start %v, expected {1 0}", session.Start)
+	}
+	if cp == nil || cp.Block != 5 || cp.Tx != 0 {
+		t.Errorf("readJournal() returned checkpoint %v, expected {5 0}", cp)
+	}
+}
+
+func TestReplayResumeDivergence(t *testing.T) {
+	r, srv, rlps := newTestReplayer(t)
+	r.Verify = true
+	diverging := rlps[4][0]
+	srv.SetOutcome(func(c *nearmock.Call) nearmock.Outcome {
+		if c.MethodName != "submit" {
+			return nearmock.Outcome{}
+		}
+		res := testSubmitResult()
+		if bytes.Equal(c.Args, diverging) {
+			res.GasUsed = 30000
+		}
+		return nearmock.Outcome{SuccessValue: encodeSubmitResult(res, true)}
+	})
+	blockNum, txNum, _, err := r.replay(testEngine)
+	if !errors.Is(err, ErrDivergence) {
+		t.Fatalf("replay() = %v, expected %v", err, ErrDivergence)
+	}
+	if blockNum != 4 || txNum != 0 {
+		t.Errorf("replay() failed at block %d, tx %d, expected block 4, tx 0", blockNum, txNum)
+	}
+	cacheDir, err := util.DetermineCacheDir(testTestnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the diverging transaction has been executed and is confirmed
+	_, cp, err := readJournal(filepath.Join(cacheDir, JournalFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Block != 4 || cp.Tx != 0 {
+		t.Fatalf("readJournal() returned checkpoint %v, expected {4 0}", cp)
+	}
+
+	// resuming does not submit the diverging transaction again
+	srv.ResetCalls()
+	r.Resume = true
+	r.Verify = false
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range srv.Calls() {
+		if c.MethodName == "submit" && bytes.Equal(c.Args, diverging) {
+			t.Error("diverging transaction submitted again after resume")
+		}
+	}
+}
+
+func TestReplayAutobreak(t *testing.T) {
+	r, srv, rlps := newTestReplayer(t)
+	failing := rlps[4][0]
+	srv.SetOutcome(func(c *nearmock.Call) nearmock.Outcome {
+		if c.MethodName == "submit" && bytes.Equal(c.Args, failing) {
+			return nearmock.Outcome{Failure: "ERR_INCORRECT_NONCE"}
+		}
+		return nearmock.Outcome{}
+	})
+	srv.SetState(testEngine, []byte("key"), []byte("value"))
+
+	// the head of the aurora-engine is determined from the directory of the
+	// contract, use this repository instead
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git.Head(wd); err != nil {
+		t.Skipf("not a git repository: %s", err)
+	}
+	r.Contract = filepath.Join(wd, "replayer.go")
+	// the breakpoint is written to the working directory
+	tmpdir := t.TempDir()
+	if err := os.Chdir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	r.Autobreak = true
+	r.BreakpointMode = BreakpointModeState
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	if r.BreakBlock != 4 || r.BreakTx != 0 {
+		t.Errorf("break point at block %d, tx %d, expected block 4, tx 0", r.BreakBlock, r.BreakTx)
+	}
+	// the second run stops before the failing transaction
+	calls := srv.Calls()
+	first := len(expectedCalls(rlps, false, 4, 0)) + 2 // begin_block(4) and failing submit
+	checkCalls(t, calls[first:], expectedCalls(rlps, false, 4, 0))
+
+	// check breakpoint
+	root, err := tar.Extract(filepath.Join(tmpdir, testTestnet+"-block-4-tx-0.tar.gz"), tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp, err := loadBreakpoint(filepath.Join(tmpdir, root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bp.Transaction != hex.EncodeToString(failing) {
+		t.Error("breakpoint contains wrong transaction")
+	}
+	if bp.Mode != BreakpointModeState || bp.ChainID.Cmp(testChainID) != 0 {
+		t.Errorf("breakpoint has mode '%s' and chain ID %s", bp.Mode, bp.ChainID)
+	}
+	var s engineState
+	if err := readJSONFile(filepath.Join(tmpdir, root, StateFilename), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Records) != 3 || s.Records[2].Data == nil || s.Records[2].Data.DataKey != "a2V5" {
+		t.Errorf("wrong engine state records")
+	}
+}
+
+func readTestEvents(t *testing.T, filename string) []Event {
+	t.Helper()
+	fp, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	var events []Event
+	s := bufio.NewScanner(fp)
+	for s.Scan() {
+		var e Event
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestReplayFilter(t *testing.T) {
+	r, srv, rlps := newTestReplayer(t)
+	// all transactions of the synthetic dump are sent to address 1
+	r.Filter = &TxFilter{Recipients: []common.Address{{1}}}
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, srv.Calls(), expectedCalls(rlps, false, -1, 0))
+
+	// no transaction matches, all blocks are empty
+	srv.ResetCalls()
+	r.Filter = &TxFilter{CreateOnly: true}
+	r.Skip = true
+	if err := r.Replay(testEngine); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, srv.Calls(), []testCall{{method: "begin_chain"}})
+}
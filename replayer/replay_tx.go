@@ -7,14 +7,17 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/aurora-is-near/evm-bully/replayer/neard"
 	"github.com/aurora-is-near/evm-bully/util/aurora"
 	"github.com/aurora-is-near/evm-bully/util/git"
 	"github.com/aurora-is-near/evm-bully/util/gnumake"
+	"github.com/aurora-is-near/evm-bully/util/tar"
 	"github.com/aurora-is-near/near-api-go"
 	"github.com/aurora-is-near/near-api-go/utils"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/frankbraun/codechain/util/file"
 )
 
@@ -32,85 +35,148 @@ func buildAuroraEngine(head string) error {
 	return nil
 }
 
-// ReplayTx replays transaction from breakpointDir.
-func ReplayTx(
-	breakpointDir string,
-	build bool,
-	contract string,
-	release bool,
-	gas uint64,
-) error {
-	// parse breakpoint.json
+// loadBreakpoint parses the breakpoint.json file in breakpointDir.
+func loadBreakpoint(breakpointDir string) (*Breakpoint, error) {
 	filename := filepath.Join(breakpointDir, "breakpoint.json")
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var bp Breakpoint
 	if err := json.Unmarshal(data, &bp); err != nil {
-		return err
+		return nil, err
 	}
+	if bp.ChainID == nil {
+		return nil, fmt.Errorf("replayer: '%s' defines no chain ID", filename)
+	}
+	return &bp, nil
+}
 
-	if build {
-		if err := buildAuroraEngine(bp.AuroraEngineHead); err != nil {
-			return err
-		}
+// BreakpointArchiveExt is the file extension of breakpoint archives.
+const BreakpointArchiveExt = ".tar.gz"
+
+// openBreakpoint opens the breakpoint at path, which is either a breakpoint
+// directory or a breakpoint archive. Archives are verified and extracted into
+// a temporary directory. It returns the breakpoint directory and a function to
+// remove the temporary directory again.
+func openBreakpoint(path string) (string, func(), error) {
+	if !strings.HasSuffix(path, BreakpointArchiveExt) {
+		return path, func() {}, nil
+	}
+	tmpdir, err := os.MkdirTemp("", "evm-bully-breakpoint-")
+	if err != nil {
+		return "", nil, err
 	}
+	cleanup := func() { os.RemoveAll(tmpdir) }
+	log.Info(fmt.Sprintf("extract '%s'", path))
+	root, err := tar.Extract(path, tmpdir)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	log.Info(fmt.Sprintf("'%s' verified", path))
+	return filepath.Join(tmpdir, root), cleanup, nil
+}
 
+// loadBreakpointNeard loads the neard for breakpoint bp from ../nearcore. If
+// build is true, the nearcore head recorded in bp is checked out and built.
+func loadBreakpointNeard(bp *Breakpoint, build, release bool) (*neard.NEARDaemon, error) {
 	nearDir := filepath.Join("..", "nearcore")
 	if build {
 		if err := git.Checkout(nearDir, bp.NearcoreHead); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	nearDaemon, err := neard.LoadFromRepo(nearDir, release, build)
-	if err != nil {
-		return err
-	}
-	if err := nearDaemon.RestoreLocalData(filepath.Join(breakpointDir, "local")); err != nil {
-		return err
+	return neard.LoadFromRepo(nearDir, release, build)
+}
+
+// replayBreakpointTx restores the local data of nearDaemon from breakpointDir
+// (or rebuilds it from the engine state snapshot with the neard profile
+// recorded in bp), starts it, upgrades the EVM contract with contract (if not
+// empty), replays the transaction of breakpoint bp, stops nearDaemon again,
+// and removes its local data.
+func replayBreakpointTx(
+	nearDaemon *neard.NEARDaemon,
+	breakpointDir string,
+	bp *Breakpoint,
+	contract string,
+	gas uint64,
+) (txResult map[string]interface{}, err error) {
+	nearDaemon.Nodes = bp.Nodes
+	nearDaemon.Shards = bp.Shards
+	switch bp.Mode {
+	case "", BreakpointModeFull:
+		err := nearDaemon.RestoreLocalData(filepath.Join(breakpointDir, "local"))
+		if err != nil {
+			return nil, err
+		}
+	case BreakpointModeState:
+		nearDaemon.Profile = bp.NeardProfile
+		if err := restoreEngineState(nearDaemon, breakpointDir, bp.AccountID); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("replayer: unknown breakpoint mode '%s'", bp.Mode)
 	}
+	defer nearDaemon.RemoveLocalData()
 	if err := nearDaemon.Start(); err != nil {
-		return err
+		return nil, err
 	}
 	defer nearDaemon.Stop()
+	// report unexpected exit of neard instead of the resulting RPC error
+	defer func() {
+		if cerr := nearDaemon.Err(); cerr != nil {
+			err = cerr
+		}
+	}()
 
 	if err := os.Setenv("NEAR_ENV", "local"); err != nil {
-		return err
+		return nil, err
 	}
 	cfg := near.GetConfig()
+	cfg.NodeURL = nearDaemon.NodeURL()
 	c := near.NewConnection(cfg.NodeURL)
 	nearStarted := checkUntilTrue(time.Second*100, "near node is not up yet", func() bool {
+		if nearDaemon.Err() != nil {
+			return true
+		}
 		_, err := c.GetNodeStatus()
 		return err == nil
 	})
+	if err := nearDaemon.Err(); err != nil {
+		return nil, err
+	}
 	if !nearStarted {
-		return fmt.Errorf("replayer: near node is not reachable after 100 seconds")
+		return nil, fmt.Errorf("replayer: near node is not reachable after 100 seconds")
 	}
 
 	// copy credentials file
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	credDir := filepath.Join(home, ".near-credentials", "local")
 	if err := os.MkdirAll(credDir, 0700); err != nil {
-		return err
+		return nil, err
 	}
 	dst := filepath.Join(credDir, bp.AccountID+".json")
 	if err := os.RemoveAll(dst); err != nil {
-		return err
+		return nil, err
 	}
 	err = file.Copy(filepath.Join(breakpointDir, bp.AccountID+".json"), dst)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// upgrade contract before replaying tx, if necessary
 	if contract != "" {
-		err := aurora.Upgrade(bp.AccountID, bp.ChainID, contract)
+		engineCfg := &near.Config{NetworkID: "local", NodeURL: cfg.NodeURL}
+		engine, err := near.LoadAccount(c, engineCfg, bp.AccountID)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if err := aurora.Upgrade(engine, bp.AccountID, contract); err != nil {
+			return nil, err
 		}
 	}
 
@@ -118,17 +184,47 @@ func ReplayTx(
 	zeroAmount := big.NewInt(0)
 	rlp, err := hex.DecodeString(bp.Transaction)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// TODO: why validator_key.json and test.near here?
-	cfg.KeyPath = filepath.Join(home, ".near", "local", "validator_key.json")
-	a, err := near.LoadAccount(c, cfg, "test.near")
+	// TODO: why validator_key.json and master account here?
+	cfg.KeyPath = nearDaemon.ValidatorKeyPath()
+	a, err := near.LoadAccount(c, cfg, nearDaemon.MasterAccount())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	txResult, err := a.FunctionCall(bp.AccountID, "submit", rlp, gas, *zeroAmount)
+	return a.FunctionCall(bp.AccountID, "submit", rlp, gas, *zeroAmount)
+}
+
+// ReplayTx replays transaction from breakpoint, which is either a breakpoint
+// directory or a breakpoint archive (.tar.gz).
+func ReplayTx(
+	breakpoint string,
+	build bool,
+	contract string,
+	release bool,
+	gas uint64,
+) error {
+	breakpointDir, cleanup, err := openBreakpoint(breakpoint)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	bp, err := loadBreakpoint(breakpointDir)
+	if err != nil {
+		return err
+	}
+	if build {
+		if err := buildAuroraEngine(bp.AuroraEngineHead); err != nil {
+			return err
+		}
+	}
+	nearDaemon, err := loadBreakpointNeard(bp, build, release)
+	if err != nil {
+		return err
+	}
+	txResult, err := replayBreakpointTx(nearDaemon, breakpointDir, bp, contract, gas)
 	if err != nil {
 		return err
 	}
@@ -15,48 +15,82 @@ import (
 
 	"github.com/aurora-is-near/evm-bully/db"
 	"github.com/aurora-is-near/evm-bully/replayer/neard"
+	"github.com/aurora-is-near/evm-bully/util"
 	"github.com/aurora-is-near/evm-bully/util/aurora"
+	"github.com/aurora-is-near/evm-bully/util/nearvm"
 	"github.com/aurora-is-near/evm-bully/util/tar"
 	"github.com/aurora-is-near/near-api-go"
 	"github.com/aurora-is-near/near-api-go/utils"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/log"
-	"github.com/frankbraun/codechain/util/file"
+)
+
+// Delays of the replayer (variables to allow shorter delays in tests).
+var (
+	installDelay = 2 * time.Second // delay before replaying (after contract installation)
+	breakDelay   = 5 * time.Second // delay at break point
 )
 
 // A Replayer replays transactions.
 type Replayer struct {
 	Config         *near.Config
 	Timeout        time.Duration
-	ChainID        uint8
+	ChainID        *big.Int
 	Gas            uint64
 	DataDir        string
 	Testnet        string
+	Genesis        *core.Genesis
 	Defrost        bool
-	Skip           bool   // skip empty blocks
-	Batch          bool   // batch transactions
-	BatchSize      int    // batch size when batching transactions
-	StartBlock     int    // start replaying at this block height
-	StartTx        int    // start replaying at this transaction (in block given by StartBlock)
-	Autobreak      bool   // automatically repeat with break point after error
-	BreakBlock     int    // break replaying at this block height
-	BreakTx        int    // break replaying at this transaction (in block given by BreakBlock)
-	Release        bool   // run release version of neard
-	Setup          bool   // setup and run neard before replaying
-	NeardPath      string // path to neard binary
-	NeardHead      string // git hash of neard
+	Skip           bool     // skip empty blocks
+	Batch          bool     // batch transactions
+	Verify         bool     // compare submit results with Ethereum receipts
+	BatchSize      int      // batch size when batching transactions
+	Concurrency    int      // number of 'submit' calls in flight (pipelined mode, if > 1)
+	Signers        []string // NEAR accounts signing 'submit' calls in pipelined mode
+	StartBlock     int      // start replaying at this block height
+	StartTx        int      // start replaying at this transaction (in block given by StartBlock)
+	Autobreak      bool     // automatically repeat with break point after error
+	BreakBlock     int      // break replaying at this block height
+	BreakTx        int      // break replaying at this transaction (in block given by BreakBlock)
+	Release        bool     // run release version of neard
+	Setup          bool     // setup and run neard before replaying
+	NeardPath      string   // path to neard binary
+	NeardHead      string   // git hash of neard
 	InitialBalance string
 	Contract       string
+	Resume         bool           // resume after last confirmed transaction in checkpoint journal
+	MetricsAddr    string         // serve Prometheus metrics on this address (if not empty)
+	EventLog       string         // write JSONL event log to this file (if not empty)
+	BreakpointMode string         // BreakpointModeFull or BreakpointModeState
+	Filter         *TxFilter      // replay only the selected transactions (all, if nil)
+	NeardProfile   *neard.Profile // profile of neard started with -setup (default, if nil)
+	Nodes          int            // number of validator nodes of localnet started with -setup
+	Shards         int            // number of shards of localnet started with -setup
+	Backend        string         // BackendNeard (default, if empty) or BackendWasm
 	Breakpoint     Breakpoint
+	journal        *journal
+	bench          *benchmark
+	metrics        *metrics
+	events         *eventLog
+	filterSet      map[txPos]bool // transactions selected with dependencies
+	confirmed      map[int]bool   // confirmed transactions in StartBlock (when resuming)
+	nearDaemon     *neard.NEARDaemon
+	runtime        *nearvm.Runtime // embedded runtime of BackendWasm
 }
 
 // Breakpoint defines a break point.
 type Breakpoint struct {
-	ChainID          uint8  `json:"chain-id"`
-	AccountID        string `json:"account-id"`
-	NearcoreHead     string `json:"nearcore"`
-	AuroraEngineHead string `json:"aurora-engine"`
-	Transaction      string `json:"transaction"`
+	ChainID          *big.Int       `json:"chain-id"`
+	AccountID        string         `json:"account-id"`
+	NearcoreHead     string         `json:"nearcore"`
+	AuroraEngineHead string         `json:"aurora-engine"`
+	Transaction      string         `json:"transaction"`
+	Mode             string         `json:"mode,omitempty"`          // empty for BreakpointModeFull
+	NeardProfile     *neard.Profile `json:"neard-profile,omitempty"` // profile of neard started with -setup
+	Nodes            int            `json:"nodes,omitempty"`         // number of localnet nodes (empty for single node)
+	Shards           int            `json:"shards,omitempty"`        // number of localnet shards (empty for single node)
 	tx               *db.Transaction
+	state            *engineState
 }
 
 // startGenerator starts a goroutine that feeds transactions into the returned tx channel.
@@ -64,9 +98,10 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 	c := make(chan *Tx, 10*r.BatchSize)
 
 	go func() {
-		// process genesis block
-		genesisBlock := getGenesisBlock(r.Testnet)
-		c <- r.beginChainTx(genesisBlock)
+		// process genesis block (unless resuming with existing engine state)
+		if !r.Resume {
+			c <- r.beginChainTx(r.Genesis)
+		}
 
 		reader, err := db.NewReader(r.Testnet)
 		if err != nil {
@@ -78,6 +113,19 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 		}
 		defer reader.Close()
 
+		// determine dependencies of filtered transactions, if necessary
+		if r.Filter != nil && r.Filter.Dependencies && r.filterSet == nil {
+			r.filterSet, err = r.scanDependencies()
+			if err != nil {
+				c <- &Tx{
+					BlockNum: -1,
+					Error:    err,
+				}
+				return
+			}
+		}
+		filtered := 0
+
 		emptyRangeStart, emptyRangeEnd := -2, -2
 		flushEmptyRange := func() {
 			if emptyRangeEnd < 0 {
@@ -90,12 +138,29 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 					emptyRangeStart,
 					emptyRangeEnd,
 				),
+				Skip: &SkipRange{First: emptyRangeStart, Last: emptyRangeEnd},
 			}
 			emptyRangeStart, emptyRangeEnd = -2, -2
 		}
 
+		// jump to start block, if necessary
+		if r.StartBlock > 0 {
+			c <- &Tx{
+				BlockNum: -1,
+				Comment:  fmt.Sprintf("skipping blocks [0;%d]", r.StartBlock-1),
+				Skip:     &SkipRange{First: 0, Last: r.StartBlock - 1},
+			}
+			if err := reader.Seek(uint64(r.StartBlock)); err != nil {
+				c <- &Tx{
+					BlockNum: -1,
+					Error:    err,
+				}
+				return
+			}
+		}
+
 	outer:
-		for blockHeight := 0; true; blockHeight++ {
+		for blockHeight := r.StartBlock; true; blockHeight++ {
 			b, err := reader.Next()
 			if err != nil {
 				flushEmptyRange()
@@ -109,14 +174,6 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 				break
 			}
 
-			if blockHeight < r.StartBlock {
-				c <- &Tx{
-					BlockNum: -1,
-					Comment:  fmt.Sprintf("skipping block %d", blockHeight),
-				}
-				continue
-			}
-
 			// early break, if necessary
 			if r.BreakBlock != -1 && r.BreakTx == 0 && blockHeight == r.BreakBlock {
 				flushEmptyRange()
@@ -125,7 +182,7 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 					Comment:  fmt.Sprintf("breaking block %d", blockHeight),
 				}
 				log.Info("sleep")
-				time.Sleep(5 * time.Second)
+				time.Sleep(breakDelay)
 				txs := b.Transactions
 				if txs != nil && len(txs) > 0 {
 					r.Breakpoint.tx = txs[0]
@@ -144,7 +201,19 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 				return
 			}
 
-			if len(b.Transactions) == 0 && r.Skip {
+			// filter transactions
+			selected, n, err := r.filterTxs(blockHeight, b.Transactions)
+			if err != nil {
+				flushEmptyRange()
+				c <- &Tx{
+					BlockNum: -1,
+					Error:    err,
+				}
+				return
+			}
+			filtered += len(b.Transactions) - n
+
+			if n == 0 && r.Skip {
 				if emptyRangeEnd != blockHeight-1 {
 					emptyRangeStart = blockHeight
 				}
@@ -153,7 +222,9 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 			}
 
 			flushEmptyRange()
-			c <- beginBlockTx(r.Gas, ctx)
+			bb := beginBlockTx(r.Gas, ctx)
+			bb.BlockNum = blockHeight
+			c <- bb
 
 			// actual transactions
 			for i, tx := range b.Transactions {
@@ -164,10 +235,13 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 						Comment:  fmt.Sprintf("breaking at transaction %d (in block %d)", i, blockHeight),
 					}
 					log.Info("sleep")
-					time.Sleep(5 * time.Second)
+					time.Sleep(breakDelay)
 					r.Breakpoint.tx = tx
 					break outer
 				}
+				if !selected[i] {
+					continue
+				}
 				if blockHeight == r.StartBlock && i < r.StartTx {
 					c <- &Tx{
 						BlockNum: -1,
@@ -175,6 +249,13 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 					}
 					continue
 				}
+				if blockHeight == r.StartBlock && r.confirmed[i] {
+					c <- &Tx{
+						BlockNum: -1,
+						Comment:  fmt.Sprintf("skipping confirmed transaction %d (in block %d)", i, blockHeight),
+					}
+					continue
+				}
 				amount, err := utils.FormatNearAmount(strconv.FormatUint(r.Gas/uint64(r.BatchSize), 10))
 				if err != nil {
 					c <- &Tx{
@@ -195,6 +276,12 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 			}
 		}
 		flushEmptyRange()
+		if r.Filter != nil {
+			c <- &Tx{
+				BlockNum: -1,
+				Comment:  fmt.Sprintf("%d transactions filtered out", filtered),
+			}
+		}
 		close(c)
 	}()
 
@@ -204,37 +291,78 @@ func (r *Replayer) startTxGenerator() chan *Tx {
 func (r *Replayer) replay(
 	evmContract string,
 ) (blockNum int, txNum int, errormsg []byte, err error) {
-	conn := near.NewConnectionWithTimeout(r.Config.NodeURL, r.Timeout)
+	defer func() {
+		if err != nil {
+			r.events.emitError(blockNum, txNum, err)
+		}
+	}()
+	signerIDs := r.Signers
 
 	// setup, if necessary
 	if r.Setup {
-		// setup neard
+		// setup neard (the local data of a previous run is removed)
 		log.Info("setup neard")
 
-		var nearDaemon *neard.NEARDaemon
-		var err error
-		if r.NeardPath != "" {
-			nearDaemon, err = neard.LoadFromBinary(r.NeardPath, r.NeardHead)
-		} else {
-			nearDaemon, err = neard.LoadFromRepo(filepath.Join("..", "nearcore"), r.Release, true)
-		}
-		if err != nil {
-			return -1, -1, nil, err
+		if r.nearDaemon == nil {
+			var nearDaemon *neard.NEARDaemon
+			var err error
+			if r.NeardPath != "" {
+				nearDaemon, err = neard.LoadFromBinary(r.NeardPath, r.NeardHead)
+			} else {
+				nearDaemon, err = neard.LoadFromRepo(filepath.Join("..", "nearcore"), r.Release, true)
+			}
+			if err != nil {
+				return -1, -1, nil, err
+			}
+			// write neard logs next to the dump
+			nearDaemon.LogDir, err = util.DetermineCacheDir(r.Testnet)
+			if err != nil {
+				return -1, -1, nil, err
+			}
+			r.nearDaemon = nearDaemon
 		}
+		nearDaemon := r.nearDaemon
 
+		nearDaemon.Profile = r.NeardProfile
+		nearDaemon.Nodes = r.Nodes
+		nearDaemon.Shards = r.Shards
 		if err := nearDaemon.SetupLocalData(); err != nil {
 			return -1, -1, nil, err
 		}
+		r.Breakpoint.NeardProfile = nearDaemon.Profile
+		if r.Nodes > 1 || r.Shards > 1 {
+			r.Breakpoint.Nodes = r.Nodes
+			r.Breakpoint.Shards = r.Shards
+		}
 		if err := nearDaemon.Start(); err != nil {
 			return -1, -1, nil, err
 		}
 		defer nearDaemon.Stop()
+		// report unexpected exit of neard instead of the resulting RPC error
+		defer func() {
+			if cerr := nearDaemon.Err(); cerr != nil {
+				err = cerr
+			}
+		}()
 		r.Breakpoint.NearcoreHead = nearDaemon.Head
 
+		// connect to the daemon and use its validator key for the master account
+		r.Config.NodeURL = nearDaemon.NodeURL()
+		r.Config.KeyPath = nearDaemon.ValidatorKeyPath()
+	}
+	conn := near.NewConnectionWithTimeout(r.Config.NodeURL, r.Timeout)
+
+	if r.Setup {
 		nearStarted := checkUntilTrue(time.Second*100, "near node is not up yet", func() bool {
+			if r.nearDaemon.Err() != nil {
+				return true
+			}
 			_, err := conn.GetNodeStatus()
 			return err == nil
 		})
+		if err := r.nearDaemon.Err(); err != nil {
+			return -1, -1, nil, err
+		}
 		if !nearStarted {
 			return -1, -1, nil, fmt.Errorf("replayer: near node is not reachable after 100 seconds")
 		}
@@ -244,7 +372,7 @@ func (r *Replayer) replay(
 		ca := CreateAccount{
 			Config:         r.Config,
 			InitialBalance: r.InitialBalance,
-			MasterAccount:  strings.Join(strings.Split(r.Breakpoint.AccountID, ".")[1:], "."),
+			MasterAccount:  r.nearDaemon.MasterAccount(),
 		}
 		if err := ca.Create(r.Breakpoint.AccountID); err != nil {
 			return -1, -1, nil, err
@@ -260,7 +388,12 @@ func (r *Replayer) replay(
 
 		// install EVM contract
 		log.Info("install EVM contract")
-		err = aurora.Install(r.Breakpoint.AccountID, r.ChainID, r.Contract)
+		engineCfg := &near.Config{NetworkID: r.Config.NetworkID, NodeURL: r.Config.NodeURL}
+		engine, err := near.LoadAccount(conn, engineCfg, r.Breakpoint.AccountID)
+		if err != nil {
+			return -1, -1, nil, err
+		}
+		err = aurora.Install(engine, r.Breakpoint.AccountID, "", r.ChainID, r.Contract)
 		if err != nil {
 			return -1, -1, nil, err
 		}
@@ -273,27 +406,102 @@ func (r *Replayer) replay(
 			return -1, -1, nil, fmt.Errorf("replayer: contract is not accessible after 100 seconds")
 		}
 
+		// create relayer accounts for pipelined mode, if necessary
+		if r.Concurrency > 1 && len(signerIDs) == 0 {
+			signerIDs, err = r.createRelayers(&ca, r.Concurrency)
+			if err != nil {
+				return -1, -1, nil, err
+			}
+		}
+
 		// reset key path
 		r.Config.KeyPath = ""
 	}
 
-	// load account
-	a, err := near.LoadAccount(conn, r.Config, r.Breakpoint.AccountID)
-	if err != nil {
+	// load account (or start the embedded engine)
+	var (
+		a        caller
+		account  *near.Account
+		hash     string
+		nearcore string // git hash or version of neard
+	)
+	if r.Backend == BackendWasm {
+		a, hash, err = r.startEngine(evmContract)
+		if err != nil {
+			return -1, -1, nil, err
+		}
+	} else {
+		account, err = near.LoadAccount(conn, r.Config, r.Breakpoint.AccountID)
+		if err != nil {
+			return -1, -1, nil, err
+		}
+		hash, err = contractHash(conn, evmContract)
+		if err != nil {
+			return -1, -1, nil, err
+		}
+		a = account
+		// use git hash of neard started with -setup or given with -neardhead
+		// and the version reported by the node otherwise
+		if !r.Setup && r.NeardHead != "" {
+			r.Breakpoint.NearcoreHead = r.NeardHead
+		}
+		nearcore = r.Breakpoint.NearcoreHead
+		if nearcore == "" {
+			nearcore, err = nodeVersion(conn)
+			if err != nil {
+				return -1, -1, nil, err
+			}
+		}
+	}
+
+	// start checkpoint journal
+	if err := r.startJournal(evmContract, hash, nearcore); err != nil {
 		return -1, -1, nil, err
 	}
+	defer r.journal.Close()
+
+	// export engine state for breakpoint at the end (while neard is running)
+	defer func() {
+		if err == nil && r.BreakBlock != -1 && r.BreakpointMode == BreakpointModeState {
+			r.Breakpoint.state, err = exportEngineState(r.Config.NodeURL, evmContract)
+		}
+	}()
+
+	// collect benchmark statistics and write report at the end
+	r.bench = newBenchmark()
+	defer func() {
+		if rerr := r.writeReport(r.bench.report(time.Now())); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
 
 	// process transactions
 	batch := make([]near.Action, 0, r.BatchSize)
+	var (
+		lastBatchTx *Tx
+		batchTxs    int // number of Ethereum transactions in batch
+	)
 	zeroAmount := big.NewInt(0)
 	c := r.startTxGenerator()
 
-	// Sleep 2 seconds to prevent contract installation data-race
+	// Sleep to prevent contract installation data-race
 	// which leads to InvalidNonce error message from nearcore
-	log.Info("sleeping for 2 seconds")
-	time.Sleep(2 * time.Second)
+	if r.Backend != BackendWasm {
+		log.Info(fmt.Sprintf("sleeping for %s", installDelay))
+		time.Sleep(installDelay)
+	}
+
+	if r.Concurrency > 1 {
+		// pipelined mode
+		signers, err := r.loadSigners(conn, account, signerIDs)
+		if err != nil {
+			return -1, -1, nil, err
+		}
+		return r.replayPipelined(evmContract, account, signers, c)
+	}
 
 	for tx := range c {
+		r.metrics.setBacklog(len(c))
 		if tx.Error != nil {
 			return -1, -1, nil, tx.Error
 		}
@@ -307,7 +515,10 @@ func (r *Replayer) replay(
 				if tx.Comment != "" {
 					fmt.Println(tx.Comment)
 				}
+				r.events.emitCall(tx.MethodName, tx)
+				start := time.Now()
 				txResult, err = a.FunctionCall(evmContract, tx.MethodName, tx.Args, r.Gas, *zeroAmount)
+				r.recordCall(tx.MethodName, tx, ethTxs(tx), time.Since(start), txResult, err)
 				if err != nil {
 					return -1, -1, nil, err
 				}
@@ -316,6 +527,8 @@ func (r *Replayer) replay(
 				if tx.Comment != "" {
 					fmt.Println("batching: " + tx.Comment)
 				}
+				lastBatchTx = tx
+				batchTxs += ethTxs(tx)
 				batch = append(batch, near.Action{
 					Enum: 2,
 					FunctionCall: near.FunctionCall{
@@ -325,13 +538,19 @@ func (r *Replayer) replay(
 						Deposit:    *zeroAmount,
 					},
 				})
+				r.metrics.setBatchFill(len(batch), r.BatchSize)
 				if len(batch) == r.BatchSize {
 					fmt.Println("running batch")
+					r.events.emitBatch(tx, len(batch), batchTxs)
+					start := time.Now()
 					txResult, err = a.SignAndSendTransaction(evmContract, batch)
+					r.recordCall(callBatch, tx, batchTxs, time.Since(start), txResult, err)
 					if err != nil {
 						return -1, -1, nil, err
 					}
 					batch = batch[:0] // reset
+					batchTxs = 0
+					r.metrics.setBatchFill(0, r.BatchSize)
 				} else {
 					continue // batch no full yet
 				}
@@ -339,27 +558,74 @@ func (r *Replayer) replay(
 			if errormsg, err := procTxResult(r.Batch, tx.EthTx, txResult); err != nil {
 				return tx.BlockNum, tx.TxNum, errormsg, err
 			}
+			// the transaction has been executed, even if verification fails
+			if err := r.journal.confirm(tx); err != nil {
+				return -1, -1, nil, err
+			}
+			if r.Verify && !r.Batch && tx.EthTx != nil {
+				if errormsg, err := verifyTxResult(tx.EthTx, txResult); err != nil {
+					return tx.BlockNum, tx.TxNum, errormsg, err
+				}
+			}
 		} else if tx.Comment != "" {
 			fmt.Println(tx.Comment)
+			if tx.Skip != nil {
+				r.events.emitSkipRange(tx)
+			}
 		}
 	}
 
 	// process last batch, if not empty
 	if len(batch) > 0 {
 		fmt.Println("running last batch")
+		r.events.emitBatch(lastBatchTx, len(batch), batchTxs)
+		start := time.Now()
 		txResult, err := a.SignAndSendTransaction(evmContract, batch)
+		r.recordCall(callBatch, lastBatchTx, batchTxs, time.Since(start), txResult, err)
 		if err != nil {
 			return -1, -1, nil, err
 		}
+		r.metrics.setBatchFill(0, r.BatchSize)
 		if errormsg, err := procTxResult(r.Batch, nil, txResult); err != nil {
 			return -1, -1, errormsg, err
 		}
+		if err := r.journal.confirm(lastBatchTx); err != nil {
+			return -1, -1, nil, err
+		}
 	}
 	return -1, -1, nil, nil
 }
 
 // Replay transactions with evmContract.
-func (r *Replayer) Replay(evmContract string) error {
+func (r *Replayer) Replay(evmContract string) (err error) {
+	// write event log, if necessary
+	if r.EventLog != "" {
+		r.events, err = createEventLog(r.EventLog)
+		if err != nil {
+			return err
+		}
+		defer r.events.Close()
+	}
+
+	// serve metrics, if necessary
+	if r.MetricsAddr != "" {
+		r.metrics = newMetrics()
+		_, stop, err := serveMetrics(r.metrics, r.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	// remove local data of neard started with -setup at the end
+	defer func() {
+		if r.nearDaemon != nil {
+			if rerr := r.nearDaemon.RemoveLocalData(); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+	}()
+
 	keyPath := r.Config.KeyPath
 	blockNum, txNum, errormsg, err := r.replay(evmContract)
 	if err != nil {
@@ -400,6 +666,11 @@ func showTx(tx *db.Transaction) {
 		fmt.Println("data:")
 		fmt.Println("0x" + hex.EncodeToString(tx.Data))
 	}
+	if tx.Receipt != nil {
+		fmt.Printf("receipt status: %d\n", tx.Receipt.Status)
+		fmt.Printf("receipt gasUsed: %d\n", tx.Receipt.GasUsed)
+		fmt.Printf("receipt logs: %d\n", len(tx.Receipt.Logs))
+	}
 }
 
 func procTxResult(
@@ -424,6 +695,10 @@ func procTxResult(
 	return nil, nil
 }
 
+// NeardLogFilename is the name of the file with the last lines of the neard
+// log in a breakpoint.
+const NeardLogFilename = "neard.log"
+
 // saveBreakpoint saves replayer break point for evmContract.
 func (r *Replayer) saveBreakpoint(errormsg []byte) error {
 	var err error
@@ -439,16 +714,21 @@ func (r *Replayer) saveBreakpoint(errormsg []byte) error {
 		return err
 	}
 
+	// set mode
+	r.Breakpoint.Mode = ""
+	if r.BreakpointMode == BreakpointModeState {
+		r.Breakpoint.Mode = BreakpointModeState
+	}
+
 	// encode transaction
 	if r.Breakpoint.tx != nil {
 		r.Breakpoint.Transaction = hex.EncodeToString(r.Breakpoint.tx.RLP)
 	}
 
-	// remove output dir
-	if err := os.RemoveAll(dir); err != nil {
-		return err
-	}
-	if err := os.Mkdir(dir, 0755); err != nil {
+	// create archive
+	filename := dir + ".tar.gz"
+	w, err := tar.Create(filename, dir)
+	if err != nil {
 		return err
 	}
 
@@ -457,38 +737,64 @@ func (r *Replayer) saveBreakpoint(errormsg []byte) error {
 	if err != nil {
 		return err
 	}
-	filename := filepath.Join(dir, "breakpoint.json")
-	if err := os.WriteFile(filename, jsn, 0644); err != nil {
+	if err := w.AddFile("breakpoint.json", jsn, 0644); err != nil {
 		return err
 	}
-	log.Info(fmt.Sprintf("'%s' written", filename))
 
-	// write error message, if defined (-autobreak was used)
+	// add error message, if defined (-autobreak was used)
 	if errormsg != nil {
-		filename := filepath.Join(dir, "errormsg.json")
-		if err := os.WriteFile(filename, errormsg, 0644); err != nil {
+		if err := w.AddFile("errormsg.json", errormsg, 0644); err != nil {
 			return err
 		}
-		log.Info(fmt.Sprintf("'%s' written", filename))
 	}
 
-	// copy key file
+	// add key file
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return err
 	}
-	filename = r.Breakpoint.AccountID + ".json"
-	path := filepath.Join(home, ".near-credentials", r.Config.NetworkID, filename)
-	if err := file.Copy(path, filepath.Join(dir, filename)); err != nil {
+	keyFile := r.Breakpoint.AccountID + ".json"
+	key, err := os.ReadFile(filepath.Join(home, ".near-credentials", r.Config.NetworkID, keyFile))
+	if err != nil {
 		return err
 	}
-
-	// copy local nearcore directory
-	localDir := filepath.Join(home, ".near", "local")
-	if err := file.CopyDir(localDir, filepath.Join(dir, "local")); err != nil {
+	if err := w.AddFile(keyFile, key, 0600); err != nil {
 		return err
 	}
 
-	// tar everything up
-	return tar.Create(dir)
+	// add last lines of neard log (of neard started with -setup, if any)
+	if r.nearDaemon != nil && r.nearDaemon.LogFile() != "" {
+		lines, err := r.nearDaemon.LogTail(neard.LogTailLines)
+		if err != nil {
+			return err
+		}
+		if err := w.AddFile(NeardLogFilename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	if r.Breakpoint.Mode == BreakpointModeState {
+		// add engine state snapshot
+		jsn, err := json.MarshalIndent(r.Breakpoint.state, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := w.AddFile(StateFilename, jsn, 0644); err != nil {
+			return err
+		}
+	} else {
+		// add local nearcore directory (of neard started with -setup, if any)
+		localDir := filepath.Join(home, ".near", "local")
+		if r.nearDaemon != nil {
+			localDir = r.nearDaemon.LocalDir()
+		}
+		if err := w.AddDir("local", localDir); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("'%s' written", filename))
+	return nil
 }
@@ -0,0 +1,285 @@
+package replayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aurora-is-near/evm-bully/util"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// ReportFilename is the base name of the benchmark report (with extensions
+// .json and .txt) written to the cache directory of a testnet.
+const ReportFilename = "replay-report"
+
+// numSlowest is the number of slowest calls listed in a report.
+const numSlowest = 10
+
+// Call kinds recorded by the benchmark.
+const (
+	callSubmit = "submit"
+	callBatch  = "batch"
+)
+
+// CallStats records the statistics of a single 'submit' call or batch.
+type CallStats struct {
+	Kind        string        `json:"kind"`     // "submit" or "batch"
+	BlockNum    int           `json:"block"`    // block number (of last transaction in batch)
+	TxNum       int           `json:"tx"`       // transaction number (of last transaction in batch)
+	Txs         int           `json:"txs"`      // number of Ethereum transactions
+	Latency     time.Duration `json:"latency"`  // wall-clock latency
+	GasBurnt    uint64        `json:"gasBurnt"` // NEAR gas burnt (including receipts)
+	TokensBurnt *big.Int      `json:"tokensBurnt"`
+	Receipts    int           `json:"receipts"` // number of receipts
+}
+
+// LatencyStats summarizes the latencies of a kind of call.
+type LatencyStats struct {
+	Count int           `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// A Report summarizes the throughput and latency of a replay run.
+type Report struct {
+	Start         time.Time     `json:"start"`
+	Duration      time.Duration `json:"duration"`
+	Txs           int           `json:"txs"` // number of replayed Ethereum transactions
+	GasBurnt      uint64        `json:"gasBurnt"`
+	TokensBurnt   *big.Int      `json:"tokensBurnt"`
+	Receipts      int           `json:"receipts"`
+	TxsPerSecond  float64       `json:"txsPerSecond"`
+	GasPerSecond  float64       `json:"gasPerSecond"`
+	SubmitLatency *LatencyStats `json:"submitLatency,omitempty"`
+	BatchLatency  *LatencyStats `json:"batchLatency,omitempty"`
+	Slowest       []CallStats   `json:"slowest"`
+}
+
+// benchmark collects the statistics of a replay run. It is safe for
+// concurrent use.
+type benchmark struct {
+	mu    sync.Mutex
+	start time.Time
+	calls []CallStats
+}
+
+func newBenchmark() *benchmark {
+	return &benchmark{start: time.Now()}
+}
+
+// parseUint64 parses JSON number v decoded into an interface value.
+func parseUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case json.Number:
+		u, _ := strconv.ParseUint(n.String(), 10, 64)
+		return u
+	case float64:
+		return uint64(n)
+	case string:
+		u, _ := strconv.ParseUint(n, 10, 64)
+		return u
+	}
+	return 0
+}
+
+// addOutcome adds the gas and tokens burnt by the outcome in o to s.
+func (s *CallStats) addOutcome(o interface{}) {
+	m, ok := o.(map[string]interface{})
+	if !ok {
+		return
+	}
+	outcome, ok := m["outcome"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	s.GasBurnt += parseUint64(outcome["gas_burnt"])
+	if tokens, ok := outcome["tokens_burnt"].(string); ok {
+		if t, ok := new(big.Int).SetString(tokens, 10); ok {
+			s.TokensBurnt.Add(s.TokensBurnt, t)
+		}
+	}
+}
+
+// newCallStats returns the statistics of a call of the given kind for tx
+// (the last transaction of a batch) with txResult.
+func newCallStats(
+	kind string,
+	tx *Tx,
+	txs int,
+	latency time.Duration,
+	txResult map[string]interface{},
+) CallStats {
+	s := CallStats{
+		Kind:        kind,
+		BlockNum:    tx.BlockNum,
+		TxNum:       tx.TxNum,
+		Txs:         txs,
+		Latency:     latency,
+		TokensBurnt: new(big.Int),
+	}
+	s.addOutcome(txResult["transaction_outcome"])
+	if receipts, ok := txResult["receipts_outcome"].([]interface{}); ok {
+		for _, r := range receipts {
+			s.addOutcome(r)
+		}
+		s.Receipts = len(receipts)
+	}
+	return s
+}
+
+// record records a call of the given kind for tx (see newCallStats).
+func (b *benchmark) record(
+	kind string,
+	tx *Tx,
+	txs int,
+	latency time.Duration,
+	txResult map[string]interface{},
+) {
+	if b == nil {
+		return
+	}
+	s := newCallStats(kind, tx, txs, latency, txResult)
+	b.mu.Lock()
+	b.calls = append(b.calls, s)
+	b.mu.Unlock()
+}
+
+// percentile returns the p-th percentile of the sorted latencies (nearest
+// rank method).
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func latencyStats(calls []CallStats, kind string) *LatencyStats {
+	var latencies []time.Duration
+	var total time.Duration
+	for _, c := range calls {
+		if c.Kind == kind {
+			latencies = append(latencies, c.Latency)
+			total += c.Latency
+		}
+	}
+	if len(latencies) == 0 {
+		return nil
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return &LatencyStats{
+		Count: len(latencies),
+		Mean:  total / time.Duration(len(latencies)),
+		P50:   percentile(latencies, 50),
+		P90:   percentile(latencies, 90),
+		P95:   percentile(latencies, 95),
+		P99:   percentile(latencies, 99),
+		Max:   latencies[len(latencies)-1],
+	}
+}
+
+// report returns the report for all calls recorded until end.
+func (b *benchmark) report(end time.Time) *Report {
+	b.mu.Lock()
+	calls := make([]CallStats, len(b.calls))
+	copy(calls, b.calls)
+	b.mu.Unlock()
+
+	rep := &Report{
+		Start:       b.start,
+		Duration:    end.Sub(b.start),
+		TokensBurnt: new(big.Int),
+	}
+	for _, c := range calls {
+		rep.Txs += c.Txs
+		rep.GasBurnt += c.GasBurnt
+		rep.TokensBurnt.Add(rep.TokensBurnt, c.TokensBurnt)
+		rep.Receipts += c.Receipts
+	}
+	if secs := rep.Duration.Seconds(); secs > 0 {
+		rep.TxsPerSecond = float64(rep.Txs) / secs
+		rep.GasPerSecond = float64(rep.GasBurnt) / secs
+	}
+	rep.SubmitLatency = latencyStats(calls, callSubmit)
+	rep.BatchLatency = latencyStats(calls, callBatch)
+	sort.SliceStable(calls, func(i, j int) bool { return calls[i].Latency > calls[j].Latency })
+	if len(calls) > numSlowest {
+		calls = calls[:numSlowest]
+	}
+	rep.Slowest = calls
+	return rep
+}
+
+func writeLatencyStats(w io.Writer, name string, s *LatencyStats) {
+	if s == nil {
+		return
+	}
+	fmt.Fprintf(w, "%s latency (%d calls):\n", name, s.Count)
+	fmt.Fprintf(w, "  mean: %s\n", s.Mean)
+	fmt.Fprintf(w, "  p50:  %s\n", s.P50)
+	fmt.Fprintf(w, "  p90:  %s\n", s.P90)
+	fmt.Fprintf(w, "  p95:  %s\n", s.P95)
+	fmt.Fprintf(w, "  p99:  %s\n", s.P99)
+	fmt.Fprintf(w, "  max:  %s\n", s.Max)
+}
+
+// WriteText writes the human-readable report to w.
+func (rep *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "start: %s\n", rep.Start.Format(time.RFC3339))
+	fmt.Fprintf(w, "duration: %s\n", rep.Duration)
+	fmt.Fprintf(w, "transactions: %d\n", rep.Txs)
+	fmt.Fprintf(w, "gas burnt: %d\n", rep.GasBurnt)
+	fmt.Fprintf(w, "tokens burnt: %s\n", rep.TokensBurnt)
+	fmt.Fprintf(w, "receipts: %d\n", rep.Receipts)
+	fmt.Fprintf(w, "tx/s: %.2f\n", rep.TxsPerSecond)
+	fmt.Fprintf(w, "gas/s: %.0f\n", rep.GasPerSecond)
+	writeLatencyStats(w, "submit", rep.SubmitLatency)
+	writeLatencyStats(w, "batch", rep.BatchLatency)
+	if len(rep.Slowest) > 0 {
+		fmt.Fprintln(w, "slowest:")
+		for _, c := range rep.Slowest {
+			fmt.Fprintf(w, "  %s block=%d tx=%d latency=%s gas=%d\n",
+				c.Kind, c.BlockNum, c.TxNum, c.Latency, c.GasBurnt)
+		}
+	}
+}
+
+// writeReport writes the report as JSON and text into the cache directory of
+// r.Testnet and prints it to stdout.
+func (r *Replayer) writeReport(rep *Report) error {
+	cacheDir, err := util.DetermineCacheDir(r.Testnet)
+	if err != nil {
+		return err
+	}
+	jsn, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(cacheDir, ReportFilename+".json")
+	if err := os.WriteFile(filename, jsn, 0644); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("'%s' written", filename))
+	var txt strings.Builder
+	rep.WriteText(&txt)
+	filename = filepath.Join(cacheDir, ReportFilename+".txt")
+	if err := os.WriteFile(filename, []byte(txt.String()), 0644); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("'%s' written", filename))
+	fmt.Print(txt.String())
+	return nil
+}
@@ -0,0 +1,109 @@
+package replayer
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aurora-is-near/evm-bully/util/aurora"
+	"github.com/aurora-is-near/evm-bully/util/nearvm"
+)
+
+const testTxResult = `{
+  "status": {"SuccessValue": ""},
+  "transaction": {"hash": "9BTa"},
+  "transaction_outcome": {"outcome": {"gas_burnt": 2428000000000, "tokens_burnt": "242800000000000000000"}},
+  "receipts_outcome": [
+    {"outcome": {"gas_burnt": 5000000000000, "tokens_burnt": "500000000000000000000"}},
+    {"outcome": {"gas_burnt": 223182562500, "tokens_burnt": "0"}}
+  ]
+}`
+
+func decodeTestTxResult(t *testing.T) map[string]interface{} {
+	var txResult map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(testTxResult))
+	dec.UseNumber()
+	if err := dec.Decode(&txResult); err != nil {
+		t.Fatal(err)
+	}
+	return txResult
+}
+
+func TestNewCallStats(t *testing.T) {
+	tx := &Tx{BlockNum: 7, TxNum: 2}
+	s := newCallStats(callSubmit, tx, 1, time.Second, decodeTestTxResult(t))
+	if s.GasBurnt != 2428000000000+5000000000000+223182562500 {
+		t.Errorf("gas burnt: %d", s.GasBurnt)
+	}
+	if s.TokensBurnt.String() != "742800000000000000000" {
+		t.Errorf("tokens burnt: %s", s.TokensBurnt)
+	}
+	if s.Receipts != 2 {
+		t.Errorf("receipts: %d", s.Receipts)
+	}
+	if s.BlockNum != 7 || s.TxNum != 2 {
+		t.Errorf("block/tx: %d/%d", s.BlockNum, s.TxNum)
+	}
+}
+
+func TestNewCallStatsWasm(t *testing.T) {
+	rt := nearvm.New()
+	rt.AddAccount(testEngine)
+	a := rt.Account(testEngine)
+	if err := aurora.Upgrade(a, testEngine, writeTestEngine(t, false)); err != nil {
+		t.Fatal(err)
+	}
+	txResult, err := a.FunctionCall(testEngine, "submit", []byte("tx"), 300000000000000, *big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newCallStats(callSubmit, &Tx{}, 1, time.Second, txResult)
+	if s.GasBurnt <= nearvm.TxGasBurnt {
+		t.Errorf("gas burnt: %d", s.GasBurnt)
+	}
+	tokens := new(big.Int).Mul(new(big.Int).SetUint64(s.GasBurnt), big.NewInt(nearvm.GasPrice))
+	if s.TokensBurnt.Cmp(tokens) != 0 {
+		t.Errorf("tokens burnt: %s, expected %s", s.TokensBurnt, tokens)
+	}
+	if s.Receipts != 1 {
+		t.Errorf("receipts: %d", s.Receipts)
+	}
+}
+
+func TestReport(t *testing.T) {
+	txResult := decodeTestTxResult(t)
+	b := newBenchmark()
+	for i := 1; i <= 100; i++ {
+		tx := &Tx{BlockNum: i, TxNum: 0}
+		b.record(callSubmit, tx, 1, time.Duration(i)*time.Millisecond, txResult)
+	}
+	b.record(callBatch, &Tx{BlockNum: 101, TxNum: 9}, 10, time.Second, txResult)
+	rep := b.report(b.start.Add(10 * time.Second))
+	if rep.Txs != 110 {
+		t.Errorf("txs: %d", rep.Txs)
+	}
+	if rep.TxsPerSecond != 11 {
+		t.Errorf("tx/s: %f", rep.TxsPerSecond)
+	}
+	s := rep.SubmitLatency
+	if s.Count != 100 || s.P50 != 50*time.Millisecond || s.P90 != 90*time.Millisecond ||
+		s.P99 != 99*time.Millisecond || s.Max != 100*time.Millisecond {
+		t.Errorf("submit latency: %+v", s)
+	}
+	if rep.BatchLatency.Count != 1 || rep.BatchLatency.P50 != time.Second {
+		t.Errorf("batch latency: %+v", rep.BatchLatency)
+	}
+	if len(rep.Slowest) != numSlowest {
+		t.Fatalf("slowest: %d", len(rep.Slowest))
+	}
+	if rep.Slowest[0].Kind != callBatch || rep.Slowest[1].BlockNum != 100 {
+		t.Errorf("slowest: %+v", rep.Slowest[:2])
+	}
+	var txt strings.Builder
+	rep.WriteText(&txt)
+	if !strings.Contains(txt.String(), "tx/s: 11.00") {
+		t.Errorf("text report:\n%s", txt.String())
+	}
+}
@@ -0,0 +1,223 @@
+package replayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/aurora-is-near/evm-bully/replayer/neard"
+	"github.com/aurora-is-near/go-jsonrpc/v3"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Breakpoint modes.
+const (
+	BreakpointModeFull  = "full"  // copy of the complete neard data directory
+	BreakpointModeState = "state" // snapshot of the engine account state
+)
+
+// StateFilename is the name of the engine state snapshot in a breakpoint.
+const StateFilename = "state.json"
+
+// State records in the format of the "records" of a NEAR genesis file.
+type (
+	stateRecord struct {
+		Account   *accountRecord   `json:"Account,omitempty"`
+		Contract  *contractRecord  `json:"Contract,omitempty"`
+		Data      *dataRecord      `json:"Data,omitempty"`
+		AccessKey *accessKeyRecord `json:"AccessKey,omitempty"`
+	}
+
+	accountRecord struct {
+		AccountID string      `json:"account_id"`
+		Account   accountData `json:"account"`
+	}
+
+	accountData struct {
+		Amount       string `json:"amount"`
+		Locked       string `json:"locked"`
+		CodeHash     string `json:"code_hash"`
+		StorageUsage uint64 `json:"storage_usage"`
+	}
+
+	contractRecord struct {
+		AccountID string `json:"account_id"`
+		Code      string `json:"code"` // base64 encoded
+	}
+
+	dataRecord struct {
+		AccountID string `json:"account_id"`
+		DataKey   string `json:"data_key"` // base64 encoded
+		Value     string `json:"value"`    // base64 encoded
+	}
+
+	accessKeyRecord struct {
+		AccountID string        `json:"account_id"`
+		PublicKey string        `json:"public_key"`
+		AccessKey accessKeyData `json:"access_key"`
+	}
+
+	accessKeyData struct {
+		Nonce      uint64 `json:"nonce"`
+		Permission string `json:"permission"`
+	}
+)
+
+// engineState is a snapshot of the state of the engine account.
+type engineState struct {
+	BlockHash string         `json:"block-hash"` // block the state was exported at
+	Records   []*stateRecord `json:"records"`
+}
+
+// keyFile is the relevant part of a NEAR credentials file.
+type keyFile struct {
+	AccountID string `json:"account_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// query calls the RPC method 'query' of client with params and decodes the
+// result into out.
+func query(client jsonrpc.RPCClient, out interface{}, params map[string]interface{}) error {
+	if err := client.CallFor(out, "query", params); err != nil {
+		return fmt.Errorf("replayer: %s query: %w", params["request_type"], err)
+	}
+	return nil
+}
+
+// exportEngineState exports the account, contract code, and contract state of
+// accountID via the NEAR RPC at nodeURL. All queries refer to the same
+// block. The state of large contracts can only be exported, if the limit
+// 'trie_viewer_state_size_limit' of the neard config is raised.
+func exportEngineState(nodeURL, accountID string) (*engineState, error) {
+	log.Info(fmt.Sprintf("export state of '%s'", accountID))
+	client := jsonrpc.NewClient(nodeURL)
+
+	var account struct {
+		accountData
+		BlockHash string `json:"block_hash"`
+	}
+	err := query(client, &account, map[string]interface{}{
+		"request_type": "view_account",
+		"finality":     "optimistic",
+		"account_id":   accountID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &engineState{
+		BlockHash: account.BlockHash,
+		Records: []*stateRecord{
+			{Account: &accountRecord{AccountID: accountID, Account: account.accountData}},
+		},
+	}
+
+	var code struct {
+		CodeBase64 string `json:"code_base64"`
+	}
+	err = query(client, &code, map[string]interface{}{
+		"request_type": "view_code",
+		"block_id":     s.BlockHash,
+		"account_id":   accountID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Records = append(s.Records, &stateRecord{
+		Contract: &contractRecord{AccountID: accountID, Code: code.CodeBase64},
+	})
+
+	var state struct {
+		Values []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"values"`
+	}
+	err = query(client, &state, map[string]interface{}{
+		"request_type":  "view_state",
+		"block_id":      s.BlockHash,
+		"account_id":    accountID,
+		"prefix_base64": "",
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range state.Values {
+		s.Records = append(s.Records, &stateRecord{
+			Data: &dataRecord{AccountID: accountID, DataKey: v.Key, Value: v.Value},
+		})
+	}
+	log.Info(fmt.Sprintf("exported %d state records at block %s", len(state.Values), s.BlockHash))
+	return s, nil
+}
+
+// genesisRecords returns the state records of s together with a full access
+// key record for key and the total amount of tokens of all accounts.
+func (s *engineState) genesisRecords(key *keyFile) ([]json.RawMessage, *big.Int, error) {
+	records := make([]*stateRecord, len(s.Records), len(s.Records)+1)
+	copy(records, s.Records)
+	records = append(records, &stateRecord{
+		AccessKey: &accessKeyRecord{
+			AccountID: key.AccountID,
+			PublicKey: key.PublicKey,
+			AccessKey: accessKeyData{Permission: "FullAccess"},
+		},
+	})
+	supply := new(big.Int)
+	raw := make([]json.RawMessage, 0, len(records))
+	for _, r := range records {
+		if r.Account != nil {
+			for _, v := range []string{r.Account.Account.Amount, r.Account.Account.Locked} {
+				n, ok := new(big.Int).SetString(v, 10)
+				if !ok {
+					return nil, nil, fmt.Errorf("replayer: invalid balance of '%s': %s",
+						r.Account.AccountID, v)
+				}
+				supply.Add(supply, n)
+			}
+		}
+		jsn, err := json.Marshal(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw = append(raw, jsn)
+	}
+	return raw, supply, nil
+}
+
+// restoreEngineState sets up fresh local data for nearDaemon which contains the
+// engine state snapshot from breakpointDir and the key of accountID.
+func restoreEngineState(nearDaemon *neard.NEARDaemon, breakpointDir, accountID string) error {
+	var s engineState
+	if err := readJSONFile(filepath.Join(breakpointDir, StateFilename), &s); err != nil {
+		return err
+	}
+	var key keyFile
+	if err := readJSONFile(filepath.Join(breakpointDir, accountID+".json"), &key); err != nil {
+		return err
+	}
+	if key.AccountID != accountID {
+		return fmt.Errorf("replayer: key file is for account '%s', expected '%s'",
+			key.AccountID, accountID)
+	}
+	records, supply, err := s.genesisRecords(&key)
+	if err != nil {
+		return err
+	}
+	if err := nearDaemon.SetupLocalData(); err != nil {
+		return err
+	}
+	return nearDaemon.AddGenesisRecords(records, supply)
+}
+
+func readJSONFile(filename string, v interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("replayer: cannot parse '%s': %w", filename, err)
+	}
+	return nil
+}
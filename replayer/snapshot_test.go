@@ -0,0 +1,97 @@
+package replayer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testBlockHash = "7nsuuitwS7xcdGnD9JgrE22cRB2vf2VS4yh1N9S71F4d"
+
+// testStateServer returns a NEAR RPC server which answers the queries of
+// exportEngineState for account "aurora.test.near".
+func testStateServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int                    `json:"id"`
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		if req.Params["account_id"] != "aurora.test.near" {
+			t.Errorf("unexpected account_id %v", req.Params["account_id"])
+		}
+		if req.Params["request_type"] != "view_account" && req.Params["block_id"] != testBlockHash {
+			t.Errorf("%v: unexpected block_id %v", req.Params["request_type"], req.Params["block_id"])
+		}
+		var result interface{}
+		switch req.Params["request_type"] {
+		case "view_account":
+			result = map[string]interface{}{
+				"amount":        "1000",
+				"locked":        "0",
+				"code_hash":     "CodeHash",
+				"storage_usage": 182,
+				"block_hash":    testBlockHash,
+			}
+		case "view_code":
+			result = map[string]interface{}{"code_base64": "AGFzbQ==", "hash": "CodeHash"}
+		case "view_state":
+			result = map[string]interface{}{
+				"values": []map[string]interface{}{
+					{"key": "a2V5MQ==", "value": "dmFsdWUx", "proof": []string{}},
+					{"key": "a2V5Mg==", "value": "dmFsdWUy", "proof": []string{}},
+				},
+				"proof": []string{},
+			}
+		default:
+			t.Errorf("unexpected request type %v", req.Params["request_type"])
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"result":  result,
+		})
+	}))
+}
+
+func TestEngineState(t *testing.T) {
+	srv := testStateServer(t)
+	defer srv.Close()
+	s, err := exportEngineState(srv.URL, "aurora.test.near")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.BlockHash != testBlockHash {
+		t.Errorf("BlockHash = %s, expected %s", s.BlockHash, testBlockHash)
+	}
+	records, supply, err := s.genesisRecords(&keyFile{
+		AccountID: "aurora.test.near",
+		PublicKey: "ed25519:6E8sCci9badyRkXb3JoRpBj5p8C6Tw41ELDZoiihKEtp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if supply.String() != "1000" {
+		t.Errorf("supply = %s, expected 1000", supply)
+	}
+	expected := []string{
+		`{"Account":{"account_id":"aurora.test.near","account":{"amount":"1000","locked":"0","code_hash":"CodeHash","storage_usage":182}}}`,
+		`{"Contract":{"account_id":"aurora.test.near","code":"AGFzbQ=="}}`,
+		`{"Data":{"account_id":"aurora.test.near","data_key":"a2V5MQ==","value":"dmFsdWUx"}}`,
+		`{"Data":{"account_id":"aurora.test.near","data_key":"a2V5Mg==","value":"dmFsdWUy"}}`,
+		`{"AccessKey":{"account_id":"aurora.test.near","public_key":"ed25519:6E8sCci9badyRkXb3JoRpBj5p8C6Tw41ELDZoiihKEtp","access_key":{"nonce":0,"permission":"FullAccess"}}}`,
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("got %d records, expected %d", len(records), len(expected))
+	}
+	for i, r := range records {
+		if string(r) != expected[i] {
+			t.Errorf("record %d = %s, expected %s", i, r, expected[i])
+		}
+	}
+}
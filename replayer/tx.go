@@ -10,5 +10,12 @@ type Tx struct {
 	MethodName string          // the Aurora Engine method name to call
 	Args       []byte          // the argument to call the method with
 	EthTx      *db.Transaction // pointer to original Ethereum transaction (for 'submit')
+	Skip       *SkipRange      // range of skipped blocks (for comments)
 	Error      error           // error during transaction construction
 }
+
+// SkipRange defines the range of blocks [First;Last] skipped during replay.
+type SkipRange struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
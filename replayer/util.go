@@ -34,7 +34,7 @@ func bigIntToUint64(b *big.Int) (uint64, error) {
 func bigIntToRawU256(b *big.Int) (RawU256, error) {
 	var res RawU256
 	bytes := b.Bytes()
-	if len(bytes) > 32 {
+	if b.Sign() < 0 || len(bytes) > 32 {
 		return res,
 			fmt.Errorf("replayer: big.Int cannot be represented as RawU256: %s",
 				b.String())
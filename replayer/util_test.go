@@ -31,3 +31,27 @@ func TestBigIntToRawU256Overflow(t *testing.T) {
 		t.Fatalf("bigIntToRawU256(2^300) has to return error, but didn't")
 	}
 }
+
+func TestBigIntToRawU256Negative(t *testing.T) {
+	_, err := bigIntToRawU256(big.NewInt(-1))
+	if err == nil {
+		t.Fatalf("bigIntToRawU256(-1) has to return error, but didn't")
+	}
+}
+
+func TestBigIntToRawU256Max(t *testing.T) {
+	// 2^256-1 is the largest value which fits into RawU256
+	input := big.NewInt(0)
+	input.Exp(big.NewInt(2), big.NewInt(256), nil)
+	input.Sub(input, big.NewInt(1))
+
+	output, err := bigIntToRawU256(input)
+	if err != nil {
+		t.Fatalf("bigIntToRawU256(2^256-1) returns error, but it shouldn't")
+	}
+	for i, b := range output {
+		if b != 0xff {
+			t.Fatalf("bigIntToRawU256(2^256-1)[%d] is %d, but expected value is 255", i, b)
+		}
+	}
+}
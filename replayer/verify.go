@@ -0,0 +1,225 @@
+package replayer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/near/borsh-go"
+)
+
+// submitResultVersion is the version byte prepended to versioned SubmitResults
+// by the Aurora Engine. It is larger than the number of TransactionStatus
+// variants, which allows to distinguish versioned from legacy results.
+const submitResultVersion = 7
+
+// ErrDivergence is returned if the result of a 'submit' call differs from the
+// original Ethereum receipt.
+var ErrDivergence = errors.New("replayer: result diverges from Ethereum receipt")
+
+// TransactionStatus encodes the status of an Aurora Engine transaction.
+type TransactionStatus struct {
+	Enum        borsh.Enum `borsh_enum:"true"`
+	Succeed     []byte
+	Revert      []byte
+	OutOfGas    struct{}
+	OutOfFund   struct{}
+	OutOfOffset struct{}
+	CallTooDeep struct{}
+}
+
+// Transaction status variants.
+const (
+	StatusSucceed borsh.Enum = iota
+	StatusRevert
+	StatusOutOfGas
+	StatusOutOfFund
+	StatusOutOfOffset
+	StatusCallTooDeep
+)
+
+var statusNames = []string{
+	"Succeed",
+	"Revert",
+	"OutOfGas",
+	"OutOfFund",
+	"OutOfOffset",
+	"CallTooDeep",
+}
+
+// String returns the name of the transaction status.
+func (s *TransactionStatus) String() string {
+	if int(s.Enum) < len(statusNames) {
+		return statusNames[s.Enum]
+	}
+	return fmt.Sprintf("Unknown(%d)", s.Enum)
+}
+
+// ResultLog encodes a log entry returned by the Aurora Engine. The address is
+// zero for legacy results.
+type ResultLog struct {
+	Address RawAddress
+	Topics  []RawU256
+	Data    []byte
+}
+
+// legacyResultLog encodes a log entry of a legacy (unversioned) SubmitResult,
+// older engine builds do not return the address.
+type legacyResultLog struct {
+	Topics []RawU256
+	Data   []byte
+}
+
+// SubmitResult encodes the result returned by the Aurora Engine 'submit'
+// function.
+type SubmitResult struct {
+	Status  TransactionStatus
+	GasUsed uint64
+	Logs    []ResultLog
+}
+
+type versionedSubmitResult struct {
+	Version uint8
+	SubmitResult
+}
+
+type legacySubmitResult struct {
+	Status  TransactionStatus
+	GasUsed uint64
+	Logs    []legacyResultLog
+}
+
+// decodeSubmitResult decodes the borsh encoded SubmitResult in data (versioned
+// or legacy).
+func decodeSubmitResult(data []byte) (*SubmitResult, error) {
+	if len(data) == 0 {
+		return nil, errors.New("replayer: empty SubmitResult")
+	}
+	if data[0] == submitResultVersion {
+		var res versionedSubmitResult
+		if err := borsh.Deserialize(&res, data); err != nil {
+			return nil, fmt.Errorf("replayer: cannot decode SubmitResult: %s", err)
+		}
+		return &res.SubmitResult, nil
+	}
+	var legacy legacySubmitResult
+	if err := borsh.Deserialize(&legacy, data); err != nil {
+		return nil, fmt.Errorf("replayer: cannot decode SubmitResult: %s", err)
+	}
+	res := &SubmitResult{Status: legacy.Status, GasUsed: legacy.GasUsed}
+	for _, l := range legacy.Logs {
+		res.Logs = append(res.Logs, ResultLog{Topics: l.Topics, Data: l.Data})
+	}
+	return res, nil
+}
+
+// getSubmitResult extracts the SubmitResult from the NEAR txResult of a
+// 'submit' call.
+func getSubmitResult(txResult map[string]interface{}) (*SubmitResult, error) {
+	status, ok := txResult["status"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("replayer: transaction result has no status")
+	}
+	enc, ok := status["SuccessValue"].(string)
+	if !ok {
+		return nil, errors.New("replayer: transaction result has no success value")
+	}
+	data, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSubmitResult(data)
+}
+
+// A Divergence describes a difference between the result of an Aurora
+// 'submit' call and the original Ethereum receipt.
+type Divergence struct {
+	Field    string `json:"field"`
+	Ethereum string `json:"ethereum"`
+	Aurora   string `json:"aurora"`
+}
+
+// compareReceipt compares the Aurora SubmitResult res with the Ethereum
+// receipt and returns all divergences.
+func compareReceipt(receipt *db.Receipt, res *SubmitResult) []Divergence {
+	var divs []Divergence
+	diverge := func(field string, ethereum, aurora interface{}) {
+		divs = append(divs, Divergence{
+			Field:    field,
+			Ethereum: fmt.Sprint(ethereum),
+			Aurora:   fmt.Sprint(aurora),
+		})
+	}
+
+	// status (only defined for post-Byzantium receipts)
+	if len(receipt.PostState) == 0 {
+		succeeded := res.Status.Enum == StatusSucceed
+		if (receipt.Status == 1) != succeeded {
+			diverge("status", receipt.Status, res.Status.String())
+		}
+	}
+
+	// gas
+	if receipt.GasUsed != res.GasUsed {
+		diverge("gasUsed", receipt.GasUsed, res.GasUsed)
+	}
+
+	// logs
+	if len(receipt.Logs) != len(res.Logs) {
+		diverge("logs", len(receipt.Logs), len(res.Logs))
+		return divs
+	}
+	for i, l := range receipt.Logs {
+		rl := res.Logs[i]
+		field := fmt.Sprintf("logs[%d]", i)
+		if len(l.Topics) != len(rl.Topics) {
+			diverge(field+".topics", len(l.Topics), len(rl.Topics))
+		} else {
+			for j, topic := range l.Topics {
+				if topic != common.Hash(rl.Topics[j]) {
+					diverge(fmt.Sprintf("%s.topics[%d]", field, j),
+						topic.Hex(), "0x"+hex.EncodeToString(rl.Topics[j][:]))
+				}
+			}
+		}
+		if !bytes.Equal(l.Data, rl.Data) {
+			diverge(field+".data", "0x"+hex.EncodeToString(l.Data),
+				"0x"+hex.EncodeToString(rl.Data))
+		}
+	}
+	return divs
+}
+
+// verifyTxResult compares the result of the 'submit' call for tx with the
+// Ethereum receipt stored in the dump. If they diverge, the divergences are
+// returned JSON encoded together with ErrDivergence.
+func verifyTxResult(
+	tx *db.Transaction,
+	txResult map[string]interface{},
+) ([]byte, error) {
+	if tx.Receipt == nil {
+		// dump without receipts, nothing to compare
+		return nil, nil
+	}
+	res, err := getSubmitResult(txResult)
+	if err != nil {
+		return nil, err
+	}
+	divs := compareReceipt(tx.Receipt, res)
+	if len(divs) == 0 {
+		return nil, nil
+	}
+	jsn, err := json.MarshalIndent(divs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("divergence:")
+	fmt.Println(string(jsn))
+	showTx(tx)
+	return jsn, ErrDivergence
+}
@@ -0,0 +1,146 @@
+package replayer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/db"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testSubmitResult() *SubmitResult {
+	return &SubmitResult{
+		Status: TransactionStatus{
+			Enum:    StatusSucceed,
+			Succeed: []byte{1, 2, 3},
+		},
+		GasUsed: 21000,
+		Logs: []ResultLog{
+			{
+				Address: RawAddress{1},
+				Topics:  []RawU256{{2}},
+				Data:    []byte{4, 5},
+			},
+		},
+	}
+}
+
+func testReceipt() *db.Receipt {
+	return &db.Receipt{
+		Status:  1,
+		GasUsed: 21000,
+		Logs: []*db.Log{
+			{
+				Address: common.Address{1},
+				Topics:  []common.Hash{{2}},
+				Data:    []byte{4, 5},
+			},
+		},
+	}
+}
+
+// encodeSubmitResult borsh encodes res by hand (borsh-go doesn't serialize
+// non-struct enum variants). Legacy results have no log addresses.
+func encodeSubmitResult(res *SubmitResult, version bool) []byte {
+	var buf bytes.Buffer
+	writeBytes := func(b []byte) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(b)))
+		buf.Write(b)
+	}
+	if version {
+		buf.WriteByte(submitResultVersion)
+	}
+	buf.WriteByte(byte(res.Status.Enum))
+	switch res.Status.Enum {
+	case StatusSucceed:
+		writeBytes(res.Status.Succeed)
+	case StatusRevert:
+		writeBytes(res.Status.Revert)
+	}
+	binary.Write(&buf, binary.LittleEndian, res.GasUsed)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(res.Logs)))
+	for _, l := range res.Logs {
+		if version {
+			buf.Write(l.Address[:])
+		}
+		binary.Write(&buf, binary.LittleEndian, uint32(len(l.Topics)))
+		for _, topic := range l.Topics {
+			buf.Write(topic[:])
+		}
+		writeBytes(l.Data)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeSubmitResult(t *testing.T) {
+	res := testSubmitResult()
+	legacy := encodeSubmitResult(res, false)
+	versioned := encodeSubmitResult(res, true)
+	for _, data := range [][]byte{legacy, versioned} {
+		txResult := map[string]interface{}{
+			"status": map[string]interface{}{
+				"SuccessValue": base64.StdEncoding.EncodeToString(data),
+			},
+		}
+		dec, err := getSubmitResult(txResult)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec.Status.Enum != StatusSucceed || !bytes.Equal(dec.Status.Succeed, []byte{1, 2, 3}) ||
+			dec.GasUsed != 21000 || len(dec.Logs) != 1 {
+			t.Fatalf("unexpected SubmitResult: %+v", dec)
+		}
+		if divs := compareReceipt(testReceipt(), dec); len(divs) != 0 {
+			t.Fatalf("unexpected divergences: %v", divs)
+		}
+	}
+}
+
+func TestDecodeLegacySubmitResult(t *testing.T) {
+	// result of an engine build without versioned results: Succeed([1 2 3]),
+	// 21000 gas, one log with a topic and data (but no address)
+	data, err := hex.DecodeString("00" + "03000000" + "010203" +
+		"0852000000000000" + "01000000" + "01000000" +
+		"0200000000000000000000000000000000000000000000000000000000000000" +
+		"02000000" + "0405")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := decodeSubmitResult(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GasUsed != 21000 || len(res.Logs) != 1 || res.Logs[0].Address != (RawAddress{}) {
+		t.Fatalf("decodeSubmitResult() = %+v, expected legacy result", res)
+	}
+	if divs := compareReceipt(testReceipt(), res); len(divs) != 0 {
+		t.Errorf("compareReceipt() = %v, expected no divergences", divs)
+	}
+}
+
+func TestCompareReceipt(t *testing.T) {
+	res := testSubmitResult()
+	res.Status = TransactionStatus{Enum: StatusRevert}
+	res.GasUsed = 22000
+	res.Logs[0].Topics[0] = RawU256{3}
+	divs := compareReceipt(testReceipt(), res)
+	if len(divs) != 3 {
+		t.Fatalf("expected 3 divergences, got %v", divs)
+	}
+	for i, field := range []string{"status", "gasUsed", "logs[0].topics[0]"} {
+		if divs[i].Field != field {
+			t.Errorf("divergence %d: field %s, expected %s", i, divs[i].Field, field)
+		}
+	}
+
+	// status of pre-Byzantium receipts is undefined
+	receipt := testReceipt()
+	receipt.PostState = []byte{1}
+	receipt.Status = 0
+	if divs := compareReceipt(receipt, testSubmitResult()); len(divs) != 0 {
+		t.Fatalf("unexpected divergences: %v", divs)
+	}
+}
@@ -10,4 +10,4 @@ ACCOUNT=evm-bully.testnet
 
 near delete evm.$ACCOUNT $ACCOUNT
 near create-account evm.$ACCOUNT --master-account=$ACCOUNT --initial-balance=100
-env NEAR_ENV=testnet aurora install --chain 1313161556 --engine evm.$ACCOUNT --signer evm.$ACCOUNT --owner evm.$ACCOUNT $1
+env NEAR_ENV=testnet evm-bully install -chainid 1313161556 evm.$ACCOUNT $1
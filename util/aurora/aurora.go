@@ -1,46 +1,147 @@
-// Package aurora contains wrappers around some Aurora CLI commands.
+// Package aurora deploys and initializes the Aurora engine (via NEAR RPC or
+// in an embedded runtime).
 package aurora
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
-	"os/exec"
-	"strconv"
-	"strings"
 
+	"github.com/aurora-is-near/near-api-go"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/near/borsh-go"
 )
 
-var auroraCliPath string
+// Defaults used for initializing the engine (the same as in aurora-cli).
+const (
+	DefaultBridgeProver       = "prover.bridge.near"
+	DefaultUpgradeDelayBlocks = 1
+	DefaultEthCustodian       = "0000000000000000000000000000000000000000" // hex without 0x
+)
+
+// initGas is the gas attached to each of the initialization calls.
+const initGas = 100000000000000 // 100 TGas
 
-func init() {
-	auroraCliPath = "aurora"
+// NewCallArgs encodes the arguments for the engine method 'new'.
+type NewCallArgs struct {
+	ChainID            [32]byte // big-endian
+	OwnerID            string
+	BridgeProverID     string
+	UpgradeDelayBlocks uint64
 }
 
-// SetAuroraCliPath sets aurora-cli path (or alias)
-func SetAuroraCliPath(path string) {
-	auroraCliPath = path
+// InitCallArgs encodes the arguments for the engine method 'new_eth_connector'.
+type InitCallArgs struct {
+	ProverAccount       string
+	EthCustodianAddress string
 }
 
-// Install the EVM contract with given accountID owner and chainID.
-func Install(accountID string, chainID uint8, contract string) error {
-	args := []string{
-		"install",
-		"--chain", strconv.FormatUint(uint64(chainID), 10),
-		"--engine", accountID,
-		"--signer", accountID,
-		"--owner", accountID,
-		contract,
+// newCallArgs returns the encoded arguments of 'new' for the given chainID
+// and ownerID.
+func newCallArgs(chainID *big.Int, ownerID string) ([]byte, error) {
+	args := NewCallArgs{
+		OwnerID:            ownerID,
+		BridgeProverID:     DefaultBridgeProver,
+		UpgradeDelayBlocks: DefaultUpgradeDelayBlocks,
 	}
-	log.Info(fmt.Sprintf("$ %v %v", auroraCliPath, strings.Join(args, " ")))
-	cmd := exec.Command(auroraCliPath, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	b := chainID.Bytes()
+	if chainID.Sign() < 0 || len(b) > len(args.ChainID) {
+		return nil, fmt.Errorf("aurora: invalid chain ID: %s", chainID)
+	}
+	copy(args.ChainID[len(args.ChainID)-len(b):], b)
+	return borsh.Serialize(args)
 }
 
-// Upgrade the EVM contract with given accountID owner and ChainID.
-func Upgrade(accountID string, chainID uint8, contract string) error {
-	// `aurora upgrade` is an alias for `aurora install`
-	return Install(accountID, chainID, contract)
+// initCallArgs returns the encoded arguments of 'new_eth_connector'.
+func initCallArgs() ([]byte, error) {
+	return borsh.Serialize(InitCallArgs{
+		ProverAccount:       DefaultBridgeProver,
+		EthCustodianAddress: DefaultEthCustodian,
+	})
+}
+
+func deployAction(contract string) (near.Action, error) {
+	code, err := os.ReadFile(contract)
+	if err != nil {
+		return near.Action{}, err
+	}
+	if len(code) == 0 {
+		return near.Action{}, errors.New("aurora: contract file is empty")
+	}
+	return near.Action{
+		Enum:           1,
+		DeployContract: near.DeployContract{Code: code},
+	}, nil
+}
+
+func functionCallAction(methodName string, args []byte) near.Action {
+	return near.Action{
+		Enum: 2,
+		FunctionCall: near.FunctionCall{
+			MethodName: methodName,
+			Args:       args,
+			Gas:        initGas,
+		},
+	}
+}
+
+// Signer signs and sends transactions. It is implemented by *near.Account
+// and by accounts of an embedded runtime.
+type Signer interface {
+	SignAndSendTransaction(receiverID string, actions []near.Action) (map[string]interface{}, error)
+}
+
+// send sends the actions as a single transaction from a to accountID and
+// returns an error if the transaction failed.
+func send(a Signer, accountID string, actions []near.Action) error {
+	txResult, err := a.SignAndSendTransaction(accountID, actions)
+	if err != nil {
+		return err
+	}
+	if _, err := near.GetTransactionLastResult(txResult); err != nil {
+		return fmt.Errorf("aurora: %w", err)
+	}
+	return nil
+}
+
+// Install deploys the EVM contract to accountID and initializes it with the
+// given chainID and ownerID as owner (accountID, if ownerID is empty). The
+// account a must be the account accountID. Deployment and initialization are
+// performed in a single transaction.
+func Install(a Signer, accountID, ownerID string, chainID *big.Int, contract string) error {
+	if ownerID == "" {
+		ownerID = accountID
+	}
+	log.Info(fmt.Sprintf("install '%s' to '%s' (chain ID %s, owner '%s')", contract, accountID,
+		chainID, ownerID))
+	deploy, err := deployAction(contract)
+	if err != nil {
+		return err
+	}
+	newArgs, err := newCallArgs(chainID, ownerID)
+	if err != nil {
+		return err
+	}
+	initArgs, err := initCallArgs()
+	if err != nil {
+		return err
+	}
+	return send(a, accountID, []near.Action{
+		deploy,
+		functionCallAction("new", newArgs),
+		functionCallAction("new_eth_connector", initArgs),
+	})
+}
+
+// Upgrade deploys the EVM contract to accountID, which has been installed
+// before. The engine state (including chain ID and owner) is kept. The
+// account a must be the account accountID.
+func Upgrade(a Signer, accountID string, contract string) error {
+	log.Info(fmt.Sprintf("upgrade '%s' with '%s'", accountID, contract))
+	deploy, err := deployAction(contract)
+	if err != nil {
+		return err
+	}
+	return send(a, accountID, []near.Action{deploy})
 }
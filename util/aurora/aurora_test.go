@@ -0,0 +1,169 @@
+package aurora
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/util/nearmock"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/aurora-is-near/near-api-go/keystore"
+)
+
+// borshString returns the Borsh encoding of s.
+func borshString(s string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len(s)))
+	b.WriteString(s)
+	return b.Bytes()
+}
+
+func TestNewCallArgs(t *testing.T) {
+	data, err := newCallArgs(big.NewInt(1313161556), "aurora.test.near")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exp bytes.Buffer
+	chainID := make([]byte, 32)
+	copy(chainID[28:], []byte{0x4e, 0x45, 0x41, 0x54}) // 1313161556
+	exp.Write(chainID)
+	exp.Write(borshString("aurora.test.near"))
+	exp.Write(borshString(DefaultBridgeProver))
+	binary.Write(&exp, binary.LittleEndian, uint64(DefaultUpgradeDelayBlocks))
+	if !bytes.Equal(data, exp.Bytes()) {
+		t.Errorf("newCallArgs() = %x, expected %x", data, exp.Bytes())
+	}
+	chainID256 := new(big.Int).Lsh(big.NewInt(1), 256)
+	if _, err := newCallArgs(chainID256, "aurora.test.near"); err == nil {
+		t.Error("newCallArgs() should fail for chain ID with more than 256 bits")
+	}
+	if _, err := newCallArgs(big.NewInt(-1), "aurora.test.near"); err == nil {
+		t.Error("newCallArgs() should fail for negative chain ID")
+	}
+}
+
+func TestInitCallArgs(t *testing.T) {
+	data, err := initCallArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := append(borshString(DefaultBridgeProver), borshString(DefaultEthCustodian)...)
+	if !bytes.Equal(data, exp) {
+		t.Errorf("initCallArgs() = %x, expected %x", data, exp)
+	}
+}
+
+// newTestEngine returns a mock server with the engine account accountID and
+// the account loaded with its key (stored in a temporary $HOME).
+func newTestEngine(t *testing.T, accountID string) (*nearmock.Server, *near.Account) {
+	home := t.TempDir()
+	oldHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	t.Cleanup(func() { os.Setenv("HOME", oldHome) })
+	kp, err := keystore.GenerateEd25519KeyPair(accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".near-credentials", "local"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kp.Write("local"); err != nil {
+		t.Fatal(err)
+	}
+	srv := nearmock.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddAccount(accountID, kp.PublicKey)
+	cfg := &near.Config{NetworkID: "local", NodeURL: srv.URL}
+	a, err := near.LoadAccount(near.NewConnection(srv.URL), cfg, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, a
+}
+
+func TestInstall(t *testing.T) {
+	const accountID = "aurora.test.near"
+	srv, a := newTestEngine(t, accountID)
+	contract := filepath.Join(t.TempDir(), "release.wasm")
+	if err := os.WriteFile(contract, []byte("\x00asm"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	chainID := big.NewInt(1313161556)
+	if err := Install(a, accountID, "", chainID, contract); err != nil {
+		t.Fatal(err)
+	}
+	if code := srv.Code(accountID); string(code) != "\x00asm" {
+		t.Errorf("deployed code = %x", code)
+	}
+	newArgs, _ := newCallArgs(chainID, accountID) // owned by the engine account
+	initArgs, _ := initCallArgs()
+	calls := srv.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("%d calls, expected 2", len(calls))
+	}
+	for i, exp := range []struct {
+		method string
+		args   []byte
+	}{{"new", newArgs}, {"new_eth_connector", initArgs}} {
+		c := calls[i]
+		if c.Tx != calls[0].Tx || c.Action != i+1 {
+			t.Errorf("call %d: tx %d action %d, expected single tx", i, c.Tx, c.Action)
+		}
+		if c.MethodName != exp.method || !bytes.Equal(c.Args, exp.args) {
+			t.Errorf("call %d: %s(%x), expected %s(%x)", i, c.MethodName, c.Args, exp.method, exp.args)
+		}
+	}
+
+	// install with another owner
+	srv.ResetCalls()
+	if err := Install(a, accountID, "owner.test.near", chainID, contract); err != nil {
+		t.Fatal(err)
+	}
+	newArgs, _ = newCallArgs(chainID, "owner.test.near")
+	if calls := srv.Calls(); len(calls) != 2 || !bytes.Equal(calls[0].Args, newArgs) {
+		t.Errorf("Install() with owner: calls %v, expected new(%x)", calls, newArgs)
+	}
+
+	// upgrade only deploys
+	srv.ResetCalls()
+	if err := os.WriteFile(contract, []byte("\x00asm\x01"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Upgrade(a, accountID, contract); err != nil {
+		t.Fatal(err)
+	}
+	if code := srv.Code(accountID); string(code) != "\x00asm\x01" {
+		t.Errorf("upgraded code = %x", code)
+	}
+	if calls := srv.Calls(); len(calls) != 0 {
+		t.Errorf("Upgrade() made %d calls, expected none", len(calls))
+	}
+}
+
+func TestInstallFailure(t *testing.T) {
+	const accountID = "aurora.test.near"
+	srv, a := newTestEngine(t, accountID)
+	srv.SetOutcome(func(c *nearmock.Call) nearmock.Outcome {
+		if c.MethodName == "new" {
+			return nearmock.Outcome{Failure: "ERR_ALREADY_INITIALIZED"}
+		}
+		return nearmock.Outcome{}
+	})
+	contract := filepath.Join(t.TempDir(), "release.wasm")
+	if err := os.WriteFile(contract, []byte("\x00asm"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Install(a, accountID, "", big.NewInt(1313161556), contract); err == nil {
+		t.Error("Install() should fail, if 'new' fails")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.wasm")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Install(a, accountID, "", big.NewInt(1313161556), empty); err == nil {
+		t.Error("Install() should fail for empty contract")
+	}
+}
@@ -3,6 +3,7 @@ package git
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"os/exec"
 	"strings"
@@ -21,6 +22,24 @@ func Head(repoPath string) (string, error) {
 	return strings.TrimSpace(stdout.String()), nil
 }
 
+// Branch returns the branch checked out in the repository by given path
+// (empty, if HEAD is detached).
+func Branch(repoPath string) (string, error) {
+	cmd := exec.Command("git", "symbolic-ref", "--quiet", "--short", "HEAD")
+	cmd.Dir = repoPath
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", nil // detached HEAD
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
 // Checkout checks out the given head in the repository by given path.
 func Checkout(repoPath string, head string) error {
 	cmd := exec.Command("git", "checkout", head)
@@ -29,3 +48,17 @@ func Checkout(repoPath string, head string) error {
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+// RevList returns the commits on the ancestry path from (but excluding) good
+// to (and including) bad in the repository by given path, oldest first.
+func RevList(repoPath, good, bad string) ([]string, error) {
+	cmd := exec.Command("git", "rev-list", "--ancestry-path", "--reverse", good+".."+bad)
+	cmd.Dir = repoPath
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return strings.Fields(stdout.String()), nil
+}
@@ -0,0 +1,163 @@
+package nearmock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/aurora-is-near/near-api-go/utils"
+	"github.com/near/borsh-go"
+)
+
+// signatureLen is the length of an encoded ed25519 signature (key type and
+// signature data).
+const signatureLen = 1 + 64
+
+// decodeSignedTransaction decodes the borsh encoded signed transaction data.
+// It returns the transaction, the encoded transaction (the signed part of
+// data), and the signature.
+//
+// The transaction is decoded by hand, because borsh-go doesn't decode unit
+// enum variants (like CreateAccount and FullAccess) the way it encodes them.
+func decodeSignedTransaction(data []byte) (*near.Transaction, []byte, []byte, error) {
+	if len(data) < signatureLen {
+		return nil, nil, nil, errors.New("transaction too short")
+	}
+	txData := data[:len(data)-signatureLen]
+	sig := data[len(data)-signatureLen:]
+	if sig[0] != utils.ED25519 {
+		return nil, nil, nil, fmt.Errorf("unsupported signature key type %d", sig[0])
+	}
+	d := &decoder{r: bytes.NewReader(txData)}
+	tx := d.transaction()
+	if d.err == nil && d.r.Len() > 0 {
+		d.err = fmt.Errorf("%d trailing bytes", d.r.Len())
+	}
+	if d.err != nil {
+		return nil, nil, nil, d.err
+	}
+	return tx, txData, sig[1:], nil
+}
+
+// A decoder decodes borsh encoded values. The first error is kept in err,
+// all later reads return zero values.
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return make([]byte, n)
+	}
+	if n > d.r.Len() {
+		d.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	b := make([]byte, n)
+	d.r.Read(b)
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	return d.read(1)[0]
+}
+
+func (d *decoder) u32() uint32 {
+	return binary.LittleEndian.Uint32(d.read(4))
+}
+
+func (d *decoder) u64() uint64 {
+	return binary.LittleEndian.Uint64(d.read(8))
+}
+
+func (d *decoder) u128() big.Int {
+	b := d.read(16)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	var n big.Int
+	n.SetBytes(b)
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	return d.read(int(d.u32()))
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) publicKey() utils.PublicKey {
+	var pk utils.PublicKey
+	pk.KeyType = d.u8()
+	if d.err == nil && pk.KeyType != utils.ED25519 {
+		d.err = fmt.Errorf("unsupported public key type %d", pk.KeyType)
+	}
+	copy(pk.Data[:], d.read(len(pk.Data)))
+	return pk
+}
+
+func (d *decoder) transaction() *near.Transaction {
+	var tx near.Transaction
+	tx.SignerID = d.string()
+	tx.PublicKey = d.publicKey()
+	tx.Nonce = d.u64()
+	tx.ReceiverID = d.string()
+	copy(tx.BlockHash[:], d.read(len(tx.BlockHash)))
+	n := d.u32()
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		tx.Actions = append(tx.Actions, d.action())
+	}
+	return &tx
+}
+
+func (d *decoder) action() near.Action {
+	a := near.Action{Enum: borsh.Enum(d.u8())}
+	switch a.Enum {
+	case 0: // CreateAccount
+	case 1: // DeployContract
+		a.DeployContract.Code = d.bytes()
+	case 2: // FunctionCall
+		a.FunctionCall.MethodName = d.string()
+		a.FunctionCall.Args = d.bytes()
+		a.FunctionCall.Gas = d.u64()
+		a.FunctionCall.Deposit = d.u128()
+	case 3: // Transfer
+		a.Transfer.Deposit = d.u128()
+	case 5: // AddKey
+		a.AddKey.PublicKey = d.publicKey()
+		a.AddKey.AccessKey.Nonce = d.u64()
+		a.AddKey.AccessKey.Permission.Enum = borsh.Enum(d.u8())
+		switch a.AddKey.AccessKey.Permission.Enum {
+		case 0: // FunctionCall
+			p := &a.AddKey.AccessKey.Permission.FunctionCall
+			if d.u8() == 1 {
+				allowance := d.u128()
+				p.Allowance = &allowance
+			}
+			p.ReceiverId = d.string()
+			n := d.u32()
+			for i := uint32(0); i < n && d.err == nil; i++ {
+				p.MethodNames = append(p.MethodNames, d.string())
+			}
+		case 1: // FullAccess
+		default:
+			if d.err == nil {
+				d.err = fmt.Errorf("unknown access key permission %d",
+					a.AddKey.AccessKey.Permission.Enum)
+			}
+		}
+	default:
+		// unsupported actions cannot be skipped, their length is unknown
+		if d.err == nil {
+			d.err = fmt.Errorf("unsupported action %d", a.Enum)
+		}
+	}
+	return a
+}
@@ -0,0 +1,70 @@
+package nearmock
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/aurora-is-near/near-api-go/utils"
+	"github.com/near/borsh-go"
+)
+
+func TestDecodeSignedTransaction(t *testing.T) {
+	deposit, _ := new(big.Int).SetString("100000000000000000000000000", 10)
+	var pk utils.PublicKey
+	pk.Data[0] = 1
+	tx := near.Transaction{
+		SignerID:   "test.near",
+		PublicKey:  pk,
+		Nonce:      7,
+		ReceiverID: "aurora.test.near",
+		Actions: []near.Action{
+			{Enum: 0},
+			{Enum: 3, Transfer: near.Transfer{Deposit: *deposit}},
+			{Enum: 5, AddKey: near.AddKey{
+				PublicKey: pk,
+				AccessKey: near.AccessKey{Permission: near.AccessKeyPermission{Enum: 1}},
+			}},
+			{Enum: 2, FunctionCall: near.FunctionCall{
+				MethodName: "submit",
+				Args:       []byte{1, 2, 3},
+				Gas:        300,
+			}},
+		},
+	}
+	txData, err := borsh.Serialize(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := bytes.Repeat([]byte{0xaa}, 64)
+	data := append(append(append([]byte{}, txData...), utils.ED25519), sig...)
+
+	dtx, dtxData, dsig, err := decodeSignedTransaction(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dtxData, txData) || !bytes.Equal(dsig, sig) {
+		t.Error("signed part or signature not split correctly")
+	}
+	if dtx.SignerID != tx.SignerID || dtx.ReceiverID != tx.ReceiverID || dtx.Nonce != tx.Nonce {
+		t.Errorf("decoded transaction header %+v", dtx)
+	}
+	if len(dtx.Actions) != len(tx.Actions) {
+		t.Fatalf("decoded %d actions, expected %d", len(dtx.Actions), len(tx.Actions))
+	}
+	if d := dtx.Actions[1].Transfer.Deposit; d.Cmp(deposit) != 0 {
+		t.Errorf("transfer deposit = %s, expected %s", d.String(), deposit)
+	}
+	if p := dtx.Actions[2].AddKey.AccessKey.Permission.Enum; p != 1 {
+		t.Errorf("access key permission = %d, expected full access", p)
+	}
+	fc := dtx.Actions[3].FunctionCall
+	if fc.MethodName != "submit" || !bytes.Equal(fc.Args, []byte{1, 2, 3}) || fc.Gas != 300 {
+		t.Errorf("decoded function call %+v", fc)
+	}
+
+	if _, _, _, err := decodeSignedTransaction(data[1:]); err == nil {
+		t.Error("decodeSignedTransaction() should fail for corrupt data")
+	}
+}
@@ -0,0 +1,510 @@
+// Package nearmock implements an in-process fake NEAR JSON-RPC server for
+// tests.
+//
+// The server keeps accounts with access keys, contract code, and contract
+// state in memory. Transactions sent with 'broadcast_tx_commit' are decoded,
+// their signatures and nonces are checked, and their actions are applied
+// immediately. The outcome of function calls is configurable, contracts are
+// not executed.
+package nearmock
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// Gas and token costs reported in transaction outcomes.
+const (
+	TxGasBurnt      = 2428000000000                  // gas burnt for converting a transaction into a receipt
+	DefaultGasBurnt = 5000000000000                  // default gas burnt by a function call
+	GasPrice        = 100000000                      // yoctoⓃ per gas unit
+	DefaultBalance  = "1000000000000000000000000000" // balance of added accounts (1000 Ⓝ)
+)
+
+// Default node version reported by 'status'.
+const (
+	DefaultVersion = "0.0.0"
+	DefaultBuild   = "nearmock"
+)
+
+const ed25519Prefix = "ed25519:"
+
+// A Call is a function call received by the server.
+type Call struct {
+	Tx         int    // number of the transaction (in the order received)
+	Action     int    // index of the action in the transaction
+	TxHash     string // hash of the transaction
+	SignerID   string
+	ReceiverID string
+	Nonce      uint64
+	MethodName string
+	Args       []byte
+	Gas        uint64
+}
+
+// An Outcome defines the outcome of a function call.
+type Outcome struct {
+	Failure      string // execution error (the call fails, if not empty)
+	SuccessValue []byte // return value of a successful call
+	GasBurnt     uint64 // gas burnt by the call (DefaultGasBurnt, if zero)
+}
+
+// An OutcomeFunc determines the outcome of function call c.
+type OutcomeFunc func(c *Call) Outcome
+
+type account struct {
+	amount *big.Int
+	code   []byte
+	keys   map[string]uint64 // public key -> nonce
+	state  map[string][]byte
+}
+
+// Server is a fake NEAR JSON-RPC server.
+type Server struct {
+	URL      string // URL of the server
+	srv      *httptest.Server
+	mu       sync.Mutex
+	latency  time.Duration
+	outcome  OutcomeFunc
+	accounts map[string]*account
+	version  map[string]interface{} // version reported by 'status'
+	calls    []*Call
+	txs      int
+	height   uint64
+}
+
+// NewServer starts and returns a new server. It must be closed with Close.
+func NewServer() *Server {
+	s := &Server{accounts: make(map[string]*account), height: 1}
+	s.SetVersion(DefaultVersion, DefaultBuild)
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// AddAccount adds the account accountID with a full access key for publicKey
+// (in NEAR encoding, e.g., "ed25519:...") and a balance of DefaultBalance.
+func (s *Server) AddAccount(accountID, publicKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	amount, _ := new(big.Int).SetString(DefaultBalance, 10)
+	s.accounts[accountID] = &account{
+		amount: amount,
+		keys:   map[string]uint64{publicKey: 0},
+		state:  make(map[string][]byte),
+	}
+}
+
+// SetVersion sets the node version and build reported by 'status'.
+func (s *Server) SetVersion(version, build string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = map[string]interface{}{"version": version, "build": build}
+}
+
+// SetCode sets the contract code of accountID.
+func (s *Server) SetCode(accountID string, code []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[accountID].code = code
+}
+
+// SetState sets the contract state of accountID for key to value.
+func (s *Server) SetState(accountID string, key, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[accountID].state[string(key)] = value
+}
+
+// Code returns the contract code of accountID.
+func (s *Server) Code(accountID string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a := s.accounts[accountID]; a != nil {
+		return a.code
+	}
+	return nil
+}
+
+// SetLatency sets the latency of 'broadcast_tx_commit' calls.
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// SetOutcome sets the function which determines the outcome of function
+// calls. By default all calls succeed without return value.
+func (s *Server) SetOutcome(f OutcomeFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outcome = f
+}
+
+// Calls returns all function calls received so far.
+func (s *Server) Calls() []*Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := make([]*Call, len(s.calls))
+	copy(calls, s.calls)
+	return calls
+}
+
+// ResetCalls forgets all function calls received so far.
+func (s *Server) ResetCalls() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      interface{}     `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Data)
+}
+
+func serverError(format string, a ...interface{}) *rpcError {
+	return &rpcError{Code: -32000, Message: "Server error", Data: fmt.Sprintf(format, a...)}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	result, err := s.dispatch(req.Method, req.Params)
+	if err != nil {
+		var e *rpcError
+		if !errors.As(err, &e) {
+			e = serverError("%s", err)
+		}
+		res["error"] = e
+	} else {
+		res["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) dispatch(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "status":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return map[string]interface{}{
+			"chain_id": "localnet",
+			"version":  s.version,
+			"sync_info": map[string]interface{}{
+				"latest_block_height": s.height,
+				"latest_block_hash":   blockHash(s.height),
+			},
+		}, nil
+	case "block":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return map[string]interface{}{
+			"header": map[string]interface{}{
+				"height": s.height,
+				"hash":   blockHash(s.height),
+			},
+		}, nil
+	case "query":
+		var q map[string]string
+		if err := json.Unmarshal(params, &q); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.query(q)
+	case "broadcast_tx_commit":
+		var p []string
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		if len(p) != 1 {
+			return nil, serverError("expected one parameter")
+		}
+		return s.broadcastTxCommit(p[0])
+	}
+	return nil, &rpcError{Code: -32601, Message: "Method not found", Data: method}
+}
+
+func blockHash(height uint64) string {
+	h := sha256.Sum256([]byte(strconv.FormatUint(height, 10)))
+	return base58.Encode(h[:])
+}
+
+func codeHash(code []byte) string {
+	if len(code) == 0 {
+		return base58.Encode(make([]byte, 32))
+	}
+	h := sha256.Sum256(code)
+	return base58.Encode(h[:])
+}
+
+func (s *Server) query(q map[string]string) (interface{}, error) {
+	a := s.accounts[q["account_id"]]
+	if a == nil {
+		return nil, serverError("account %s does not exist while viewing", q["account_id"])
+	}
+	res := map[string]interface{}{
+		"block_height": s.height,
+		"block_hash":   blockHash(s.height),
+	}
+	switch q["request_type"] {
+	case "view_account":
+		res["amount"] = a.amount.String()
+		res["locked"] = "0"
+		res["code_hash"] = codeHash(a.code)
+		res["storage_usage"] = 182 + len(a.code)
+	case "view_access_key":
+		nonce, ok := a.keys[q["public_key"]]
+		if !ok {
+			return nil, serverError("access key %s does not exist while viewing", q["public_key"])
+		}
+		res["nonce"] = nonce
+		res["permission"] = "FullAccess"
+	case "view_code":
+		if len(a.code) == 0 {
+			return nil, serverError("contract code for account %s does not exist", q["account_id"])
+		}
+		res["code_base64"] = base64.StdEncoding.EncodeToString(a.code)
+		res["hash"] = codeHash(a.code)
+	case "view_state":
+		prefix, err := base64.StdEncoding.DecodeString(q["prefix_base64"])
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for k := range a.state {
+			if bytes.HasPrefix([]byte(k), prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		values := make([]map[string]interface{}, 0, len(keys))
+		for _, k := range keys {
+			values = append(values, map[string]interface{}{
+				"key":   base64.StdEncoding.EncodeToString([]byte(k)),
+				"value": base64.StdEncoding.EncodeToString(a.state[k]),
+				"proof": []string{},
+			})
+		}
+		res["values"] = values
+		res["proof"] = []string{}
+	default:
+		return nil, serverError("unsupported request type %s", q["request_type"])
+	}
+	return res, nil
+}
+
+// broadcastTxCommit decodes, verifies, and applies the base64 encoded signed
+// transaction enc.
+func (s *Server) broadcastTxCommit(enc string) (interface{}, error) {
+	data, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, err
+	}
+	tx, txData, sig, err := decodeSignedTransaction(data)
+	if err != nil {
+		return nil, serverError("cannot decode transaction: %s", err)
+	}
+	hash := sha256.Sum256(txData)
+	pubKey := ed25519.PublicKey(tx.PublicKey.Data[:])
+	if !ed25519.Verify(pubKey, hash[:], sig) {
+		return nil, serverError("invalid signature of transaction from %s", tx.SignerID)
+	}
+
+	s.mu.Lock()
+	latency := s.latency
+	res, err := s.apply(tx, base58.Encode(hash[:]))
+	s.mu.Unlock()
+	time.Sleep(latency)
+	return res, err
+}
+
+// apply applies the transaction tx with the given hash.
+func (s *Server) apply(tx *near.Transaction, hash string) (interface{}, error) {
+	signer := s.accounts[tx.SignerID]
+	if signer == nil {
+		return nil, serverError("signer %s does not exist", tx.SignerID)
+	}
+	publicKey := ed25519Prefix + base58.Encode(tx.PublicKey.Data[:])
+	nonce, ok := signer.keys[publicKey]
+	if !ok {
+		return nil, serverError("access key %s of %s does not exist", publicKey, tx.SignerID)
+	}
+	if tx.Nonce <= nonce {
+		return nil, &rpcError{
+			Code:    -32000,
+			Message: "Server error",
+			Data: map[string]interface{}{"TxExecutionError": map[string]interface{}{
+				"InvalidTxError": map[string]interface{}{
+					"InvalidNonce": map[string]interface{}{"tx_nonce": tx.Nonce, "ak_nonce": nonce},
+				},
+			}},
+		}
+	}
+	signer.keys[publicKey] = tx.Nonce
+	s.height++
+	txNum := s.txs
+	s.txs++
+
+	var (
+		gasBurnt uint64
+		value    []byte
+		failure  interface{}
+	)
+	for i, action := range tx.Actions {
+		if err := s.applyAction(tx, i, &action, &gasBurnt, &value); err != nil {
+			failure = map[string]interface{}{"ActionError": map[string]interface{}{
+				"index": i,
+				"kind":  err,
+			}}
+			break
+		}
+	}
+	var status map[string]interface{}
+	if failure != nil {
+		status = map[string]interface{}{"Failure": failure}
+	} else {
+		status = map[string]interface{}{"SuccessValue": base64.StdEncoding.EncodeToString(value)}
+	}
+	for _, c := range s.calls {
+		if c.Tx == txNum {
+			c.TxHash = hash
+		}
+	}
+	receiptHash := sha256.Sum256([]byte("receipt:" + hash))
+	receiptID := base58.Encode(receiptHash[:])
+	return map[string]interface{}{
+		"status": status,
+		"transaction": map[string]interface{}{
+			"hash":        hash,
+			"signer_id":   tx.SignerID,
+			"receiver_id": tx.ReceiverID,
+			"nonce":       tx.Nonce,
+		},
+		"transaction_outcome": outcome(hash, TxGasBurnt,
+			map[string]interface{}{"SuccessReceiptId": receiptID}),
+		"receipts_outcome": []interface{}{outcome(receiptID, gasBurnt, status)},
+	}, nil
+}
+
+func outcome(id string, gasBurnt uint64, status interface{}) map[string]interface{} {
+	tokens := new(big.Int).Mul(new(big.Int).SetUint64(gasBurnt), big.NewInt(GasPrice))
+	return map[string]interface{}{
+		"id": id,
+		"outcome": map[string]interface{}{
+			"gas_burnt":    gasBurnt,
+			"tokens_burnt": tokens.String(),
+			"logs":         []string{},
+			"receipt_ids":  []string{},
+			"status":       status,
+		},
+	}
+}
+
+// actionError is the kind of a failed action.
+type actionError map[string]interface{}
+
+func (e actionError) Error() string {
+	jsn, _ := json.Marshal(map[string]interface{}(e))
+	return string(jsn)
+}
+
+// applyAction applies action i of tx and adds the burnt gas to gasBurnt. The
+// return value of function calls is stored in value.
+func (s *Server) applyAction(
+	tx *near.Transaction,
+	i int,
+	action *near.Action,
+	gasBurnt *uint64,
+	value *[]byte,
+) error {
+	receiver := s.accounts[tx.ReceiverID]
+	if receiver == nil && action.Enum != 0 {
+		return actionError{"AccountDoesNotExist": map[string]interface{}{"account_id": tx.ReceiverID}}
+	}
+	switch action.Enum {
+	case 0: // CreateAccount
+		if receiver != nil {
+			return actionError{"AccountAlreadyExists": map[string]interface{}{"account_id": tx.ReceiverID}}
+		}
+		s.accounts[tx.ReceiverID] = &account{
+			amount: new(big.Int),
+			keys:   make(map[string]uint64),
+			state:  make(map[string][]byte),
+		}
+	case 1: // DeployContract
+		receiver.code = action.DeployContract.Code
+	case 2: // FunctionCall
+		fc := &action.FunctionCall
+		c := &Call{
+			Tx:         s.txs - 1,
+			Action:     i,
+			SignerID:   tx.SignerID,
+			ReceiverID: tx.ReceiverID,
+			Nonce:      tx.Nonce,
+			MethodName: fc.MethodName,
+			Args:       fc.Args,
+			Gas:        fc.Gas,
+		}
+		s.calls = append(s.calls, c)
+		var o Outcome
+		if len(receiver.code) == 0 {
+			o.Failure = "CompilationError(CodeDoesNotExist)"
+		} else if s.outcome != nil {
+			o = s.outcome(c)
+		}
+		if o.GasBurnt == 0 {
+			o.GasBurnt = DefaultGasBurnt
+		}
+		*gasBurnt += o.GasBurnt
+		if o.Failure != "" {
+			return actionError{"FunctionCallError": map[string]interface{}{"ExecutionError": o.Failure}}
+		}
+		*value = o.SuccessValue
+	case 3: // Transfer
+		receiver.amount.Add(receiver.amount, &action.Transfer.Deposit)
+	case 5: // AddKey
+		pk := ed25519Prefix + base58.Encode(action.AddKey.PublicKey.Data[:])
+		receiver.keys[pk] = action.AddKey.AccessKey.Nonce
+	default:
+		return actionError{"UnsupportedAction": map[string]interface{}{"enum": action.Enum}}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package nearvm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/aurora-is-near/evm-bully/util/wasm"
+	"github.com/ethereum/go-ethereum/crypto/bn256"
+)
+
+// The alt_bn128 host functions encode field elements and scalars as 32-byte
+// little-endian integers. A G1 point is (x, y), a G2 point is (x, y) with
+// coordinates (real, imaginary) in Fq2, and the point at infinity is encoded
+// as zeros. go-ethereum's bn256 uses big-endian integers and puts the
+// imaginary part first (EIP-196 and EIP-197).
+const (
+	g1Size = 64
+	g2Size = 128
+)
+
+// bn256Order is the order of the alt_bn128 groups.
+var bn256Order, _ = new(big.Int).SetString(
+	"21888242871839275222246405745257275088548364400416034343698204186575808495617", 10)
+
+func altBn128Error(msg string) error {
+	return hostError(fmt.Sprintf("AltBn128InvalidInput { msg: %q }", msg))
+}
+
+// reverseWords returns b with the byte order of each 32-byte word reversed.
+func reverseWords(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := 0; i < len(b); i += 32 {
+		for j := 0; j < 32; j++ {
+			r[i+j] = b[i+31-j]
+		}
+	}
+	return r
+}
+
+func decodeG1(b []byte) (*bn256.G1, error) {
+	p := new(bn256.G1)
+	if _, err := p.Unmarshal(reverseWords(b[:g1Size])); err != nil {
+		return nil, altBn128Error(fmt.Sprintf("invalid g1: %s", err))
+	}
+	return p, nil
+}
+
+func encodeG1(p *bn256.G1) []byte {
+	return reverseWords(p.Marshal())
+}
+
+func decodeG2(b []byte) (*bn256.G2, error) {
+	// (x.re, x.im, y.re, y.im) -> (x.im, x.re, y.im, y.re)
+	w := reverseWords(b[:g2Size])
+	m := make([]byte, 0, g2Size)
+	m = append(m, w[32:64]...)
+	m = append(m, w[0:32]...)
+	m = append(m, w[96:128]...)
+	m = append(m, w[64:96]...)
+	p := new(bn256.G2)
+	if _, err := p.Unmarshal(m); err != nil {
+		return nil, altBn128Error(fmt.Sprintf("invalid g2: %s", err))
+	}
+	return p, nil
+}
+
+func decodeScalar(b []byte) (*big.Int, error) {
+	s := new(big.Int).SetBytes(reverseWords(b[:32]))
+	if s.Cmp(bn256Order) >= 0 {
+		return nil, altBn128Error("invalid fr")
+	}
+	return s, nil
+}
+
+// readItems reads the input of an alt_bn128 host function (value_len,
+// value_ptr) consisting of items of size bytes and burns base plus cost gas
+// per item.
+func (c *context) readItems(inst *wasm.Instance, args []uint64, size int, base, cost uint64) ([]byte, int, error) {
+	data, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data)%size != 0 {
+		return nil, 0, altBn128Error(fmt.Sprintf("slice of size %d cannot be split into elements of size %d",
+			len(data), size))
+	}
+	n := len(data) / size
+	if err := c.useGasPerByte(base, uint64(n), cost); err != nil {
+		return nil, 0, err
+	}
+	return data, n, nil
+}
+
+func (c *context) altBn128G1Multiexp(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (value_len, value_ptr, register_id) with items (g1, fr)
+	const size = g1Size + 32
+	data, n, err := c.readItems(inst, args, size, extAltBn128G1MultiexpBase, extAltBn128G1MultiexpElement)
+	if err != nil {
+		return nil, err
+	}
+	sum := new(bn256.G1).ScalarBaseMult(new(big.Int))
+	for i := 0; i < n; i++ {
+		item := data[i*size:]
+		p, err := decodeG1(item)
+		if err != nil {
+			return nil, err
+		}
+		s, err := decodeScalar(item[g1Size:])
+		if err != nil {
+			return nil, err
+		}
+		sum.Add(sum, new(bn256.G1).ScalarMult(p, s))
+	}
+	return nil, c.setRegister(args[2], encodeG1(sum))
+}
+
+func (c *context) altBn128G1Sum(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (value_len, value_ptr, register_id) with items (sign, g1), the point is
+	// subtracted if sign is 1
+	const size = 1 + g1Size
+	data, n, err := c.readItems(inst, args, size, extAltBn128G1SumBase, extAltBn128G1SumElement)
+	if err != nil {
+		return nil, err
+	}
+	sum := new(bn256.G1).ScalarBaseMult(new(big.Int))
+	for i := 0; i < n; i++ {
+		item := data[i*size:]
+		if item[0] > 1 {
+			return nil, altBn128Error("invalid bool")
+		}
+		p, err := decodeG1(item[1:])
+		if err != nil {
+			return nil, err
+		}
+		if item[0] == 1 {
+			p.Neg(p)
+		}
+		sum.Add(sum, p)
+	}
+	return nil, c.setRegister(args[2], encodeG1(sum))
+}
+
+func (c *context) altBn128PairingCheck(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (value_len, value_ptr) with items (g1, g2)
+	const size = g1Size + g2Size
+	data, n, err := c.readItems(inst, args, size, extAltBn128PairingCheckBase, extAltBn128PairingCheckElement)
+	if err != nil {
+		return nil, err
+	}
+	g1s := make([]*bn256.G1, n)
+	g2s := make([]*bn256.G2, n)
+	for i := 0; i < n; i++ {
+		item := data[i*size:]
+		if g1s[i], err = decodeG1(item); err != nil {
+			return nil, err
+		}
+		if g2s[i], err = decodeG2(item[g1Size:]); err != nil {
+			return nil, err
+		}
+	}
+	if bn256.PairingCheck(g1s, g2s) {
+		return []uint64{1}, nil
+	}
+	return []uint64{0}, nil
+}
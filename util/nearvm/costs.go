@@ -0,0 +1,132 @@
+package nearvm
+
+import (
+	"math/bits"
+)
+
+// Limits of the runtime (as in nearcore).
+const (
+	MaxMemoryPages = 2048 // maximum memory of a contract (in wasm pages)
+)
+
+// Fees for executing receipts and their actions (as in nearcore).
+const (
+	actionReceiptCreationExecFee = 108059500000
+	createAccountExecFee         = 99607375000
+	deployContractExecFee        = 184765750000
+	deployContractExecFeeByte    = 64572944 // per byte of code
+	functionCallExecFee          = 2319861500000
+	functionCallExecFeeByte      = 2235934 // per byte of method name and arguments
+	transferExecFee              = 115123062500
+)
+
+// Ext costs of host functions (as in nearcore's runtime configuration of
+// protocol version 53). Trie node costs depend on the state layout of a
+// node and are not charged.
+const (
+	extBase                 = 264768111
+	extContractLoadingBase  = 35445963
+	extContractLoadingBytes = 216750
+
+	extReadMemoryBase    = 2609863200
+	extReadMemoryByte    = 3801333
+	extWriteMemoryBase   = 2803794861
+	extWriteMemoryByte   = 2723772
+	extReadRegisterBase  = 2517165186
+	extReadRegisterByte  = 98562
+	extWriteRegisterBase = 2865522486
+	extWriteRegisterByte = 3801564
+
+	extUTF8DecodingBase  = 3111779061
+	extUTF8DecodingByte  = 291580479
+	extUTF16DecodingBase = 3543313050
+	extUTF16DecodingByte = 163577493
+
+	extSHA256Base        = 4540970250
+	extSHA256Byte        = 24117351
+	extKeccak256Base     = 5879491275
+	extKeccak256Byte     = 21471105
+	extKeccak512Base     = 5811388236
+	extKeccak512Byte     = 36649701
+	extRIPEMD160Base     = 853675086
+	extRIPEMD160Block    = 680107584
+	extECRecoverBase     = 278821988457
+	extEd25519VerifyBase = 210000000000
+	extEd25519VerifyByte = 9000000
+
+	extAltBn128G1MultiexpBase      = 713000000000
+	extAltBn128G1MultiexpElement   = 320000000000
+	extAltBn128G1SumBase           = 3000000000
+	extAltBn128G1SumElement        = 5000000000
+	extAltBn128PairingCheckBase    = 9686000000000
+	extAltBn128PairingCheckElement = 5102000000000
+
+	extLogBase = 3543313050
+	extLogByte = 13198791
+
+	extStorageWriteBase        = 64196736000
+	extStorageWriteKeyByte     = 70482867
+	extStorageWriteValueByte   = 31018539
+	extStorageWriteEvictedByte = 32117307
+	extStorageReadBase         = 56356845750
+	extStorageReadKeyByte      = 30952533
+	extStorageReadValueByte    = 5611005
+	extStorageRemoveBase       = 53473030500
+	extStorageRemoveKeyByte    = 38220384
+	extStorageRemoveRetByte    = 11531556
+	extStorageHasKeyBase       = 54039896625
+	extStorageHasKeyByte       = 30790845
+
+	extPromiseReturn           = 560152386
+	extValidatorStakeBase      = 911834726400
+	extValidatorTotalStakeBase = 911834726400
+)
+
+// mulGas returns the cost of n units with the given cost per unit (saturating
+// at MaxUint64).
+func mulGas(n, cost uint64) uint64 {
+	hi, lo := bits.Mul64(n, cost)
+	if hi != 0 {
+		return ^uint64(0)
+	}
+	return lo
+}
+
+// errGasExceeded is returned by host functions if the prepaid gas is used up.
+var errGasExceeded = hostError("Exceeded the prepaid gas.")
+
+// usedGas returns the gas used so far by the executed instructions and the
+// host functions.
+func (c *context) usedGas() uint64 {
+	if c.inst == nil {
+		return c.extGas
+	}
+	return c.inst.Steps()*RegularOpCost + c.extGas
+}
+
+// useGas burns gas for a host function and limits the instructions executed
+// afterwards to the remaining prepaid gas. It fails if the prepaid gas is
+// exceeded.
+func (c *context) useGas(gas uint64) error {
+	used := c.usedGas()
+	if used > c.prepaidGas || gas > c.prepaidGas-used {
+		return errGasExceeded
+	}
+	c.extGas += gas
+	if c.inst != nil {
+		steps := c.inst.Steps() + (c.prepaidGas-used-gas)/RegularOpCost
+		if steps == 0 {
+			return errGasExceeded
+		}
+		c.inst.MaxSteps = steps
+	}
+	return nil
+}
+
+// useGasPerByte burns base plus n times cost gas.
+func (c *context) useGasPerByte(base, n, cost uint64) error {
+	if err := c.useGas(base); err != nil {
+		return err
+	}
+	return c.useGas(mulGas(n, cost))
+}
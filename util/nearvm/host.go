@@ -0,0 +1,682 @@
+package nearvm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/aurora-is-near/evm-bully/util/wasm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/sha3"
+)
+
+// A hostError is an error raised by a host function (reported as execution
+// error of the function call).
+type hostError string
+
+func (e hostError) Error() string {
+	return string(e)
+}
+
+// context is the execution context of a function call.
+type context struct {
+	rt          *Runtime
+	rc          *receipt
+	account     *account
+	inst        *wasm.Instance
+	input       []byte
+	deposit     *big.Int
+	prepaidGas  uint64
+	extGas      uint64 // gas burnt by host functions (see useGas)
+	registers   map[uint64][]byte
+	returnValue []byte
+	logs        []string
+	promises    uint64 // number of created (and ignored) promises
+}
+
+type hostFunc func(c *context, inst *wasm.Instance, args []uint64) ([]uint64, error)
+
+// hostFuncs are the implemented host functions of module "env".
+var hostFuncs = map[string]hostFunc{
+	// registers
+	"read_register":  (*context).readRegister,
+	"register_len":   (*context).registerLen,
+	"write_register": (*context).writeRegister,
+	// context
+	"current_account_id":     (*context).currentAccountID,
+	"signer_account_id":      (*context).signerAccountID,
+	"signer_account_pk":      (*context).signerAccountPK,
+	"predecessor_account_id": (*context).predecessorAccountID,
+	"input":                  (*context).inputFunc,
+	"block_index":            (*context).blockIndex,
+	"block_timestamp":        (*context).blockTimestamp,
+	"epoch_height":           (*context).epochHeight,
+	"storage_usage":          (*context).storageUsage,
+	// economics
+	"account_balance":        (*context).accountBalance,
+	"account_locked_balance": (*context).zeroBalance,
+	"attached_deposit":       (*context).attachedDeposit,
+	"prepaid_gas":            (*context).prepaidGasFunc,
+	"used_gas":               (*context).usedGasFunc,
+	"validator_stake":        (*context).validatorStake,
+	"validator_total_stake":  (*context).validatorTotalStake,
+	"gas":                    (*context).gas,
+	// math
+	"random_seed":    (*context).randomSeed,
+	"sha256":         (*context).sha256,
+	"keccak256":      (*context).keccak256,
+	"keccak512":      (*context).keccak512,
+	"ripemd160":      (*context).ripemd160,
+	"ecrecover":      (*context).ecrecover,
+	"ed25519_verify": (*context).ed25519Verify,
+	// alt_bn128
+	"alt_bn128_g1_multiexp":   (*context).altBn128G1Multiexp,
+	"alt_bn128_g1_sum":        (*context).altBn128G1Sum,
+	"alt_bn128_pairing_check": (*context).altBn128PairingCheck,
+	// miscellaneous
+	"value_return": (*context).valueReturn,
+	"panic":        (*context).panicFunc,
+	"panic_utf8":   (*context).panicUTF8,
+	"log_utf8":     (*context).logUTF8,
+	"log_utf16":    (*context).logUTF16,
+	"abort":        (*context).abort,
+	// promises (see promiseStub for the others)
+	"promise_results_count": (*context).promiseResultsCount,
+	"promise_result":        (*context).promiseResult,
+	// storage
+	"storage_write":   (*context).storageWrite,
+	"storage_read":    (*context).storageRead,
+	"storage_remove":  (*context).storageRemove,
+	"storage_has_key": (*context).storageHasKey,
+}
+
+// imports returns the host functions for the imports of m. Each call burns
+// the base cost (except for 'gas'). Promise functions are accepted (and
+// ignored). Other unknown functions are left out, which makes the
+// instantiation fail (like linking in nearcore).
+func (c *context) imports(m *wasm.Module) map[string]wasm.HostFunc {
+	imports := make(map[string]wasm.HostFunc)
+	for _, imp := range m.Imports {
+		if imp.Kind != wasm.ExternFunc || imp.Module != "env" {
+			continue
+		}
+		f := hostFuncs[imp.Name]
+		if f == nil && strings.HasPrefix(imp.Name, "promise_") {
+			f = promiseStub(&m.Types[imp.Type])
+		}
+		if f == nil {
+			continue
+		}
+		var base uint64 = extBase
+		if imp.Name == "gas" {
+			base = 0
+		}
+		imports[imp.Module+"."+imp.Name] = func(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+			if err := c.useGas(base); err != nil {
+				return nil, err
+			}
+			return f(c, inst, args)
+		}
+	}
+	return imports
+}
+
+func (c *context) read(inst *wasm.Instance, n, ptr uint64) ([]byte, error) {
+	if err := c.useGasPerByte(extReadMemoryBase, n, extReadMemoryByte); err != nil {
+		return nil, err
+	}
+	b, err := inst.Read(ptr, n)
+	if err != nil {
+		return nil, hostError("MemoryAccessViolation")
+	}
+	return b, nil
+}
+
+func (c *context) write(inst *wasm.Instance, ptr uint64, data []byte) error {
+	if err := c.useGasPerByte(extWriteMemoryBase, uint64(len(data)), extWriteMemoryByte); err != nil {
+		return err
+	}
+	if err := inst.Write(ptr, data); err != nil {
+		return hostError("MemoryAccessViolation")
+	}
+	return nil
+}
+
+// setRegister stores data in register id.
+func (c *context) setRegister(id uint64, data []byte) error {
+	if err := c.useGasPerByte(extWriteRegisterBase, uint64(len(data)), extWriteRegisterByte); err != nil {
+		return err
+	}
+	c.registers[id] = data
+	return nil
+}
+
+// readString reads a UTF-8 string of n bytes at ptr (null-terminated, if n
+// is MaxUint64).
+func (c *context) readString(inst *wasm.Instance, n, ptr uint64) ([]byte, error) {
+	var b []byte
+	if n != math.MaxUint64 {
+		var err error
+		if b, err = c.read(inst, n, ptr); err != nil {
+			return nil, err
+		}
+	} else {
+		// the string is read byte by byte
+		mem := inst.Memory()
+		if ptr >= uint64(len(mem)) {
+			return nil, hostError("MemoryAccessViolation")
+		}
+		i := bytes.IndexByte(mem[ptr:], 0)
+		if i < 0 {
+			return nil, hostError("MemoryAccessViolation")
+		}
+		if err := c.useGas(mulGas(uint64(i)+1, extReadMemoryBase+extReadMemoryByte)); err != nil {
+			return nil, err
+		}
+		b = append([]byte(nil), mem[ptr:ptr+uint64(i)]...)
+	}
+	if err := c.useGasPerByte(extUTF8DecodingBase, uint64(len(b)), extUTF8DecodingByte); err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		return nil, hostError("BadUTF8")
+	}
+	return b, nil
+}
+
+// readUTF16 reads a UTF-16LE string of n bytes at ptr (null-terminated, if n
+// is MaxUint64).
+func (c *context) readUTF16(inst *wasm.Instance, n, ptr uint64) (string, error) {
+	var u []uint16
+	if n == math.MaxUint64 {
+		for p := ptr; ; p += 2 {
+			b, err := c.read(inst, 2, p)
+			if err != nil {
+				return "", err
+			}
+			v := binary.LittleEndian.Uint16(b)
+			if v == 0 {
+				break
+			}
+			u = append(u, v)
+		}
+	} else {
+		if n%2 != 0 {
+			return "", hostError("BadUTF16")
+		}
+		b, err := c.read(inst, n, ptr)
+		if err != nil {
+			return "", err
+		}
+		for i := 0; i < len(b); i += 2 {
+			u = append(u, binary.LittleEndian.Uint16(b[i:]))
+		}
+	}
+	err := c.useGasPerByte(extUTF16DecodingBase, 2*uint64(len(u)), extUTF16DecodingByte)
+	if err != nil {
+		return "", err
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// u128 encodes v as little-endian 128-bit integer.
+func u128(v *big.Int) []byte {
+	b := make([]byte, 16)
+	be := v.Bytes()
+	for i := range be {
+		b[i] = be[len(be)-1-i]
+	}
+	return b
+}
+
+func (c *context) readRegister(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	data, ok := c.registers[args[0]]
+	if !ok {
+		return nil, hostError(fmt.Sprintf("InvalidRegisterId { register_id: %d }", args[0]))
+	}
+	err := c.useGasPerByte(extReadRegisterBase, uint64(len(data)), extReadRegisterByte)
+	if err != nil {
+		return nil, err
+	}
+	return nil, c.write(inst, args[1], data)
+}
+
+func (c *context) registerLen(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	data, ok := c.registers[args[0]]
+	if !ok {
+		return []uint64{math.MaxUint64}, nil
+	}
+	return []uint64{uint64(len(data))}, nil
+}
+
+func (c *context) writeRegister(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	data, err := c.read(inst, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return nil, c.setRegister(args[0], data)
+}
+
+func (c *context) currentAccountID(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, c.setRegister(args[0], []byte(c.rc.receiverID))
+}
+
+func (c *context) signerAccountID(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, c.setRegister(args[0], []byte(c.rc.signerID))
+}
+
+func (c *context) signerAccountPK(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// borsh encoded ed25519 public key (transactions are not signed)
+	return nil, c.setRegister(args[0], make([]byte, 1+ed25519.PublicKeySize))
+}
+
+func (c *context) predecessorAccountID(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, c.setRegister(args[0], []byte(c.rc.predecessorID))
+}
+
+func (c *context) inputFunc(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, c.setRegister(args[0], c.input)
+}
+
+func (c *context) blockIndex(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return []uint64{c.rt.height}, nil
+}
+
+func (c *context) blockTimestamp(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return []uint64{GenesisTimestamp + c.rt.height*BlockTime}, nil
+}
+
+func (c *context) epochHeight(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return []uint64{c.rt.height / EpochLength}, nil
+}
+
+func (c *context) storageUsage(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return []uint64{c.account.storageUsage}, nil
+}
+
+func (c *context) accountBalance(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, c.write(inst, args[0], u128(c.account.balance))
+}
+
+func (c *context) zeroBalance(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, c.write(inst, args[0], make([]byte, 16))
+}
+
+func (c *context) attachedDeposit(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, c.write(inst, args[0], u128(c.deposit))
+}
+
+func (c *context) prepaidGasFunc(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return []uint64{c.prepaidGas}, nil
+}
+
+func (c *context) usedGasFunc(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return []uint64{c.usedGas()}, nil
+}
+
+func (c *context) validatorStake(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (account_id_len, account_id_ptr, stake_ptr)
+	if _, err := c.read(inst, args[0], args[1]); err != nil {
+		return nil, err
+	}
+	if err := c.useGas(extValidatorStakeBase); err != nil {
+		return nil, err
+	}
+	return nil, c.write(inst, args[2], make([]byte, 16))
+}
+
+func (c *context) validatorTotalStake(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	if err := c.useGas(extValidatorTotalStakeBase); err != nil {
+		return nil, err
+	}
+	return nil, c.write(inst, args[0], make([]byte, 16))
+}
+
+func (c *context) gas(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (opcodes) called by instrumented code (in addition to the gas burnt per
+	// executed instruction)
+	return nil, c.useGas(mulGas(uint64(uint32(args[0])), RegularOpCost))
+}
+
+func (c *context) randomSeed(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], c.rt.height)
+	seed := sha256.Sum256(b[:])
+	return nil, c.setRegister(args[0], seed[:])
+}
+
+// hash implements a hash function with arguments (value_len, value_ptr,
+// register_id) which burns base plus n times cost gas for n bytes.
+func (c *context) hash(
+	inst *wasm.Instance,
+	args []uint64,
+	base, cost uint64,
+	f func([]byte) []byte,
+) ([]uint64, error) {
+	data, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := c.useGasPerByte(base, uint64(len(data)), cost); err != nil {
+		return nil, err
+	}
+	return nil, c.setRegister(args[2], f(data))
+}
+
+func (c *context) sha256(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return c.hash(inst, args, extSHA256Base, extSHA256Byte, func(b []byte) []byte {
+		h := sha256.Sum256(b)
+		return h[:]
+	})
+}
+
+func (c *context) keccak256(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return c.hash(inst, args, extKeccak256Base, extKeccak256Byte, func(b []byte) []byte {
+		return crypto.Keccak256(b)
+	})
+}
+
+func (c *context) keccak512(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return c.hash(inst, args, extKeccak512Base, extKeccak512Byte, func(b []byte) []byte {
+		h := sha3.NewLegacyKeccak512()
+		h.Write(b)
+		return h.Sum(nil)
+	})
+}
+
+func (c *context) ripemd160(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// the gas is burnt per (padded) message block of 64 bytes
+	data, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	blocks := (uint64(len(data))+8)/64 + 1
+	if err := c.useGasPerByte(extRIPEMD160Base, blocks, extRIPEMD160Block); err != nil {
+		return nil, err
+	}
+	h := ripemd160.New()
+	h.Write(data)
+	return nil, c.setRegister(args[2], h.Sum(nil))
+}
+
+func (c *context) ecrecover(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (hash_len, hash_ptr, sig_len, sig_ptr, v, malleability_flag, register_id)
+	hash, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	sig, err := c.read(inst, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	if err := c.useGas(extECRecoverBase); err != nil {
+		return nil, err
+	}
+	if len(hash) != 32 || len(sig) != 64 {
+		return nil, hostError("ECRecoverError { msg: \"invalid input size\" }")
+	}
+	v, flag := args[4], args[5]
+	if v > 3 || flag > 1 {
+		return nil, hostError("ECRecoverError { msg: \"invalid recovery id or malleability flag\" }")
+	}
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !crypto.ValidateSignatureValues(byte(v&1), r, s, flag == 1) {
+		return []uint64{0}, nil
+	}
+	pub, err := crypto.Ecrecover(hash, append(sig, byte(v)))
+	if err != nil {
+		return []uint64{0}, nil
+	}
+	// without 0x04 prefix
+	if err := c.setRegister(args[6], pub[1:]); err != nil {
+		return nil, err
+	}
+	return []uint64{1}, nil
+}
+
+func (c *context) ed25519Verify(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (sig_len, sig_ptr, msg_len, msg_ptr, pub_key_len, pub_key_ptr)
+	sig, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	msg, err := c.read(inst, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	pub, err := c.read(inst, args[4], args[5])
+	if err != nil {
+		return nil, err
+	}
+	err = c.useGasPerByte(extEd25519VerifyBase, uint64(len(msg)), extEd25519VerifyByte)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) != ed25519.SignatureSize || len(pub) != ed25519.PublicKeySize {
+		return nil, hostError("Ed25519VerifyInvalidInput")
+	}
+	if ed25519.Verify(pub, msg, sig) {
+		return []uint64{1}, nil
+	}
+	return []uint64{0}, nil
+}
+
+func (c *context) valueReturn(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	value, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	c.returnValue = value
+	return nil, nil
+}
+
+func (c *context) panicFunc(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, hostError("Smart contract panicked: explicit guest panic")
+}
+
+func (c *context) panicUTF8(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	msg, err := c.readString(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, hostError("Smart contract panicked: " + string(msg))
+}
+
+func (c *context) logUTF8(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	msg, err := c.readString(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, c.log(string(msg))
+}
+
+func (c *context) logUTF16(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	msg, err := c.readUTF16(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, c.log(msg)
+}
+
+// log adds msg to the logs of the function call.
+func (c *context) log(msg string) error {
+	if err := c.useGasPerByte(extLogBase, uint64(len(msg)), extLogByte); err != nil {
+		return err
+	}
+	c.logs = append(c.logs, msg)
+	return nil
+}
+
+func (c *context) abort(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (msg_ptr, filename_ptr, line, col) with AssemblyScript strings (UTF-16
+	// with the length in bytes before the pointer)
+	str := func(ptr uint64) (string, error) {
+		if ptr < 4 {
+			return "", hostError("MemoryAccessViolation")
+		}
+		n, err := c.read(inst, 4, ptr-4)
+		if err != nil {
+			return "", err
+		}
+		return c.readUTF16(inst, uint64(binary.LittleEndian.Uint32(n)), ptr)
+	}
+	msg, err := str(args[0])
+	if err != nil {
+		return nil, err
+	}
+	filename, err := str(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, hostError(fmt.Sprintf("Smart contract panicked: %s, filename: \"%s\" line: %d col: %d",
+		msg, filename, uint32(args[2]), uint32(args[3])))
+}
+
+// promiseStub returns a host function of type ft which accepts a promise
+// function call without executing it. Functions creating promises return
+// increasing promise indices.
+func promiseStub(ft *wasm.FuncType) hostFunc {
+	return func(c *context, inst *wasm.Instance, args []uint64) ([]uint64, error) {
+		if len(ft.Results) == 0 {
+			return nil, nil
+		}
+		c.promises++
+		return []uint64{c.promises - 1}, nil
+	}
+}
+
+func (c *context) promiseResultsCount(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return []uint64{0}, nil
+}
+
+func (c *context) promiseResult(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	return nil, hostError(fmt.Sprintf("InvalidPromiseResultIndex { result_idx: %d }", args[0]))
+}
+
+// set stores value under key (or deletes key, if value is nil) and records
+// how to revert the change.
+func (c *context) set(key string, value []byte) {
+	a := c.account
+	old, ok := a.state[key]
+	usage := a.storageUsage
+	c.rc.undo = append(c.rc.undo, func() {
+		if ok {
+			a.state[key] = old
+		} else {
+			delete(a.state, key)
+		}
+		a.storageUsage = usage
+	})
+	if ok {
+		a.storageUsage -= uint64(len(key)+len(old)) + recordStorage
+	}
+	if value != nil {
+		a.state[key] = value
+		a.storageUsage += uint64(len(key)+len(value)) + recordStorage
+	} else {
+		delete(a.state, key)
+	}
+}
+
+func (c *context) storageWrite(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (key_len, key_ptr, value_len, value_ptr, register_id)
+	key, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.read(inst, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	err = c.useGasPerByte(extStorageWriteBase, uint64(len(key)), extStorageWriteKeyByte)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.useGas(mulGas(uint64(len(value)), extStorageWriteValueByte)); err != nil {
+		return nil, err
+	}
+	old, ok := c.account.state[string(key)]
+	c.set(string(key), value)
+	if !ok {
+		return []uint64{0}, nil
+	}
+	if err := c.useGas(mulGas(uint64(len(old)), extStorageWriteEvictedByte)); err != nil {
+		return nil, err
+	}
+	if err := c.setRegister(args[4], old); err != nil {
+		return nil, err
+	}
+	return []uint64{1}, nil
+}
+
+func (c *context) storageRead(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (key_len, key_ptr, register_id)
+	key, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = c.useGasPerByte(extStorageReadBase, uint64(len(key)), extStorageReadKeyByte)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := c.account.state[string(key)]
+	if !ok {
+		return []uint64{0}, nil
+	}
+	if err := c.useGas(mulGas(uint64(len(value)), extStorageReadValueByte)); err != nil {
+		return nil, err
+	}
+	if err := c.setRegister(args[2], value); err != nil {
+		return nil, err
+	}
+	return []uint64{1}, nil
+}
+
+func (c *context) storageRemove(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (key_len, key_ptr, register_id)
+	key, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = c.useGasPerByte(extStorageRemoveBase, uint64(len(key)), extStorageRemoveKeyByte)
+	if err != nil {
+		return nil, err
+	}
+	old, ok := c.account.state[string(key)]
+	if !ok {
+		return []uint64{0}, nil
+	}
+	if err := c.useGas(mulGas(uint64(len(old)), extStorageRemoveRetByte)); err != nil {
+		return nil, err
+	}
+	c.set(string(key), nil)
+	if err := c.setRegister(args[2], old); err != nil {
+		return nil, err
+	}
+	return []uint64{1}, nil
+}
+
+func (c *context) storageHasKey(inst *wasm.Instance, args []uint64) ([]uint64, error) {
+	// (key_len, key_ptr)
+	key, err := c.read(inst, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = c.useGasPerByte(extStorageHasKeyBase, uint64(len(key)), extStorageHasKeyByte)
+	if err != nil {
+		return nil, err
+	}
+	_, ok := c.account.state[string(key)]
+	if ok {
+		return []uint64{1}, nil
+	}
+	return []uint64{0}, nil
+}
@@ -0,0 +1,242 @@
+package nearvm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/util/wasm"
+	"github.com/aurora-is-near/evm-bully/util/wasm/wasmtest"
+	"github.com/ethereum/go-ethereum/crypto/bn256"
+)
+
+// newTestContext returns the context of a function call with prepaid gas in a
+// contract with one page of memory.
+func newTestContext(t *testing.T, gas uint64) (*context, *wasm.Instance) {
+	m, err := wasm.Parse(wasmtest.Module(wasmtest.Section(wasmtest.SectionMemory, []byte{0x00, 1})), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := wasm.Instantiate(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst.MaxSteps = gas / RegularOpCost
+	c := &context{
+		rt: New(),
+		rc: &receipt{
+			signerID:      "signer.test.near",
+			predecessorID: "predecessor.test.near",
+			receiverID:    testContract,
+		},
+		account:    newAccount(new(big.Int)),
+		inst:       inst,
+		deposit:    new(big.Int),
+		prepaidGas: gas,
+		registers:  make(map[uint64][]byte),
+	}
+	return c, inst
+}
+
+func TestContextAccounts(t *testing.T) {
+	c, inst := newTestContext(t, 300000000000000)
+	for i, f := range []hostFunc{
+		(*context).currentAccountID,
+		(*context).signerAccountID,
+		(*context).predecessorAccountID,
+	} {
+		if _, err := f(c, inst, []uint64{uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, exp := range []string{testContract, "signer.test.near", "predecessor.test.near"} {
+		if v := string(c.registers[uint64(i)]); v != exp {
+			t.Errorf("register %d = %s, expected %s", i, v, exp)
+		}
+	}
+}
+
+func TestExtCosts(t *testing.T) {
+	const (
+		readMemory    = extReadMemoryBase
+		writeRegister = extWriteRegisterBase
+	)
+	tests := []struct {
+		name string
+		f    hostFunc
+		args []uint64
+		gas  uint64
+	}{
+		{"gas", (*context).gas, []uint64{5}, 5 * RegularOpCost},
+		{"sha256", (*context).sha256, []uint64{10, 0, 0},
+			readMemory + 10*extReadMemoryByte +
+				extSHA256Base + 10*extSHA256Byte +
+				writeRegister + 32*extWriteRegisterByte},
+		{"ripemd160", (*context).ripemd160, []uint64{56, 0, 0},
+			readMemory + 56*extReadMemoryByte +
+				extRIPEMD160Base + 2*extRIPEMD160Block +
+				writeRegister + 20*extWriteRegisterByte},
+		{"log_utf8", (*context).logUTF8, []uint64{3, 0},
+			readMemory + 3*extReadMemoryByte +
+				extUTF8DecodingBase + 3*extUTF8DecodingByte +
+				extLogBase + 3*extLogByte},
+		{"storage_write", (*context).storageWrite, []uint64{1, 0, 2, 0, 0},
+			2*readMemory + 3*extReadMemoryByte +
+				extStorageWriteBase + extStorageWriteKeyByte + 2*extStorageWriteValueByte},
+		{"storage_write (evicting)", (*context).storageWrite, []uint64{1, 0, 3, 0, 0},
+			2*readMemory + 4*extReadMemoryByte +
+				extStorageWriteBase + extStorageWriteKeyByte + 3*extStorageWriteValueByte +
+				2*extStorageWriteEvictedByte + writeRegister + 2*extWriteRegisterByte},
+		{"storage_read", (*context).storageRead, []uint64{1, 0, 0},
+			readMemory + extReadMemoryByte +
+				extStorageReadBase + extStorageReadKeyByte + 3*extStorageReadValueByte +
+				writeRegister + 3*extWriteRegisterByte},
+		{"read_register", (*context).readRegister, []uint64{0, 0},
+			extReadRegisterBase + 3*extReadRegisterByte +
+				extWriteMemoryBase + 3*extWriteMemoryByte},
+		{"storage_remove", (*context).storageRemove, []uint64{1, 0, 0},
+			readMemory + extReadMemoryByte +
+				extStorageRemoveBase + extStorageRemoveKeyByte + 3*extStorageRemoveRetByte +
+				writeRegister + 3*extWriteRegisterByte},
+	}
+	c, inst := newTestContext(t, 300000000000000)
+	if err := inst.Write(0, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		used := c.usedGas()
+		if _, err := test.f(c, inst, test.args); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if gas := c.usedGas() - used; gas != test.gas {
+			t.Errorf("%s burnt %d gas, expected %d", test.name, gas, test.gas)
+		}
+	}
+	h := sha256.Sum256([]byte("abc"))
+	if _, err := (*context).sha256(c, inst, []uint64{3, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.registers[1], h[:]) {
+		t.Errorf("sha256() = %x, expected %x", c.registers[1], h)
+	}
+}
+
+func TestGasExceeded(t *testing.T) {
+	c, inst := newTestContext(t, extStorageWriteBase)
+	_, err := (*context).storageWrite(c, inst, []uint64{1, 0, 1, 0, 0})
+	if err != errGasExceeded {
+		t.Errorf("storage_write() = %v, expected %v", err, errGasExceeded)
+	}
+	if len(c.account.state) != 0 {
+		t.Error("storage_write() exceeding prepaid gas changed state")
+	}
+	// an overflowing length exceeds the gas as well
+	c, inst = newTestContext(t, 300000000000000)
+	if _, err := (*context).sha256(c, inst, []uint64{^uint64(0), 0, 0}); err != errGasExceeded {
+		t.Errorf("sha256() of huge value = %v, expected %v", err, errGasExceeded)
+	}
+}
+
+// encodeG2 encodes p in the format of the alt_bn128 host functions.
+func encodeG2(p *bn256.G2) []byte {
+	// (x.im, x.re, y.im, y.re) -> (x.re, x.im, y.re, y.im)
+	m := p.Marshal()
+	b := make([]byte, 0, g2Size)
+	b = append(b, m[32:64]...)
+	b = append(b, m[0:32]...)
+	b = append(b, m[96:128]...)
+	b = append(b, m[64:96]...)
+	return reverseWords(b)
+}
+
+// encodeScalar encodes s as 32-byte little-endian integer.
+func encodeScalar(s *big.Int) []byte {
+	b := make([]byte, 32)
+	s.FillBytes(b)
+	return reverseWords(b)
+}
+
+func g1(k int64) *bn256.G1 {
+	return new(bn256.G1).ScalarBaseMult(big.NewInt(k))
+}
+
+func g2(k int64) *bn256.G2 {
+	return new(bn256.G2).ScalarBaseMult(big.NewInt(k))
+}
+
+func concat(items ...[]byte) []byte {
+	var b []byte
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+func TestAltBn128(t *testing.T) {
+	zero := make([]byte, g1Size)
+	notOnCurve := make([]byte, g1Size)
+	notOnCurve[0] = 1
+	order := encodeScalar(bn256Order)
+	tests := []struct {
+		name  string
+		f     hostFunc
+		input []byte
+		exp   []byte // register 0 (or result of pairing check)
+		err   string
+	}{
+		{"multiexp", (*context).altBn128G1Multiexp,
+			concat(encodeG1(g1(3)), encodeScalar(big.NewInt(2)), encodeG1(g1(1)), encodeScalar(big.NewInt(5))),
+			encodeG1(g1(11)), ""},
+		{"multiexp (empty)", (*context).altBn128G1Multiexp, nil, zero, ""},
+		{"multiexp (invalid scalar)", (*context).altBn128G1Multiexp,
+			concat(encodeG1(g1(1)), order), nil, "invalid fr"},
+		{"multiexp (invalid length)", (*context).altBn128G1Multiexp,
+			encodeG1(g1(1)), nil, "cannot be split"},
+		{"sum", (*context).altBn128G1Sum,
+			concat([]byte{0}, encodeG1(g1(3)), []byte{0}, encodeG1(g1(4)), []byte{1}, encodeG1(g1(2))),
+			encodeG1(g1(5)), ""},
+		{"sum (zero)", (*context).altBn128G1Sum,
+			concat([]byte{0}, encodeG1(g1(3)), []byte{1}, encodeG1(g1(3)), []byte{0}, zero),
+			zero, ""},
+		{"sum (invalid sign)", (*context).altBn128G1Sum,
+			concat([]byte{2}, encodeG1(g1(3))), nil, "invalid bool"},
+		{"sum (invalid point)", (*context).altBn128G1Sum,
+			concat([]byte{0}, notOnCurve), nil, "invalid g1"},
+		{"pairing check", (*context).altBn128PairingCheck,
+			concat(encodeG1(g1(6)), encodeG2(g2(1)), encodeG1(g1(2)), encodeG2(g2(3)),
+				encodeG1(new(bn256.G1).Neg(g1(4))), encodeG2(g2(3))),
+			[]byte{1}, ""},
+		{"pairing check (fails)", (*context).altBn128PairingCheck,
+			concat(encodeG1(g1(6)), encodeG2(g2(1)), encodeG1(new(bn256.G1).Neg(g1(2))), encodeG2(g2(2))),
+			[]byte{0}, ""},
+		{"pairing check (empty)", (*context).altBn128PairingCheck, nil, []byte{1}, ""},
+		{"pairing check (invalid point)", (*context).altBn128PairingCheck,
+			concat(encodeG1(g1(1)), make([]byte, g2Size-1), []byte{1}), nil, "invalid g2"},
+	}
+	for _, test := range tests {
+		c, inst := newTestContext(t, 300000000000000)
+		if err := inst.Write(0, test.input); err != nil {
+			t.Fatal(err)
+		}
+		res, err := test.f(c, inst, []uint64{uint64(len(test.input)), 0, 0})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, expected %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		v := c.registers[0]
+		if res != nil {
+			v = []byte{byte(res[0])}
+		}
+		if !bytes.Equal(v, test.exp) {
+			t.Errorf("%s = %x, expected %x", test.name, v, test.exp)
+		}
+	}
+}
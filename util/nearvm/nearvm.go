@@ -0,0 +1,397 @@
+// Package nearvm runs NEAR contracts with the embedded wazero runtime.
+//
+// The runtime keeps accounts with their contract code and state in memory and
+// applies transactions immediately, one block per transaction. Contracts are
+// executed with the host functions of the NEAR runtime (registers, storage,
+// context, math, alt_bn128, logs, and panics). Promises are accepted but never
+// executed, contracts importing other host functions fail to link. Gas is
+// burnt per executed instruction, with the ext costs of nearcore for host
+// functions, and with the execution fees of actions (trie node costs are not
+// charged). Block heights, timestamps, random seeds, and burnt gas are derived
+// from the sequence of transactions, which makes runs deterministic. No NEAR
+// node is involved.
+package nearvm
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+
+	"github.com/aurora-is-near/evm-bully/util/wasm"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/near/borsh-go"
+)
+
+// Parameters of the runtime.
+const (
+	GenesisTimestamp = 1600000000000000000            // block timestamp at height 0 (in ns)
+	BlockTime        = 1000000000                     // time between blocks (in ns)
+	EpochLength      = 43200                          // blocks per epoch
+	RegularOpCost    = 822756                         // gas burnt per executed wasm instruction
+	TxGasBurnt       = 2428000000000                  // gas burnt for converting a transaction into a receipt
+	GasPrice         = 100000000                      // yoctoⓃ per gas unit
+	DefaultBalance   = "1000000000000000000000000000" // balance of added accounts (1000 Ⓝ)
+)
+
+// Storage usage accounting (as in nearcore).
+const (
+	accountStorage = 100 // bytes used by an account
+	recordStorage  = 40  // extra bytes used by each state record
+)
+
+type account struct {
+	balance      *big.Int
+	code         []byte
+	module       *wasm.Module // compiled code (nil, if not compiled yet)
+	state        map[string][]byte
+	storageUsage uint64
+}
+
+// A Runtime executes NEAR transactions in-process.
+type Runtime struct {
+	mu       sync.Mutex
+	accounts map[string]*account
+	height   uint64
+	nonce    uint64
+}
+
+// New returns a new runtime without accounts at block height 0.
+func New() *Runtime {
+	return &Runtime{accounts: make(map[string]*account)}
+}
+
+func newAccount(balance *big.Int) *account {
+	return &account{
+		balance:      balance,
+		state:        make(map[string][]byte),
+		storageUsage: accountStorage,
+	}
+}
+
+// AddAccount adds the account accountID with DefaultBalance, if it does not
+// exist yet.
+func (rt *Runtime) AddAccount(accountID string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.accounts[accountID] == nil {
+		balance, _ := new(big.Int).SetString(DefaultBalance, 10)
+		rt.accounts[accountID] = newAccount(balance)
+	}
+}
+
+// Height returns the current block height (the number of applied
+// transactions).
+func (rt *Runtime) Height() uint64 {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.height
+}
+
+// Code returns the contract code of accountID (nil, if none is deployed).
+func (rt *Runtime) Code(accountID string) []byte {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if a := rt.accounts[accountID]; a != nil {
+		return a.code
+	}
+	return nil
+}
+
+// CodeHash returns the hash of the contract code of accountID in the format
+// of 'view_code'.
+func (rt *Runtime) CodeHash(accountID string) string {
+	hash := sha256.Sum256(rt.Code(accountID))
+	return base58.Encode(hash[:])
+}
+
+// State returns the value stored under key in the state of accountID (nil, if
+// none).
+func (rt *Runtime) State(accountID string, key []byte) []byte {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if a := rt.accounts[accountID]; a != nil {
+		return a.state[string(key)]
+	}
+	return nil
+}
+
+// An Account signs transactions sent to the runtime. It can be used in place
+// of a near.Account.
+type Account struct {
+	rt        *Runtime
+	accountID string
+}
+
+// Account returns the account accountID of rt, which must have been added
+// before.
+func (rt *Runtime) Account(accountID string) *Account {
+	return &Account{rt: rt, accountID: accountID}
+}
+
+// SignAndSendTransaction applies a transaction with the given actions from a
+// to receiverID and returns the result in the format of 'broadcast_tx_commit'.
+// The actions are applied atomically: if one of them fails, the changes of
+// all of them are reverted.
+func (a *Account) SignAndSendTransaction(
+	receiverID string,
+	actions []near.Action,
+) (map[string]interface{}, error) {
+	return a.rt.apply(a.accountID, receiverID, actions)
+}
+
+// FunctionCall calls methodName on contractID with the given args, gas, and
+// deposit amount.
+func (a *Account) FunctionCall(
+	contractID, methodName string,
+	args []byte,
+	gas uint64,
+	amount big.Int,
+) (map[string]interface{}, error) {
+	return a.SignAndSendTransaction(contractID, []near.Action{{
+		Enum: 2,
+		FunctionCall: near.FunctionCall{
+			MethodName: methodName,
+			Args:       args,
+			Gas:        gas,
+			Deposit:    amount,
+		},
+	}})
+}
+
+// actionError is the kind of a failed action.
+type actionError map[string]interface{}
+
+func (e actionError) Error() string {
+	jsn, _ := json.Marshal(map[string]interface{}(e))
+	return string(jsn)
+}
+
+// A receipt collects the effects of the actions of a transaction.
+type receipt struct {
+	signerID      string
+	predecessorID string
+	receiverID    string
+	gasBurnt      uint64
+	value         []byte
+	logs          []string
+	undo          []func() // reverts the changes (in reverse order)
+}
+
+func (rc *receipt) revert() {
+	for i := len(rc.undo) - 1; i >= 0; i-- {
+		rc.undo[i]()
+	}
+	rc.undo = nil
+}
+
+// apply applies a transaction from signerID to receiverID with actions.
+func (rt *Runtime) apply(
+	signerID, receiverID string,
+	actions []near.Action,
+) (map[string]interface{}, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.accounts[signerID] == nil {
+		return nil, fmt.Errorf("nearvm: signer %s does not exist", signerID)
+	}
+	rt.height++
+	rt.nonce++
+	data, err := borsh.Serialize(near.Transaction{
+		SignerID:   signerID,
+		Nonce:      rt.nonce,
+		ReceiverID: receiverID,
+		Actions:    actions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	txHash := sha256.Sum256(data)
+	hash := base58.Encode(txHash[:])
+
+	// the receipt of a transaction has the signer as predecessor (receipts of
+	// promises, which have the calling contract as predecessor, are not
+	// executed)
+	rc := &receipt{
+		signerID:      signerID,
+		predecessorID: signerID,
+		receiverID:    receiverID,
+		gasBurnt:      actionReceiptCreationExecFee,
+	}
+	var failure interface{}
+	for i := range actions {
+		if err := rt.applyAction(rc, &actions[i]); err != nil {
+			rc.revert()
+			failure = map[string]interface{}{"ActionError": map[string]interface{}{
+				"index": i,
+				"kind":  err,
+			}}
+			break
+		}
+	}
+	var status map[string]interface{}
+	if failure != nil {
+		status = map[string]interface{}{"Failure": failure}
+	} else {
+		status = map[string]interface{}{"SuccessValue": base64.StdEncoding.EncodeToString(rc.value)}
+	}
+	receiptHash := sha256.Sum256([]byte("receipt:" + hash))
+	receiptID := base58.Encode(receiptHash[:])
+	return map[string]interface{}{
+		"status": status,
+		"transaction": map[string]interface{}{
+			"hash":        hash,
+			"signer_id":   signerID,
+			"receiver_id": receiverID,
+			"nonce":       rt.nonce,
+		},
+		"transaction_outcome": outcome(hash, TxGasBurnt, nil,
+			map[string]interface{}{"SuccessReceiptId": receiptID}),
+		"receipts_outcome": []interface{}{outcome(receiptID, rc.gasBurnt, rc.logs, status)},
+	}, nil
+}
+
+// outcome returns an execution outcome. The burnt gas is a json.Number (as
+// decoded from a JSON-RPC result with UseNumber), not a uint64.
+func outcome(id string, gasBurnt uint64, logs []string, status interface{}) map[string]interface{} {
+	tokens := new(big.Int).Mul(new(big.Int).SetUint64(gasBurnt), big.NewInt(GasPrice))
+	if logs == nil {
+		logs = []string{}
+	}
+	return map[string]interface{}{
+		"id": id,
+		"outcome": map[string]interface{}{
+			"gas_burnt":    json.Number(strconv.FormatUint(gasBurnt, 10)),
+			"tokens_burnt": tokens.String(),
+			"logs":         logs,
+			"receipt_ids":  []string{},
+			"status":       status,
+		},
+	}
+}
+
+// applyAction applies action to the receiver of rc.
+func (rt *Runtime) applyAction(rc *receipt, action *near.Action) error {
+	receiver := rt.accounts[rc.receiverID]
+	if receiver == nil && action.Enum != 0 {
+		return actionError{"AccountDoesNotExist": map[string]interface{}{"account_id": rc.receiverID}}
+	}
+	switch action.Enum {
+	case 0: // CreateAccount
+		rc.gasBurnt += createAccountExecFee
+		if receiver != nil {
+			return actionError{"AccountAlreadyExists": map[string]interface{}{"account_id": rc.receiverID}}
+		}
+		rt.accounts[rc.receiverID] = newAccount(new(big.Int))
+		rc.undo = append(rc.undo, func() { delete(rt.accounts, rc.receiverID) })
+	case 1: // DeployContract
+		rc.gasBurnt += deployContractExecFee + deployContractExecFeeByte*uint64(len(action.DeployContract.Code))
+		code, module, usage := receiver.code, receiver.module, receiver.storageUsage
+		rc.undo = append(rc.undo, func() {
+			receiver.code, receiver.module, receiver.storageUsage = code, module, usage
+		})
+		receiver.storageUsage += uint64(len(action.DeployContract.Code)) - uint64(len(code))
+		receiver.code = action.DeployContract.Code
+		receiver.module = nil
+	case 2: // FunctionCall
+		rc.gasBurnt += functionCallExecFee + functionCallExecFeeByte*
+			uint64(len(action.FunctionCall.MethodName)+len(action.FunctionCall.Args))
+		return rt.functionCall(rc, receiver, &action.FunctionCall)
+	case 3: // Transfer
+		rc.gasBurnt += transferExecFee
+		rt.transfer(rc, receiver, &action.Transfer.Deposit)
+	default:
+		return actionError{"UnsupportedAction": map[string]interface{}{"enum": action.Enum}}
+	}
+	return nil
+}
+
+// transfer adds deposit to the balance of receiver.
+func (rt *Runtime) transfer(rc *receipt, receiver *account, deposit *big.Int) {
+	if deposit.Sign() == 0 {
+		return
+	}
+	balance := receiver.balance
+	rc.undo = append(rc.undo, func() { receiver.balance = balance })
+	receiver.balance = new(big.Int).Add(balance, deposit)
+}
+
+// functionCall executes the function call fc on receiver.
+func (rt *Runtime) functionCall(rc *receipt, receiver *account, fc *near.FunctionCall) error {
+	executionError := func(msg string) error {
+		return actionError{"FunctionCallError": map[string]interface{}{"ExecutionError": msg}}
+	}
+	if len(receiver.code) == 0 {
+		return actionError{"FunctionCallError": map[string]interface{}{
+			"CompilationError": map[string]interface{}{
+				"CodeDoesNotExist": map[string]interface{}{"account_id": rc.receiverID},
+			},
+		}}
+	}
+	if receiver.module == nil {
+		m, err := wasm.Parse(receiver.code, MaxMemoryPages)
+		if errors.Is(err, wasm.ErrMemoryLimit) {
+			return executionError("CompilationError(PrepareError(Memory))")
+		} else if err != nil {
+			return executionError(fmt.Sprintf("CompilationError(PrepareError(Deserialization)): %s", err))
+		}
+		receiver.module = m
+	}
+	e, ok := receiver.module.Exports[fc.MethodName]
+	if !ok || e.Kind != wasm.ExternFunc {
+		return executionError("MethodResolveError(MethodNotFound)")
+	}
+	ft, err := receiver.module.Func(e.Index)
+	if err != nil || len(ft.Params) != 0 || len(ft.Results) != 0 {
+		return executionError("MethodResolveError(MethodInvalidSignature)")
+	}
+	rt.transfer(rc, receiver, &fc.Deposit)
+
+	c := &context{
+		rt:         rt,
+		rc:         rc,
+		account:    receiver,
+		input:      fc.Args,
+		deposit:    &fc.Deposit,
+		prepaidGas: fc.Gas,
+		registers:  make(map[uint64][]byte),
+	}
+	inst, err := wasm.Instantiate(receiver.module, c.imports(receiver.module))
+	if err != nil {
+		return executionError(fmt.Sprintf("LinkError { msg: %q }", err.Error()))
+	}
+	defer inst.Close()
+	c.inst = inst
+	inst.MaxSteps = fc.Gas / RegularOpCost
+	err = c.useGasPerByte(extContractLoadingBase, uint64(len(receiver.code)), extContractLoadingBytes)
+	if err == nil {
+		_, err = inst.Call(fc.MethodName)
+	}
+	rc.logs = append(rc.logs, c.logs...)
+	if errors.Is(err, wasm.ErrStepLimit) || errors.Is(err, errGasExceeded) {
+		rc.gasBurnt += fc.Gas
+		return executionError("Exceeded the prepaid gas.")
+	}
+	rc.gasBurnt += c.usedGas()
+	if err != nil {
+		var (
+			herr hostError
+			trap wasm.Trap
+		)
+		switch {
+		case errors.As(err, &herr):
+			return executionError(string(herr))
+		case errors.As(err, &trap):
+			return executionError("WebAssembly trap: " + string(trap))
+		default:
+			return executionError(err.Error())
+		}
+	}
+	rc.value = c.returnValue
+	return nil
+}
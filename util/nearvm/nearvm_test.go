@@ -0,0 +1,423 @@
+package nearvm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/util/aurora"
+	"github.com/aurora-is-near/evm-bully/util/wasm/wasmtest"
+	"github.com/aurora-is-near/near-api-go"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const testContract = "contract.test.near"
+
+// testCode returns a contract with the following methods:
+//
+//	echo: return the input
+//	store: store the input under "key"
+//	load: return the value stored under "key"
+//	fail: log and panic with "key"
+//	loop: loop forever
+//	promise: create a promise (which is ignored)
+func testCode() []byte {
+	k := wasmtest.I64Const
+	call := wasmtest.Call
+	drop, end := []byte{0x1a}, []byte{0x0b}
+	const keyPtr = 1024
+	i64 := byte(wasmtest.I64)
+	return wasmtest.Module(
+		wasmtest.Section(wasmtest.SectionType,
+			wasmtest.FuncType([]byte{i64}, nil),
+			wasmtest.FuncType([]byte{i64}, []byte{i64}),
+			wasmtest.FuncType([]byte{i64, i64}, nil),
+			wasmtest.FuncType([]byte{i64, i64, i64, i64, i64}, []byte{i64}),
+			wasmtest.FuncType([]byte{i64, i64, i64}, []byte{i64}),
+			wasmtest.FuncType(nil, nil),
+			wasmtest.FuncType([]byte{i64, i64}, []byte{i64})),
+		wasmtest.Section(wasmtest.SectionImport,
+			wasmtest.Import("env", "input", 0),
+			wasmtest.Import("env", "register_len", 1),
+			wasmtest.Import("env", "read_register", 2),
+			wasmtest.Import("env", "value_return", 2),
+			wasmtest.Import("env", "storage_write", 3),
+			wasmtest.Import("env", "storage_read", 4),
+			wasmtest.Import("env", "log_utf8", 2),
+			wasmtest.Import("env", "panic_utf8", 2),
+			wasmtest.Import("env", "promise_batch_create", 6)),
+		wasmtest.Section(wasmtest.SectionFunction,
+			wasmtest.ULEB(5), wasmtest.ULEB(5), wasmtest.ULEB(5),
+			wasmtest.ULEB(5), wasmtest.ULEB(5), wasmtest.ULEB(5)),
+		wasmtest.Section(wasmtest.SectionMemory, []byte{0x00, 1}),
+		wasmtest.Section(wasmtest.SectionExport,
+			wasmtest.Export("memory", 2, 0),
+			wasmtest.Export("echo", 0, 9),
+			wasmtest.Export("store", 0, 10),
+			wasmtest.Export("load", 0, 11),
+			wasmtest.Export("fail", 0, 12),
+			wasmtest.Export("loop", 0, 13),
+			wasmtest.Export("promise", 0, 14)),
+		wasmtest.Section(wasmtest.SectionCode,
+			wasmtest.Body(nil, // echo
+				k(0), call(0),
+				k(0), k(0), call(2),
+				k(0), call(1), k(0), call(3),
+				end),
+			wasmtest.Body(nil, // store
+				k(0), call(0),
+				k(0), k(0), call(2),
+				k(3), k(keyPtr), k(0), call(1), k(0), k(1), call(4), drop,
+				end),
+			wasmtest.Body(nil, // load
+				k(3), k(keyPtr), k(0), call(5), drop,
+				k(0), k(0), call(2),
+				k(0), call(1), k(0), call(3),
+				end),
+			wasmtest.Body(nil, // fail
+				k(3), k(keyPtr), call(6),
+				k(3), k(keyPtr), call(7),
+				end),
+			wasmtest.Body(nil, // loop
+				[]byte{0x03, 0x40, 0x0c, 0x00, 0x0b},
+				end),
+			wasmtest.Body(nil, // promise
+				k(3), k(keyPtr), call(8), drop,
+				end)),
+		wasmtest.Section(wasmtest.SectionData, wasmtest.Data(keyPtr, []byte("key"))),
+	)
+}
+
+func functionCall(method string, args []byte, gas uint64) near.Action {
+	return near.Action{
+		Enum: 2,
+		FunctionCall: near.FunctionCall{
+			MethodName: method,
+			Args:       args,
+			Gas:        gas,
+		},
+	}
+}
+
+// newTestRuntime returns a runtime with the test contract deployed to
+// testContract.
+func newTestRuntime(t *testing.T) (*Runtime, *Account) {
+	rt := New()
+	rt.AddAccount(testContract)
+	a := rt.Account(testContract)
+	res, err := a.SignAndSendTransaction(testContract, []near.Action{{
+		Enum:           1,
+		DeployContract: near.DeployContract{Code: testCode()},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	successValue(t, res)
+	return rt, a
+}
+
+// successValue returns the decoded return value of a successful transaction.
+func successValue(t *testing.T, res map[string]interface{}) []byte {
+	t.Helper()
+	status := res["status"].(map[string]interface{})
+	enc, ok := status["SuccessValue"].(string)
+	if !ok {
+		t.Fatalf("transaction failed: %s", failure(res))
+	}
+	value, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// failure returns the JSON encoded failure of a transaction (empty, if the
+// transaction succeeded).
+func failure(res map[string]interface{}) string {
+	status := res["status"].(map[string]interface{})
+	if status["Failure"] == nil {
+		return ""
+	}
+	jsn, _ := json.Marshal(status["Failure"])
+	return string(jsn)
+}
+
+func receiptOutcome(res map[string]interface{}) map[string]interface{} {
+	receipts := res["receipts_outcome"].([]interface{})
+	return receipts[0].(map[string]interface{})["outcome"].(map[string]interface{})
+}
+
+func TestFunctionCall(t *testing.T) {
+	rt, a := newTestRuntime(t)
+	res, err := a.FunctionCall(testContract, "echo", []byte("hello"), 300000000000000, *big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := successValue(t, res); string(v) != "hello" {
+		t.Errorf("echo() = %q, expected \"hello\"", v)
+	}
+	gasBurnt, err := strconv.ParseUint(receiptOutcome(res)["gas_burnt"].(json.Number).String(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gasBurnt <= actionReceiptCreationExecFee+functionCallExecFee {
+		t.Errorf("echo() burnt %d gas", gasBurnt)
+	}
+	for _, v := range []string{"v1", "v2"} {
+		res, err = a.FunctionCall(testContract, "store", []byte(v), 300000000000000, *big.NewInt(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		successValue(t, res)
+	}
+	res, err = a.FunctionCall(testContract, "load", nil, 300000000000000, *big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := successValue(t, res); string(v) != "v2" {
+		t.Errorf("load() = %q, expected \"v2\"", v)
+	}
+	if v := rt.State(testContract, []byte("key")); string(v) != "v2" {
+		t.Errorf("State() = %q, expected \"v2\"", v)
+	}
+	if h := rt.Height(); h != 5 {
+		t.Errorf("Height() = %d, expected 5", h)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	rt, a := newTestRuntime(t)
+	res, err := a.FunctionCall(testContract, "store", []byte("v1"), 300000000000000, *big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	successValue(t, res)
+	// the changes of a failed transaction are reverted
+	res, err = a.SignAndSendTransaction(testContract, []near.Action{
+		functionCall("store", []byte("v2"), 100000000000000),
+		functionCall("fail", nil, 100000000000000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"ActionError":{"index":1,"kind":{"FunctionCallError":{"ExecutionError":"Smart contract panicked: key"}}}}`
+	if f := failure(res); f != exp {
+		t.Errorf("failure %s, expected %s", f, exp)
+	}
+	if logs := receiptOutcome(res)["logs"].([]string); !reflect.DeepEqual(logs, []string{"key"}) {
+		t.Errorf("logs %v, expected [key]", logs)
+	}
+	if v := rt.State(testContract, []byte("key")); string(v) != "v1" {
+		t.Errorf("State() = %q after failure, expected \"v1\"", v)
+	}
+}
+
+func TestExecutionErrors(t *testing.T) {
+	_, a := newTestRuntime(t)
+	tests := []struct {
+		method string
+		gas    uint64
+		err    string
+	}{
+		{"loop", 1000 * RegularOpCost, "Exceeded the prepaid gas."},
+		{"store", extStorageWriteBase, "Exceeded the prepaid gas."}, // by storage_write
+		{"missing", 300000000000000, "MethodResolveError(MethodNotFound)"},
+		{"promise", 300000000000000, ""},
+	}
+	for _, test := range tests {
+		res, err := a.FunctionCall(testContract, test.method, nil, test.gas, *big.NewInt(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := failure(res)
+		if test.err == "" && f != "" || !strings.Contains(f, test.err) {
+			t.Errorf("%s(): failure %s, expected %q", test.method, f, test.err)
+		}
+	}
+	res, err := a.FunctionCall("missing.test.near", "echo", nil, 300000000000000, *big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := failure(res); !strings.Contains(f, "AccountDoesNotExist") {
+		t.Errorf("call of missing account: failure %s", f)
+	}
+	rt := New()
+	if _, err := rt.Account("missing.test.near").FunctionCall(testContract, "echo", nil, 1, *big.NewInt(0)); err == nil {
+		t.Error("FunctionCall() should fail for missing signer")
+	}
+}
+
+// gasBurnt returns the gas burnt by the receipt of a transaction.
+func gasBurnt(t *testing.T, res map[string]interface{}) uint64 {
+	t.Helper()
+	gas, err := strconv.ParseUint(receiptOutcome(res)["gas_burnt"].(json.Number).String(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gas
+}
+
+func TestGas(t *testing.T) {
+	// the same instructions are executed for values of different length,
+	// only the costs per byte differ
+	var gas [2]uint64
+	for i, v := range []string{"v", "value"} {
+		_, a := newTestRuntime(t)
+		res, err := a.FunctionCall(testContract, "store", []byte(v), 300000000000000, *big.NewInt(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		successValue(t, res)
+		gas[i] = gasBurnt(t, res)
+	}
+	perByte := uint64(functionCallExecFeeByte + // arguments
+		extWriteRegisterByte + // input
+		extReadRegisterByte + extWriteMemoryByte + // read_register
+		extReadMemoryByte + extStorageWriteValueByte) // storage_write
+	if d := gas[1] - gas[0]; d != 4*perByte {
+		t.Errorf("store() of 4 more bytes burnt %d more gas, expected %d", d, 4*perByte)
+	}
+	// all prepaid gas is burnt if it is exceeded
+	_, a := newTestRuntime(t)
+	res, err := a.FunctionCall(testContract, "store", []byte("v"), extStorageWriteBase, *big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := uint64(actionReceiptCreationExecFee + functionCallExecFee + 6*functionCallExecFeeByte + extStorageWriteBase)
+	if g := gasBurnt(t, res); g != exp {
+		t.Errorf("store() exceeding prepaid gas burnt %d gas, expected %d", g, exp)
+	}
+}
+
+// deploy deploys code to a new account and returns the account.
+func deploy(t *testing.T, rt *Runtime, accountID string, code []byte) *Account {
+	rt.AddAccount(accountID)
+	a := rt.Account(accountID)
+	res, err := a.SignAndSendTransaction(accountID, []near.Action{{
+		Enum:           1,
+		DeployContract: near.DeployContract{Code: code},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	successValue(t, res)
+	return a
+}
+
+func TestPrepareErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		err  string
+	}{
+		{
+			"unknown import",
+			wasmtest.Module(
+				wasmtest.Section(wasmtest.SectionType, wasmtest.FuncType(nil, nil)),
+				wasmtest.Section(wasmtest.SectionImport, wasmtest.Import("env", "storage_iter_next", 0)),
+				wasmtest.Section(wasmtest.SectionFunction, wasmtest.ULEB(0)),
+				wasmtest.Section(wasmtest.SectionExport, wasmtest.Export("main", 0, 1)),
+				wasmtest.Section(wasmtest.SectionCode, wasmtest.Body(nil, []byte{0x0b})),
+			),
+			`LinkError { msg: \"wasm: unknown import env.storage_iter_next\" }`,
+		},
+		{
+			"memory limit",
+			wasmtest.Module(
+				wasmtest.Section(wasmtest.SectionType, wasmtest.FuncType(nil, nil)),
+				wasmtest.Section(wasmtest.SectionFunction, wasmtest.ULEB(0)),
+				wasmtest.Section(wasmtest.SectionMemory, append([]byte{0x00}, wasmtest.ULEB(MaxMemoryPages+1)...)),
+				wasmtest.Section(wasmtest.SectionExport, wasmtest.Export("main", 0, 0)),
+				wasmtest.Section(wasmtest.SectionCode, wasmtest.Body(nil, []byte{0x0b})),
+			),
+			"CompilationError(PrepareError(Memory))",
+		},
+	}
+	for _, test := range tests {
+		rt := New()
+		a := deploy(t, rt, testContract, test.code)
+		res, err := a.FunctionCall(testContract, "main", nil, 300000000000000, *big.NewInt(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := failure(res); !strings.Contains(f, test.err) {
+			t.Errorf("%s: failure %s, expected %s", test.name, f, test.err)
+		}
+	}
+}
+
+func TestDeterminism(t *testing.T) {
+	var results [2][]byte
+	for i := range results {
+		_, a := newTestRuntime(t)
+		var all []map[string]interface{}
+		for _, method := range []string{"store", "load", "fail"} {
+			res, err := a.FunctionCall(testContract, method, []byte("v"), 300000000000000, *big.NewInt(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, res)
+		}
+		jsn, err := json.Marshal(all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results[i] = jsn
+	}
+	if string(results[0]) != string(results[1]) {
+		t.Errorf("runs differ:\n%s\n%s", results[0], results[1])
+	}
+}
+
+// TestAuroraEngine installs the aurora-engine build given by the environment
+// variable AURORA_ENGINE_WASM and submits a transaction.
+func TestAuroraEngine(t *testing.T) {
+	contract := os.Getenv("AURORA_ENGINE_WASM")
+	if contract == "" {
+		t.Skip("AURORA_ENGINE_WASM not set")
+	}
+	const engineID = "aurora.test.near"
+	chainID := big.NewInt(1313161556)
+	rt := New()
+	rt.AddAccount(engineID)
+	a := rt.Account(engineID)
+	if err := aurora.Install(a, engineID, "", chainID, contract); err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := types.SignTx(types.NewTransaction(0, common.Address{1}, new(big.Int), 21000, new(big.Int), nil),
+		types.LatestSignerForChainID(chainID), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rlp, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := a.FunctionCall(engineID, "submit", rlp, 300000000000000, *big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	successValue(t, res)
+	if gas := gasBurnt(t, res); gas >= 300000000000000 {
+		t.Errorf("submit() burnt %d gas, expected less than the prepaid gas", gas)
+	}
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	res, err = a.FunctionCall(engineID, "get_nonce", sender.Bytes(), 300000000000000, *big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce := new(big.Int).SetBytes(successValue(t, res)); nonce.Uint64() != 1 {
+		t.Errorf("get_nonce() = %s, expected 1", nonce)
+	}
+}
@@ -1,15 +1,331 @@
-// Package tar contains wrappers around some tar commands.
+// Package tar creates and extracts gzipped tar archives which contain a
+// manifest of the SHA-256 hashes of all archived files.
 package tar
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
-// Create creates a tar archive for the given dir.
-func Create(dir string) error {
-	cmd := exec.Command("tar", "cvzf", dir+".tar.gz", dir)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// ManifestFilename is the name of the manifest in the root directory of an
+// archive.
+const ManifestFilename = "manifest.json"
+
+// ManifestVersion is the current manifest version.
+const ManifestVersion = 1
+
+// ErrCorrupt is returned by Extract if an archive does not match its manifest.
+var ErrCorrupt = errors.New("tar: archive corrupt")
+
+// A ManifestEntry describes a single file in an archive.
+type ManifestEntry struct {
+	Path   string `json:"path"` // slash-separated path relative to root directory
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // hex encoded
+}
+
+// A Manifest lists all regular files in an archive (except the manifest).
+type Manifest struct {
+	Version int             `json:"version"`
+	Files   []ManifestEntry `json:"files"`
+}
+
+// A Writer writes a gzipped tar archive with a single root directory. The
+// manifest is written as the last file by Close.
+type Writer struct {
+	fp       *os.File
+	gw       *gzip.Writer
+	tw       *tar.Writer
+	root     string
+	manifest Manifest
+	modTime  time.Time
+}
+
+// Create creates the archive filename with the given root directory.
+func Create(filename, root string) (*Writer, error) {
+	if !validName(root) || strings.Contains(root, "/") {
+		return nil, fmt.Errorf("tar: invalid root directory '%s'", root)
+	}
+	fp, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		fp:       fp,
+		gw:       gzip.NewWriter(fp),
+		root:     root,
+		manifest: Manifest{Version: ManifestVersion},
+		modTime:  time.Now(),
+	}
+	w.tw = tar.NewWriter(w.gw)
+	if err := w.writeDir(""); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// validName reports whether name is a clean, relative, slash-separated path.
+func validName(name string) bool {
+	return name != "" && name != "." && !strings.HasPrefix(name, "/") &&
+		path.Clean(name) == name && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+func (w *Writer) writeDir(name string) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     path.Join(w.root, name) + "/",
+		Mode:     0755,
+		ModTime:  w.modTime,
+	})
+}
+
+// add writes the file name with the given mode and size from r.
+func (w *Writer) add(name string, mode fs.FileMode, size int64, r io.Reader) error {
+	if !validName(name) || name == ManifestFilename {
+		return fmt.Errorf("tar: invalid file name '%s'", name)
+	}
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(w.root, name),
+		Mode:     int64(mode.Perm()),
+		Size:     size,
+		ModTime:  w.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(w.tw, io.TeeReader(r, h))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("tar: file '%s' changed size while archiving", name)
+	}
+	w.manifest.Files = append(w.manifest.Files, ManifestEntry{
+		Path:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	})
+	return nil
+}
+
+// AddFile adds a file with the given name and data to the archive.
+func (w *Writer) AddFile(name string, data []byte, mode fs.FileMode) error {
+	return w.add(name, mode, int64(len(data)), bytes.NewReader(data))
+}
+
+// AddDir adds the directory dir recursively to the archive as name.
+// Only directories and regular files are supported.
+func (w *Writer) AddDir(name, dir string) error {
+	if !validName(name) {
+		return fmt.Errorf("tar: invalid directory name '%s'", name)
+	}
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		entry := path.Join(name, filepath.ToSlash(rel))
+		if d.IsDir() {
+			return w.writeDir(entry)
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("tar: '%s' is not a regular file", p)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fp, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		return w.add(entry, info.Mode(), info.Size(), fp)
+	})
+}
+
+// Close writes the manifest and closes the archive.
+func (w *Writer) Close() error {
+	defer w.fp.Close()
+	jsn, err := json.MarshalIndent(&w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(w.root, ManifestFilename),
+		Mode:     0644,
+		Size:     int64(len(jsn)),
+		ModTime:  w.modTime,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(jsn); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if err := w.gw.Close(); err != nil {
+		return err
+	}
+	return w.fp.Close()
+}
+
+// Extract extracts the archive filename into destDir and verifies all
+// extracted files against the manifest of the archive. It returns the name
+// of the root directory of the archive (in destDir). If the archive does not
+// match its manifest, an error wrapping ErrCorrupt is returned.
+func Extract(filename, destDir string) (string, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	gr, err := gzip.NewReader(fp)
+	if err != nil {
+		return "", err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	var (
+		root     string
+		manifest *Manifest
+		files    = make(map[string]ManifestEntry)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !validName(name) {
+			return "", fmt.Errorf("%w: invalid file name '%s'", ErrCorrupt, hdr.Name)
+		}
+		parts := strings.SplitN(name, "/", 2)
+		if root == "" {
+			root = parts[0]
+		} else if parts[0] != root {
+			return "", fmt.Errorf("%w: '%s' is not in root directory '%s'",
+				ErrCorrupt, hdr.Name, root)
+		}
+		dst := filepath.Join(destDir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return "", err
+			}
+		case tar.TypeReg:
+			if len(parts) != 2 {
+				return "", fmt.Errorf("%w: '%s' is not a directory", ErrCorrupt, hdr.Name)
+			}
+			rel := parts[1]
+			if _, ok := files[rel]; ok {
+				return "", fmt.Errorf("%w: duplicate file '%s'", ErrCorrupt, hdr.Name)
+			}
+			if rel == ManifestFilename {
+				jsn, err := io.ReadAll(tr)
+				if err != nil {
+					return "", err
+				}
+				manifest = new(Manifest)
+				if err := json.Unmarshal(jsn, manifest); err != nil {
+					return "", fmt.Errorf("%w: cannot parse manifest: %s", ErrCorrupt, err)
+				}
+				if err := os.WriteFile(dst, jsn, 0644); err != nil {
+					return "", err
+				}
+				files[rel] = ManifestEntry{}
+				continue
+			}
+			entry, err := extractFile(dst, hdr, tr)
+			if err != nil {
+				return "", err
+			}
+			entry.Path = rel
+			files[rel] = entry
+		default:
+			return "", fmt.Errorf("%w: '%s' is not a regular file or directory",
+				ErrCorrupt, hdr.Name)
+		}
+	}
+	if err := verify(manifest, files); err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+func extractFile(dst string, hdr *tar.Header, r io.Reader) (ManifestEntry, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return ManifestEntry{}, err
+	}
+	fp, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fs.FileMode(hdr.Mode).Perm())
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer fp.Close()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(fp, h), r)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	if err := fp.Close(); err != nil {
+		return ManifestEntry{}, err
+	}
+	return ManifestEntry{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// verify verifies the extracted files against manifest.
+func verify(manifest *Manifest, files map[string]ManifestEntry) error {
+	if manifest == nil {
+		return fmt.Errorf("%w: manifest missing", ErrCorrupt)
+	}
+	if manifest.Version != ManifestVersion {
+		return fmt.Errorf("%w: unsupported manifest version %d", ErrCorrupt, manifest.Version)
+	}
+	delete(files, ManifestFilename)
+	for _, want := range manifest.Files {
+		got, ok := files[want.Path]
+		if !ok {
+			return fmt.Errorf("%w: file '%s' missing", ErrCorrupt, want.Path)
+		}
+		if got.Size != want.Size || got.SHA256 != want.SHA256 {
+			return fmt.Errorf("%w: file '%s' does not match manifest", ErrCorrupt, want.Path)
+		}
+		delete(files, want.Path)
+	}
+	if len(files) > 0 {
+		var extra []string
+		for name := range files {
+			extra = append(extra, name)
+		}
+		sort.Strings(extra)
+		return fmt.Errorf("%w: files not in manifest: %s", ErrCorrupt, strings.Join(extra, ", "))
+	}
+	return nil
 }
@@ -0,0 +1,171 @@
+package tar
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createTestArchive(t *testing.T, filename string) {
+	t.Helper()
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "data", "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "data", "db"), []byte("database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Create(filename, "bp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile("breakpoint.json", []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddDir("local", src); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateExtract(t *testing.T) {
+	tmpdir := t.TempDir()
+	filename := filepath.Join(tmpdir, "bp.tar.gz")
+	createTestArchive(t, filename)
+	dst := filepath.Join(tmpdir, "dst")
+	root, err := Extract(filename, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != "bp" {
+		t.Errorf("Extract() root = %s, expected bp", root)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "bp", "local", "data", "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "database" {
+		t.Errorf("extracted file = %q, expected %q", data, "database")
+	}
+	fi, err := os.Stat(filepath.Join(dst, "bp", "local", "data", "empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Error("empty directory not extracted")
+	}
+	for _, name := range []string{"breakpoint.json", ManifestFilename} {
+		if _, err := os.Stat(filepath.Join(dst, "bp", name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// rewriteArchive rewrites the archive filename and calls modify for every
+// header and contents (contents is replaced by the returned value, the entry
+// is dropped if ok is false).
+func rewriteArchive(
+	t *testing.T,
+	filename string,
+	modify func(hdr *tar.Header, data []byte) (newData []byte, ok bool),
+) {
+	t.Helper()
+	fp, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type entry struct {
+		hdr  *tar.Header
+		data []byte
+	}
+	var entries []entry
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, ok := modify(hdr, data); ok {
+			hdr.Size = int64(len(data))
+			entries = append(entries, entry{hdr, data})
+		}
+	}
+	fp.Close()
+	fp, err = os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	gw := gzip.NewWriter(fp)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		if err := tw.WriteHeader(e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gw.Close()
+}
+
+func TestExtractCorrupt(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(hdr *tar.Header, data []byte) ([]byte, bool)
+	}{
+		{"modified file", func(hdr *tar.Header, data []byte) ([]byte, bool) {
+			if hdr.Name == "bp/local/data/db" {
+				return []byte("databasf"), true
+			}
+			return data, true
+		}},
+		{"missing file", func(hdr *tar.Header, data []byte) ([]byte, bool) {
+			return data, hdr.Name != "bp/breakpoint.json"
+		}},
+		{"missing manifest", func(hdr *tar.Header, data []byte) ([]byte, bool) {
+			return data, hdr.Name != "bp/"+ManifestFilename
+		}},
+		{"path traversal", func(hdr *tar.Header, data []byte) ([]byte, bool) {
+			if hdr.Name == "bp/breakpoint.json" {
+				hdr.Name = "bp/../breakpoint.json"
+			}
+			return data, true
+		}},
+		{"symlink", func(hdr *tar.Header, data []byte) ([]byte, bool) {
+			if hdr.Name == "bp/breakpoint.json" {
+				hdr.Typeflag = tar.TypeSymlink
+				hdr.Linkname = "/etc/passwd"
+				return nil, true
+			}
+			return data, true
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpdir := t.TempDir()
+			filename := filepath.Join(tmpdir, "bp.tar.gz")
+			createTestArchive(t, filename)
+			rewriteArchive(t, filename, tt.modify)
+			_, err := Extract(filename, filepath.Join(tmpdir, "dst"))
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Extract() error = %v, expected %v", err, ErrCorrupt)
+			}
+		})
+	}
+}
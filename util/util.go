@@ -8,7 +8,8 @@ import (
 )
 
 // DetermineCacheDir determines the evm-bully cache directory:
-//  ~/.config/evm-bully/tetstnet
+//
+//	~/.config/evm-bully/tetstnet
 func DetermineCacheDir(testnet string) (string, error) {
 	homeDir := homedir.Get("evm-bully")
 	cacheDir := filepath.Join(homeDir, testnet)
@@ -0,0 +1,250 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// PageSize is the size of a memory page.
+const PageSize = 65536
+
+// A HostFunc implements an imported function. Arguments and results are
+// passed as raw bits (i32 values are zero-extended). An error aborts the
+// execution and is returned by Call.
+type HostFunc func(inst *Instance, args []uint64) ([]uint64, error)
+
+// A Trap is a runtime error of WebAssembly code.
+type Trap string
+
+// Error returns the error message of the trap.
+func (t Trap) Error() string {
+	return "wasm trap: " + string(t)
+}
+
+// ErrStepLimit is returned by Call if the instruction limit (MaxSteps) is
+// exceeded.
+var ErrStepLimit = Trap("instruction limit exceeded")
+
+var errMemory = Trap("out of bounds memory access")
+
+// An Instance is an instantiated module.
+type Instance struct {
+	module  *Module
+	host    []HostFunc
+	ctx     context.Context
+	mod     api.Module
+	steps   api.MutableGlobal
+	limit   api.MutableGlobal
+	hostErr error // error of the last host function call
+
+	// MaxSteps limits the number of instructions executed by a single Call
+	// (0 means no limit). Instructions are counted at the start of each
+	// straight-line sequence, a call fails before executing a sequence which
+	// exceeds the limit. MaxSteps can be changed by host functions.
+	MaxSteps uint64
+}
+
+type instanceKey struct{}
+
+// instantiateHost instantiates the host modules providing the imported
+// functions of m. They call the functions passed to Instantiate of the
+// calling instance.
+func (m *Module) instantiateHost(ctx context.Context) error {
+	builders := make(map[string]wazero.HostModuleBuilder)
+	var names []string
+	idx := 0
+	for _, imp := range m.Imports {
+		if imp.Kind != ExternFunc {
+			continue
+		}
+		b, ok := builders[imp.Module]
+		if !ok {
+			b = m.rt.NewHostModuleBuilder(imp.Module)
+			builders[imp.Module] = b
+			names = append(names, imp.Module)
+		}
+		ft := &m.Types[imp.Type]
+		i := idx
+		f := func(ctx context.Context, mod api.Module, stack []uint64) {
+			inst := ctx.Value(instanceKey{}).(*Instance)
+			if inst.mod == nil {
+				inst.mod = mod // called by the start function
+			}
+			res, err := inst.host[i](inst, zeroExtend(ft.Params, stack[:len(ft.Params)]))
+			if err == nil && len(res) != len(ft.Results) {
+				err = fmt.Errorf("wasm: host function %s.%s returned %d results, want %d",
+					imp.Module, imp.Name, len(res), len(ft.Results))
+			}
+			if err != nil {
+				inst.hostErr = err
+				panic(err)
+			}
+			copy(stack, res)
+			inst.setLimit()
+		}
+		b.NewFunctionBuilder().
+			WithGoModuleFunction(api.GoModuleFunc(f), valueTypes(ft.Params), valueTypes(ft.Results)).
+			Export(imp.Name)
+		idx++
+	}
+	for _, name := range names {
+		if _, err := builders[name].Instantiate(ctx); err != nil {
+			return fmt.Errorf("wasm: %w", err)
+		}
+	}
+	return nil
+}
+
+// zeroExtend returns a copy of the values of the given types with cleared
+// upper bits of 32-bit values, which wazero leaves undefined.
+func zeroExtend(types []ValType, vals []uint64) []uint64 {
+	res := make([]uint64, len(vals))
+	for i, v := range vals {
+		if types[i] == I32 || types[i] == F32 {
+			v = uint64(uint32(v))
+		}
+		res[i] = v
+	}
+	return res
+}
+
+func valueTypes(types []ValType) []api.ValueType {
+	vt := make([]api.ValueType, len(types))
+	for i, t := range types {
+		vt[i] = api.ValueType(t)
+	}
+	return vt
+}
+
+// Instantiate instantiates module m. The imported functions are looked up in
+// imports with "module.name" keys. Imported memories are created with the
+// limits of the import.
+func Instantiate(m *Module, imports map[string]HostFunc) (*Instance, error) {
+	inst := &Instance{module: m}
+	for _, imp := range m.Imports {
+		if imp.Kind != ExternFunc {
+			continue
+		}
+		f, ok := imports[imp.Module+"."+imp.Name]
+		if !ok {
+			return nil, fmt.Errorf("wasm: unknown import %s.%s", imp.Module, imp.Name)
+		}
+		inst.host = append(inst.host, f)
+	}
+	inst.ctx = context.WithValue(context.Background(), instanceKey{}, inst)
+	mod, err := m.rt.InstantiateModule(inst.ctx, m.compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions())
+	if err != nil {
+		if inst.hostErr != nil {
+			return nil, inst.hostErr
+		}
+		return nil, fmt.Errorf("wasm: %w", toTrap(err))
+	}
+	inst.mod = mod
+	inst.steps = mod.ExportedGlobal(stepsGlobal).(api.MutableGlobal)
+	inst.limit = mod.ExportedGlobal(limitGlobal).(api.MutableGlobal)
+	return inst, nil
+}
+
+// toTrap returns the trap of the runtime error err of wazero, which may be
+// followed by a stack trace.
+func toTrap(err error) Trap {
+	msg := strings.TrimPrefix(err.Error(), "wasm error: ")
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg = msg[:i]
+	}
+	return Trap(msg)
+}
+
+// Close releases the resources of the instance.
+func (inst *Instance) Close() error {
+	return inst.mod.Close(inst.ctx)
+}
+
+// Module returns the module of the instance.
+func (inst *Instance) Module() *Module {
+	return inst.module
+}
+
+// Memory returns the linear memory of the instance.
+func (inst *Instance) Memory() []byte {
+	mem := inst.mod.Memory()
+	if mem == nil {
+		return nil
+	}
+	b, _ := mem.Read(0, mem.Size())
+	return b
+}
+
+// Read returns a copy of n bytes of the memory at ptr.
+func (inst *Instance) Read(ptr, n uint64) ([]byte, error) {
+	mem := inst.Memory()
+	if ptr+n < ptr || ptr+n > uint64(len(mem)) {
+		return nil, errMemory
+	}
+	b := make([]byte, n)
+	copy(b, mem[ptr:])
+	return b, nil
+}
+
+// Write copies data to the memory at ptr.
+func (inst *Instance) Write(ptr uint64, data []byte) error {
+	mem := inst.Memory()
+	n := uint64(len(data))
+	if ptr+n < ptr || ptr+n > uint64(len(mem)) {
+		return errMemory
+	}
+	copy(mem[ptr:], data)
+	return nil
+}
+
+// Steps returns the number of instructions executed by the last Call.
+func (inst *Instance) Steps() uint64 {
+	return inst.steps.Get()
+}
+
+func (inst *Instance) setLimit() {
+	if inst.limit == nil {
+		return // start function
+	}
+	if inst.MaxSteps == 0 {
+		inst.limit.Set(math.MaxUint64)
+	} else {
+		inst.limit.Set(inst.MaxSteps)
+	}
+}
+
+// Call calls the exported function name with args and returns its results.
+func (inst *Instance) Call(name string, args ...uint64) ([]uint64, error) {
+	e, ok := inst.module.Exports[name]
+	if !ok || e.Kind != ExternFunc {
+		return nil, fmt.Errorf("wasm: unknown function export '%s'", name)
+	}
+	ft, err := inst.module.Func(e.Index)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(ft.Params) {
+		return nil, fmt.Errorf("wasm: %s: got %d arguments, want %d",
+			name, len(args), len(ft.Params))
+	}
+	inst.steps.Set(0)
+	inst.setLimit()
+	inst.hostErr = nil
+	res, err := inst.mod.ExportedFunction(name).Call(inst.ctx, args...)
+	if err != nil {
+		switch {
+		case inst.hostErr != nil:
+			return nil, inst.hostErr
+		case inst.Steps() > inst.limit.Get():
+			return nil, ErrStepLimit
+		}
+		return nil, toTrap(err)
+	}
+	return zeroExtend(ft.Results, res), nil
+}
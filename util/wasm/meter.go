@@ -0,0 +1,368 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Names of the exported globals added by instrument, which count the executed
+// instructions and hold the limit of the count.
+const (
+	stepsGlobal = "\x00steps"
+	limitGlobal = "\x00limit"
+)
+
+func appendULEB(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+		} else {
+			return append(b, c)
+		}
+	}
+}
+
+func appendSLEB(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendName(b []byte, s string) []byte {
+	return append(appendULEB(b, uint64(len(s))), s...)
+}
+
+// appendVec appends a vector of n items, of which the first ones are
+// contained in the (encoded) vector prefix, followed by the encoded items.
+func appendVec(b, prefix []byte, items ...[]byte) ([]byte, error) {
+	r := &reader{b: prefix}
+	var n uint32
+	if len(prefix) > 0 {
+		var err error
+		if n, err = r.u32(); err != nil {
+			return nil, err
+		}
+	}
+	b = appendULEB(b, uint64(n)+uint64(len(items)))
+	b = append(b, prefix[r.pos:]...)
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b, nil
+}
+
+func appendLimits(b []byte, l Limits) []byte {
+	if !l.HasMax {
+		return appendULEB(append(b, 0x00), uint64(l.Min))
+	}
+	b = appendULEB(append(b, 0x01), uint64(l.Min))
+	return appendULEB(b, uint64(l.Max))
+}
+
+// instrument returns the code of m with an instruction counter. Two mutable
+// i64 globals are added and exported: the number of executed instructions
+// (stepsGlobal) and its limit (limitGlobal). Every function body is divided
+// into straight-line sequences, which end with a control instruction, and
+// each sequence starts by adding its number of instructions to the counter
+// and trapping if the limit is exceeded. An imported memory is turned into a
+// defined memory and the maximum of the memory is lowered to maxPages.
+func (m *Module) instrument(maxPages uint32) ([]byte, error) {
+	sections := make(map[byte][]byte)
+	for id, data := range m.sections {
+		sections[id] = data
+	}
+	if l, ok := m.Memory(); ok {
+		if !l.HasMax || l.Max > maxPages {
+			l.HasMax, l.Max = true, maxPages
+		}
+		sections[sectionMemory], _ = appendVec(nil, nil, appendLimits(nil, l))
+	}
+	if _, ok := sections[sectionImport]; ok {
+		var imports [][]byte
+		for _, imp := range m.Imports {
+			if imp.Kind == ExternFunc {
+				b := appendName(appendName(nil, imp.Module), imp.Name)
+				imports = append(imports, appendULEB(append(b, ExternFunc), uint64(imp.Type)))
+			}
+		}
+		sections[sectionImport], _ = appendVec(nil, nil, imports...)
+	}
+	// the counter and its limit (no limit before the first call)
+	steps, limit := m.nGlobals, m.nGlobals+1
+	var err error
+	sections[sectionGlobal], err = appendVec(nil, sections[sectionGlobal],
+		[]byte{byte(I64), 1, opI64Const, 0, opEnd},
+		[]byte{byte(I64), 1, opI64Const, 0x7f, opEnd})
+	if err != nil {
+		return nil, err
+	}
+	sections[sectionExport], err = appendVec(nil, sections[sectionExport],
+		appendULEB(append(appendName(nil, stepsGlobal), ExternGlobal), uint64(steps)),
+		appendULEB(append(appendName(nil, limitGlobal), ExternGlobal), uint64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := sections[sectionCode]; ok {
+		if sections[sectionCode], err = m.meterCode(data, steps, limit); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := sections[sectionDataCount]; ok {
+		if err := m.dropActiveData(sections); err != nil {
+			return nil, err
+		}
+	}
+	code := []byte("\x00asm\x01\x00\x00\x00")
+	for _, id := range sectionOrder {
+		data, ok := sections[id]
+		if !ok {
+			continue
+		}
+		code = appendULEB(append(code, id), uint64(len(data)))
+		code = append(code, data...)
+	}
+	return code, nil
+}
+
+// dropActiveData adds a start function which drops the active data segments,
+// as required by the bulk memory operations. wazero keeps them after
+// instantiation. The start function of the module is called afterwards.
+func (m *Module) dropActiveData(sections map[byte][]byte) error {
+	r := &reader{b: sections[sectionData]}
+	var active []uint32
+	var i uint32
+	err := r.count(func() error {
+		flags, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if flags == 2 {
+			if _, err := r.u32(); err != nil { // memory index
+				return err
+			}
+		}
+		if flags == 0 || flags == 2 {
+			if err := r.constExpr(); err != nil {
+				return err
+			}
+			active = append(active, i)
+		}
+		n, err := r.u32()
+		if err != nil {
+			return err
+		}
+		_, err = r.bytes(n)
+		i++
+		return err
+	})
+	if err != nil || len(active) == 0 {
+		return err
+	}
+	// the type of the start function
+	typ := uint32(len(m.Types))
+	for i, t := range m.Types {
+		if len(t.Params) == 0 && len(t.Results) == 0 {
+			typ = uint32(i)
+			break
+		}
+	}
+	if typ == uint32(len(m.Types)) {
+		if sections[sectionType], err = appendVec(nil, sections[sectionType], []byte{0x60, 0, 0}); err != nil {
+			return err
+		}
+	}
+	body := []byte{0} // no locals
+	for _, i := range active {
+		body = appendULEB(append(body, opPrefixMisc), uint64(opDataDrop-opMisc))
+		body = appendULEB(body, uint64(i))
+	}
+	if start, ok := sections[sectionStart]; ok {
+		body = append(append(body, opCall), start...)
+	}
+	body = append(body, opEnd)
+	if sections[sectionFunction], err = appendVec(nil, sections[sectionFunction], appendULEB(nil, uint64(typ))); err != nil {
+		return err
+	}
+	if sections[sectionCode], err = appendVec(nil, sections[sectionCode], append(appendULEB(nil, uint64(len(body))), body...)); err != nil {
+		return err
+	}
+	sections[sectionStart] = appendULEB(nil, uint64(m.nFuncs+len(m.funcs)))
+	return nil
+}
+
+// meterCode returns the code section data with metered function bodies.
+func (m *Module) meterCode(data []byte, steps, limit uint32) ([]byte, error) {
+	r := &reader{b: data}
+	var bodies [][]byte
+	err := r.count(func() error {
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		body, err := r.bytes(size)
+		if err != nil {
+			return err
+		}
+		metered, err := meter(body, steps, limit)
+		if err != nil {
+			return fmt.Errorf("function %d: %w", m.nFuncs+len(bodies), err)
+		}
+		bodies = append(bodies, append(appendULEB(nil, uint64(len(metered))), metered...))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !r.eof() {
+		return nil, errors.New("section size mismatch")
+	}
+	if len(bodies) != len(m.funcs) {
+		return nil, errors.New("function and code section have inconsistent lengths")
+	}
+	return appendVec(nil, nil, bodies...)
+}
+
+// charge returns the instructions adding n to the counter and trapping if
+// the limit is exceeded.
+func charge(n int, steps, limit uint32) []byte {
+	b := appendULEB([]byte{opGlobalGet}, uint64(steps))
+	b = appendSLEB(append(b, opI64Const), int64(n))
+	b = append(b, opI64Add, opGlobalSet)
+	b = appendULEB(b, uint64(steps))
+	b = appendULEB(append(b, opGlobalGet), uint64(steps))
+	b = appendULEB(append(b, opGlobalGet), uint64(limit))
+	return append(b, opI64GtU, opIf, 0x40, opUnreachable, opEnd)
+}
+
+// meter returns the function body with a charge at the start of each
+// straight-line sequence of instructions. A sequence ends after a block,
+// loop, if, else, end, branch, return, or unreachable instruction, which
+// makes every branch target the start of a sequence.
+func meter(body []byte, steps, limit uint32) ([]byte, error) {
+	r := &reader{b: body}
+	err := r.count(func() error {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+		_, err := r.valType()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	metered := append([]byte{}, body[:r.pos]...)
+	start, n, depth := r.pos, 0, 0
+	for {
+		if r.eof() {
+			return nil, errors.New("unexpected end of function")
+		}
+		op, _ := r.byte()
+		if err := r.immediates(op); err != nil {
+			return nil, err
+		}
+		n++
+		switch op {
+		case opBlock, opLoop, opIf:
+			depth++
+		case opEnd:
+			depth--
+		case opElse, opBr, opBrIf, opBrTable, opReturn, opUnreachable:
+		default:
+			continue
+		}
+		metered = append(metered, charge(n, steps, limit)...)
+		metered = append(metered, body[start:r.pos]...)
+		start, n = r.pos, 0
+		if depth < 0 {
+			if !r.eof() {
+				return nil, errors.New("operators remaining after end of function")
+			}
+			return metered, nil
+		}
+	}
+}
+
+// immediates skips the immediates of the instruction op.
+func (r *reader) immediates(op byte) error {
+	var err error
+	switch {
+	case op == opBlock || op == opLoop || op == opIf:
+		if r.eof() {
+			return errEOF
+		}
+		if c := r.b[r.pos]; c == 0x40 || c >= 0x6f {
+			r.pos++
+		} else {
+			_, err = r.sleb(33)
+		}
+	case op == opBr || op == opBrIf || op == opCall || op == opLocalGet ||
+		op == opLocalSet || op == opLocalTee || op == opGlobalGet ||
+		op == opGlobalSet || op == opTableGet || op == opTableSet ||
+		op == opRefFunc || op == opMemorySize || op == opMemoryGrow:
+		_, err = r.u32()
+	case op == opBrTable:
+		if _, err = r.indices(); err == nil {
+			_, err = r.u32()
+		}
+	case op == opCallIndirect:
+		if _, err = r.u32(); err == nil {
+			_, err = r.u32()
+		}
+	case op == opSelectT:
+		_, err = r.valTypes()
+	case op >= opI32Load && op <= opI64Store32:
+		if _, err = r.u32(); err == nil { // alignment
+			_, err = r.u32()
+		}
+	case op == opI32Const:
+		_, err = r.sleb(32)
+	case op == opI64Const:
+		_, err = r.sleb(64)
+	case op == opF32Const:
+		_, err = r.bytes(4)
+	case op == opF64Const:
+		_, err = r.bytes(8)
+	case op == opRefNull:
+		_, err = r.byte()
+	case op == opPrefixMisc:
+		err = r.miscImmediates()
+	case op == opUnreachable || op == opNop || op == opElse || op == opEnd ||
+		op == opReturn || op == opDrop || op == opSelect || op == opRefIsNull ||
+		(op >= opI32Eqz && op <= opI64Extend32S):
+	default:
+		return fmt.Errorf("unknown opcode 0x%02x", op)
+	}
+	return err
+}
+
+// miscImmediates skips the immediates of an instruction with the 0xfc prefix.
+func (r *reader) miscImmediates() error {
+	sub, err := r.u32()
+	if err != nil {
+		return err
+	}
+	var n int // number of immediates
+	switch opMisc + uint16(sub) {
+	case opI32TruncSatF32S, opI32TruncSatF32U, opI32TruncSatF64S, opI32TruncSatF64U,
+		opI64TruncSatF32S, opI64TruncSatF32U, opI64TruncSatF64S, opI64TruncSatF64U:
+	case opDataDrop, opElemDrop, opMemoryFill, opTableGrow, opTableSize, opTableFill:
+		n = 1
+	case opMemoryInit, opMemoryCopy, opTableInit, opTableCopy:
+		n = 2
+	default:
+		return fmt.Errorf("unknown opcode 0xfc %d", sub)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
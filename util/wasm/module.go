@@ -0,0 +1,585 @@
+// Package wasm runs WebAssembly modules with the wazero runtime and meters
+// the executed instructions.
+//
+// Modules are decoded, instrumented with an instruction counter (a charge at
+// the start of every straight-line sequence of instructions, as done by NEAR
+// for gas metering), and then validated and compiled by wazero. Imported
+// functions are implemented in Go (see HostFunc).
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Value types.
+const (
+	I32       ValType = 0x7f
+	I64       ValType = 0x7e
+	F32       ValType = 0x7d
+	F64       ValType = 0x7c
+	FuncRef   ValType = 0x70
+	ExternRef ValType = 0x6f
+)
+
+// A ValType is a value type.
+type ValType byte
+
+// String returns the name of the value type.
+func (t ValType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case FuncRef:
+		return "funcref"
+	case ExternRef:
+		return "externref"
+	default:
+		return fmt.Sprintf("type(0x%02x)", byte(t))
+	}
+}
+
+// A FuncType is the type of a function.
+type FuncType struct {
+	Params  []ValType
+	Results []ValType
+}
+
+// String returns the function type in text format.
+func (ft *FuncType) String() string {
+	var b bytes.Buffer
+	b.WriteString("(func")
+	for _, t := range ft.Params {
+		fmt.Fprintf(&b, " (param %s)", t)
+	}
+	for _, t := range ft.Results {
+		fmt.Fprintf(&b, " (result %s)", t)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// External kinds of imports and exports.
+const (
+	ExternFunc   = 0x00
+	ExternTable  = 0x01
+	ExternMemory = 0x02
+	ExternGlobal = 0x03
+)
+
+// Limits of a memory (in pages) or a table (in elements).
+type Limits struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+// An Import is an import of a module. Only function and memory imports are
+// supported.
+type Import struct {
+	Module string
+	Name   string
+	Kind   byte
+	Type   uint32 // type index of imported function
+	Memory Limits // limits of imported memory
+}
+
+// An Export is an export of a module.
+type Export struct {
+	Kind  byte
+	Index uint32
+}
+
+// A Module is a decoded WebAssembly module.
+type Module struct {
+	Types    []FuncType
+	Imports  []Import
+	Exports  map[string]Export
+	funcs    []uint32 // type indices of defined functions
+	mems     []Limits
+	nGlobals uint32 // number of defined globals
+	nFuncs   int    // number of imported functions
+	sections map[byte][]byte
+
+	rt       wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// Section IDs.
+const (
+	sectionCustom    = 0
+	sectionType      = 1
+	sectionImport    = 2
+	sectionFunction  = 3
+	sectionTable     = 4
+	sectionMemory    = 5
+	sectionGlobal    = 6
+	sectionExport    = 7
+	sectionStart     = 8
+	sectionElement   = 9
+	sectionCode      = 10
+	sectionData      = 11
+	sectionDataCount = 12
+)
+
+// sectionOrder is the order of the (non-custom) sections in a module.
+var sectionOrder = []byte{
+	sectionType, sectionImport, sectionFunction, sectionTable, sectionMemory,
+	sectionGlobal, sectionExport, sectionStart, sectionElement, sectionDataCount,
+	sectionCode, sectionData,
+}
+
+// A reader decodes the binary format.
+type reader struct {
+	b   []byte
+	pos int
+}
+
+var errEOF = errors.New("unexpected end")
+
+func (r *reader) eof() bool {
+	return r.pos >= len(r.b)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, errEOF
+	}
+	c := r.b[r.pos]
+	r.pos++
+	return c, nil
+}
+
+func (r *reader) bytes(n uint32) ([]byte, error) {
+	if uint64(r.pos)+uint64(n) > uint64(len(r.b)) {
+		return nil, errEOF
+	}
+	b := r.b[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// uleb reads an unsigned LEB128 value with at most bits bits.
+func (r *reader) uleb(bits uint) (uint64, error) {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		c, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits {
+			return 0, errors.New("integer representation too long")
+		}
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, nil
+		}
+	}
+}
+
+// sleb reads a signed LEB128 value with at most bits bits.
+func (r *reader) sleb(bits uint) (int64, error) {
+	var v int64
+	for shift := uint(0); ; {
+		c, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits {
+			return 0, errors.New("integer representation too long")
+		}
+		v |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, nil
+		}
+	}
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	return string(b), err
+}
+
+func (r *reader) valType() (ValType, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch t := ValType(c); t {
+	case I32, I64, F32, F64, FuncRef, ExternRef:
+		return t, nil
+	default:
+		return 0, fmt.Errorf("invalid value type 0x%02x", c)
+	}
+}
+
+func (r *reader) limits() (Limits, error) {
+	flag, err := r.byte()
+	if err != nil {
+		return Limits{}, err
+	}
+	var l Limits
+	if l.Min, err = r.u32(); err != nil {
+		return l, err
+	}
+	switch flag {
+	case 0x00:
+	case 0x01:
+		if l.Max, err = r.u32(); err != nil {
+			return l, err
+		}
+		l.HasMax = true
+		if l.Max < l.Min {
+			return l, errors.New("size minimum must not be greater than maximum")
+		}
+	default:
+		return l, fmt.Errorf("invalid limits flag 0x%02x", flag)
+	}
+	return l, nil
+}
+
+// constExpr skips a constant expression (used for initial values of globals).
+func (r *reader) constExpr() error {
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if op == opEnd {
+			return nil
+		}
+		if err := r.immediates(op); err != nil {
+			return err
+		}
+	}
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// ErrMemoryLimit is returned by Parse if the initial memory of a module
+// exceeds the limit.
+var ErrMemoryLimit = errors.New("wasm: initial memory exceeds limit")
+
+// Parse decodes, instruments, and validates the WebAssembly module in binary
+// format code. The memory of the module is limited to maxPages pages (0 for
+// the limit of WebAssembly).
+func Parse(code []byte, maxPages uint32) (*Module, error) {
+	if maxPages == 0 || maxPages > maxMemoryPages {
+		maxPages = maxMemoryPages
+	}
+	m, err := parse(code)
+	if err != nil {
+		return nil, fmt.Errorf("wasm: %w", err)
+	}
+	if l, ok := m.Memory(); ok && l.Min > maxPages {
+		return nil, fmt.Errorf("%w: memory of %d pages exceeds limit of %d pages",
+			ErrMemoryLimit, l.Min, maxPages)
+	}
+	instrumented, err := m.instrument(maxPages)
+	if err != nil {
+		return nil, fmt.Errorf("wasm: %w", err)
+	}
+	if err := m.compile(instrumented, maxPages); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parse(code []byte) (*Module, error) {
+	r := &reader{b: code}
+	magic, err := r.bytes(8)
+	if err != nil || !bytes.Equal(magic[:4], []byte("\x00asm")) {
+		return nil, errors.New("magic header not detected")
+	}
+	if le32(magic[4:]) != 1 {
+		return nil, fmt.Errorf("unknown binary version %d", le32(magic[4:]))
+	}
+	m := &Module{Exports: make(map[string]Export), sections: make(map[byte][]byte)}
+	var lastID byte
+	for !r.eof() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+		if id == sectionCustom {
+			continue
+		}
+		order := bytes.IndexByte(sectionOrder, id)
+		if order < 0 {
+			return nil, fmt.Errorf("malformed section id %d", id)
+		}
+		if lastID != 0 && order <= bytes.IndexByte(sectionOrder, lastID) {
+			return nil, fmt.Errorf("unexpected section %d", id)
+		}
+		lastID = id
+		m.sections[id] = data
+		s := &reader{b: data}
+		switch id {
+		case sectionType:
+			err = m.parseTypes(s)
+		case sectionImport:
+			err = m.parseImports(s)
+		case sectionFunction:
+			m.funcs, err = s.indices()
+		case sectionMemory:
+			err = m.parseMems(s)
+		case sectionGlobal:
+			err = m.parseGlobals(s)
+		case sectionExport:
+			err = m.parseExports(s)
+		default:
+			continue // decoded by wazero
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, err)
+		}
+		if !s.eof() {
+			return nil, fmt.Errorf("section %d: section size mismatch", id)
+		}
+	}
+	if len(m.mems) > 1 {
+		return nil, errors.New("multiple memories")
+	}
+	for _, typ := range m.funcs {
+		if int(typ) >= len(m.Types) {
+			return nil, fmt.Errorf("unknown type %d", typ)
+		}
+	}
+	for name, e := range m.Exports {
+		if e.Kind == ExternFunc && int(e.Index) >= m.nFuncs+len(m.funcs) {
+			return nil, fmt.Errorf("export '%s' of unknown function %d", name, e.Index)
+		}
+	}
+	return m, nil
+}
+
+// count reads the length of a vector and calls parse for each element.
+func (r *reader) count(parse func() error) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if uint64(n) > uint64(len(r.b)) {
+		return errEOF
+	}
+	for i := uint32(0); i < n; i++ {
+		if err := parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *reader) valTypes() ([]ValType, error) {
+	var types []ValType
+	err := r.count(func() error {
+		t, err := r.valType()
+		types = append(types, t)
+		return err
+	})
+	return types, err
+}
+
+func (r *reader) indices() ([]uint32, error) {
+	var idx []uint32
+	err := r.count(func() error {
+		i, err := r.u32()
+		idx = append(idx, i)
+		return err
+	})
+	return idx, err
+}
+
+func (m *Module) parseTypes(r *reader) error {
+	return r.count(func() error {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != 0x60 {
+			return fmt.Errorf("invalid function type form 0x%02x", form)
+		}
+		var ft FuncType
+		if ft.Params, err = r.valTypes(); err != nil {
+			return err
+		}
+		if ft.Results, err = r.valTypes(); err != nil {
+			return err
+		}
+		m.Types = append(m.Types, ft)
+		return nil
+	})
+}
+
+func (m *Module) parseImports(r *reader) error {
+	return r.count(func() error {
+		var imp Import
+		var err error
+		if imp.Module, err = r.name(); err != nil {
+			return err
+		}
+		if imp.Name, err = r.name(); err != nil {
+			return err
+		}
+		if imp.Kind, err = r.byte(); err != nil {
+			return err
+		}
+		switch imp.Kind {
+		case ExternFunc:
+			if imp.Type, err = r.u32(); err != nil {
+				return err
+			}
+			if int(imp.Type) >= len(m.Types) {
+				return fmt.Errorf("unknown type %d", imp.Type)
+			}
+			m.nFuncs++
+		case ExternMemory:
+			if imp.Memory, err = r.limits(); err != nil {
+				return err
+			}
+			m.mems = append(m.mems, imp.Memory)
+		default:
+			return fmt.Errorf("unsupported import kind %d of %s.%s", imp.Kind, imp.Module, imp.Name)
+		}
+		m.Imports = append(m.Imports, imp)
+		return nil
+	})
+}
+
+func (m *Module) parseMems(r *reader) error {
+	return r.count(func() error {
+		l, err := r.limits()
+		if err != nil {
+			return err
+		}
+		if l.Min > maxMemoryPages || (l.HasMax && l.Max > maxMemoryPages) {
+			return errors.New("memory size must be at most 65536 pages (4GiB)")
+		}
+		m.mems = append(m.mems, l)
+		return nil
+	})
+}
+
+func (m *Module) parseGlobals(r *reader) error {
+	return r.count(func() error {
+		if _, err := r.valType(); err != nil {
+			return err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if mut > 1 {
+			return errors.New("malformed mutability")
+		}
+		if err := r.constExpr(); err != nil {
+			return err
+		}
+		m.nGlobals++
+		return nil
+	})
+}
+
+func (m *Module) parseExports(r *reader) error {
+	return r.count(func() error {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		var e Export
+		if e.Kind, err = r.byte(); err != nil {
+			return err
+		}
+		if e.Kind > ExternGlobal {
+			return fmt.Errorf("invalid export kind %d", e.Kind)
+		}
+		if e.Index, err = r.u32(); err != nil {
+			return err
+		}
+		if _, ok := m.Exports[name]; ok {
+			return fmt.Errorf("duplicate export name '%s'", name)
+		}
+		m.Exports[name] = e
+		return nil
+	})
+}
+
+// compile validates and compiles the instrumented code with a runtime whose
+// memories are limited to maxPages pages.
+func (m *Module) compile(code []byte, maxPages uint32) error {
+	ctx := context.Background()
+	m.rt = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithMemoryLimitPages(maxPages))
+	compiled, err := m.rt.CompileModule(ctx, code)
+	if err != nil {
+		return fmt.Errorf("wasm: %w", err)
+	}
+	m.compiled = compiled
+	return m.instantiateHost(ctx)
+}
+
+// Func returns the type of the function with index idx (imported functions
+// first).
+func (m *Module) Func(idx uint32) (*FuncType, error) {
+	if int(idx) < m.nFuncs {
+		i := 0
+		for _, imp := range m.Imports {
+			if imp.Kind != ExternFunc {
+				continue
+			}
+			if i == int(idx) {
+				return &m.Types[imp.Type], nil
+			}
+			i++
+		}
+	}
+	if int(idx)-m.nFuncs < len(m.funcs) {
+		return &m.Types[m.funcs[int(idx)-m.nFuncs]], nil
+	}
+	return nil, fmt.Errorf("wasm: unknown function %d", idx)
+}
+
+// Memory returns the limits of the memory of m (defined or imported) and
+// false, if m has no memory.
+func (m *Module) Memory() (Limits, bool) {
+	if len(m.mems) == 0 {
+		return Limits{}, false
+	}
+	return m.mems[0], true
+}
+
+// maxMemoryPages is the maximum number of memory pages.
+const maxMemoryPages = math.MaxUint16 + 1
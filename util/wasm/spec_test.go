@@ -0,0 +1,680 @@
+package wasm
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/util/wasm/wasmtest"
+)
+
+// The test cases in this file are taken from the WebAssembly spec test suite
+// (i32.wast, i64.wast, f32.wast, f64.wast, float_misc.wast, conversions.wast,
+// memory_*.wast, ...). Floats are compared bitwise, except for NaN results.
+
+func i32(v int32) uint64                { return uint64(uint32(v)) }
+func i64(v int64) uint64                { return uint64(v) }
+func bitsF32(v float32) uint64          { return uint64(math.Float32bits(v)) }
+func bitsF64(v float64) uint64          { return math.Float64bits(v) }
+func u32(v uint32) uint64               { return uint64(v) }
+func negZero32() uint64                 { return 0x80000000 }
+func negZero64() uint64                 { return 0x8000000000000000 }
+func inf32(sign int) uint64             { return bitsF32(float32(math.Inf(sign))) }
+func inf64(sign int) uint64             { return bitsF64(math.Inf(sign)) }
+func nan32() uint64                     { return 0x7fc00000 }
+func nan64() uint64                     { return 0x7ff8000000000000 }
+func misc(sub byte, imm ...byte) []byte { return append([]byte{opPrefixMisc, sub}, imm...) }
+func op(code byte) []byte               { return []byte{code} }
+func isNaN(t ValType, v uint64) bool {
+	if t == F32 {
+		return math.IsNaN(float64(math.Float32frombits(uint32(v))))
+	}
+	return math.IsNaN(math.Float64frombits(v))
+}
+
+// A specCase applies the instruction op to args of types params.
+type specCase struct {
+	name   string
+	op     []byte
+	params []ValType
+	result ValType
+	args   []uint64
+	exp    uint64
+	nan    bool // result is NaN (exp is ignored)
+	trap   Trap
+}
+
+// specCases returns cases for op with the given types. Each case is a list
+// of arguments followed by the expected result (or a Trap).
+func specCases(name string, code []byte, params []ValType, result ValType, cases ...[]interface{}) []specCase {
+	var tests []specCase
+	for _, c := range cases {
+		test := specCase{name: name, op: code, params: params, result: result}
+		for _, v := range c[:len(c)-1] {
+			test.args = append(test.args, v.(uint64))
+		}
+		switch exp := c[len(c)-1].(type) {
+		case Trap:
+			test.trap = exp
+		case uint64:
+			test.exp = exp
+			test.nan = (result == F32 || result == F64) && isNaN(result, exp)
+		}
+		tests = append(tests, test)
+	}
+	return tests
+}
+
+func unop(name string, code []byte, t, result ValType, cases ...[]interface{}) []specCase {
+	return specCases(name, code, []ValType{t}, result, cases...)
+}
+
+func binop(name string, code []byte, t, result ValType, cases ...[]interface{}) []specCase {
+	return specCases(name, code, []ValType{t, t}, result, cases...)
+}
+
+type vals = []interface{}
+
+const (
+	trapDivZero  = Trap("integer divide by zero")
+	trapOverflow = Trap("integer overflow")
+	trapInvalid  = Trap("invalid conversion to integer")
+)
+
+func TestSpecNumeric(t *testing.T) {
+	var tests []specCase
+	add := func(cases ...[]specCase) {
+		for _, c := range cases {
+			tests = append(tests, c...)
+		}
+	}
+	// i32
+	add(
+		binop("i32.add", op(opI32Add), I32, I32,
+			vals{i32(1), i32(1), i32(2)},
+			vals{i32(0x7fffffff), i32(1), u32(0x80000000)},
+			vals{u32(0x80000000), i32(-1), i32(0x7fffffff)},
+			vals{u32(0x3fffffff), i32(1), i32(0x40000000)}),
+		binop("i32.sub", op(opI32Sub), I32, I32,
+			vals{u32(0x80000000), i32(1), i32(0x7fffffff)},
+			vals{i32(-1), i32(-1), i32(0)}),
+		binop("i32.mul", op(opI32Mul), I32, I32,
+			vals{u32(0x80000000), i32(-1), u32(0x80000000)},
+			vals{i32(0x01234567), i32(0x76543210), i32(0x358e7470)},
+			vals{i32(0x7fffffff), i32(-1), u32(0x80000001)}),
+		binop("i32.div_s", op(opI32DivS), I32, I32,
+			vals{i32(1), i32(0), trapDivZero},
+			vals{u32(0x80000000), i32(-1), trapOverflow},
+			vals{u32(0x80000000), i32(2), u32(0xc0000000)},
+			vals{i32(-5), i32(2), i32(-2)},
+			vals{i32(5), i32(-2), i32(-2)},
+			vals{i32(-7), i32(-3), i32(2)}),
+		binop("i32.div_u", op(opI32DivU), I32, I32,
+			vals{i32(1), i32(0), trapDivZero},
+			vals{u32(0x80000000), i32(-1), i32(0)},
+			vals{u32(0x80000000), i32(2), i32(0x40000000)},
+			vals{i32(-5), i32(2), i32(0x7ffffffd)},
+			vals{u32(0x8ff00ff0), i32(0x10001), i32(0x8fef)}),
+		binop("i32.rem_s", op(opI32RemS), I32, I32,
+			vals{i32(1), i32(0), trapDivZero},
+			vals{u32(0x80000000), i32(-1), i32(0)},
+			vals{i32(-5), i32(2), i32(-1)},
+			vals{i32(5), i32(-2), i32(1)},
+			vals{i32(-7), i32(-3), i32(-1)}),
+		binop("i32.rem_u", op(opI32RemU), I32, I32,
+			vals{i32(1), i32(0), trapDivZero},
+			vals{u32(0x80000000), i32(-1), u32(0x80000000)},
+			vals{i32(-5), i32(2), i32(1)},
+			vals{u32(0x8ff00ff0), i32(0x10001), i32(0x8001)}),
+		binop("i32.and", op(opI32And), I32, I32,
+			vals{u32(0xf0f0ffff), u32(0xfffff0f0), u32(0xf0f0f0f0)}),
+		binop("i32.or", op(opI32Or), I32, I32,
+			vals{u32(0xf0f0ffff), u32(0xfffff0f0), u32(0xffffffff)}),
+		binop("i32.xor", op(opI32Xor), I32, I32,
+			vals{u32(0xf0f0ffff), u32(0xfffff0f0), u32(0x0f0f0f0f)}),
+		binop("i32.shl", op(opI32Shl), I32, I32,
+			vals{i32(0x7fffffff), i32(1), u32(0xfffffffe)},
+			vals{i32(1), i32(31), u32(0x80000000)},
+			vals{i32(1), i32(32), i32(1)},
+			vals{i32(1), i32(33), i32(2)},
+			vals{i32(1), i32(-1), u32(0x80000000)}),
+		binop("i32.shr_s", op(opI32ShrS), I32, I32,
+			vals{i32(-1), i32(1), i32(-1)},
+			vals{u32(0x80000000), i32(31), i32(-1)},
+			vals{i32(1), i32(32), i32(1)},
+			vals{i32(-1), i32(-1), i32(-1)}),
+		binop("i32.shr_u", op(opI32ShrU), I32, I32,
+			vals{i32(-1), i32(1), i32(0x7fffffff)},
+			vals{u32(0x80000000), i32(31), i32(1)},
+			vals{i32(1), i32(32), i32(1)},
+			vals{i32(-1), i32(-1), i32(1)}),
+		binop("i32.rotl", op(opI32Rotl), I32, I32,
+			vals{u32(0xabcd9876), i32(1), i32(0x579b30ed)},
+			vals{u32(0xfe00dc00), i32(4), u32(0xe00dc00f)},
+			vals{i32(0x00008000), i32(37), i32(0x00100000)},
+			vals{i32(1), i32(-1), u32(0x80000000)}),
+		binop("i32.rotr", op(opI32Rotr), I32, I32,
+			vals{u32(0xb0c1d2e3), i32(5), i32(0x1d860e97)},
+			vals{u32(0xb0c1d2e3), i32(0xff05), i32(0x1d860e97)},
+			vals{i32(1), i32(-1), i32(2)}),
+		unop("i32.clz", op(opI32Clz), I32, I32,
+			vals{i32(0), i32(32)},
+			vals{i32(0x00008000), i32(16)},
+			vals{u32(0x80000000), i32(0)}),
+		unop("i32.ctz", op(opI32Ctz), I32, I32,
+			vals{i32(0), i32(32)},
+			vals{i32(0x00010000), i32(16)},
+			vals{u32(0x80000000), i32(31)}),
+		unop("i32.popcnt", op(opI32Popcnt), I32, I32,
+			vals{i32(-1), i32(32)},
+			vals{u32(0xaaaaaaaa), i32(16)},
+			vals{u32(0xdeadbeef), i32(24)}),
+		unop("i32.extend8_s", op(opI32Extend8S), I32, I32,
+			vals{i32(0x7f), i32(0x7f)},
+			vals{i32(0x80), i32(-128)},
+			vals{u32(0xfedcba80), i32(-128)}),
+		unop("i32.extend16_s", op(opI32Extend16S), I32, I32,
+			vals{i32(0x7fff), i32(0x7fff)},
+			vals{i32(0x8000), i32(-32768)},
+			vals{u32(0x01230000), i32(0)}),
+		unop("i32.eqz", op(opI32Eqz), I32, I32,
+			vals{i32(0), i32(1)},
+			vals{u32(0x80000000), i32(0)}),
+		binop("i32.lt_s", op(opI32LtS), I32, I32,
+			vals{i32(-1), i32(1), i32(1)},
+			vals{u32(0x80000000), i32(0x7fffffff), i32(1)}),
+		binop("i32.lt_u", op(opI32LtU), I32, I32,
+			vals{i32(-1), i32(1), i32(0)},
+			vals{u32(0x80000000), i32(0x7fffffff), i32(0)}),
+		binop("i32.ge_s", op(opI32GeS), I32, I32,
+			vals{i32(-1), i32(-1), i32(1)},
+			vals{i32(0), i32(-1), i32(1)}),
+		binop("i32.gt_u", op(opI32GtU), I32, I32,
+			vals{i32(-1), i32(0), i32(1)}),
+	)
+	// i64
+	const minI64 = uint64(1) << 63
+	add(
+		binop("i64.add", op(opI64Add), I64, I64,
+			vals{i64(0x7fffffffffffffff), i64(1), minI64},
+			vals{minI64, i64(-1), i64(0x7fffffffffffffff)}),
+		binop("i64.mul", op(opI64Mul), I64, I64,
+			vals{i64(0x0123456789abcdef), uint64(0xfedcba9876543210), i64(0x2236d88fe5618cf0)},
+			vals{minI64, i64(-1), minI64}),
+		binop("i64.div_s", op(opI64DivS), I64, I64,
+			vals{i64(1), i64(0), trapDivZero},
+			vals{minI64, i64(-1), trapOverflow},
+			vals{i64(-5), i64(2), i64(-2)},
+			vals{i64(-7), i64(-3), i64(2)}),
+		binop("i64.div_u", op(opI64DivU), I64, I64,
+			vals{i64(1), i64(0), trapDivZero},
+			vals{minI64, i64(2), i64(0x4000000000000000)},
+			vals{uint64(0x8ff00ff00ff00ff0), i64(0x100000001), i64(0x8ff00fef)}),
+		binop("i64.rem_s", op(opI64RemS), I64, I64,
+			vals{i64(1), i64(0), trapDivZero},
+			vals{minI64, i64(-1), i64(0)},
+			vals{i64(-7), i64(-3), i64(-1)}),
+		binop("i64.rem_u", op(opI64RemU), I64, I64,
+			vals{i64(1), i64(0), trapDivZero},
+			vals{uint64(0x8ff00ff00ff00ff0), i64(0x100000001), i64(0x80000001)}),
+		binop("i64.shl", op(opI64Shl), I64, I64,
+			vals{i64(1), i64(63), minI64},
+			vals{i64(1), i64(64), i64(1)},
+			vals{i64(1), i64(-1), minI64}),
+		binop("i64.shr_s", op(opI64ShrS), I64, I64,
+			vals{minI64, i64(63), i64(-1)},
+			vals{i64(1), i64(64), i64(1)}),
+		binop("i64.shr_u", op(opI64ShrU), I64, I64,
+			vals{minI64, i64(63), i64(1)},
+			vals{i64(-1), i64(-1), i64(1)}),
+		binop("i64.rotl", op(opI64Rotl), I64, I64,
+			vals{uint64(0xabcd987602468ace), i64(1), uint64(0x579b30ec048d159d)},
+			vals{i64(1), i64(63), minI64}),
+		binop("i64.rotr", op(opI64Rotr), I64, I64,
+			vals{uint64(0xabcd987602468ace), i64(1), uint64(0x55e6cc3b01234567)},
+			vals{i64(1), i64(65), minI64}),
+		unop("i64.clz", op(opI64Clz), I64, I64,
+			vals{i64(0), i64(64)},
+			vals{i64(1), i64(63)}),
+		unop("i64.ctz", op(opI64Ctz), I64, I64,
+			vals{i64(0), i64(64)},
+			vals{minI64, i64(63)}),
+		unop("i64.popcnt", op(opI64Popcnt), I64, I64,
+			vals{i64(-1), i64(64)},
+			vals{i64(0x0000800000008000), i64(2)}),
+		unop("i64.extend32_s", op(opI64Extend32S), I64, I64,
+			vals{i64(0x7fffffff), i64(0x7fffffff)},
+			vals{i64(0x80000000), i64(-0x80000000)}),
+		unop("i64.extend_i32_s", op(opI64ExtendI32S), I32, I64,
+			vals{i32(-1), i64(-1)},
+			vals{u32(0x80000000), i64(-0x80000000)}),
+		unop("i64.extend_i32_u", op(opI64ExtendI32U), I32, I64,
+			vals{i32(-1), i64(0xffffffff)}),
+		unop("i32.wrap_i64", op(opI32WrapI64), I64, I32,
+			vals{i64(-1), i32(-1)},
+			vals{i64(0x100000001), i32(1)}),
+		binop("i64.lt_s", op(opI64LtS), I64, I32,
+			vals{minI64, i64(0), i32(1)}),
+		binop("i64.ge_u", op(opI64GeU), I64, I32,
+			vals{minI64, i64(0), i32(1)}),
+	)
+	// f32
+	add(
+		binop("f32.add", op(opF32Add), F32, F32,
+			vals{bitsF32(1.5), bitsF32(2.25), bitsF32(3.75)},
+			vals{negZero32(), negZero32(), negZero32()},
+			vals{negZero32(), bitsF32(0), bitsF32(0)},
+			vals{inf32(1), inf32(-1), nan32()},
+			vals{bitsF32(1.1754944e-38), bitsF32(-1.1754942e-38), bitsF32(1.4e-45)}),
+		binop("f32.sub", op(opF32Sub), F32, F32,
+			vals{inf32(1), inf32(1), nan32()},
+			vals{bitsF32(0), bitsF32(0), bitsF32(0)}),
+		binop("f32.mul", op(opF32Mul), F32, F32,
+			vals{bitsF32(0), inf32(1), nan32()},
+			vals{negZero32(), bitsF32(1), negZero32()},
+			vals{bitsF32(1e38), bitsF32(10), inf32(1)}),
+		binop("f32.div", op(opF32Div), F32, F32,
+			vals{bitsF32(1), bitsF32(0), inf32(1)},
+			vals{bitsF32(-1), bitsF32(0), inf32(-1)},
+			vals{bitsF32(0), bitsF32(0), nan32()},
+			vals{bitsF32(1), bitsF32(3), bitsF32(0.33333334)}),
+		binop("f32.min", op(opF32Min), F32, F32,
+			vals{negZero32(), bitsF32(0), negZero32()},
+			vals{bitsF32(0), negZero32(), negZero32()},
+			vals{nan32(), bitsF32(1), nan32()},
+			vals{bitsF32(1), nan32(), nan32()},
+			vals{nan32(), inf32(-1), nan32()}),
+		binop("f32.max", op(opF32Max), F32, F32,
+			vals{negZero32(), bitsF32(0), bitsF32(0)},
+			vals{bitsF32(0), negZero32(), bitsF32(0)},
+			vals{nan32(), inf32(1), nan32()}),
+		binop("f32.copysign", op(opF32Copysign), F32, F32,
+			vals{bitsF32(1), negZero32(), bitsF32(-1)},
+			vals{bitsF32(-1), bitsF32(0), bitsF32(1)},
+			vals{nan32(), bitsF32(-1), u32(0xffc00000)}),
+		unop("f32.sqrt", op(opF32Sqrt), F32, F32,
+			vals{bitsF32(4), bitsF32(2)},
+			vals{bitsF32(-1), nan32()},
+			vals{negZero32(), negZero32()}),
+		unop("f32.ceil", op(opF32Ceil), F32, F32,
+			vals{bitsF32(-0.5), negZero32()},
+			vals{bitsF32(1.5), bitsF32(2)}),
+		unop("f32.floor", op(opF32Floor), F32, F32,
+			vals{bitsF32(-0.5), bitsF32(-1)},
+			vals{bitsF32(1.5), bitsF32(1)}),
+		unop("f32.trunc", op(opF32Trunc), F32, F32,
+			vals{bitsF32(-1.5), bitsF32(-1)},
+			vals{bitsF32(-0.5), negZero32()}),
+		unop("f32.nearest", op(opF32Nearest), F32, F32,
+			vals{bitsF32(-0.5), negZero32()},
+			vals{bitsF32(0.5), bitsF32(0)},
+			vals{bitsF32(1.5), bitsF32(2)},
+			vals{bitsF32(3.5), bitsF32(4)},
+			vals{bitsF32(-3.5), bitsF32(-4)},
+			vals{bitsF32(8388609), bitsF32(8388609)}),
+		unop("f32.abs", op(opF32Abs), F32, F32,
+			vals{negZero32(), bitsF32(0)},
+			vals{u32(0xffc00000), nan32()}),
+		unop("f32.neg", op(opF32Neg), F32, F32,
+			vals{bitsF32(0), negZero32()},
+			vals{nan32(), u32(0xffc00000)}),
+		binop("f32.eq", op(opF32Eq), F32, I32,
+			vals{nan32(), nan32(), i32(0)},
+			vals{negZero32(), bitsF32(0), i32(1)}),
+		binop("f32.ne", op(opF32Ne), F32, I32,
+			vals{nan32(), nan32(), i32(1)}),
+		binop("f32.lt", op(opF32Lt), F32, I32,
+			vals{negZero32(), bitsF32(0), i32(0)},
+			vals{inf32(-1), bitsF32(-3.4e38), i32(1)}),
+		binop("f32.le", op(opF32Le), F32, I32,
+			vals{negZero32(), bitsF32(0), i32(1)},
+			vals{nan32(), bitsF32(0), i32(0)}),
+		binop("f32.ge", op(opF32Ge), F32, I32,
+			vals{bitsF32(0), nan32(), i32(0)}),
+	)
+	// f64
+	add(
+		binop("f64.add", op(opF64Add), F64, F64,
+			vals{bitsF64(0.1), bitsF64(0.2), bitsF64(0.30000000000000004)},
+			vals{inf64(1), inf64(-1), nan64()},
+			vals{bitsF64(1e308), bitsF64(1e308), inf64(1)}),
+		binop("f64.mul", op(opF64Mul), F64, F64,
+			vals{bitsF64(1e-200), bitsF64(1e-200), bitsF64(0)},
+			vals{negZero64(), inf64(1), nan64()}),
+		binop("f64.div", op(opF64Div), F64, F64,
+			vals{bitsF64(1), bitsF64(3), bitsF64(0.3333333333333333)},
+			vals{bitsF64(-1), negZero64(), inf64(1)}),
+		binop("f64.min", op(opF64Min), F64, F64,
+			vals{bitsF64(0), negZero64(), negZero64()},
+			vals{nan64(), bitsF64(1), nan64()},
+			vals{inf64(-1), nan64(), nan64()}),
+		binop("f64.max", op(opF64Max), F64, F64,
+			vals{negZero64(), bitsF64(0), bitsF64(0)},
+			vals{bitsF64(1), nan64(), nan64()},
+			vals{nan64(), inf64(1), nan64()}),
+		binop("f64.copysign", op(opF64Copysign), F64, F64,
+			vals{bitsF64(2), negZero64(), bitsF64(-2)}),
+		unop("f64.sqrt", op(opF64Sqrt), F64, F64,
+			vals{bitsF64(2), bitsF64(1.4142135623730951)},
+			vals{bitsF64(-0.1), nan64()}),
+		unop("f64.nearest", op(opF64Nearest), F64, F64,
+			vals{bitsF64(4.5), bitsF64(4)},
+			vals{bitsF64(-4.5), bitsF64(-4)},
+			vals{bitsF64(-0.4), negZero64()},
+			vals{bitsF64(4503599627370497), bitsF64(4503599627370497)}),
+		unop("f64.ceil", op(opF64Ceil), F64, F64,
+			vals{bitsF64(-0.9), negZero64()}),
+		unop("f64.floor", op(opF64Floor), F64, F64,
+			vals{bitsF64(-0.1), bitsF64(-1)}),
+		unop("f64.trunc", op(opF64Trunc), F64, F64,
+			vals{bitsF64(-4.9), bitsF64(-4)}),
+		binop("f64.gt", op(opF64Gt), F64, I32,
+			vals{nan64(), bitsF64(0), i32(0)},
+			vals{bitsF64(0), negZero64(), i32(0)}),
+	)
+	// conversions
+	const maxI64 = uint64(math.MaxInt64)
+	add(
+		unop("i32.trunc_f32_s", op(opI32TruncF32S), F32, I32,
+			vals{bitsF32(-1.9), i32(-1)},
+			vals{bitsF32(-2147483648), u32(0x80000000)},
+			vals{bitsF32(2147483648), trapOverflow},
+			vals{bitsF32(-2147483904), trapOverflow},
+			vals{nan32(), trapInvalid},
+			vals{inf32(1), trapOverflow}),
+		unop("i32.trunc_f32_u", op(opI32TruncF32U), F32, I32,
+			vals{bitsF32(-0.9), i32(0)},
+			vals{bitsF32(4294967040), u32(0xffffff00)},
+			vals{bitsF32(4294967296), trapOverflow},
+			vals{bitsF32(-1), trapOverflow}),
+		unop("i32.trunc_f64_s", op(opI32TruncF64S), F64, I32,
+			vals{bitsF64(2147483647.9), i32(0x7fffffff)},
+			vals{bitsF64(-2147483648.9), u32(0x80000000)},
+			vals{bitsF64(2147483648), trapOverflow},
+			vals{bitsF64(-2147483649), trapOverflow}),
+		unop("i32.trunc_f64_u", op(opI32TruncF64U), F64, I32,
+			vals{bitsF64(4294967295.9), u32(0xffffffff)},
+			vals{bitsF64(-0.9), i32(0)},
+			vals{bitsF64(4294967296), trapOverflow},
+			vals{nan64(), trapInvalid}),
+		unop("i64.trunc_f32_s", op(opI64TruncF32S), F32, I64,
+			vals{bitsF32(-9223372036854775808), minI64},
+			vals{bitsF32(9223372036854775808), trapOverflow}),
+		unop("i64.trunc_f64_s", op(opI64TruncF64S), F64, I64,
+			vals{bitsF64(9223372036854774784), i64(9223372036854774784)},
+			vals{bitsF64(-9223372036854775808), minI64},
+			vals{bitsF64(9223372036854775808), trapOverflow},
+			vals{nan64(), trapInvalid}),
+		unop("i64.trunc_f64_u", op(opI64TruncF64U), F64, I64,
+			vals{bitsF64(18446744073709549568), uint64(18446744073709549568)},
+			vals{bitsF64(18446744073709551616), trapOverflow},
+			vals{bitsF64(-1), trapOverflow}),
+		unop("i32.trunc_sat_f32_s", misc(0), F32, I32,
+			vals{nan32(), i32(0)},
+			vals{inf32(-1), u32(0x80000000)},
+			vals{bitsF32(2147483648), i32(0x7fffffff)}),
+		unop("i32.trunc_sat_f32_u", misc(1), F32, I32,
+			vals{bitsF32(-1), i32(0)},
+			vals{bitsF32(1e10), u32(0xffffffff)}),
+		unop("i32.trunc_sat_f64_s", misc(2), F64, I32,
+			vals{bitsF64(-2147483649), u32(0x80000000)}),
+		unop("i32.trunc_sat_f64_u", misc(3), F64, I32,
+			vals{bitsF64(1e10), u32(0xffffffff)},
+			vals{nan64(), i32(0)}),
+		unop("i64.trunc_sat_f32_u", misc(5), F32, I64,
+			vals{inf32(1), uint64(math.MaxUint64)}),
+		unop("i64.trunc_sat_f64_s", misc(6), F64, I64,
+			vals{inf64(-1), minI64},
+			vals{inf64(1), maxI64},
+			vals{nan64(), i64(0)}),
+		unop("i64.trunc_sat_f64_u", misc(7), F64, I64,
+			vals{inf64(1), uint64(math.MaxUint64)},
+			vals{bitsF64(-1), i64(0)}),
+		unop("f32.convert_i32_s", op(opF32ConvertI32S), I32, F32,
+			vals{i32(-1), bitsF32(-1)},
+			vals{i32(16777217), bitsF32(16777216)},
+			vals{i32(16777219), bitsF32(16777220)}),
+		unop("f32.convert_i32_u", op(opF32ConvertI32U), I32, F32,
+			vals{i32(-1), bitsF32(4294967296)},
+			vals{u32(0x80000080), bitsF32(2147483648)},
+			vals{u32(0x80000081), bitsF32(2147483904)}),
+		unop("f32.convert_i64_s", op(opF32ConvertI64S), I64, F32,
+			vals{i64(0x20000020000001), bitsF32(9007200328482816)},
+			vals{i64(-0x20000020000001), bitsF32(-9007200328482816)}),
+		unop("f32.convert_i64_u", op(opF32ConvertI64U), I64, F32,
+			vals{uint64(math.MaxUint64), bitsF32(18446744073709551616)},
+			vals{uint64(0x8000008000000001), bitsF32(9223373136366403584)}),
+		unop("f64.convert_i32_u", op(opF64ConvertI32U), I32, F64,
+			vals{i32(-1), bitsF64(4294967295)}),
+		unop("f64.convert_i64_s", op(opF64ConvertI64S), I64, F64,
+			vals{i64(9007199254740993), bitsF64(9007199254740992)},
+			vals{i64(-9007199254740995), bitsF64(-9007199254740996)}),
+		unop("f64.convert_i64_u", op(opF64ConvertI64U), I64, F64,
+			vals{uint64(0x8000000000000401), bitsF64(9223372036854777856)},
+			vals{uint64(math.MaxUint64), bitsF64(18446744073709551616)}),
+		unop("f64.promote_f32", op(opF64PromoteF32), F32, F64,
+			vals{bitsF32(1.5), bitsF64(1.5)},
+			vals{negZero32(), negZero64()},
+			vals{inf32(-1), inf64(-1)},
+			vals{nan32(), nan64()}),
+		unop("f32.demote_f64", op(opF32DemoteF64), F64, F32,
+			vals{bitsF64(1e300), inf32(1)},
+			vals{bitsF64(1.0000000000000002), bitsF32(1)},
+			vals{bitsF64(-1e-300), negZero32()},
+			vals{bitsF64(16777217), bitsF32(16777216)}),
+		unop("i32.reinterpret_f32", op(opI32ReinterpretF32), F32, I32,
+			vals{negZero32(), u32(0x80000000)},
+			vals{u32(0x7fa00000), i32(0x7fa00000)}),
+		unop("f32.reinterpret_i32", op(opF32ReinterpretI32), I32, F32,
+			vals{u32(0x80000000), negZero32()},
+			vals{u32(0x7f800000), inf32(1)}),
+		unop("i64.reinterpret_f64", op(opI64ReinterpretF64), F64, I64,
+			vals{inf64(-1), uint64(0xfff0000000000000)}),
+		unop("f64.reinterpret_i64", op(opF64ReinterpretI64), I64, F64,
+			vals{i64(1), uint64(1)}),
+	)
+	for _, test := range tests {
+		var code []byte
+		for i := range test.params {
+			code = append(code, opLocalGet, byte(i))
+		}
+		code = append(append(code, test.op...), opEnd)
+		var params []byte
+		for _, p := range test.params {
+			params = append(params, byte(p))
+		}
+		inst := instantiate(t, wasmtest.Module(
+			wasmtest.Section(sectionType, wasmtest.FuncType(params, []byte{byte(test.result)})),
+			wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+			wasmtest.Section(sectionExport, wasmtest.Export("f", ExternFunc, 0)),
+			wasmtest.Section(sectionCode, body(noLocals, code...)),
+		), nil)
+		res, err := inst.Call("f", test.args...)
+		switch {
+		case test.trap != "":
+			if err != test.trap {
+				t.Errorf("%s%#x: %v, expected trap %q", test.name, test.args, err, test.trap)
+			}
+		case err != nil:
+			t.Errorf("%s%#x: %s", test.name, test.args, err)
+		case test.nan:
+			if !isNaN(test.result, res[0]) {
+				t.Errorf("%s%#x = %#x, expected NaN", test.name, test.args, res[0])
+			}
+		case res[0] != test.exp:
+			t.Errorf("%s%#x = %#x, expected %#x", test.name, test.args, res[0], test.exp)
+		}
+	}
+}
+
+func TestSpecControl(t *testing.T) {
+	oneLocal := wasmtest.Vec(append(wasmtest.ULEB(1), byte(I32)))
+	tests := []struct {
+		name   string
+		locals []byte
+		code   []byte
+		arg    uint64
+		exp    uint64
+		trap   Trap
+	}{
+		{"br with value", noLocals, []byte{
+			opBlock, byte(I32), opI32Const, 1, opBr, 0, opI32Const, 2, opEnd, opEnd,
+		}, 0, 1, ""},
+		{"br drops operands", noLocals, []byte{
+			opI32Const, 5,
+			opBlock, byte(I32), opI32Const, 6, opI32Const, 7, opBr, 0, opEnd,
+			opI32Add, opEnd,
+		}, 0, 12, ""},
+		{"br_if taken", noLocals, []byte{
+			opBlock, byte(I32), opI32Const, 10, opLocalGet, 0, opBrIf, 0, opDrop, opI32Const, 20, opEnd, opEnd,
+		}, 1, 10, ""},
+		{"br_if not taken", noLocals, []byte{
+			opBlock, byte(I32), opI32Const, 10, opLocalGet, 0, opBrIf, 0, opDrop, opI32Const, 20, opEnd, opEnd,
+		}, 0, 20, ""},
+		{"if", noLocals, []byte{
+			opLocalGet, 0, opIf, byte(I32), opI32Const, 1, opElse, opI32Const, 2, opEnd, opEnd,
+		}, 7, 1, ""},
+		{"else", noLocals, []byte{
+			opLocalGet, 0, opIf, byte(I32), opI32Const, 1, opElse, opI32Const, 2, opEnd, opEnd,
+		}, 0, 2, ""},
+		{"if without else", noLocals, []byte{
+			opLocalGet, 0, opIf, 0x40, opI32Const, 9, opLocalSet, 0, opEnd, opLocalGet, 0, opEnd,
+		}, 0, 0, ""},
+		{"br out of if", noLocals, []byte{
+			opBlock, byte(I32),
+			opLocalGet, 0, opIf, byte(I32), opI32Const, 1, opBr, 1, opElse, opI32Const, 2, opEnd,
+			opI32Const, 3, opI32Add, opEnd, opEnd,
+		}, 1, 1, ""},
+		{"br_table", noLocals, []byte{
+			opBlock, 0x40, opBlock, 0x40, opBlock, 0x40,
+			opLocalGet, 0, opBrTable, 2, 0, 1, 2, opEnd,
+			opI32Const, 10, opReturn, opEnd,
+			opI32Const, 11, opReturn, opEnd,
+			opI32Const, 12, opEnd,
+		}, 1, 11, ""},
+		{"br_table default", noLocals, []byte{
+			opBlock, 0x40, opBlock, 0x40, opBlock, 0x40,
+			opLocalGet, 0, opBrTable, 2, 0, 1, 2, opEnd,
+			opI32Const, 10, opReturn, opEnd,
+			opI32Const, 11, opReturn, opEnd,
+			opI32Const, 12, opEnd,
+		}, 0xffffffff, 12, ""},
+		{"return from loop", noLocals, []byte{
+			opBlock, 0x40, opLoop, 0x40, opLocalGet, 0, opReturn, opEnd, opEnd, opI32Const, 0, opEnd,
+		}, 42, 42, ""},
+		{"loop", oneLocal, []byte{
+			opLoop, 0x40,
+			opLocalGet, 1, opLocalGet, 0, opI32Add, opLocalSet, 1,
+			opLocalGet, 0, opI32Const, 1, opI32Sub, opLocalTee, 0, opBrIf, 0,
+			opEnd, opLocalGet, 1, opEnd,
+		}, 10, 55, ""},
+		{"select first", noLocals, []byte{
+			opI32Const, 1, opI32Const, 2, opLocalGet, 0, opSelect, opEnd,
+		}, 5, 1, ""},
+		{"select second", noLocals, []byte{
+			opI32Const, 1, opI32Const, 2, opLocalGet, 0, opSelect, opEnd,
+		}, 0, 2, ""},
+		{"unreachable", noLocals, []byte{
+			opUnreachable, opEnd,
+		}, 0, 0, "unreachable"},
+		{"infinite recursion", noLocals, []byte{
+			opLocalGet, 0, opCall, 0, opEnd,
+		}, 0, 0, "stack overflow"},
+	}
+	for _, test := range tests {
+		inst := instantiate(t, wasmtest.Module(
+			wasmtest.Section(sectionType, wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I32)})),
+			wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+			wasmtest.Section(sectionExport, wasmtest.Export("f", ExternFunc, 0)),
+			wasmtest.Section(sectionCode, body(test.locals, test.code...)),
+		), nil)
+		res, err := inst.Call("f", test.arg)
+		switch {
+		case test.trap != "":
+			if err != test.trap {
+				t.Errorf("%s: %v, expected trap %q", test.name, err, test.trap)
+			}
+		case err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case res[0] != test.exp:
+			t.Errorf("%s(%d) = %d, expected %d", test.name, test.arg, res[0], test.exp)
+		}
+	}
+}
+
+func TestSpecBulkMemory(t *testing.T) {
+	args := func(code []byte) []byte {
+		return append([]byte{opLocalGet, 0, opLocalGet, 1, opLocalGet, 2}, append(code, opEnd)...)
+	}
+	inst := instantiate(t, wasmtest.Module(
+		wasmtest.Section(sectionType,
+			wasmtest.FuncType([]byte{byte(I32), byte(I32), byte(I32)}, nil),
+			wasmtest.FuncType(nil, nil),
+			wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I32)}),
+			wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I64)})),
+		wasmtest.Section(sectionFunction,
+			wasmtest.ULEB(0), wasmtest.ULEB(0), wasmtest.ULEB(0), wasmtest.ULEB(0),
+			wasmtest.ULEB(1), wasmtest.ULEB(2), wasmtest.ULEB(3)),
+		wasmtest.Section(sectionMemory, []byte{0x01, 1, 1}),
+		wasmtest.Section(sectionExport,
+			wasmtest.Export("fill", ExternFunc, 0),
+			wasmtest.Export("copy", ExternFunc, 1),
+			wasmtest.Export("init", ExternFunc, 2),
+			wasmtest.Export("init active", ExternFunc, 3),
+			wasmtest.Export("drop", ExternFunc, 4),
+			wasmtest.Export("load offset", ExternFunc, 5),
+			wasmtest.Export("load64", ExternFunc, 6)),
+		[]byte{sectionDataCount, 1, 2},
+		wasmtest.Section(sectionCode,
+			body(noLocals, args(misc(byte(opMemoryFill-opMisc), 0))...),
+			body(noLocals, args(misc(byte(opMemoryCopy-opMisc), 0, 0))...),
+			body(noLocals, args(misc(byte(opMemoryInit-opMisc), 1, 0))...),
+			body(noLocals, args(misc(byte(opMemoryInit-opMisc), 0, 0))...),
+			body(noLocals, append(misc(byte(opDataDrop-opMisc), 1), opEnd)...),
+			body(noLocals, append([]byte{opLocalGet, 0, opI32Load, 2}, append(wasmtest.ULEB(0xffffffff), opEnd)...)...),
+			body(noLocals, opLocalGet, 0, opI64Load, 3, 0, opEnd)),
+		wasmtest.Section(sectionData,
+			wasmtest.Data(0, []byte("abcdef")),
+			[]byte{0x01, 4, 0xaa, 0xbb, 0xcc, 0xdd}),
+	), nil)
+	tests := []struct {
+		f    string
+		args []uint64
+		trap error
+		addr int
+		mem  string // memory at addr after the call
+	}{
+		{"fill", []uint64{0xff00, 0x55, 0x100}, nil, 0xfeff, "\x00" + strings.Repeat("\x55", 0x100)},
+		{"fill", []uint64{0x10000, 0, 0}, nil, 0xff00, "\x55"},
+		{"fill", []uint64{0x10001, 0, 0}, errMemory, 0xff00, "\x55"},
+		// out of bounds fills write nothing
+		{"fill", []uint64{0xff00, 0, 0x101}, errMemory, 0xff00, "\x55"},
+		{"copy", []uint64{2, 0, 4}, nil, 0, "ababcd"},
+		{"copy", []uint64{0, 1, 5}, nil, 0, "babcdd"},
+		{"copy", []uint64{0x10000, 0, 0}, nil, 0, "babcdd"},
+		{"copy", []uint64{0, 0x10001, 0}, errMemory, 0, "babcdd"},
+		{"copy", []uint64{0xfffe, 0, 3}, errMemory, 0xfffe, "\x55\x55"},
+		{"init", []uint64{100, 1, 2}, nil, 100, "\xbb\xcc\x00"},
+		{"init", []uint64{0, 4, 0}, nil, 0, "b"},
+		{"init", []uint64{0, 5, 0}, errMemory, 0, "b"},
+		{"init", []uint64{0xffff, 0, 2}, errMemory, 0xffff, "\x55"},
+		// active segments are dropped after instantiation
+		{"init active", []uint64{0, 0, 1}, errMemory, 0, "b"},
+		{"init active", []uint64{0, 0, 0}, nil, 0, "b"},
+		{"drop", nil, nil, 0, "b"},
+		{"init", []uint64{0, 0, 1}, errMemory, 0, "b"},
+		{"init", []uint64{0, 0, 0}, nil, 0, "b"},
+		{"load offset", []uint64{1}, errMemory, 0, "b"},
+		{"load64", []uint64{0xfff8}, nil, 0, "b"},
+		{"load64", []uint64{0xfff9}, errMemory, 0, "b"},
+	}
+	for _, test := range tests {
+		_, err := inst.Call(test.f, test.args...)
+		if err != test.trap {
+			t.Errorf("%s%v: %v, expected %v", test.f, test.args, err, test.trap)
+		}
+		if mem := string(inst.Memory()[test.addr:][:len(test.mem)]); mem != test.mem {
+			t.Errorf("%s%v: memory at %#x is %q, expected %q", test.f, test.args, test.addr, mem, test.mem)
+		}
+	}
+}
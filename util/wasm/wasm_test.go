@@ -0,0 +1,351 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/aurora-is-near/evm-bully/util/wasm/wasmtest"
+)
+
+// body returns the code of a function with the given local declarations.
+func body(locals []byte, code ...byte) []byte {
+	return wasmtest.Body(locals, code)
+}
+
+var noLocals []byte
+
+func instantiate(t *testing.T, code []byte, imports map[string]HostFunc) *Instance {
+	t.Helper()
+	m, err := Parse(code, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := Instantiate(m, imports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inst
+}
+
+func call(t *testing.T, inst *Instance, name string, args ...uint64) uint64 {
+	t.Helper()
+	res, err := inst.Call(name, args...)
+	if err != nil {
+		t.Fatalf("%s(%v): %s", name, args, err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("%s(%v) returned %d results", name, args, len(res))
+	}
+	return res[0]
+}
+
+func TestFactorial(t *testing.T) {
+	inst := instantiate(t, wasmtest.Module(
+		wasmtest.Section(sectionType, wasmtest.FuncType([]byte{byte(I64)}, []byte{byte(I64)})),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+		wasmtest.Section(sectionExport, wasmtest.Export("fac", ExternFunc, 0)),
+		wasmtest.Section(sectionCode, body(noLocals,
+			opLocalGet, 0, opI64Eqz,
+			opIf, byte(I64),
+			opI64Const, 1,
+			opElse,
+			opLocalGet, 0,
+			opLocalGet, 0, opI64Const, 1, opI64Sub,
+			opCall, 0,
+			opI64Mul,
+			opEnd,
+			opEnd)),
+	), nil)
+	if v := call(t, inst, "fac", 20); v != 2432902008176640000 {
+		t.Errorf("fac(20) = %d, expected 2432902008176640000", v)
+	}
+}
+
+func TestLoop(t *testing.T) {
+	inst := instantiate(t, wasmtest.Module(
+		wasmtest.Section(sectionType, wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I32)})),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+		wasmtest.Section(sectionExport, wasmtest.Export("sum", ExternFunc, 0)),
+		wasmtest.Section(sectionCode, body(wasmtest.Vec([]byte{1, byte(I32)}),
+			opBlock, 0x40,
+			opLoop, 0x40,
+			opLocalGet, 0, opI32Eqz, opBrIf, 1,
+			opLocalGet, 1, opLocalGet, 0, opI32Add, opLocalSet, 1,
+			opLocalGet, 0, opI32Const, 1, opI32Sub, opLocalSet, 0,
+			opBr, 0,
+			opEnd,
+			opEnd,
+			opLocalGet, 1,
+			opEnd)),
+	), nil)
+	if v := call(t, inst, "sum", 1000); v != 500500 {
+		t.Errorf("sum(1000) = %d, expected 500500", v)
+	}
+	// limit the number of executed instructions
+	inst.MaxSteps = 1000
+	if _, err := inst.Call("sum", 1000); !errors.Is(err, ErrStepLimit) {
+		t.Errorf("sum(1000) with step limit: %v, expected %v", err, ErrStepLimit)
+	}
+	if inst.Steps() <= 1000 {
+		t.Errorf("Steps() = %d, expected more than 1000", inst.Steps())
+	}
+}
+
+func TestBrTable(t *testing.T) {
+	inst := instantiate(t, wasmtest.Module(
+		wasmtest.Section(sectionType, wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I32)})),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+		wasmtest.Section(sectionExport, wasmtest.Export("switch", ExternFunc, 0)),
+		wasmtest.Section(sectionCode, body(noLocals,
+			opBlock, 0x40,
+			opBlock, 0x40,
+			opBlock, 0x40,
+			opLocalGet, 0,
+			opBrTable, 2, 0, 1, 2,
+			opEnd,
+			opI32Const, 10, opReturn,
+			opEnd,
+			opI32Const, 20, opReturn,
+			opEnd,
+			opI32Const, 30,
+			opEnd)),
+	), nil)
+	for i, exp := range []uint64{10, 20, 30, 30} {
+		if v := call(t, inst, "switch", uint64(i)); v != exp {
+			t.Errorf("switch(%d) = %d, expected %d", i, v, exp)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	inst := instantiate(t, wasmtest.Module(
+		wasmtest.Section(sectionType,
+			wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I32)}),
+			wasmtest.FuncType([]byte{byte(I32), byte(I64)}, nil)),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0), wasmtest.ULEB(0), wasmtest.ULEB(1)),
+		wasmtest.Section(sectionMemory, []byte{0x01, 1, 2}),
+		wasmtest.Section(sectionExport,
+			wasmtest.Export("load8", ExternFunc, 0),
+			wasmtest.Export("grow", ExternFunc, 1),
+			wasmtest.Export("store", ExternFunc, 2),
+			wasmtest.Export("memory", ExternMemory, 0)),
+		wasmtest.Section(sectionCode,
+			body(noLocals, opLocalGet, 0, opI32Load8U, 0, 0, opEnd),
+			body(noLocals, opLocalGet, 0, opMemoryGrow, 0, opEnd),
+			body(noLocals, opLocalGet, 0, opLocalGet, 1, opI64Store, 3, 0, opEnd)),
+		wasmtest.Section(sectionData, wasmtest.Data(16, []byte("hello"))),
+	), nil)
+	if v := call(t, inst, "load8", 17); v != 'e' {
+		t.Errorf("load8(17) = %d, expected %d", v, 'e')
+	}
+	if _, err := inst.Call("store", 8, 0x0102030405060708); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(inst.Memory()[8:16], []byte{8, 7, 6, 5, 4, 3, 2, 1}) {
+		t.Errorf("memory after store: %x", inst.Memory()[8:16])
+	}
+	if _, err := inst.Call("store", PageSize-4, 0); err != Trap("out of bounds memory access") {
+		t.Errorf("store out of bounds: %v", err)
+	}
+	if v := call(t, inst, "grow", 1); v != 1 {
+		t.Errorf("grow(1) = %d, expected 1", v)
+	}
+	if v := call(t, inst, "grow", 1); v != math.MaxUint32 {
+		t.Errorf("grow(1) beyond maximum = %d, expected -1", v)
+	}
+	if v := call(t, inst, "load8", PageSize+1); v != 0 {
+		t.Errorf("load8() of grown memory = %d, expected 0", v)
+	}
+}
+
+func TestLimitMemory(t *testing.T) {
+	code := wasmtest.Module(
+		wasmtest.Section(sectionType, wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I32)})),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+		wasmtest.Section(sectionMemory, []byte{0x00, 2}), // no maximum
+		wasmtest.Section(sectionExport, wasmtest.Export("grow", ExternFunc, 0)),
+		wasmtest.Section(sectionCode, body(noLocals, opLocalGet, 0, opMemoryGrow, 0, opEnd)),
+	)
+	if _, err := Parse(code, 1); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("Parse(code, 1) = %v, expected %v", err, ErrMemoryLimit)
+	}
+	m, err := Parse(code, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, ok := m.Memory(); !ok || l.Min != 2 || l.HasMax {
+		t.Errorf("Memory() = %+v, %v, expected {Min:2}, true", l, ok)
+	}
+	inst, err := Instantiate(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := call(t, inst, "grow", 2); v != 2 {
+		t.Errorf("grow(2) = %d, expected 2", v)
+	}
+	if v := call(t, inst, "grow", 1); v != math.MaxUint32 {
+		t.Errorf("grow(1) beyond limit = %d, expected -1", v)
+	}
+}
+
+func TestCallIndirect(t *testing.T) {
+	inst := instantiate(t, wasmtest.Module(
+		wasmtest.Section(sectionType,
+			wasmtest.FuncType(nil, []byte{byte(I32)}),
+			wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I32)})),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0), wasmtest.ULEB(0), wasmtest.ULEB(1)),
+		wasmtest.Section(sectionTable, []byte{byte(FuncRef), 0x00, 4}),
+		wasmtest.Section(sectionExport, wasmtest.Export("dispatch", ExternFunc, 2)),
+		wasmtest.Section(sectionElement, append([]byte{0, opI32Const, 0, opEnd}, wasmtest.Vec(wasmtest.ULEB(0), wasmtest.ULEB(1), wasmtest.ULEB(2))...)),
+		wasmtest.Section(sectionCode,
+			body(noLocals, opI32Const, 1, opEnd),
+			body(noLocals, opI32Const, 2, opEnd),
+			body(noLocals, opLocalGet, 0, opCallIndirect, 0, 0, opEnd)),
+	), nil)
+	for i, exp := range []uint64{1, 2} {
+		if v := call(t, inst, "dispatch", uint64(i)); v != exp {
+			t.Errorf("dispatch(%d) = %d, expected %d", i, v, exp)
+		}
+	}
+	for i, exp := range []Trap{"indirect call type mismatch", "invalid table access", "invalid table access"} {
+		if _, err := inst.Call("dispatch", uint64(i+2)); err != exp {
+			t.Errorf("dispatch(%d): %v, expected %v", i+2, err, exp)
+		}
+	}
+}
+
+func TestHostFunc(t *testing.T) {
+	var got []uint64
+	inst := instantiate(t, wasmtest.Module(
+		wasmtest.Section(sectionType, wasmtest.FuncType([]byte{byte(I64), byte(I64)}, []byte{byte(I64)})),
+		wasmtest.Section(sectionImport, wasmtest.Import("env", "add", 0)),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+		wasmtest.Section(sectionExport, wasmtest.Export("twice", ExternFunc, 1)),
+		wasmtest.Section(sectionCode, body(noLocals,
+			opLocalGet, 0, opLocalGet, 1, opCall, 0,
+			opLocalGet, 1, opCall, 0,
+			opEnd)),
+	), map[string]HostFunc{
+		"env.add": func(inst *Instance, args []uint64) ([]uint64, error) {
+			got = append(got, args...)
+			if args[0] > 100 {
+				return nil, errors.New("too large")
+			}
+			return []uint64{args[0] + args[1]}, nil
+		},
+	})
+	if v := call(t, inst, "twice", 3, 4); v != 11 {
+		t.Errorf("twice(3, 4) = %d, expected 11", v)
+	}
+	if len(got) != 4 || got[2] != 7 {
+		t.Errorf("host function called with %v", got)
+	}
+	if _, err := inst.Call("twice", 101, 1); err == nil || err.Error() != "too large" {
+		t.Errorf("twice(101, 1): %v, expected host function error", err)
+	}
+	m, _ := Parse(wasmtest.Module(
+		wasmtest.Section(sectionType, wasmtest.FuncType(nil, nil)),
+		wasmtest.Section(sectionImport, wasmtest.Import("env", "missing", 0)),
+	), 0)
+	if _, err := Instantiate(m, nil); err == nil {
+		t.Error("Instantiate() should fail for unknown import")
+	}
+}
+
+func TestNumeric(t *testing.T) {
+	f32Bits := func(f float32) []byte {
+		b := uint64(math.Float32bits(f))
+		return []byte{byte(b), byte(b >> 8), byte(b >> 16), byte(b >> 24)}
+	}
+	tests := []struct {
+		name string
+		code []byte
+		exp  uint64
+		trap Trap
+	}{
+		{"i32.div_s", []byte{opI32Const, 0x79, opI32Const, 2, opI32DivS}, math.MaxUint32 - 2, ""}, // -7/2 = -3
+		{"i32.rem_s", []byte{opI32Const, 0x79, opI32Const, 2, opI32RemS}, math.MaxUint32, ""},     // -7%2 = -1
+		{"i32.div_u by zero", []byte{opI32Const, 1, opI32Const, 0, opI32DivU}, 0, "integer divide by zero"},
+		{"i32.div_s overflow", []byte{opI32Const, 0x80, 0x80, 0x80, 0x80, 0x78, opI32Const, 0x7f, opI32DivS}, 0, "integer overflow"},
+		{"i32.rotl", []byte{opI32Const, 0x80, 0x80, 0x80, 0x80, 0x78, opI32Const, 1, opI32Rotl}, 1, ""},
+		{"i32.extend8_s", []byte{opI32Const, 0x80, 0x01, opI32Extend8S}, 0xffffff80, ""},
+		{"i32.clz", []byte{opI32Const, 1, opI32Clz}, 31, ""},
+		{"i32.trunc_f32_s", append(append([]byte{opF32Const}, f32Bits(-3.7)...), opI32TruncF32S), math.MaxUint32 - 2, ""},
+		{"i32.trunc_f32_u NaN", append(append([]byte{opF32Const}, f32Bits(float32(math.NaN()))...), opI32TruncF32U), 0, "invalid conversion to integer"},
+		{"i32.trunc_sat_f32_s", append(append([]byte{opF32Const}, f32Bits(1e20)...), opPrefixMisc, 0), math.MaxInt32, ""},
+		{"f32.nearest", append(append(append([]byte{opF32Const}, f32Bits(2.5)...), opF32Nearest), opI32TruncF32S), 2, ""},
+		{"unreachable", []byte{opUnreachable}, 0, "unreachable"},
+	}
+	for _, test := range tests {
+		code := append(append([]byte{}, test.code...), opEnd)
+		inst := instantiate(t, wasmtest.Module(
+			wasmtest.Section(sectionType, wasmtest.FuncType(nil, []byte{byte(I32)})),
+			wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+			wasmtest.Section(sectionExport, wasmtest.Export("f", ExternFunc, 0)),
+			wasmtest.Section(sectionCode, body(noLocals, code...)),
+		), nil)
+		res, err := inst.Call("f")
+		if test.trap != "" {
+			if err != test.trap {
+				t.Errorf("%s: %v, expected trap %q", test.name, err, test.trap)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if res[0] != test.exp {
+			t.Errorf("%s = %#x, expected %#x", test.name, res[0], test.exp)
+		}
+	}
+}
+
+func TestMultiValue(t *testing.T) {
+	inst := instantiate(t, wasmtest.Module(
+		wasmtest.Section(sectionType,
+			wasmtest.FuncType(nil, []byte{byte(I32), byte(I32)}),
+			wasmtest.FuncType([]byte{byte(I32)}, []byte{byte(I32)})),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+		wasmtest.Section(sectionExport, wasmtest.Export("f", ExternFunc, 0)),
+		wasmtest.Section(sectionCode, body(noLocals,
+			opI32Const, 3,
+			opBlock, 1, // [i32] -> [i32]
+			opI32Const, 4, opI32Add,
+			opEnd,
+			opI32Const, 5,
+			opEnd)),
+	), nil)
+	res, err := inst.Call("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0] != 7 || res[1] != 5 {
+		t.Errorf("f() = %v, expected [7 5]", res)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	valid := wasmtest.Module(
+		wasmtest.Section(sectionType, wasmtest.FuncType(nil, nil)),
+		wasmtest.Section(sectionFunction, wasmtest.ULEB(0)),
+		wasmtest.Section(sectionCode, body(noLocals, opNop, opEnd)),
+	)
+	if _, err := Parse(valid, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range [][]byte{
+		[]byte("\x00asn\x01\x00\x00\x00"),                                    // magic
+		[]byte("\x00asm\x02\x00\x00\x00"),                                    // version
+		valid[:len(valid)-1],                                                 // truncated
+		append(valid[:len(valid)-2:len(valid)-2], 0xfe, opEnd),               // unknown opcode
+		wasmtest.Module(wasmtest.Section(sectionFunction, wasmtest.ULEB(0))), // missing code
+		append(valid[:len(valid)-2:len(valid)-2], opI32Const, 0, opEnd),      // type mismatch
+		append(valid[:len(valid)-2:len(valid)-2], opLocalGet, 0, opEnd),      // unknown local
+		append(valid[:len(valid)-2:len(valid)-2], opBr, 1, opEnd),            // unknown label
+	} {
+		if _, err := Parse(code, 0); err == nil {
+			t.Errorf("Parse(%x) should fail", code)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package wasmtest assembles WebAssembly modules in binary format for tests.
+package wasmtest
+
+// Section IDs.
+const (
+	SectionType     = 1
+	SectionImport   = 2
+	SectionFunction = 3
+	SectionTable    = 4
+	SectionMemory   = 5
+	SectionExport   = 7
+	SectionElement  = 9
+	SectionCode     = 10
+	SectionData     = 11
+)
+
+// Value types.
+const (
+	I32     = 0x7f
+	I64     = 0x7e
+	F32     = 0x7d
+	F64     = 0x7c
+	FuncRef = 0x70
+)
+
+// ULEB returns the unsigned LEB128 encoding of v.
+func ULEB(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// SLEB returns the signed LEB128 encoding of v.
+func SLEB(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// I64Const returns the instruction i64.const v.
+func I64Const(v int64) []byte {
+	return append([]byte{0x42}, SLEB(v)...)
+}
+
+// Call returns the instruction call idx.
+func Call(idx uint64) []byte {
+	return append([]byte{0x10}, ULEB(idx)...)
+}
+
+// Vec returns a vector with the given (encoded) items.
+func Vec(items ...[]byte) []byte {
+	b := ULEB(uint64(len(items)))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+// Name returns the encoding of name s.
+func Name(s string) []byte {
+	return append(ULEB(uint64(len(s))), s...)
+}
+
+// Section returns a section with the given id and (encoded) items.
+func Section(id byte, items ...[]byte) []byte {
+	contents := Vec(items...)
+	return append(append([]byte{id}, ULEB(uint64(len(contents)))...), contents...)
+}
+
+// FuncType returns a function type with the given parameter and result
+// types.
+func FuncType(params, results []byte) []byte {
+	b := append([]byte{0x60}, ULEB(uint64(len(params)))...)
+	b = append(b, params...)
+	b = append(b, ULEB(uint64(len(results)))...)
+	return append(b, results...)
+}
+
+// Body returns the body of a function with the given local declarations
+// (Vec of count and type pairs, nil for none) and code.
+func Body(locals []byte, code ...[]byte) []byte {
+	if locals == nil {
+		locals = Vec()
+	}
+	b := append([]byte{}, locals...)
+	for _, c := range code {
+		b = append(b, c...)
+	}
+	return append(ULEB(uint64(len(b))), b...)
+}
+
+// Import returns the import of function module.name with type typeIdx.
+func Import(module, name string, typeIdx uint64) []byte {
+	b := append(Name(module), Name(name)...)
+	return append(append(b, 0x00), ULEB(typeIdx)...)
+}
+
+// Export returns the export of name with kind (0 for functions, 2 for
+// memories) and index idx.
+func Export(name string, kind byte, idx uint64) []byte {
+	return append(append(Name(name), kind), ULEB(idx)...)
+}
+
+// Data returns an active data segment with init at offset of memory 0.
+func Data(offset int32, init []byte) []byte {
+	b := append([]byte{0x00, 0x41}, SLEB(int64(offset))...)
+	b = append(b, 0x0b)
+	return append(b, append(ULEB(uint64(len(init))), init...)...)
+}
+
+// Module returns a module with the given sections.
+func Module(sections ...[]byte) []byte {
+	b := []byte("\x00asm\x01\x00\x00\x00")
+	for _, s := range sections {
+		b = append(b, s...)
+	}
+	return b
+}